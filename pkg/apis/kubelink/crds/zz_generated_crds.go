@@ -62,6 +62,10 @@ spec:
     - jsonPath: .status.state
       name: State
       type: string
+    - jsonPath: .spec.description
+      name: Description
+      priority: 1
+      type: string
     name: v1alpha1
     schema:
       openAPIV3Schema:
@@ -80,6 +84,10 @@ spec:
             type: object
           spec:
             properties:
+              advertise:
+                items:
+                  type: string
+                type: array
               apiAccess:
                 description: SecretReference represents a Secret Reference. It has
                   enough information to retrieve secret in any namespace
@@ -93,10 +101,25 @@ spec:
                       name must be unique.
                     type: string
                 type: object
+              buffers:
+                properties:
+                  bandwidth:
+                    type: string
+                  readBufferSize:
+                    type: integer
+                  rtt:
+                    type: string
+                  socketBufferSize:
+                    type: integer
+                type: object
               cidr:
                 type: string
               clusterAddress:
                 type: string
+              deduplicationWindow:
+                type: string
+              description:
+                type: string
               dns:
                 properties:
                   baseDomain:
@@ -106,26 +129,59 @@ spec:
                   omitDNSPropagation:
                     type: boolean
                 type: object
+              dscp:
+                type: integer
               egress:
                 items:
                   type: string
                 type: array
               endpoint:
                 type: string
+              failoverEndpoints:
+                items:
+                  type: string
+                type: array
               ingress:
                 items:
                   type: string
                 type: array
+              interface:
+                type: string
+              plaintext:
+                type: boolean
+              serverName:
+                type: string
+              serviceRanges:
+                items:
+                  type: string
+                type: array
             required:
             - clusterAddress
             - endpoint
             type: object
           status:
             properties:
+              endpoint:
+                type: string
               gateway:
                 type: string
+              lastErrorTime:
+                format: date-time
+                type: string
               message:
                 type: string
+              refusedRoutes:
+                items:
+                  type: string
+                type: array
+              routes:
+                items:
+                  type: string
+                type: array
+              services:
+                items:
+                  type: string
+                type: array
               state:
                 type: string
             type: object
@@ -46,6 +46,7 @@ type KubeLinkList struct {
 // +kubebuilder:printcolumn:name=Endpoint,JSONPath=".spec.endpoint",type=string
 // +kubebuilder:printcolumn:name=Gateway,JSONPath=".status.gateway",type=string
 // +kubebuilder:printcolumn:name=State,JSONPath=".status.state",type=string
+// +kubebuilder:printcolumn:name=Description,JSONPath=".spec.description",type=string,priority=1
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
@@ -63,15 +64,54 @@ type KubeLinkSpec struct {
 	// +optional
 	Ingress []string `json:"ingress,omitempty"`
 	// +optional
-	Egress         []string `json:"egress,omitempty"`
+	Egress []string `json:"egress,omitempty"`
+	// +optional
+	ServiceRanges []string `json:"serviceRanges,omitempty"`
+	// +optional
+	Advertise      []string `json:"advertise,omitempty"`
 	ClusterAddress string   `json:"clusterAddress"`
 	Endpoint       string   `json:"endpoint"`
 
+	// +optional
+	FailoverEndpoints []string `json:"failoverEndpoints,omitempty"`
+
+	// +optional
+	Description string `json:"description,omitempty"`
+
 	// +optional
 	APIAccess *core.SecretReference `json:"apiAccess,omitempty"`
 
 	// +optional
 	DNS *KubeLinkDNS `json:"dns,omitempty"`
+
+	// +optional
+	DSCP *int `json:"dscp,omitempty"`
+
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+
+	// +optional
+	Plaintext bool `json:"plaintext,omitempty"`
+
+	// +optional
+	Buffers *KubeLinkBuffers `json:"buffers,omitempty"`
+
+	// +optional
+	DeduplicationWindow string `json:"deduplicationWindow,omitempty"`
+
+	// +optional
+	Interface string `json:"interface,omitempty"`
+}
+
+type KubeLinkBuffers struct {
+	// +optional
+	SocketBufferSize *int `json:"socketBufferSize,omitempty"`
+	// +optional
+	ReadBufferSize *int `json:"readBufferSize,omitempty"`
+	// +optional
+	RTT string `json:"rtt,omitempty"`
+	// +optional
+	Bandwidth string `json:"bandwidth,omitempty"`
 }
 
 type KubeLinkDNS struct {
@@ -89,5 +129,15 @@ type KubeLinkStatus struct {
 	// +optional
 	Message string `json:"message,omitempty"`
 	// +optional
+	LastErrorTime *metav1.Time `json:"lastErrorTime,omitempty"`
+	// +optional
 	Gateway string `json:"gateway,omitempty"`
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// +optional
+	Services []string `json:"services,omitempty"`
+	// +optional
+	Routes []string `json:"routes,omitempty"`
+	// +optional
+	RefusedRoutes []string `json:"refusedRoutes,omitempty"`
 }
@@ -31,7 +31,7 @@ func (in *KubeLink) DeepCopyInto(out *KubeLink) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -53,6 +53,32 @@ func (in *KubeLink) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeLinkBuffers) DeepCopyInto(out *KubeLinkBuffers) {
+	*out = *in
+	if in.SocketBufferSize != nil {
+		in, out := &in.SocketBufferSize, &out.SocketBufferSize
+		*out = new(int)
+		**out = **in
+	}
+	if in.ReadBufferSize != nil {
+		in, out := &in.ReadBufferSize, &out.ReadBufferSize
+		*out = new(int)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeLinkBuffers.
+func (in *KubeLinkBuffers) DeepCopy() *KubeLinkBuffers {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeLinkBuffers)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubeLinkDNS) DeepCopyInto(out *KubeLinkDNS) {
 	*out = *in
@@ -120,6 +146,21 @@ func (in *KubeLinkSpec) DeepCopyInto(out *KubeLinkSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ServiceRanges != nil {
+		in, out := &in.ServiceRanges, &out.ServiceRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Advertise != nil {
+		in, out := &in.Advertise, &out.Advertise
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.FailoverEndpoints != nil {
+		in, out := &in.FailoverEndpoints, &out.FailoverEndpoints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.APIAccess != nil {
 		in, out := &in.APIAccess, &out.APIAccess
 		*out = new(v1.SecretReference)
@@ -130,6 +171,16 @@ func (in *KubeLinkSpec) DeepCopyInto(out *KubeLinkSpec) {
 		*out = new(KubeLinkDNS)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.DSCP != nil {
+		in, out := &in.DSCP, &out.DSCP
+		*out = new(int)
+		**out = **in
+	}
+	if in.Buffers != nil {
+		in, out := &in.Buffers, &out.Buffers
+		*out = new(KubeLinkBuffers)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -146,6 +197,25 @@ func (in *KubeLinkSpec) DeepCopy() *KubeLinkSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubeLinkStatus) DeepCopyInto(out *KubeLinkStatus) {
 	*out = *in
+	if in.LastErrorTime != nil {
+		in, out := &in.LastErrorTime, &out.LastErrorTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Services != nil {
+		in, out := &in.Services, &out.Services
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RefusedRoutes != nil {
+		in, out := &in.RefusedRoutes, &out.RefusedRoutes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
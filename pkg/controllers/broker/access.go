@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mandelsoft/kubelink/pkg/controllers"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// AccessInfo is the api access info of a link exposed by the access api.
+type AccessInfo struct {
+	CACert string `json:"caCert,omitempty"`
+	Token  string `json:"token"`
+}
+
+// AccessHandler serves the api access info of all links providing it
+// to clients authenticated by a bearer token.
+type AccessHandler struct {
+	links *kubelink.Links
+	token string
+}
+
+func NewAccessHandler(links *kubelink.Links, token string) *AccessHandler {
+	return &AccessHandler{links: links, token: token}
+}
+
+func (this *AccessHandler) authorized(r *http.Request) bool {
+	return controllers.BearerAuthorized(r, this.token)
+}
+
+// AccessInfos returns the api access info of all links providing it.
+func (this *AccessHandler) AccessInfos() map[string]AccessInfo {
+	result := map[string]AccessInfo{}
+	this.links.Visit(func(l *kubelink.Link) bool {
+		if l.Token != "" {
+			result[l.Name] = AccessInfo{CACert: l.CACert, Token: l.Token}
+		}
+		return true
+	})
+	return result
+}
+
+func (this *AccessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !this.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	infos := this.AccessInfos()
+	if name := strings.TrimPrefix(r.URL.Path, "/access/"); name != r.URL.Path && name != "" {
+		info, ok := infos[name]
+		if !ok {
+			http.Error(w, "no access info for link "+name, http.StatusNotFound)
+			return
+		}
+		this.write(w, info)
+		return
+	}
+	this.write(w, infos)
+}
+
+func (this *AccessHandler) write(w http.ResponseWriter, obj interface{}) {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func TestAccessHandler(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+		testLink("b", "192.168.0.11/24", "100.64.2.0/24"),
+	)
+	m.links.UpdateLinkInfo(logger.New(), "a", &kubelink.LinkAccessInfo{CACert: "ca", Token: "secret"}, nil, false)
+	h := NewAccessHandler(m.links, "access")
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, token := range []string{"", "other"} {
+		if w := get("/access", token); w.Code != http.StatusUnauthorized {
+			t.Errorf("access with token %q: got status %d", token, w.Code)
+		}
+	}
+
+	w := get("/access", "access")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	infos := map[string]AccessInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatalf("invalid response: %s", err)
+	}
+	if len(infos) != 1 || infos["a"] != (AccessInfo{CACert: "ca", Token: "secret"}) {
+		t.Errorf("unexpected access infos %v", infos)
+	}
+
+	w = get("/access/a", "access")
+	info := AccessInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); w.Code != http.StatusOK || err != nil || info.Token != "secret" {
+		t.Errorf("access info of link a not served: %d %s", w.Code, w.Body.String())
+	}
+	if w = get("/access/b", "access"); w.Code != http.StatusNotFound {
+		t.Errorf("link without access info: got status %d", w.Code)
+	}
+	if s := m.links.GetLink("a").LinkAccessInfo.String(); strings.Contains(s, "secret") {
+		t.Errorf("token not redacted: %s", s)
+	}
+}
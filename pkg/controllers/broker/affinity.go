@@ -0,0 +1,82 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// SetDialInterface sets the default local interface used for
+// outbound tunnel connections of links without an interface affinity.
+func (this *Mux) SetDialInterface(name string) {
+	this.dialInterface = name
+}
+
+// dialSource determines the local address for dialing the given
+// endpoint of a link according to its interface affinity. It returns
+// nil if no affinity is configured.
+func (this *Mux) dialSource(link *kubelink.Link, endpoint string) (*net.TCPAddr, error) {
+	name := link.Interface
+	if name == "" {
+		name = this.dialInterface
+	}
+	if name == "" {
+		return nil, nil
+	}
+	ifce, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface affinity %q: %s", name, err)
+	}
+	addrs, err := ifce.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get addresses of interface %q: %s", name, err)
+	}
+	ip := SourceAddress(addrs, net.ParseIP(kubelink.EndpointHost(endpoint)))
+	if ip == nil {
+		return nil, fmt.Errorf("no usable address on interface %q", name)
+	}
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// SourceAddress selects a global unicast address of the given
+// interface addresses. If the remote address is known, an address
+// of the same family is required, otherwise IPv4 is preferred.
+func SourceAddress(addrs []net.Addr, remote net.IP) net.IP {
+	var v6 net.IP
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || !n.IP.IsGlobalUnicast() {
+			continue
+		}
+		if n.IP.To4() != nil {
+			if remote == nil || remote.To4() != nil {
+				return n.IP
+			}
+		} else if v6 == nil {
+			v6 = n.IP
+		}
+	}
+	if remote != nil && remote.To4() != nil {
+		return nil
+	}
+	return v6
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+func TestSourceAddress(t *testing.T) {
+	addrs := func(cidrs ...string) []net.Addr {
+		var result []net.Addr
+		for _, c := range cidrs {
+			ip, cidr, err := net.ParseCIDR(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cidr.IP = ip
+			result = append(result, cidr)
+		}
+		return result
+	}
+	cases := map[string]struct {
+		addrs    []net.Addr
+		remote   string
+		expected string
+	}{
+		"prefer ipv4":  {addrs("fd00::2/64", "192.0.2.2/24"), "", "192.0.2.2"},
+		"ipv6 only":    {addrs("fe80::1/64", "fd00::2/64"), "", "fd00::2"},
+		"ipv4 remote":  {addrs("fd00::2/64", "192.0.2.2/24"), "10.0.0.1", "192.0.2.2"},
+		"ipv6 remote":  {addrs("192.0.2.2/24", "fd00::2/64"), "fd01::1", "fd00::2"},
+		"no ipv4":      {addrs("fd00::2/64"), "10.0.0.1", ""},
+		"no ipv6":      {addrs("192.0.2.2/24"), "fd01::1", ""},
+		"no global":    {addrs("127.0.0.1/8", "fe80::1/64"), "", ""},
+		"no addresses": {nil, "", ""},
+	}
+	for name, c := range cases {
+		ip := SourceAddress(c.addrs, net.ParseIP(c.remote))
+		if (ip == nil && c.expected != "") || (ip != nil && !ip.Equal(net.ParseIP(c.expected))) {
+			t.Errorf("%s: got %s, expected %q", name, ip, c.expected)
+		}
+	}
+}
+
+// testInterface returns a local interface with a global unicast
+// IPv4 address.
+func testInterface(t *testing.T) (string, net.IP) {
+	ifces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifce := range ifces {
+		if ifce.Flags&net.FlagUp == 0 || ifce.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifce.Addrs()
+		if err != nil {
+			continue
+		}
+		if ip := SourceAddress(addrs, net.IPv4(127, 0, 0, 1)); ip != nil {
+			return ifce.Name, ip
+		}
+	}
+	t.Skip("no interface with a global unicast IPv4 address")
+	return "", nil
+}
+
+func TestInterfaceAffinity(t *testing.T) {
+	name, ip := testInterface(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	remotes := make(chan net.IP, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			remotes <- c.RemoteAddr().(*net.TCPAddr).IP
+			c.Close()
+		}
+	}()
+	endpoint := l.Addr().String()
+
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	m.LogContext = logger.New()
+
+	cases := map[string]struct {
+		ifce     string
+		dflt     string
+		expected net.IP
+	}{
+		"none":     {"", "", net.IPv4(127, 0, 0, 1)},
+		"link":     {name, "", ip},
+		"default":  {"", name, ip},
+		"override": {name, "lo", ip},
+	}
+	for n, c := range cases {
+		link := *m.links.GetLink("a")
+		link.Interface = c.ifce
+		m.SetDialInterface(c.dflt)
+		local, err := m.dialSource(&link, endpoint)
+		if err != nil {
+			t.Errorf("%s: %s", n, err)
+			continue
+		}
+		conn, err := m.certInfo.Dial(endpoint, link.GetServerName(), time.Second, local)
+		if err != nil {
+			t.Errorf("%s: %s", n, err)
+			continue
+		}
+		conn.Close()
+		if remote := <-remotes; !remote.Equal(c.expected) {
+			t.Errorf("%s: dialed from %s, expected %s", n, remote, c.expected)
+		}
+	}
+
+	m.SetDialInterface("")
+	link := *m.links.GetLink("a")
+	link.Interface = "no-such-interface"
+	if _, err := m.dialSource(&link, endpoint); err == nil {
+		t.Errorf("unknown interface accepted")
+	}
+}
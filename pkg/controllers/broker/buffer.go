@@ -0,0 +1,60 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"sync"
+)
+
+// frameHeaderSize is the size of the packet framing (length and type).
+const frameHeaderSize = 3
+
+type Buffer [BufferSize + frameHeaderSize]byte
+
+// BufferPool provides reusable packet buffers. If pooling is disabled
+// every request gets a fresh buffer.
+type BufferPool struct {
+	pool *sync.Pool
+}
+
+func NewBufferPool(enabled bool) *BufferPool {
+	if !enabled {
+		return &BufferPool{}
+	}
+	return &BufferPool{
+		pool: &sync.Pool{
+			New: func() interface{} {
+				return &Buffer{}
+			},
+		},
+	}
+}
+
+func (this *BufferPool) Get() *Buffer {
+	if this == nil || this.pool == nil {
+		return &Buffer{}
+	}
+	return this.pool.Get().(*Buffer)
+}
+
+func (this *BufferPool) Put(b *Buffer) {
+	if this != nil && this.pool != nil && b != nil {
+		this.pool.Put(b)
+	}
+}
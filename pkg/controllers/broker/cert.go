@@ -19,6 +19,7 @@
 package broker
 
 import (
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -40,6 +41,12 @@ type CertInfo struct {
 	lock  sync.RWMutex
 	roots *x509.CertPool
 	certs.CertificateSource
+
+	ticketsDisabled bool
+	ticketRotation  time.Duration
+	ticketKeys      [][32]byte
+	ticketKeyTime   time.Time
+	sessions        tls.ClientSessionCache
 }
 
 func NewCertInfo(logger logger.LogContext, source certs.CertificateSource) *CertInfo {
@@ -56,15 +63,95 @@ func NewCertInfo(logger logger.LogContext, source certs.CertificateSource) *Cert
 	return i
 }
 
+// SetSessionTickets configures TLS session resumption. If enabled,
+// the server side ticket keys are rotated after the given interval
+// (0 keeps the key for the lifetime of the process). The previous key
+// is kept for one more interval to resume sessions issued before
+// the rotation.
+func (this *CertInfo) SetSessionTickets(enabled bool, rotation time.Duration) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.ticketsDisabled = !enabled
+	this.ticketRotation = rotation
+	this.ticketKeys = nil
+	this.sessions = nil
+	if enabled {
+		this.sessions = tls.NewLRUClientSessionCache(0)
+		return this.rotateTicketKeys()
+	}
+	return nil
+}
+
+// RotateTicketKeys enforces a new session ticket key.
+func (this *CertInfo) RotateTicketKeys() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.ticketsDisabled {
+		return nil
+	}
+	return this.rotateTicketKeys()
+}
+
+func (this *CertInfo) rotateTicketKeys() error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return fmt.Errorf("cannot generate session ticket key: %s", err)
+	}
+	keys := [][32]byte{key}
+	if len(this.ticketKeys) > 0 {
+		keys = append(keys, this.ticketKeys[0])
+	}
+	this.ticketKeys = keys
+	this.ticketKeyTime = time.Now()
+	return nil
+}
+
+// TicketKeys returns the actually valid session ticket keys. The first
+// one is used to issue new tickets. Outdated keys are rotated on demand.
+func (this *CertInfo) TicketKeys() [][32]byte {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.ticketsDisabled {
+		return nil
+	}
+	if len(this.ticketKeys) == 0 || (this.ticketRotation > 0 && time.Since(this.ticketKeyTime) >= this.ticketRotation) {
+		if err := this.rotateTicketKeys(); err != nil {
+			logger.Errorf("%s", err)
+		}
+	}
+	return append([][32]byte{}, this.ticketKeys...)
+}
+
+func (this *CertInfo) setupSessionTickets(cfg *tls.Config) {
+	keys := this.TicketKeys()
+	if len(keys) == 0 {
+		cfg.SessionTicketsDisabled = true
+		return
+	}
+	cfg.SetSessionTicketKeys(keys)
+}
+
 func (this *CertInfo) UseTLS() bool {
 	return this != nil && this.CertificateSource != nil
 }
 
-func (this *CertInfo) Dial(endpoint string) (net.Conn, error) {
+// Dial connects to the given endpoint. For TLS connections the server
+// certificate must match the given server name.
+func (this *CertInfo) Dial(endpoint string, serverName string, timeout time.Duration, local *net.TCPAddr) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if local != nil {
+		dialer.LocalAddr = local
+	}
 	if this.UseTLS() {
-		return tls.Dial("tcp", endpoint, this.ClientConfig())
+		cfg := this.ClientConfig()
+		if cfg == nil {
+			return nil, fmt.Errorf("no client config")
+		}
+		cfg.ServerName = serverName
+		return tls.DialWithDialer(dialer, "tcp", endpoint, cfg)
 	} else {
-		return net.Dial("tcp", endpoint)
+		return dialer.Dial("tcp", endpoint)
 	}
 }
 
@@ -92,25 +179,29 @@ func (this *CertInfo) serverClientConfig(_ *tls.ClientHelloInfo) (*tls.Config, e
 		return nil, nil
 	}
 	this.lock.RLock()
-	defer this.lock.RUnlock()
-	return &tls.Config{
+	cfg := &tls.Config{
 		NextProtos:     []string{"h2"},
 		GetCertificate: this.GetCertificate,
 		ClientAuth:     tls.RequireAndVerifyClientCert,
 		ClientCAs:      this.roots,
-	}, nil
+	}
+	this.lock.RUnlock()
+	this.setupSessionTickets(cfg)
+	return cfg, nil
 }
 
 func (this *CertInfo) ServerConfig() *tls.Config {
 	if !this.UseTLS() {
 		return nil
 	}
-	return &tls.Config{
+	cfg := &tls.Config{
 		NextProtos:         []string{"h2"},
 		GetCertificate:     this.GetCertificate,
 		GetConfigForClient: this.serverClientConfig,
 		ClientAuth:         tls.RequireAndVerifyClientCert,
 	}
+	this.setupSessionTickets(cfg)
+	return cfg
 }
 
 func (this *CertInfo) ClientConfig() *tls.Config {
@@ -128,8 +219,9 @@ func (this *CertInfo) ClientConfig() *tls.Config {
 	defer this.lock.RUnlock()
 
 	return &tls.Config{
-		Certificates: []tls.Certificate{*cert},
-		RootCAs:      this.roots,
+		Certificates:       []tls.Certificate{*cert},
+		RootCAs:            this.roots,
+		ClientSessionCache: this.sessions,
 	}
 }
 
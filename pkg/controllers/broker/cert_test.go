@@ -0,0 +1,168 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/certmgmt"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+type testCertSource struct {
+	info certmgmt.CertificateInfo
+	cert tls.Certificate
+}
+
+func (this *testCertSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &this.cert, nil
+}
+
+func (this *testCertSource) GetCertificateInfo() certmgmt.CertificateInfo {
+	return this.info
+}
+
+// testCertInfo creates a certificate usable for both ends of a tunnel
+// for the given dns names and ip addresses signed by a new ca.
+func testCertInfo(t *testing.T, hosts ...string) *CertInfo {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			leaf.IPAddresses = append(leaf.IPAddresses, ip)
+		} else {
+			leaf.DNSNames = append(leaf.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := certmgmt.NewCertInfo(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		nil,
+	)
+	cert, err := certmgmt.GetCertificate(info)
+	if err != nil {
+		t.Fatalf("cannot load certificate: %s", err)
+	}
+	return NewCertInfo(nil, &testCertSource{info: info, cert: cert})
+}
+
+func testTLSServer(t *testing.T, certs *CertInfo) net.Listener {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", certs.ServerConfig())
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c.(*tls.Conn).Handshake()
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestDialServerName(t *testing.T) {
+	certs := testCertInfo(t, "peer.example.com", "127.0.0.1")
+	l := testTLSServer(t, certs)
+	defer l.Close()
+	endpoint := l.Addr().String()
+
+	cases := map[string]struct {
+		link  kubelink.Link
+		valid bool
+	}{
+		"address": {
+			link:  kubelink.Link{Endpoint: "backup.example.com:80"},
+			valid: true,
+		},
+		"override": {
+			link:  kubelink.Link{Endpoint: endpoint, ServerName: "peer.example.com"},
+			valid: true,
+		},
+		"mismatch": {
+			link:  kubelink.Link{Endpoint: endpoint, ServerName: "other.example.com"},
+			valid: false,
+		},
+	}
+	for name, c := range cases {
+		serverName := c.link.GetServerNameFor(endpoint)
+		conn, err := certs.Dial(endpoint, serverName, time.Second, nil)
+		if err == nil {
+			conn.Close()
+		}
+		if c.valid && err != nil {
+			t.Errorf("%s: dial with server name %q failed: %s", name, serverName, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: dial with server name %q succeeded", name, serverName)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterExtension(EXT_COMPRESSION, &CompressionExtensionHandler{})
+}
+
+// PACKET_FLAG_COMPRESSED marks a data packet with a compressed payload.
+const PACKET_FLAG_COMPRESSED = 0x80
+
+const COMPRESSION_NONE = "none"
+const COMPRESSION_DEFLATE = "deflate"
+
+// Codec ids used in the compression extension.
+const CODEC_NONE = 0
+const CODEC_DEFLATE = 1
+
+// DEFAULT_COMPRESSION_THRESHOLD is the minimal payload size for
+// compressing data packets.
+const DEFAULT_COMPRESSION_THRESHOLD = 256
+
+var codecs = map[string]byte{
+	COMPRESSION_NONE:    CODEC_NONE,
+	COMPRESSION_DEFLATE: CODEC_DEFLATE,
+}
+
+// ParseCompression maps a compression name to its codec id.
+func ParseCompression(name string) (byte, error) {
+	codec, ok := codecs[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("invalid compression %q (use %s or %s)", name, COMPRESSION_NONE, COMPRESSION_DEFLATE)
+	}
+	return codec, nil
+}
+
+// CompressionName returns the name of a codec id.
+func CompressionName(codec byte) string {
+	for n, c := range codecs {
+		if c == codec {
+			return n
+		}
+	}
+	return fmt.Sprintf("codec-%d", codec)
+}
+
+// CompressionExtension announces the payload codecs supported by
+// the sender.
+type CompressionExtension []byte
+
+var _ ConnectionHelloExtension = (*CompressionExtension)(nil)
+
+func (this *CompressionExtension) Id() byte {
+	return EXT_COMPRESSION
+}
+
+func (this *CompressionExtension) Data() []byte {
+	return []byte(*this)
+}
+
+func (this *CompressionExtension) Supports(codec byte) bool {
+	return bytes.IndexByte(*this, codec) >= 0
+}
+
+func (this *CompressionExtension) String() string {
+	names := []string{}
+	for _, c := range *this {
+		names = append(names, CompressionName(c))
+	}
+	return strings.Join(names, ",")
+}
+
+type CompressionExtensionHandler struct{}
+
+var _ ConnectionHelloExtensionHandler = &CompressionExtensionHandler{}
+
+func (this *CompressionExtensionHandler) Parse(id byte, data []byte) (ConnectionHelloExtension, error) {
+	if id != EXT_COMPRESSION {
+		return nil, fmt.Errorf("invalid extension %d for compression", id)
+	}
+	ext := CompressionExtension(append(data[:0:0], data...))
+	return &ext, nil
+}
+
+func (this *CompressionExtensionHandler) Add(hello *ConnectionHello, mux *Mux) {
+	if mux.compression != CODEC_NONE {
+		ext := CompressionExtension{mux.compression}
+		hello.Extensions[EXT_COMPRESSION] = &ext
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SetCompression enables the compression of data packets with
+// at least threshold bytes for connections to peers supporting the
+// given codec.
+func (this *Mux) SetCompression(codec byte, threshold int) {
+	this.compression = codec
+	this.compressionThreshold = threshold
+}
+
+// negotiateCompression selects the codec used for sending data packets
+// on a connection. Compression is only used if both sides announce the
+// same codec.
+func (this *TunnelConnection) negotiateCompression(remote *ConnectionHello) {
+	this.compression = CODEC_NONE
+	if this.mux.compression == CODEC_NONE {
+		return
+	}
+	if ext, ok := remote.Extensions[EXT_COMPRESSION].(*CompressionExtension); ok && ext.Supports(this.mux.compression) {
+		this.compression = this.mux.compression
+		this.Infof("using %s compression for data packets", CompressionName(this.compression))
+	}
+}
+
+// compress returns the compressed payload of a data packet, or nil
+// if the packet should be sent uncompressed. The buffer and the deflate
+// writer are reused for the connection, so it must be called with the
+// write lock held and the result is only valid until the next call.
+func (this *TunnelConnection) compress(ty byte, data []byte) []byte {
+	if ty != PACKET_TYPE_DATA || this.compression != CODEC_DEFLATE || len(data) < this.mux.compressionThreshold {
+		return nil
+	}
+	this.deflated.Reset()
+	if this.deflate == nil {
+		this.deflate, _ = flate.NewWriter(&this.deflated, flate.BestSpeed)
+	} else {
+		this.deflate.Reset(&this.deflated)
+	}
+	if _, err := this.deflate.Write(data); err != nil {
+		return nil
+	}
+	if err := this.deflate.Close(); err != nil {
+		return nil
+	}
+	if this.deflated.Len() >= len(data) {
+		return nil
+	}
+	return this.deflated.Bytes()
+}
+
+// decompress decompresses a compressed payload into the given buffer
+// and returns the size of the decompressed packet.
+func (this *TunnelConnection) decompress(compressed []byte, data []byte) (int, error) {
+	r := deflateReaders.Get().(io.ReadCloser)
+	defer deflateReaders.Put(r)
+	if err := r.(flate.Resetter).Reset(bytes.NewReader(compressed), nil); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, data)
+	if err == nil {
+		var extra [1]byte
+		if m, _ := r.Read(extra[:]); m > 0 {
+			return 0, fmt.Errorf("buffer too small (%d) for decompressed packet", len(data))
+		}
+		return n, nil
+	}
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return n, nil
+	}
+	return 0, fmt.Errorf("cannot decompress packet: %s", err)
+}
+
+var deflateReaders = sync.Pool{
+	New: func() interface{} {
+		return flate.NewReader(bytes.NewReader(nil))
+	},
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+func testCompressionPeers(t *testing.T, sender, receiver byte) (*TunnelConnection, *TunnelConnection, func()) {
+	m := testMux(t, "192.168.0.1/24")
+	m.SetCompression(CODEC_DEFLATE, 16)
+	c1, c2 := net.Pipe()
+	w := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1, compression: sender}
+	r := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c2, reader: c2, compression: receiver}
+	return w, r, func() {
+		c1.Close()
+		c2.Close()
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	w, r, done := testCompressionPeers(t, CODEC_DEFLATE, CODEC_DEFLATE)
+	defer done()
+
+	packets := [][]byte{
+		bytes.Repeat([]byte("kubelink"), 100),
+		bytes.Repeat([]byte("mesh"), 50),
+		[]byte("short"),
+	}
+	go func() {
+		for _, p := range packets {
+			if err := w.WritePacket(PACKET_TYPE_DATA, p); err != nil {
+				t.Errorf("cannot write packet: %s", err)
+			}
+		}
+	}()
+
+	data := make([]byte, BufferSize)
+	for i, expected := range packets {
+		n, ty, err := r.ReadPacket(data)
+		if err != nil {
+			t.Fatalf("packet %d: %s", i, err)
+		}
+		if ty != PACKET_TYPE_DATA || !bytes.Equal(data[:n], expected) {
+			t.Errorf("packet %d: unexpected type %d with %d bytes, expected %d bytes", i, ty, n, len(expected))
+		}
+	}
+}
+
+func TestCompressionNotNegotiated(t *testing.T) {
+	// a peer without compression in its hello gets uncompressed packets
+	w, r, done := testCompressionPeers(t, CODEC_DEFLATE, CODEC_NONE)
+	defer done()
+	w.negotiateCompression(NewConnectionHello())
+	if w.compression != CODEC_NONE {
+		t.Fatalf("compression negotiated without support of the peer")
+	}
+	packet := bytes.Repeat([]byte("kubelink"), 100)
+	go w.WritePacket(PACKET_TYPE_DATA, packet)
+	data := make([]byte, BufferSize)
+	n, _, err := r.ReadPacket(data)
+	if err != nil || !bytes.Equal(data[:n], packet) {
+		t.Fatalf("unexpected packet with %d bytes: %v", n, err)
+	}
+
+	// a compressed packet is a protocol error if compression has not
+	// been negotiated.
+	w.compression = CODEC_DEFLATE
+	go w.WritePacket(PACKET_TYPE_DATA, packet)
+	if _, _, err := r.ReadPacket(data); err == nil {
+		t.Errorf("compressed packet accepted without negotiated compression")
+	}
+}
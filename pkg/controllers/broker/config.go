@@ -20,8 +20,10 @@ package broker
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/gardener/controller-manager-library/pkg/config"
 	"github.com/gardener/controller-manager-library/pkg/resources"
@@ -44,23 +46,36 @@ type Config struct {
 	controllers.Config
 
 	address     string
+	meshCIDR    string
 	service     string
 	responsible string
 
 	ClusterAddress *net.IPNet
 	ClusterCIDR    *net.IPNet
 	ClusterName    string
+	MeshCIDR       *net.IPNet
 
-	ServiceCIDR *net.IPNet
+	ServiceCIDR        *net.IPNet
+	ServiceCIDROverlap string
+
+	endpointAllowlist []string
+	EndpointAllowlist *kubelink.EndpointAllowlist
 
 	Responsible    utils.StringSet
+	MaxLinks       int
 	Port           int
 	AdvertisedPort int
 
+	advertisedPortOverrides []string
+	AdvertisedPortOverrides PortOverrides
+
 	CertFile   string
 	KeyFile    string
 	CACertFile string
 
+	TLSSessionTickets    bool
+	TLSTicketKeyRotation time.Duration
+
 	Secret     string
 	ManageMode string
 	DNSName    string
@@ -73,6 +88,7 @@ type Config struct {
 	DNSAdvertisement bool
 
 	DNSPropagation    string
+	DNSInfoRetention  time.Duration
 	coreDNSServiceIP  string
 	CoreDNSServiceIP  net.IP
 	CoreDNSDeployment string
@@ -84,26 +100,95 @@ type Config struct {
 	ClusterDomain string
 
 	AutoConnect   bool
+	ReapAfter     time.Duration
 	DisableBridge bool
+
+	DialTimeout   time.Duration
+	DialInterface string
+	HelloTimeout  time.Duration
+
+	FailBackInterval time.Duration
+
+	MaxHandshakes         int
+	HandshakeQueueTimeout time.Duration
+	HandoffSocket         string
+	DrainTimeout          time.Duration
+	DSCP                  int
+	BufferPool            bool
+
+	TunQueues     int
+	TunTxQueueLen int
+	TunMTU        int
+
+	TrustPeerAddress bool
+	HealthProbe      bool
+	Relay            bool
+	AntiSpoofing     bool
+
+	RelayTimeExceeded    bool
+	UnreachableOnFailure bool
+
+	StrictHelloExtensions bool
+	PrematureData         string
+	IngressMode           string
+	PacketLogRate         int
+	RejectMeshMismatch    bool
+
+	Compression          string
+	CompressionCodec     byte
+	CompressionThreshold int
+
+	MeshHealth kubelink.HealthThresholds
+
+	KeepAlive               KeepAlive
+	KeepAlivePacketInterval time.Duration
+	KeepAlivePacketTimeout  time.Duration
+	TCPInfoInterval         time.Duration
+	BufferSizes             BufferSizes
+
+	UDPLivenessPort     int
+	UDPLivenessInterval time.Duration
+	UDPLivenessTimeout  time.Duration
+
+	TracingEndpoint string
+	TracingService  string
+
+	accessTokenFile string
+	AccessToken     string `redact:"true"`
+	adminTokenFile  string
+	AdminToken      string `redact:"true"`
+
+	advertisedServices []string
+	AdvertisedServices kubelink.ServiceEndpoints
 }
 
 func (this *Config) AddOptionsToSet(set config.OptionSet) {
 	this.Config.AddOptionsToSet(set)
 	set.AddStringOption(&this.service, "service-cidr", "", "", "CIDR of local service network")
+	set.AddStringOption(&this.ServiceCIDROverlap, "service-cidr-overlap", "", kubelink.OVERLAP_WARN, "Handling of links with an egress overlapping the local service cidr (ignore, warn or reject)")
+	set.AddStringArrayOption(&this.endpointAllowlist, "endpoint-allowlist", "", nil, "Link endpoint hosts allowed to be dialed (cidr, ip, host name or *.<domain>)")
 	set.AddStringOption(&this.address, "link-address", "", "", "CIDR of cluster in cluster network")
+	set.AddStringOption(&this.meshCIDR, "mesh-cidr", "", "", "CIDR of the cluster mesh network (used to validate the link address)")
 	set.AddStringOption(&this.ClusterName, "cluster-name", "", "", "Name of local cluster in cluster mesh")
 	set.AddStringOption(&this.responsible, "served-links", "", "all", "Comma separated list of links to serve")
+	set.AddIntOption(&this.MaxLinks, "max-links", "", 0, "Maximum number of links served by the broker (0 for unlimited)")
 	set.AddIntOption(&this.Port, "broker-port", "", 8088, "Port for broker")
 	set.AddIntOption(&this.AdvertisedPort, "advertised-port", "", kubelink.DEFAULT_PORT, "Advertised broker port for auto-connect")
+	set.AddStringArrayOption(&this.advertisedPortOverrides, "advertised-port-override", "", nil, "Advertised broker port for a dedicated link or peer network (<link or cidr>=<port>)")
 	set.AddStringOption(&this.CertFile, "certfile", "", "", "TLS certificate file")
 	set.AddStringOption(&this.KeyFile, "keyfile", "", "", "TLS certificate key file")
 	set.AddStringOption(&this.CACertFile, "cacertfile", "", "", "TLS ca certificate file")
+	set.AddBoolOption(&this.TLSSessionTickets, "tls-session-tickets", "", true, "Enable TLS session resumption for tunnel connections")
+	set.AddDurationOption(&this.TLSTicketKeyRotation, "tls-ticket-key-rotation", "", 12*time.Hour, "Rotation interval for TLS session ticket keys (0 for no rotation)")
 	set.AddStringOption(&this.Secret, "secret", "", "", "TLS secret")
 	set.AddBoolOption(&this.DisableBridge, "disable-bridge", "", false, "Disable network bridge")
 	set.AddStringOption(&this.ManageMode, "secret-manage-mode", "", MANAGE_MODE_NONE, "Manage mode for TLS secret")
 	set.AddStringOption(&this.DNSName, "dns-name", "", "", "DNS Name for managed certificate")
 	set.AddStringOption(&this.Service, "service", "", "", "Service name for managed certificate")
 	set.AddStringOption(&this.Interface, "ifce-name", "", "", "Name of the tun interface")
+	set.AddIntOption(&this.TunQueues, "tun-queues", "", 1, "Number of queues of the tun interface (multi queue mode if greater than 1)")
+	set.AddIntOption(&this.TunTxQueueLen, "tun-txqueuelen", "", 0, "Transmit queue length of the tun interface (0 for system default)")
+	set.AddIntOption(&this.TunMTU, "tun-mtu", "", 0, "MTU of the tun interface announced to peers (0 for system default)")
 	set.AddStringOption(&this.MeshDomain, "mesh-domain", "", "kubelink", "Base domain for cluster mesh services")
 
 	set.AddStringOption(&this.serviceAccount, "service-account", "", "", "Service Account for API Access propagation")
@@ -113,11 +198,55 @@ func (this *Config) AddOptionsToSet(set config.OptionSet) {
 	set.AddStringOption(&this.ClusterDomain, "cluster-domain", "", "cluster.local", "Cluster Domain of Cluster DNS Service (for DNS Info Propagation)")
 
 	set.AddStringOption(&this.DNSPropagation, "dns-propagation", "", "none", "Mode for accessing foreign DNS information (none, dns or kubernetes)")
+	set.AddDurationOption(&this.DNSInfoRetention, "dns-info-retention", "", 0, "Retention time of propagated foreign DNS info without refresh (0 for no expiry)")
 	set.AddStringOption(&this.coreDNSServiceIP, "coredns-service-ip", "", "", "Service IP of coredns deployment used by kubelink")
 	set.AddStringOption(&this.CoreDNSDeployment, "coredns-deployment", "", "kubelink-coredns", "Name of coredns deployment used by kubelink")
 	set.AddStringOption(&this.CoreDNSSecret, "coredns-secret", "", "kubelink-coredns", "Name of dns secret used by kubelink")
 	set.AddBoolOption(&this.CoreDNSConfigure, "coredns-configure", "", false, "Enable automatic configuration of cluster DNS (coredns)")
+	set.AddStringOption(&this.TracingEndpoint, "tracing-endpoint", "", "", "OTLP/HTTP endpoint of an OpenTelemetry collector for connection lifecycle traces (tracing disabled if not set)")
+	set.AddStringOption(&this.TracingService, "tracing-service-name", "", "kubelink", "Service name used for exported traces")
+	set.AddStringOption(&this.accessTokenFile, "access-api-token-file", "", "", "File containing the bearer token required for the link access api (api disabled if not set)")
+	set.AddStringOption(&this.adminTokenFile, "admin-api-token-file", "", "", "File containing the bearer token required for administrative requests like a cluster address rotation")
 	set.AddBoolOption(&this.AutoConnect, "auto-connect", "", false, "Automatically register cluster for authenticated incoming requests")
+	set.AddDurationOption(&this.ReapAfter, "auto-connect-reap-after", "", 0, "Remove auto-registered links whose connection is down for this grace period (0 to keep them)")
+	set.AddDurationOption(&this.DialTimeout, "broker-dial-timeout", "", 30*time.Second, "Timeout for dialing a tunnel connection (0 for none)")
+	set.AddStringOption(&this.DialInterface, "dial-interface", "", "", "Default local interface used for outbound tunnel connections")
+	set.AddDurationOption(&this.FailBackInterval, "failback-interval", "", time.Minute, "Interval for probing the primary endpoint of links connected via a failover endpoint (0 to disable)")
+	set.AddDurationOption(&this.HelloTimeout, "broker-hello-timeout", "", 10*time.Second, "Timeout for the hello exchange of a tunnel connection (0 for none)")
+	set.AddIntOption(&this.MaxHandshakes, "max-pending-handshakes", "", 64, "Maximum number of concurrently pending handshakes of incoming tunnel connections (0 for no limit)")
+	set.AddDurationOption(&this.HandshakeQueueTimeout, "handshake-queue-timeout", "", 2*time.Second, "Time an incoming connection waits for a free handshake slot before it is rejected")
+	set.AddStringOption(&this.HandoffSocket, "handoff-socket", "", "", "Unix socket used to hand off the broker listener to a successor process for a graceful restart")
+	set.AddDurationOption(&this.DrainTimeout, "drain-timeout", "", 30*time.Second, "Maximum time to wait for active tunnel connections after a listener handoff")
+	set.AddBoolOption(&this.TrustPeerAddress, "trust-peer-address", "", false, "Update the cluster address of a link on a mismatch reported by an authenticated peer")
+	set.AddBoolOption(&this.HealthProbe, "health-probe", "", true, "Answer http health probes on plaintext connections to the broker port")
+	set.AddBoolOption(&this.Relay, "broker-relay", "", false, "Relay packets not destined for the local cluster to the link providing an appropriate egress")
+	set.AddBoolOption(&this.RelayTimeExceeded, "broker-relay-time-exceeded", "", true, "Send ICMP time exceeded messages for relayed packets dropped because of an expired ttl")
+	set.AddBoolOption(&this.UnreachableOnFailure, "unreachable-on-failure", "", false, "Replace the routes to a link by unreachable routes while its tunnel connection is failing")
+	set.AddBoolOption(&this.AntiSpoofing, "anti-spoofing", "", false, "Drop packets received from the tun device with a source address outside the mesh and link egress ranges")
+	set.AddBoolOption(&this.RejectMeshMismatch, "reject-mesh-mismatch", "", false, "Reject tunnel connections of peers advertising a narrower or wider mesh range than the local one")
+	set.AddIntOption(&this.MeshHealth.Degraded, "mesh-degraded-percent", "", 0, "Percentage of failed mesh members above which a mesh is degraded")
+	set.AddIntOption(&this.MeshHealth.Critical, "mesh-critical-percent", "", 50, "Percentage of failed mesh members from which on a mesh is critical")
+	set.AddIntOption(&this.PacketLogRate, "packet-log-rate", "", 10, "Maximum number of per packet debug log entries per second (0 to disable)")
+	set.AddStringOption(&this.PrematureData, "premature-data", "", PREMATURE_DATA_REJECT, "Handling of data packets received before the hello handshake (reject or drop)")
+	set.AddStringOption(&this.Compression, "compression", "", COMPRESSION_NONE, "Compression of data packets on tunnel connections to peers supporting it (none or deflate)")
+	set.AddIntOption(&this.CompressionThreshold, "compression-threshold", "", DEFAULT_COMPRESSION_THRESHOLD, "Minimal size of data packets to be compressed")
+	set.AddStringOption(&this.IngressMode, "ingress-mode", "", kubelink.INGRESS_ENFORCE, "Default handling of packets denied by the ingress of a link (enforce or audit)")
+	set.AddBoolOption(&this.StrictHelloExtensions, "strict-hello-extensions", "", false, "Reject tunnel connections announcing hello extensions not understood by the broker")
+	set.AddBoolOption(&this.BufferPool, "buffer-pool", "", true, "Reuse packet buffers to reduce allocations")
+	set.AddBoolOption(&this.KeepAlive.Enabled, "tcp-keepalive", "", true, "Enable tcp keepalive probing for tunnel connections")
+	set.AddDurationOption(&this.KeepAlive.Idle, "tcp-keepalive-idle", "", 30*time.Second, "Idle time of a tunnel connection before sending tcp keepalive probes")
+	set.AddDurationOption(&this.KeepAlive.Interval, "tcp-keepalive-interval", "", 10*time.Second, "Interval between tcp keepalive probes")
+	set.AddIntOption(&this.KeepAlive.Count, "tcp-keepalive-count", "", 3, "Number of unanswered tcp keepalive probes before a tunnel connection is dropped")
+	set.AddDurationOption(&this.KeepAlivePacketInterval, "keepalive-packet-interval", "", 0, "Interval for sending keepalive packets on tunnel connections (0 to disable)")
+	set.AddDurationOption(&this.KeepAlivePacketTimeout, "keepalive-packet-timeout", "", 30*time.Second, "Time without received packets after which a tunnel connection of a peer sending keepalives is dropped (0 to disable)")
+	set.AddIntOption(&this.UDPLivenessPort, "udp-liveness-port", "", 0, "UDP port used for heartbeats checking the liveness of tunnel peers (0 to disable)")
+	set.AddDurationOption(&this.UDPLivenessInterval, "udp-liveness-interval", "", time.Second, "Interval for sending udp liveness heartbeats")
+	set.AddDurationOption(&this.UDPLivenessTimeout, "udp-liveness-timeout", "", 5*time.Second, "Time without heartbeat answers after which an outbound tunnel connection is dropped")
+	set.AddDurationOption(&this.TCPInfoInterval, "tcp-info-interval", "", 30*time.Second, "Interval for sampling the tcp info of tunnel connections for quality metrics (0 to disable)")
+	set.AddIntOption(&this.BufferSizes.Socket, "socket-buffer-size", "", 0, "Default socket buffer size for tunnel connections (0 for system default)")
+	set.AddIntOption(&this.BufferSizes.Read, "read-buffer-size", "", 0, "Default application read buffer size for tunnel connections (0 for unbuffered reads)")
+	set.AddIntOption(&this.DSCP, "dscp", "", 0, "Default DSCP value used for tunnel connections")
+	set.AddStringArrayOption(&this.advertisedServices, "advertised-services", "", nil, "Local service endpoints (<ip>[:<port>[/<protocol>]]) advertised to the mesh")
 }
 
 func (this *Config) Prepare() error {
@@ -133,11 +262,36 @@ func (this *Config) Prepare() error {
 	this.ClusterCIDR = cidr
 	this.ClusterAddress = tcp.CIDRIP(cidr, ip)
 
+	_, this.MeshCIDR, err = this.OptionalCIDR(this.meshCIDR, "mesh-cidr")
+	if err != nil {
+		return err
+	}
+	err = this.ValidateClusterAddress()
+	if err != nil {
+		return err
+	}
+
 	_, this.ServiceCIDR, err = this.OptionalCIDR(this.service, "service-cidr")
 	if err != nil {
 		return err
 	}
+	this.ServiceCIDROverlap = strings.ToLower(this.ServiceCIDROverlap)
+	switch this.ServiceCIDROverlap {
+	case kubelink.OVERLAP_IGNORE, kubelink.OVERLAP_WARN, kubelink.OVERLAP_REJECT:
+	default:
+		return fmt.Errorf("invalid service cidr overlap mode: %s", this.ServiceCIDROverlap)
+	}
+	this.EndpointAllowlist, err = kubelink.ParseEndpointAllowlist(this.endpointAllowlist)
+	if err != nil {
+		return err
+	}
 
+	if this.ReapAfter < 0 {
+		return fmt.Errorf("invalid reap grace period %s", this.ReapAfter)
+	}
+	if this.FailBackInterval < 0 {
+		return fmt.Errorf("invalid failback interval %s", this.FailBackInterval)
+	}
 	if this.AutoConnect {
 		if this.ServiceCIDR == nil {
 			return fmt.Errorf("auto-connect requires local service cidr")
@@ -206,7 +360,7 @@ func (this *Config) Prepare() error {
 	if this.dnsServiceIP != "" {
 		this.DNSServiceIP = net.ParseIP(this.dnsServiceIP)
 		if this.DNSServiceIP == nil {
-			return fmt.Errorf("invalid ip of coredns service: %s", this.coreDNSServiceIP)
+			return fmt.Errorf("invalid ip of dns service: %s", this.dnsServiceIP)
 		}
 	}
 	if this.DNSServiceIP == nil {
@@ -221,9 +375,173 @@ func (this *Config) Prepare() error {
 	default:
 		return fmt.Errorf("invalid dns mode: %s", this.DNSPropagation)
 	}
+	if this.DNSServiceIP == nil {
+		if this.DNSPropagation == DNSMODE_DNS {
+			return fmt.Errorf("dns propagation mode %q requires a dns service ip: set dns-service-ip or service-cidr", this.DNSPropagation)
+		}
+		if this.DNSAdvertisement {
+			return fmt.Errorf("dns advertisement requires a dns service ip: set dns-service-ip or service-cidr")
+		}
+	}
+
+	if this.accessTokenFile != "" {
+		data, err := ioutil.ReadFile(this.accessTokenFile)
+		if err != nil {
+			return fmt.Errorf("cannot read access api token: %s", err)
+		}
+		this.AccessToken = strings.TrimSpace(string(data))
+		if this.AccessToken == "" {
+			return fmt.Errorf("empty access api token in %s", this.accessTokenFile)
+		}
+	}
+	if this.adminTokenFile != "" {
+		data, err := ioutil.ReadFile(this.adminTokenFile)
+		if err != nil {
+			return fmt.Errorf("cannot read admin api token: %s", err)
+		}
+		this.AdminToken = strings.TrimSpace(string(data))
+		if this.AdminToken == "" {
+			return fmt.Errorf("empty admin api token in %s", this.adminTokenFile)
+		}
+		if this.AdminToken == this.AccessToken {
+			return fmt.Errorf("admin api token must differ from access api token")
+		}
+	}
+
+	if this.KeepAlive.Idle < 0 || this.KeepAlive.Interval < 0 || this.KeepAlive.Count < 0 {
+		return fmt.Errorf("invalid tcp keepalive settings")
+	}
+	if this.KeepAlive.Interval > 0 && this.KeepAlive.Interval < time.Second {
+		return fmt.Errorf("tcp keepalive interval must be at least 1s")
+	}
+	if this.KeepAlivePacketInterval < 0 || this.KeepAlivePacketTimeout < 0 {
+		return fmt.Errorf("invalid keepalive packet settings")
+	}
+	if this.KeepAlivePacketInterval > 0 && this.KeepAlivePacketTimeout > 0 && this.KeepAlivePacketTimeout < 2*this.KeepAlivePacketInterval {
+		return fmt.Errorf("keepalive packet timeout %s must be at least twice the interval %s", this.KeepAlivePacketTimeout, this.KeepAlivePacketInterval)
+	}
+	if this.UDPLivenessPort < 0 || this.UDPLivenessPort > 65535 {
+		return fmt.Errorf("invalid udp liveness port %d", this.UDPLivenessPort)
+	}
+	if this.UDPLivenessPort > 0 {
+		if this.UDPLivenessInterval <= 0 {
+			return fmt.Errorf("invalid udp liveness interval %s", this.UDPLivenessInterval)
+		}
+		if this.UDPLivenessTimeout < 2*this.UDPLivenessInterval {
+			return fmt.Errorf("udp liveness timeout %s must be at least twice the interval %s", this.UDPLivenessTimeout, this.UDPLivenessInterval)
+		}
+	}
+	if this.TCPInfoInterval < 0 {
+		return fmt.Errorf("tcp info interval must not be negative")
+	}
+
+	if this.BufferSizes.Socket < 0 || this.BufferSizes.Read < 0 {
+		return fmt.Errorf("invalid buffer sizes")
+	}
+	if this.MaxLinks < 0 {
+		return fmt.Errorf("invalid maximum number of links %d", this.MaxLinks)
+	}
+	if this.MaxHandshakes < 0 {
+		return fmt.Errorf("invalid number of pending handshakes %d", this.MaxHandshakes)
+	}
+	if this.HandshakeQueueTimeout < 0 {
+		return fmt.Errorf("invalid handshake queue timeout %s", this.HandshakeQueueTimeout)
+	}
+	if this.MeshHealth.Degraded < 0 || this.MeshHealth.Critical > 100 || this.MeshHealth.Degraded > this.MeshHealth.Critical {
+		return fmt.Errorf("invalid mesh health thresholds: degraded %d%%, critical %d%%", this.MeshHealth.Degraded, this.MeshHealth.Critical)
+	}
+	if this.PacketLogRate < 0 {
+		return fmt.Errorf("invalid packet log rate %d", this.PacketLogRate)
+	}
+	this.IngressMode = strings.ToLower(this.IngressMode)
+	switch this.IngressMode {
+	case kubelink.INGRESS_ENFORCE, kubelink.INGRESS_AUDIT:
+	default:
+		return fmt.Errorf("invalid ingress mode %q", this.IngressMode)
+	}
+	this.CompressionCodec, err = ParseCompression(this.Compression)
+	if err != nil {
+		return err
+	}
+	if this.CompressionThreshold < 0 {
+		return fmt.Errorf("invalid compression threshold %d", this.CompressionThreshold)
+	}
+	this.PrematureData = strings.ToLower(this.PrematureData)
+	switch this.PrematureData {
+	case PREMATURE_DATA_REJECT, PREMATURE_DATA_DROP:
+	default:
+		return fmt.Errorf("invalid premature data mode: %s", this.PrematureData)
+	}
+	if this.DrainTimeout < 0 {
+		return fmt.Errorf("invalid drain timeout %s", this.DrainTimeout)
+	}
+
+	if this.TLSTicketKeyRotation < 0 {
+		return fmt.Errorf("invalid tls ticket key rotation interval %s", this.TLSTicketKeyRotation)
+	}
+
+	if this.DSCP < 0 || this.DSCP > kubelink.MAX_DSCP {
+		return fmt.Errorf("invalid dscp value %d: must be between 0 and %d", this.DSCP, kubelink.MAX_DSCP)
+	}
+
+	if this.TunQueues < 1 {
+		return fmt.Errorf("invalid number of tun queues %d: must be at least 1", this.TunQueues)
+	}
+	if this.TunTxQueueLen < 0 {
+		return fmt.Errorf("invalid tun tx queue length %d", this.TunTxQueueLen)
+	}
+	if this.TunMTU != 0 && (this.TunMTU < 576 || this.TunMTU > BufferSize) {
+		return fmt.Errorf("invalid tun mtu %d: must be between 576 and %d", this.TunMTU, BufferSize)
+	}
+
+	this.AdvertisedPortOverrides, err = ParsePortOverrides(this.advertisedPortOverrides)
+	if err != nil {
+		return err
+	}
+
+	this.AdvertisedServices, err = kubelink.ParseServiceEndpoints(this.advertisedServices)
+	if err != nil {
+		return fmt.Errorf("invalid advertised services: %s", err)
+	}
+	return nil
+}
+
+// ValidateClusterAddress checks that the local cluster address is located
+// in the mesh network (if configured) and is not a reserved address.
+func (this *Config) ValidateClusterAddress() error {
+	ip := this.ClusterAddress.IP
+	nets := []*net.IPNet{this.ClusterCIDR}
+	if this.MeshCIDR != nil {
+		if !this.MeshCIDR.Contains(ip) {
+			return fmt.Errorf("link address %s not in mesh cidr %s", ip, this.MeshCIDR)
+		}
+		if !this.MeshCIDR.Contains(this.ClusterCIDR.IP) || !this.MeshCIDR.Contains(tcp.BroadcastIP(this.ClusterCIDR)) {
+			return fmt.Errorf("link cidr %s not in mesh cidr %s", this.ClusterCIDR, this.MeshCIDR)
+		}
+		nets = append(nets, this.MeshCIDR)
+	}
+	for _, n := range nets {
+		if ones, bits := n.Mask.Size(); bits-ones < 2 {
+			continue
+		}
+		if ip.Equal(n.IP) {
+			return fmt.Errorf("link address %s is the network address of %s", ip, n)
+		}
+		if ip.Equal(tcp.BroadcastIP(n)) {
+			return fmt.Errorf("link address %s is the broadcast address of %s", ip, n)
+		}
+	}
 	return nil
 }
 
+func (this *Config) TunOptions() TunOptions {
+	return TunOptions{
+		Queues:     this.TunQueues,
+		TxQueueLen: this.TunTxQueueLen,
+		MTU:        this.TunMTU,
+	}
+}
+
 func (this *Config) MatchLink(obj *v1alpha1.KubeLink) (bool, net.IP) {
 	ip, _, err := net.ParseCIDR(obj.Spec.ClusterAddress)
 	if err != nil {
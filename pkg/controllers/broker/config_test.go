@@ -0,0 +1,172 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/config"
+	"github.com/spf13/pflag"
+
+	"github.com/mandelsoft/kubelink/pkg/controllers"
+)
+
+func TestValidateClusterAddress(t *testing.T) {
+	cases := map[string]struct {
+		address string
+		mesh    string
+		valid   bool
+	}{
+		"valid":              {address: "192.168.0.11/24", mesh: "192.168.0.0/16", valid: true},
+		"without mesh":       {address: "192.168.0.11/24", valid: true},
+		"outside of mesh":    {address: "192.168.0.11/24", mesh: "10.0.0.0/16", valid: false},
+		"cidr exceeds mesh":  {address: "192.168.0.11/16", mesh: "192.168.0.0/24", valid: false},
+		"network address":    {address: "192.168.0.0/24", valid: false},
+		"broadcast address":  {address: "192.168.0.255/24", valid: false},
+		"mesh network":       {address: "192.168.0.0/31", mesh: "192.168.0.0/16", valid: false},
+		"mesh broadcast":     {address: "192.168.255.255/31", mesh: "192.168.0.0/16", valid: false},
+		"point to point /31": {address: "192.168.0.0/31", valid: true},
+	}
+	for name, c := range cases {
+		ip, cidr, err := net.ParseCIDR(c.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{
+			ClusterAddress: &net.IPNet{IP: ip, Mask: cidr.Mask},
+			ClusterCIDR:    cidr,
+		}
+		if c.mesh != "" {
+			_, cfg.MeshCIDR, _ = net.ParseCIDR(c.mesh)
+		}
+		err = cfg.ValidateClusterAddress()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: invalid address %s accepted", name, c.address)
+		}
+	}
+}
+
+func testConfig(t *testing.T, args ...string) *Config {
+	cfg, err := testPrepareConfig(t, args...)
+	if err != nil {
+		t.Fatalf("invalid config: %s", err)
+	}
+	return cfg
+}
+
+// testPrepareConfig evaluates the given arguments and returns the
+// config together with the result of its preparation.
+func testPrepareConfig(t *testing.T, args ...string) (*Config, error) {
+	cfg := &Config{}
+	set := config.NewDefaultOptionSet("test", "")
+	cfg.AddOptionsToSet(set)
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	set.AddToFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatalf("invalid arguments: %s", err)
+	}
+	if err := set.Evaluate(); err != nil {
+		t.Fatalf("cannot evaluate options: %s", err)
+	}
+	return cfg, cfg.Prepare()
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubelink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	token := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(token, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(t,
+		"--node-cidr=10.250.0.0/16",
+		"--ipip=none",
+		"--link-address=192.168.0.11/24",
+		"--service-cidr=100.64.1.0/24",
+		"--service-account=kubelink",
+		"--dns-propagation=DNS",
+		"--access-api-token-file="+token,
+	)
+	effective := controllers.EffectiveConfig(cfg)
+	expected := map[string]interface{}{
+		"DNSServiceIP":   "100.64.1.10",
+		"ServiceAccount": "kube-system/kubelink",
+		"DNSPropagation": DNSMODE_DNS,
+		"ClusterAddress": "192.168.0.11/24",
+		"NodeCIDR":       "10.250.0.0/16",
+		"AccessToken":    controllers.REDACTED,
+	}
+	for k, v := range expected {
+		if effective[k] != v {
+			t.Errorf("%s: got %v, expected %v", k, effective[k], v)
+		}
+	}
+	data, err := json.Marshal(effective)
+	if err != nil {
+		t.Fatalf("cannot marshal effective config: %s", err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("token not redacted: %s", data)
+	}
+}
+
+func TestDNSServiceIPDerivation(t *testing.T) {
+	base := []string{"--node-cidr=10.250.0.0/16", "--ipip=none", "--link-address=192.168.0.11/24"}
+	cases := map[string]struct {
+		args  []string
+		ip    string
+		valid bool
+	}{
+		"derived":             {[]string{"--dns-propagation=dns", "--service-cidr=100.64.1.0/24"}, "100.64.1.10", true},
+		"explicit":            {[]string{"--dns-propagation=dns", "--dns-service-ip=100.64.1.53"}, "100.64.1.53", true},
+		"missing":             {[]string{"--dns-propagation=dns"}, "", false},
+		"advertisement":       {[]string{"--dns-advertisement"}, "", false},
+		"kubernetes":          {[]string{"--dns-propagation=kubernetes"}, "", true},
+		"without propagation": {nil, "", true},
+	}
+	for name, c := range cases {
+		cfg, err := testPrepareConfig(t, append(base, c.args...)...)
+		if !c.valid {
+			if err == nil || !strings.Contains(err.Error(), "dns service ip") {
+				t.Errorf("%s: unexpected result %v", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		if c.ip != "" && !cfg.DNSServiceIP.Equal(net.ParseIP(c.ip)) {
+			t.Errorf("%s: got dns service ip %s, expected %s", name, cfg.DNSServiceIP, c.ip)
+		}
+	}
+}
@@ -19,7 +19,10 @@
 package broker
 
 import (
+	"bytes"
+	"compress/flate"
 	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
@@ -30,6 +33,7 @@ import (
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"github.com/gardener/controller-manager-library/pkg/utils"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 
 	"github.com/mandelsoft/kubelink/pkg/kubelink"
 	"github.com/mandelsoft/kubelink/pkg/tcp"
@@ -40,11 +44,20 @@ const BufferSize = 17000
 // Packet types:
 // 0: Normal data payload
 // 1: Hello message
+// 2: Keepalive (no payload)
+// 3: Goodbye (no payload), sender is shutting down the connection
+// The flag 0x80 marks data packets with a compressed payload.
 // More types planned for intermediate transfer of meta information
 // Unknown packets have to be skipped and returned with reject bit set
 
 const PACKET_TYPE_DATA = 0
 const PACKET_TYPE_HELLO = 1
+const PACKET_TYPE_KEEPALIVE = 2
+const PACKET_TYPE_GOODBYE = 3
+
+// Handling of data packets received before the hello handshake.
+const PREMATURE_DATA_REJECT = "reject"
+const PREMATURE_DATA_DROP = "drop"
 
 ////////////////////////////////////////////////////////////////////////////////
 
@@ -53,61 +66,251 @@ type ConnectionFailHandler interface {
 }
 
 type TunnelConnection struct {
+	lastReceived int64 // unix nano, accessed atomically
+
 	logger.LogContext
 	lock          sync.RWMutex
 	mux           *Mux
 	conn          net.Conn
+	reader        io.Reader
 	clusterCIDR   *net.IPNet
+	previous      *net.IPNet
+	linkName      string
+	stats         *kubelink.LinkStats
 	remoteAddress string
+	outbound      bool
+	dnsPropagated bool
+	extensions    NegotiatedExtensions
+	quality       *ConnectionQuality
+	abort         error
+	mtu           int
+	compression   byte
+	deflate       *flate.Writer
+	deflated      bytes.Buffer
+	security      ConnectionSecurity
 	handlers      []ConnectionFailHandler
 
+	livenessMonitor *LivenessMonitor
+
 	wlock sync.Mutex
 	rlock sync.Mutex
 }
 
-func NewTunnelConnection(mux *Mux, conn net.Conn, link *kubelink.Link, handlers ...ConnectionFailHandler) (*TunnelConnection, *ConnectionHello, error) {
+func NewTunnelConnection(mux *Mux, conn net.Conn, link *kubelink.Link, outbound bool, handlers ...ConnectionFailHandler) (*TunnelConnection, *ConnectionHello, error) {
 	remote := conn.RemoteAddr().String()
 	t := &TunnelConnection{
 		LogContext:    mux.NewContext("source", remote),
 		mux:           mux,
 		conn:          conn,
 		remoteAddress: remote,
+		outbound:      outbound,
 		handlers:      append(handlers[:0:0], handlers...),
 	}
+	dscp := mux.dscp
 	if link != nil {
 		t.clusterCIDR = link.ClusterAddress
+		if link.DSCP != nil {
+			dscp = *link.DSCP
+		}
+	}
+	if dscp > 0 {
+		if err := SetDSCP(conn, dscp); err != nil {
+			t.Warnf("cannot set dscp %d: %s", dscp, err)
+		}
+	}
+	if err := SetKeepAlive(conn, mux.keepalive); err != nil {
+		t.Warnf("cannot set tcp keepalive: %s", err)
+	}
+	buffers := mux.buffersizes.ForLink(link)
+	if err := SetSocketBuffers(conn, buffers.Socket); err != nil {
+		t.Warnf("cannot set socket buffer size %d: %s", buffers.Socket, err)
+	}
+	t.reader = newReader(conn, buffers.Read)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		t.security = NewConnectionSecurity(&state)
 	}
 
-	hello, err := t.handshake()
+	_, secure := conn.(*tls.Conn)
+	plaintext := !secure && mux.certInfo.UseTLS()
+	acceptPlaintext := func(hello *ConnectionHello) error {
+		cidr := hello.GetClusterCIDR()
+		if link == nil {
+			link = mux.links.GetLinkForClusterAddress(cidr.IP)
+		}
+		if link == nil || !link.Plaintext {
+			return fmt.Errorf("plaintext connection for cluster address %s rejected", cidr.IP)
+		}
+		t.clusterCIDR = link.ClusterAddress
+		return nil
+	}
+
+	var identify func(*ConnectionHello) (*kubelink.Link, error)
+	if !outbound {
+		// the peer of an inbound connection must be identified before
+		// anything is sent: an unauthenticated peer must be accepted
+		// and the hello is filtered according to the link.
+		identify = func(hello *ConnectionHello) (*kubelink.Link, error) {
+			if plaintext {
+				return link, acceptPlaintext(hello)
+			}
+			if link != nil {
+				return link, nil
+			}
+			return mux.links.GetLinkForClusterAddress(hello.GetClusterAddress()), nil
+		}
+	}
+	hello, err := t.handshake(link, identify)
 	if err != nil {
-		return nil, nil, err
+		return nil, hello, err
 	}
 	if hello != nil {
 		cidr := hello.GetClusterCIDR()
+		if plaintext {
+			if err := acceptPlaintext(hello); err != nil {
+				return nil, hello, err
+			}
+		}
 		if !net.IPv6zero.Equal(cidr.IP) {
+			own := mux.GetClusterAddress()
 			if link != nil {
 				if !link.ClusterAddress.IP.Equal(cidr.IP) {
-					return nil, hello, fmt.Errorf("cluster address mismatch: got %s but expected %s", cidr.IP, link.ClusterAddress.IP)
+					if err := mux.checkPeerAddress(isAuthenticated(conn), link.ClusterAddress.IP, cidr.IP); err != nil {
+						return nil, hello, err
+					}
+					t.Warnf("trusting authenticated peer: cluster address changed from %s to %s", link.ClusterAddress.IP, cidr.IP)
+					t.previous = link.ClusterAddress
+					t.clusterCIDR = tcp.CIDRIP(link.ClusterAddress, cidr.IP)
+					if mux.addressHandler != nil {
+						go mux.addressHandler.UpdateClusterAddress(link.ClusterAddress.IP, cidr.IP)
+					}
 				}
 			}
-			if !cidr.Contains(mux.clusterAddr.IP) {
+			if !cidr.Contains(own.IP) {
 				// obsolete when we support unidirectional connections
-				return nil, hello, fmt.Errorf("cluster address mismatch: own address %s not in foreign range", mux.clusterAddr.IP, cidr)
+				return nil, hello, fmt.Errorf("cluster address mismatch: own address %s not in foreign range %s", own.IP, cidr)
 			}
-			if !mux.clusterAddr.Contains(cidr.IP) {
-				return nil, hello, fmt.Errorf("cluster address mismatch: remote address %s not in local range", cidr.IP, mux.clusterAddr)
+			if !own.Contains(cidr.IP) {
+				return nil, hello, fmt.Errorf("cluster address mismatch: remote address %s not in local range %s", cidr.IP, own)
+			}
+			if prefixLen(cidr) != prefixLen(own) {
+				msg := fmt.Sprintf("mesh range mismatch: remote %s advertises %s, but local range is %s", cidr.IP, tcp.CIDRNet(cidr), tcp.CIDRNet(own))
+				if mux.rejectMaskMismatch {
+					return nil, hello, fmt.Errorf("%s", msg)
+				}
+				t.Warnf("%s", msg)
 			}
 		}
-		if mux.connectionHandler != nil {
-			t.Infof("start hello handling....")
-			go mux.connectionHandler.UpdateAccess(hello)
-		}
+		t.handleHello(hello)
+		t.startKeepAlive(hello)
 	}
+	t.updateStats()
 	return t, hello, nil
 }
 
+// prefixLen returns the prefix length of a cidr independent of the
+// representation of an IPv4 mask.
+func prefixLen(cidr *net.IPNet) int {
+	ones, bits := cidr.Mask.Size()
+	if bits == net.IPv6len*8 && cidr.IP.To4() != nil {
+		ones -= (net.IPv6len - net.IPv4len) * 8
+	}
+	return ones
+}
+
+func (this *TunnelConnection) handleHello(hello *ConnectionHello) {
+	this.lock.Lock()
+	this.dnsPropagated = hello.Extensions[EXT_DNS] != nil
+	this.extensions = hello.Negotiated()
+	this.lock.Unlock()
+	if this.mux.connectionHandler != nil {
+		this.Infof("start hello handling....")
+		go this.mux.connectionHandler.UpdateAccess(hello)
+	}
+	if this.mux.serviceHandler != nil {
+		var services kubelink.ServiceEndpoints
+		if ext := hello.Extensions[EXT_SERVICES]; ext != nil {
+			services = kubelink.ServiceEndpoints(*ext.(*ServiceExtension))
+		}
+		go this.mux.serviceHandler.UpdateServices(hello.GetClusterAddress(), services)
+	}
+}
+
+// updateHello handles a hello update sent on an established connection.
+func (this *TunnelConnection) updateHello(hello *ConnectionHello) {
+	ip := hello.GetClusterAddress()
+	cidr := this.ClusterCIDR()
+	if cidr != nil && !net.IPv6zero.Equal(ip) && !cidr.IP.Equal(ip) {
+		if !tcp.CIDRNet(cidr).Contains(ip) {
+			this.Errorf("announced cluster address %s not in mesh %s", ip, tcp.CIDRNet(cidr))
+			return
+		}
+		if err := this.mux.checkPeerAddress(isAuthenticated(this.conn), cidr.IP, ip); err != nil {
+			this.Errorf("rejecting address rotation: %s", err)
+			return
+		}
+		this.mux.UpdateRemoteAddress(this, ip)
+	}
+	this.handleHello(hello)
+}
+
+// ClusterCIDR returns the actual cluster address of the peer.
+func (this *TunnelConnection) ClusterCIDR() *net.IPNet {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.clusterCIDR
+}
+
+// link returns the actual link object for the connection.
+func (this *TunnelConnection) link() *kubelink.Link {
+	cidr := this.ClusterCIDR()
+	if cidr == nil {
+		return nil
+	}
+	return this.mux.links.GetLinkForClusterAddress(cidr.IP)
+}
+
+// linkStats returns the traffic counters of the link of the connection.
+// They are resolved once for the connection and refreshed on link
+// updates, so the link need not be looked up for every packet.
+func (this *TunnelConnection) linkStats() *kubelink.LinkStats {
+	_, stats := this.linkInfo()
+	return stats
+}
+
+// linkInfo returns the name and the traffic counters of the link
+// of the connection.
+func (this *TunnelConnection) linkInfo() (string, *kubelink.LinkStats) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.linkName, this.stats
+}
+
+// updateStats resolves the traffic counters for the actual link
+// of the connection.
+func (this *TunnelConnection) updateStats() {
+	name := ""
+	var stats *kubelink.LinkStats
+	if l := this.link(); l != nil {
+		name = l.Name
+		stats = l.Stats
+	}
+	this.lock.Lock()
+	this.linkName = name
+	this.stats = stats
+	this.lock.Unlock()
+}
+
+// remoteIP returns the ip address of the remote side of the connection.
+func (this *TunnelConnection) remoteIP() net.IP {
+	if addr, ok := this.conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
 func (this *TunnelConnection) String() string {
-	return fmt.Sprintf("%s[%s]", this.clusterCIDR, this.remoteAddress)
+	return fmt.Sprintf("%s[%s]", this.ClusterCIDR(), this.remoteAddress)
 }
 
 func (this *TunnelConnection) RegisterStateHandler(handlers ...ConnectionFailHandler) {
@@ -123,12 +326,22 @@ func (this *TunnelConnection) notify(err error) {
 	}
 	this.mux.Notify(this, err)
 	this.lock.RLock()
-	defer this.lock.RUnlock()
-	for _, h := range this.handlers {
+	handlers := this.handlers
+	this.lock.RUnlock()
+	for _, h := range handlers {
 		h.Notify(this, err)
 	}
 }
 
+// isAuthenticated checks whether the peer of a connection is
+// authenticated by a certificate.
+func isAuthenticated(conn net.Conn) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return len(tlsConn.ConnectionState().PeerCertificates) > 0
+	}
+	return false
+}
+
 func printConnState(log logger.LogContext, state tls.ConnectionState) {
 	log.Info(">>>>>>>>>>>>>>>> State <<<<<<<<<<<<<<<<")
 	log.Infof("Version: %x", state.Version)
@@ -160,15 +373,39 @@ func (this *TunnelConnection) writeHello(hello *ConnectionHello) error {
 }
 
 func (this *TunnelConnection) readHello() (*ConnectionHello, error) {
-	var buffer [BufferSize]byte
-	n, ty, err := this.ReadPacket(buffer[:])
-	if err != nil {
-		return nil, err
-	}
-	if ty != PACKET_TYPE_HELLO {
-		return nil, fmt.Errorf("unexpected packet %d instead of hello handshake", ty)
+	buffer := this.mux.buffers.Get()
+	defer this.mux.buffers.Put(buffer)
+	dropped := 0
+	for {
+		n, ty, err := this.ReadPacket(buffer[:BufferSize])
+		if err != nil {
+			return nil, err
+		}
+		switch ty {
+		case PACKET_TYPE_HELLO:
+			if dropped > 0 {
+				this.Infof("dropped %d data packets received before hello handshake", dropped)
+			}
+			return this.parseHelloPacket(buffer[:n])
+		case PACKET_TYPE_KEEPALIVE:
+			continue
+		case PACKET_TYPE_GOODBYE:
+			return nil, fmt.Errorf("peer is shutting down")
+		case PACKET_TYPE_DATA:
+			if this.mux.prematureData == PREMATURE_DATA_DROP {
+				// warn only once per connection, a peer may send a
+				// burst of packets before its hello
+				if dropped == 0 {
+					this.Warnf("dropping data packets received before hello handshake")
+				}
+				dropped++
+				continue
+			}
+			return nil, fmt.Errorf("data packet received before hello handshake")
+		default:
+			return nil, fmt.Errorf("unexpected packet %d instead of hello handshake", ty)
+		}
 	}
-	return this.parseHelloPacket(buffer[:n])
 }
 
 func (this *TunnelConnection) parseHelloPacket(data []byte) (*ConnectionHello, error) {
@@ -182,28 +419,65 @@ func (this *TunnelConnection) parseHelloPacket(data []byte) (*ConnectionHello, e
 		this.Errorf("invalid hello packet: %s", err)
 		return nil, err
 	}
+	if len(hello.Unknown) > 0 && this.mux.strictExtensions {
+		return nil, fmt.Errorf("hello packet with unknown extensions %v", hello.Unknown)
+	}
 	this.Infof("hello packet with %d extensions", len(hello.Extensions))
 	return hello, nil
 }
 
-func (this *TunnelConnection) createHello() *ConnectionHello {
+// createHello creates the hello for the connection to the given link.
+func (this *TunnelConnection) createHello(link *kubelink.Link) *ConnectionHello {
 	hello := NewConnectionHello()
-	hello.SetClusterCIDR(this.mux.clusterAddr)
-	hello.SetPort(this.mux.port)
-	if len(this.mux.local) > 0 {
-		hello.SetCIDR(this.mux.local[0])
+	hello.SetClusterCIDR(this.mux.GetClusterAddress())
+	port := this.mux.portOverrides.Lookup(link, this.remoteIP())
+	if port == 0 {
+		port = this.mux.port
+	}
+	hello.SetPort(port)
+	local := this.mux.local
+	if link != nil {
+		local = link.AdvertisedCIDRs(local)
 	}
+	hello.SetCIDRs(local)
 	lock.RLock()
 	defer lock.RUnlock()
 	for _, h := range registry {
 		h.Add(hello, this.mux)
 	}
+	if _, ok := this.conn.(*tls.Conn); !ok {
+		// never propagate credentials or dns info over an unencrypted connection
+		delete(hello.Extensions, EXT_APIACCESS)
+		delete(hello.Extensions, EXT_DNS)
+	}
 	return hello
 }
 
-func (this *TunnelConnection) handshake() (*ConnectionHello, error) {
-	local := this.createHello()
+// handshake exchanges the connection hellos. If an identify function
+// is given, the hello of the peer is read first and the local hello
+// is sent for the link determined by this function.
+func (this *TunnelConnection) handshake(link *kubelink.Link, identify func(remote *ConnectionHello) (*kubelink.Link, error)) (*ConnectionHello, error) {
+	if this.mux.helloTimeout > 0 {
+		this.conn.SetDeadline(time.Now().Add(this.mux.helloTimeout))
+		defer this.conn.SetDeadline(time.Time{})
+	}
 
+	if identify != nil {
+		remote, err := this.readHello()
+		if err != nil {
+			return nil, fmt.Errorf("cannot finish connection handshake: %s", helloError(err))
+		}
+		link, err = identify(remote)
+		if err != nil {
+			return remote, err
+		}
+		if err := this.writeHello(this.createHello(link)); err != nil {
+			return nil, fmt.Errorf("cannot finish connection handshake: %s", helloError(err))
+		}
+		return this.finishHandshake(remote), nil
+	}
+
+	local := this.createHello(link)
 	var werr error
 	wg := sync.WaitGroup{}
 	wg.Add(1)
@@ -215,25 +489,50 @@ func (this *TunnelConnection) handshake() (*ConnectionHello, error) {
 	remote, rerr := this.readHello()
 	wg.Wait()
 	if rerr != nil {
-		return nil, fmt.Errorf("cannot finish connection handshake: %s", rerr)
+		return nil, fmt.Errorf("cannot finish connection handshake: %s", helloError(rerr))
 	}
 	if werr != nil {
-		return nil, fmt.Errorf("cannot finish connection handshake: %s", werr)
+		return nil, fmt.Errorf("cannot finish connection handshake: %s", helloError(werr))
 	}
-	this.Infof("REMOTE SIDE: cluster %s, net: %s port: %d", remote.GetClusterCIDR(), remote.GetCIDR(), remote.GetPort())
-	return remote, nil
+	return this.finishHandshake(remote), nil
+}
+
+func (this *TunnelConnection) finishHandshake(remote *ConnectionHello) *ConnectionHello {
+	cidrs := remote.GetCIDRs()
+	this.Infof("REMOTE SIDE: cluster %s, net: %s port: %d", remote.GetClusterCIDR(), cidrs.String(), remote.GetPort())
+	this.negotiateMTU(remote)
+	this.negotiateCompression(remote)
+	return remote
+}
+
+func helloError(err error) error {
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return fmt.Errorf("hello timeout: %s", err)
+	}
+	return err
 }
 
 func (this *TunnelConnection) Serve() error {
+	if this.outbound && this.mux.livenessPort > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go this.liveness(stop)
+	}
 	err := this.serve()
+	this.lock.RLock()
+	if this.abort != nil {
+		err = this.abort
+	}
+	this.lock.RUnlock()
 	this.notify(err)
 	return err
 }
 
 func (this *TunnelConnection) serve() error {
-	var buffer [BufferSize]byte
+	buffer := this.mux.buffers.Get()
+	defer this.mux.buffers.Put(buffer)
 	for {
-		n, ty, err := this.ReadPacket(buffer[:])
+		n, ty, err := this.ReadPacket(buffer[:BufferSize])
 		if n < 0 || err != nil {
 			this.Infof("connection aborted: %d bytes, err=%s", n, err)
 			if n <= 0 {
@@ -241,12 +540,34 @@ func (this *TunnelConnection) serve() error {
 			}
 			return err
 		}
-		if n == 0 {
+		this.received()
+		if ty == PACKET_TYPE_GOODBYE {
+			this.Infof("peer is shutting down the connection")
+			return io.EOF
+		}
+		if n == 0 || ty == PACKET_TYPE_KEEPALIVE {
 			continue
 		}
 		packet := buffer[:n]
+		if ty == PACKET_TYPE_HELLO {
+			hello, err := this.parseHelloPacket(packet)
+			if err == nil {
+				this.updateHello(hello)
+			}
+			continue
+		}
 		if ty != PACKET_TYPE_DATA {
 			this.Infof("got packet of unknown type %x", ty)
+			this.recordDrop(kubelink.DROP_UNKNOWN_TYPE, nil)
+			continue
+		}
+		if n > this.mux.MTU() {
+			this.recordDrop(kubelink.DROP_OVERSIZED, nil)
+			if msg := this.mux.tooBig(packet, this.mux.MTU()); msg != nil {
+				if err := this.WritePacket(PACKET_TYPE_DATA, msg); err != nil {
+					this.Warnf("cannot report MTU %d: %s", this.mux.MTU(), err)
+				}
+			}
 			continue
 		}
 		vers := int(packet[0]) >> 4
@@ -254,44 +575,148 @@ func (this *TunnelConnection) serve() error {
 			header, err := ipv4.ParseHeader(packet)
 			if err != nil {
 				this.Errorf("err: %s", err)
+				this.recordDrop(kubelink.DROP_INVALID_HEADER, nil)
 				continue
 			} else {
-				this.Infof("receiving ipv4[%d]: (%d) hdr: %d, total: %d, prot: %d,  %s->%s\n",
+				this.mux.logPacket(this, "receiving ipv4[%d]: (%d) hdr: %d, total: %d, prot: %d,  %s->%s",
 					header.Version, len(packet), header.Len, header.TotalLen, header.Protocol, header.Src, header.Dst)
-				if this.mux.clusterAddr.Contains(header.Src) {
+				if this.mux.GetClusterAddress().Contains(header.Src) {
 					l := this.mux.links.GetLinkForClusterAddress(header.Src)
+					if l == nil && this.previous != nil && header.Src.Equal(this.ClusterCIDR().IP) {
+						l = this.mux.links.GetLinkForClusterAddress(this.previous.IP)
+					}
 					if l == nil {
-						this.Warnf("  dropping packet because of unknown cluster siurce address [%s]", header.Src)
+						this.recordDrop(kubelink.DROP_UNKNOWN_SOURCE, header)
 						continue
 					}
-					granted, set := l.AllowIngress(header.Dst)
-					if !granted {
-						this.Warnf("  dropping packet because of non-matching destination address %s for cluster address %s", header.Dst, header.Src)
+					if this.mux.interceptPacket(this, header, packet) {
 						continue
 					}
-					if !set && this.mux.local.IsSet() && !this.mux.local.Contains(header.Dst) {
-						this.Warnf("  dropping packet because of non-matching destination address %s for cluster %s", header.Dst, header.Src)
+					port := destinationPort(packet, header)
+					granted, set := l.AllowIngress(header.Dst, byte(header.Protocol), port)
+					if !granted {
+						if !this.mux.auditIngress(l) {
+							this.recordDrop(kubelink.DROP_INGRESS_DENIED, header)
+							continue
+						}
+						l.Stats.CountAudit()
+						this.mux.logPacket(this, "ingress audit: %s->%s not granted", header.Src, header.Dst)
+					}
+					if !set && this.mux.local.IsSet() && !this.mux.local.Contains(header.Dst) &&
+						!this.mux.services.Match(header.Dst, byte(header.Protocol), port) {
+						if !this.relayPacket(header, packet) {
+							this.recordDrop(kubelink.DROP_WRONG_DESTINATION, header)
+						}
 						continue
 					}
 				} else {
-					if !header.Dst.Equal(this.mux.clusterAddr.IP) {
-						this.Warnf("  dropping packet because of non-matching destination address [%s<>%s]", this.mux.clusterAddr.IP, header.Dst)
+					if !this.mux.IsLocalAddress(header.Dst) {
+						if !this.allowRelay(header, packet) {
+							continue
+						}
+						if !this.relayPacket(header, packet) {
+							this.recordDrop(kubelink.DROP_WRONG_DESTINATION, header)
+						}
+						continue
+					}
+					if this.mux.interceptPacket(this, header, packet) {
 						continue
 					}
 				}
 			}
+		} else if vers == ipv6.Version {
+			header, err := ipv6.ParseHeader(packet)
+			if err != nil {
+				this.Errorf("err: %s", err)
+				this.recordDrop6(kubelink.DROP_INVALID_HEADER, nil)
+				continue
+			}
+			this.mux.logPacket(this, "receiving ipv6[%d]: (%d) payload: %d, next: %d,  %s->%s",
+				header.Version, len(packet), header.PayloadLen, header.NextHeader, header.Src, header.Dst)
+			if this.mux.GetClusterAddress().Contains(header.Src) {
+				l := this.mux.links.GetLinkForClusterAddress(header.Src)
+				if l == nil && this.previous != nil && header.Src.Equal(this.ClusterCIDR().IP) {
+					l = this.mux.links.GetLinkForClusterAddress(this.previous.IP)
+				}
+				if l == nil {
+					this.recordDrop6(kubelink.DROP_UNKNOWN_SOURCE, header)
+					continue
+				}
+				port := destinationPort6(packet, header)
+				granted, set := l.AllowIngress(header.Dst, byte(header.NextHeader), port)
+				if !granted {
+					if !this.mux.auditIngress(l) {
+						this.recordDrop6(kubelink.DROP_INGRESS_DENIED, header)
+						continue
+					}
+					l.Stats.CountAudit()
+					this.mux.logPacket(this, "ingress audit: %s->%s not granted", header.Src, header.Dst)
+				}
+				if !set && this.mux.local.IsSet() && !this.mux.local.Contains(header.Dst) &&
+					!this.mux.services.Match(header.Dst, byte(header.NextHeader), port) {
+					if !this.relayPacket6(header, packet) {
+						this.recordDrop6(kubelink.DROP_WRONG_DESTINATION, header)
+					}
+					continue
+				}
+			} else {
+				if !this.mux.IsLocalAddress(header.Dst) {
+					if !this.allowRelay6(header, packet) {
+						continue
+					}
+					if !this.relayPacket6(header, packet) {
+						this.recordDrop6(kubelink.DROP_WRONG_DESTINATION, header)
+					}
+					continue
+				}
+			}
+		} else {
+			this.recordDrop(kubelink.DROP_UNKNOWN_VERSION, nil)
+			continue
 		}
-		o, err := this.mux.tun.Write(buffer[:n])
+		if l := this.link(); l != nil && l.DedupWindow > 0 && this.mux.isDuplicate(l.Name, l.DedupWindow, packet) {
+			this.recordDrop(kubelink.DROP_DUPLICATE, nil)
+			continue
+		}
+		o, err := this.mux.GetTun().Write(buffer[:n])
 		if err != nil {
-			if err != io.EOF {
-				this.Infof("connection aborted: cannot write tun: %s", err)
-			}
-			return err
+			// a tun failure affects all connections, so recover the tun
+			// instead of aborting the connection
+			this.mux.RecoverTun(err)
+			continue
 		}
 		if n != o {
 			panic(fmt.Errorf("packet length %d, but written %d", n, o))
 		}
+		this.linkStats().CountIn(n)
+	}
+}
+
+// destinationPort returns the destination port of a tcp or udp packet
+// or 0 for other protocols. Non-first fragments carry no transport
+// header, so they have no port and are only accepted by rules not
+// restricting the port.
+func destinationPort(packet []byte, header *ipv4.Header) uint16 {
+	if header.FragOff != 0 {
+		return 0
+	}
+	switch header.Protocol {
+	case kubelink.PROTO_TCP, kubelink.PROTO_UDP:
+		if len(packet) >= header.Len+4 {
+			return tcp.NtoHs(packet[header.Len+2:])
+		}
 	}
+	return 0
+}
+
+func destinationPort6(packet []byte, header *ipv6.Header) uint16 {
+	switch header.NextHeader {
+	case kubelink.PROTO_TCP, kubelink.PROTO_UDP:
+		if len(packet) >= ipv6.HeaderLen+4 {
+			return tcp.NtoHs(packet[ipv6.HeaderLen+2:])
+		}
+	}
+	return 0
 }
 
 func (this *TunnelConnection) read(r io.Reader, data []byte) error {
@@ -330,32 +755,80 @@ func (this *TunnelConnection) write(w io.Writer, data []byte) error {
 func (this *TunnelConnection) ReadPacket(data []byte) (int, byte, error) {
 	this.rlock.Lock()
 	defer this.rlock.Unlock()
-	lbuf := [3]byte{}
-	err := this.read(this.conn, lbuf[:])
+	lbuf := [frameHeaderSize]byte{}
+	err := this.read(this.reader, lbuf[:])
 
 	if err != nil {
 		return 0, 0, err
 	}
 
 	length := tcp.NtoHs(lbuf[:2])
+	ty := lbuf[2]
+	if ty&PACKET_FLAG_COMPRESSED != 0 {
+		if this.compression == CODEC_NONE {
+			return 0, 0, fmt.Errorf("protocol error: compressed packet without negotiated compression")
+		}
+		buffer := this.mux.buffers.Get()
+		defer this.mux.buffers.Put(buffer)
+		if int(length) > len(buffer) {
+			return 0, 0, fmt.Errorf("compressed packet too large (%d)", length)
+		}
+		err = this.read(this.reader, buffer[:length])
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := this.decompress(buffer[:length], data)
+		return n, ty &^ PACKET_FLAG_COMPRESSED, err
+	}
 	if int(length) > len(data) {
 		return 0, 0, fmt.Errorf("buffer too small (%d): packet size is %d", len(data), length)
 	}
-	return int(length), lbuf[2], this.read(this.conn, data[0:length])
+	return int(length), ty, this.read(this.reader, data[0:length])
 }
 
+// WritePacket writes a packet from the caller's slice without copying
+// it. Header and payload are sent with a single vectored write on plain
+// tcp connections.
 func (this *TunnelConnection) WritePacket(ty byte, data []byte) error {
+	this.wlock.Lock()
+	defer this.wlock.Unlock()
+	if c := this.compress(ty, data); c != nil {
+		ty |= PACKET_FLAG_COMPRESSED
+		data = c
+	}
+	return this.writePacket(ty, data)
+}
+
+// writePacket must be called with the write lock held.
+func (this *TunnelConnection) writePacket(ty byte, data []byte) error {
 	if len(data) > 65535 {
 		return fmt.Errorf("packet too large (%d)", len(data))
 	}
-	lbuf := tcp.HtoNs(uint16(len(data)))
+	header := [frameHeaderSize]byte{}
+	setFrameHeader(header[:], ty, len(data))
+	buffers := net.Buffers{header[:], data}
+	_, err := buffers.WriteTo(this.conn)
+	return err
+}
+
+// writeFrame writes a data packet prepared in place after the room
+// for the frame header at the beginning of the frame. This way a packet
+// read from the tun device is sent with a single write, also for tls
+// connections.
+func (this *TunnelConnection) writeFrame(frame []byte) error {
+	data := frame[frameHeaderSize:]
 	this.wlock.Lock()
 	defer this.wlock.Unlock()
-	err := this.write(this.conn, append(lbuf, ty))
-	if err != nil {
-		return err
+	if c := this.compress(PACKET_TYPE_DATA, data); c != nil {
+		return this.writePacket(PACKET_TYPE_DATA|PACKET_FLAG_COMPRESSED, c)
 	}
-	return this.write(this.conn, data)
+	setFrameHeader(frame, PACKET_TYPE_DATA, len(data))
+	return this.write(this.conn, frame)
+}
+
+func setFrameHeader(header []byte, ty byte, n int) {
+	binary.BigEndian.PutUint16(header, uint16(n))
+	header[2] = ty
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,294 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func testPacket(t *testing.T, fragOff int, port uint16) ([]byte, *ipv4.Header) {
+	return testPacketTo(t, "10.0.0.1", fragOff, port)
+}
+
+func testPacketTo(t *testing.T, dst string, fragOff int, port uint16) ([]byte, *ipv4.Header) {
+	h := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + 8,
+		FragOff:  fragOff,
+		TTL:      64,
+		Protocol: kubelink.PROTO_TCP,
+		Src:      net.ParseIP("192.168.0.10"),
+		Dst:      net.ParseIP(dst),
+	}
+	data, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := append(data, 0, 0, byte(port>>8), byte(port), 0, 0, 0, 0)
+	header, err := ipv4.ParseHeader(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet, header
+}
+
+func TestDestinationPortFragments(t *testing.T) {
+	rule, err := kubelink.ParseIngressRule("10.0.0.0/24:tcp/80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := kubelink.IngressRules{rule}
+
+	packet, header := testPacket(t, 0, 80)
+	if port := destinationPort(packet, header); port != 80 || !rules.Match(header.Dst, byte(header.Protocol), port) {
+		t.Errorf("first fragment: got port %d", port)
+	}
+	packet, header = testPacket(t, 185, 80)
+	if port := destinationPort(packet, header); port != 0 || rules.Match(header.Dst, byte(header.Protocol), port) {
+		t.Errorf("non-first fragment: got port %d", port)
+	}
+}
+
+func TestAllowRelay(t *testing.T) {
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Ingress = []string{"10.0.0.0/24:tcp/80"}
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.drops = NewDropSamples(4)
+	conn := &TunnelConnection{LogContext: logger.New(), mux: m, clusterCIDR: m.links.GetLink("a").ClusterAddress}
+
+	packet, header := testPacket(t, 0, 80)
+	if !conn.allowRelay(header, packet) {
+		t.Errorf("granted packet not relayed")
+	}
+	packet, header = testPacket(t, 0, 22)
+	if conn.allowRelay(header, packet) {
+		t.Errorf("denied packet relayed")
+	}
+	if drops := m.drops.Get(); len(drops) != 1 || drops[0].Reason != kubelink.DROP_INGRESS_DENIED.String() {
+		t.Errorf("unexpected drops %v", drops)
+	}
+}
+
+func testPayload(n int, b byte) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func TestWritePacketBufferReuse(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24")
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	writer := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1}
+	reader := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c2, reader: c2}
+
+	long := testPayload(1000, 1)
+	short := testPayload(10, 2)
+	plain := testPayload(20, 3)
+	go func() {
+		// a pooled buffer used for consecutive packets must only
+		// provide the actual packet.
+		buffer := m.buffers.Get()
+		defer m.buffers.Put(buffer)
+		for _, p := range [][]byte{long, short} {
+			n := copy(buffer[frameHeaderSize:], p)
+			if err := writer.writeFrame(buffer[:frameHeaderSize+n]); err != nil {
+				t.Errorf("cannot write frame: %s", err)
+			}
+		}
+		if err := writer.WritePacket(PACKET_TYPE_DATA, plain); err != nil {
+			t.Errorf("cannot write packet: %s", err)
+		}
+	}()
+
+	data := make([]byte, BufferSize)
+	for _, expected := range [][]byte{long, short, plain} {
+		n, ty, err := reader.ReadPacket(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ty != PACKET_TYPE_DATA || !bytes.Equal(data[:n], expected) {
+			t.Errorf("unexpected packet type %d with %d bytes, expected %d bytes", ty, n, len(expected))
+		}
+	}
+	if !bytes.Equal(plain, testPayload(20, 3)) {
+		t.Errorf("packet of caller modified")
+	}
+}
+
+func benchmarkConnection(b *testing.B) (*TunnelConnection, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			io.Copy(ioutil.Discard, c)
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := &Mux{buffers: NewBufferPool(true)}
+	return &TunnelConnection{LogContext: logger.New(), mux: m, conn: c}, func() {
+		c.Close()
+		l.Close()
+	}
+}
+
+func BenchmarkWritePacket(b *testing.B) {
+	t, done := benchmarkConnection(b)
+	defer done()
+	packet := testPayload(1400, 1)
+	b.SetBytes(int64(len(packet)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := t.WritePacket(PACKET_TYPE_DATA, packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	t, done := benchmarkConnection(b)
+	defer done()
+	frame := testPayload(frameHeaderSize+1400, 1)
+	b.SetBytes(int64(len(frame) - frameHeaderSize))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := t.writeFrame(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func testPacket6(t *testing.T, dst string, hopLimit int) ([]byte, *ipv6.Header) {
+	packet := make([]byte, ipv6.HeaderLen+8)
+	packet[0] = ipv6.Version << 4
+	packet[5] = 8
+	packet[6] = kubelink.PROTO_UDP
+	packet[7] = byte(hopLimit)
+	copy(packet[8:24], net.ParseIP("fd00:1::10"))
+	copy(packet[24:40], net.ParseIP(dst))
+	packet[ipv6.HeaderLen+3] = 53
+	header, err := ipv6.ParseHeader(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet, header
+}
+
+func TestFindConnection6(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "fd00:2::/64"))
+	m.LogContext = logger.New()
+	a := testConnection(t, m, "a", true)
+	defer a.Close()
+	m.AddTunnel(a)
+
+	packet, _ := testPacket6(t, "fd00:2::5", 64)
+	if c := m.FindConnection(m, packet); c != a {
+		t.Errorf("no connection found for outbound ipv6 packet")
+	}
+	packet, _ = testPacket6(t, "fd00:3::5", 64)
+	if c := m.FindConnection(m, packet); c != nil {
+		t.Errorf("connection found for unknown ipv6 destination")
+	}
+}
+
+func TestRelayHopLimit(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "fd00:1::/64"),
+		testLink("b", "192.168.0.11/24", "fd00:2::/64"),
+	)
+	m.LogContext = logger.New()
+	m.relay = true
+	a := testConnection(t, m, "a", false)
+	b := testConnection(t, m, "b", true)
+	defer a.Close()
+	defer b.Close()
+	m.AddTunnel(a)
+	m.AddTunnel(b)
+
+	packet, header := testPacket6(t, "fd00:2::5", 5)
+	if !a.relayPacket6(header, packet) {
+		t.Fatalf("ipv6 packet not relayed")
+	}
+	if packet[7] != 4 {
+		t.Errorf("hop limit not decremented: %d", packet[7])
+	}
+	if s := m.links.Stats()["b"]; s.PacketsOut != 1 {
+		t.Errorf("relayed packet not counted: %+v", s)
+	}
+
+	packet, header = testPacket6(t, "fd00:2::5", 1)
+	if a.relayPacket6(header, packet) {
+		t.Errorf("packet with exceeded hop limit relayed")
+	}
+}
+
+func TestPrematureData(t *testing.T) {
+	for _, mode := range []string{PREMATURE_DATA_REJECT, PREMATURE_DATA_DROP} {
+		m := testMux(t, "192.168.0.1/24")
+		m.prematureData = mode
+		c1, c2 := net.Pipe()
+		conn := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1, reader: c1}
+		peer := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c2}
+
+		go func() {
+			packet, _ := testPacket(t, 0, 80)
+			for i := 0; i < 3; i++ {
+				if peer.WritePacket(PACKET_TYPE_DATA, packet) != nil {
+					return
+				}
+			}
+			hello := NewConnectionHello()
+			hello.SetClusterCIDR(m.GetClusterAddress())
+			peer.writeHello(hello)
+		}()
+
+		hello, err := conn.readHello()
+		switch mode {
+		case PREMATURE_DATA_REJECT:
+			if err == nil {
+				t.Errorf("%s: data packet before hello accepted", mode)
+			}
+		case PREMATURE_DATA_DROP:
+			if err != nil || hello == nil {
+				t.Errorf("%s: hello not read after dropped data packets: %v", mode, err)
+			}
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
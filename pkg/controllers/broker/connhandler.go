@@ -19,6 +19,8 @@
 package broker
 
 import (
+	"time"
+
 	"github.com/mandelsoft/kubelink/pkg/kubelink"
 )
 
@@ -47,6 +49,11 @@ func (this *DefaultConnectionHandler) UpdateAccess(hello *ConnectionHello) {
 	if ext != nil {
 		dns := ext.(*DNSExtension)
 		this.reconciler.Controller().Infof("found dns advertisement %s", dns)
+		if retention := this.reconciler.config.DNSInfoRetention; retention > 0 {
+			if l := this.reconciler.Links().RefreshDNSInfo(link.Name, time.Now().Add(retention)); l != nil {
+				link = l
+			}
+		}
 		if !link.LinkDNSInfo.Equal(*(*kubelink.LinkDNSInfo)(dns)) {
 			this.reconciler.mux.Infof("update dns info for link %s: %s", link.Name, dns)
 			infoDNS = (*kubelink.LinkDNSInfo)(dns)
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// DEDUP_MAX_ENTRIES limits the number of packet hashes kept per link.
+// If exceeded, the oldest entries are evicted before the window expires.
+const DEDUP_MAX_ENTRIES = 65536
+
+// dedupEntry is a packet hash seen at a given time.
+type dedupEntry struct {
+	hash uint64
+	time time.Time
+}
+
+// dedupFilter detects duplicates of packets received via redundant
+// paths within a time window.
+type dedupFilter struct {
+	lock   sync.Mutex
+	window time.Duration
+	seen   map[uint64]int
+	queue  []dedupEntry
+}
+
+func newDedupFilter(window time.Duration) *dedupFilter {
+	return &dedupFilter{
+		window: window,
+		seen:   map[uint64]int{},
+	}
+}
+
+// packetHash hashes an ip packet ignoring the ttl and header checksum
+// (or the hop limit for IPv6), which may differ for duplicates taking
+// different paths.
+func packetHash(packet []byte) uint64 {
+	h := fnv.New64a()
+	switch {
+	case len(packet) >= 8 && packet[0]>>4 == 6:
+		h.Write(packet[:7])
+		h.Write(packet[8:])
+	case len(packet) >= 12:
+		h.Write(packet[:8])
+		h.Write(packet[9:10])
+		h.Write(packet[12:])
+	default:
+		h.Write(packet)
+	}
+	return h.Sum64()
+}
+
+// IsDuplicate reports whether the packet has already been seen
+// within the window and records it otherwise.
+func (this *dedupFilter) IsDuplicate(packet []byte, now time.Time) bool {
+	hash := packetHash(packet)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	limit := now.Add(-this.window)
+	i := 0
+	for ; i < len(this.queue) && (this.queue[i].time.Before(limit) || len(this.queue)-i >= DEDUP_MAX_ENTRIES); i++ {
+		e := this.queue[i]
+		if this.seen[e.hash]--; this.seen[e.hash] <= 0 {
+			delete(this.seen, e.hash)
+		}
+	}
+	this.queue = this.queue[i:]
+
+	if this.seen[hash] > 0 {
+		return true
+	}
+	this.seen[hash]++
+	this.queue = append(this.queue, dedupEntry{hash: hash, time: now})
+	return false
+}
+
+// isDuplicate checks a packet received for a link with enabled
+// deduplication. The filter is shared by all connections of a link.
+func (this *Mux) isDuplicate(name string, window time.Duration, packet []byte) bool {
+	this.dedupLock.Lock()
+	f := this.dedup[name]
+	if f == nil || f.window != window {
+		f = newDedupFilter(window)
+		this.dedup[name] = f
+	}
+	this.dedupLock.Unlock()
+	return f.IsDuplicate(packet, time.Now())
+}
+
+// releaseDedup discards the duplicate filter of a link.
+func (this *Mux) releaseDedup(name string) {
+	this.dedupLock.Lock()
+	defer this.dedupLock.Unlock()
+	delete(this.dedup, name)
+}
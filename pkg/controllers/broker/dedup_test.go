@@ -0,0 +1,66 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestDedupHopLimit(t *testing.T) {
+	f := newDedupFilter(time.Second)
+	now := time.Now()
+	packet := make([]byte, 48)
+	packet[0] = 6 << 4
+	packet[7] = 64
+	if f.IsDuplicate(packet, now) {
+		t.Fatalf("first packet reported as duplicate")
+	}
+	dup := append([]byte{}, packet...)
+	dup[7] = 63
+	if !f.IsDuplicate(dup, now) {
+		t.Errorf("duplicate with different hop limit not detected")
+	}
+}
+
+func TestDedupMaxEntries(t *testing.T) {
+	f := newDedupFilter(time.Hour)
+	now := time.Now()
+	packet := make([]byte, 24)
+	packet[0] = 4<<4 | 5
+	for i := 0; i < DEDUP_MAX_ENTRIES+10; i++ {
+		binary.BigEndian.PutUint32(packet[20:], uint32(i))
+		if f.IsDuplicate(packet, now) {
+			t.Fatalf("packet %d reported as duplicate", i)
+		}
+	}
+	if len(f.queue) != DEDUP_MAX_ENTRIES || len(f.seen) != DEDUP_MAX_ENTRIES {
+		t.Errorf("filter not limited: %d queued, %d seen", len(f.queue), len(f.seen))
+	}
+}
+
+func TestReleaseDedup(t *testing.T) {
+	m := &Mux{dedup: map[string]*dedupFilter{}}
+	m.isDuplicate("a", time.Second, []byte{0x45})
+	m.releaseDedup("a")
+	if len(m.dedup) != 0 {
+		t.Errorf("filter not released")
+	}
+}
@@ -45,8 +45,16 @@ import (
 const CLUSTER_DNS_IP = 10
 const KUBELINK_DNS_IP = 11
 
+// DNSMODE_NONE disables the DNS propagation.
 const DNSMODE_NONE = "none"
+
+// DNSMODE_KUBERNETES serves the domain of a foreign cluster by a coredns
+// kubernetes plugin accessing the foreign API server with the api access
+// info of the link. Links without access info are omitted.
 const DNSMODE_KUBERNETES = "kubernetes"
+
+// DNSMODE_DNS forwards requests for the domain of a foreign cluster to
+// the dns service of the foreign cluster.
 const DNSMODE_DNS = "dns"
 
 type Manifest map[string]interface{}
@@ -157,6 +165,84 @@ func coreEntry(first *bool, name, basedomain string, dnsIP, clusterDomain string
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// CoreDNSSettings describes the local settings used to generate
+// the coredns configuration for the cluster mesh.
+type CoreDNSSettings struct {
+	Mode        string
+	ClusterName string
+	MeshDomain  string
+	ServiceCIDR *net.IPNet
+	DNSInfo     kubelink.LinkDNSInfo
+}
+
+// GenerateCoreDNSConfig generates the content of the coredns secret
+// (Corefile and kubeconfig) for the given links. Links with expired
+// dns info are omitted.
+func GenerateCoreDNSConfig(settings CoreDNSSettings, links []*kubelink.Link) (map[string][]byte, error) {
+	data := map[string][]byte{}
+
+	first := true
+	selected := []*kubelink.Link{}
+
+	kubeconfig := NewKubeconfig()
+	for _, l := range links {
+		switch settings.Mode {
+		case DNSMODE_KUBERNETES:
+			if l.Token == "" {
+				continue
+			}
+			ip := tcp.SubIP(l.ServiceCIDR, 1)
+			kubeconfig.AddCluster(l.Name, fmt.Sprintf("https://%s", ip), l.CACert, l.Token)
+		case DNSMODE_DNS:
+			if l.DNSInfoExpired() {
+				continue
+			}
+		}
+		selected = append(selected, l)
+	}
+	b, err := yaml.Marshal(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal kubeconfig: %s", err)
+	}
+	data["kubeconfig"] = b
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+
+	corefile := ""
+	ip := ""
+	if settings.ClusterName != "" {
+		clusterDomain := "cluster.local"
+		if settings.Mode == DNSMODE_DNS {
+			if settings.DNSInfo.DnsIP != nil {
+				ip = settings.DNSInfo.DnsIP.String()
+			} else {
+				ip = tcp.SubIP(settings.ServiceCIDR, CLUSTER_DNS_IP).String()
+			}
+			if settings.DNSInfo.ClusterDomain != "" {
+				clusterDomain = settings.DNSInfo.ClusterDomain
+			}
+		}
+		corefile += coreEntry(&first, settings.ClusterName, settings.MeshDomain, ip, clusterDomain, true)
+	}
+	for _, l := range selected {
+		clusterDomain := "cluster.local"
+		if settings.Mode == DNSMODE_DNS {
+			if l.DnsIP != nil {
+				ip = l.DnsIP.String()
+			} else {
+				ip = tcp.SubIP(l.ServiceCIDR, CLUSTER_DNS_IP).String()
+			}
+			if l.ClusterDomain != "" {
+				clusterDomain = l.ClusterDomain
+			}
+		}
+		corefile += coreEntry(&first, l.Name, settings.MeshDomain, ip, clusterDomain, false)
+	}
+	data["Corefile"] = []byte(corefile)
+	return data, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 func (this *reconciler) getSecretName(link *api.KubeLink) resources.ObjectName {
 	if link.Spec.APIAccess == nil {
 		return nil
@@ -319,7 +405,7 @@ func (this *reconciler) updateObjectFromLink(logger logger.LogContext, klink *ap
 		if create {
 			sobj, err = this.secretResource.Create(secret)
 			if err != nil {
-				return fmt.Errorf("cannot create secret for link %q: err", entry.Name, err), nil
+				return fmt.Errorf("cannot create secret for link %q: %s", entry.Name, err), nil
 			}
 			access := _core.SecretReference{
 				Name:      sobj.GetName(),
@@ -380,69 +466,23 @@ func (this *reconciler) updateCorefile(logger logger.LogContext) {
 		return
 	}
 	logger.Debug("update corefile")
-	data := map[string][]byte{}
-
-	first := true
-	keys := []string{}
-
-	kubeconfig := NewKubeconfig()
-	if this.config.DNSPropagation == DNSMODE_KUBERNETES {
-
-		this.Links().Visit(func(l *kubelink.Link) bool {
-			if l.Token != "" {
-				ip := tcp.SubIP(l.ServiceCIDR, 1)
-				kubeconfig.AddCluster(l.Name, fmt.Sprintf("https://%s", ip), l.CACert, l.Token)
-				keys = append(keys, l.Name)
-			}
-			return true
-		})
-	} else {
-		this.Links().Visit(func(l *kubelink.Link) bool {
-			keys = append(keys, l.Name)
-			return true
-		})
+	var links []*kubelink.Link
+	this.Links().Visit(func(l *kubelink.Link) bool {
+		links = append(links, l)
+		return true
+	})
+	settings := CoreDNSSettings{
+		Mode:        this.config.DNSPropagation,
+		ClusterName: this.config.ClusterName,
+		MeshDomain:  this.config.MeshDomain,
+		ServiceCIDR: this.config.ServiceCIDR,
+		DNSInfo:     this.dnsInfo,
 	}
-	b, err := yaml.Marshal(kubeconfig)
+	data, err := GenerateCoreDNSConfig(settings, links)
 	if err != nil {
-		logger.Errorf("cannot marshal kubeconfig: %s", err)
+		logger.Errorf("%s", err)
 		return
 	}
-	data["kubeconfig"] = b
-	sort.Strings(keys)
-
-	corefile := ""
-	ip := ""
-	if this.config.ClusterName != "" {
-		clusterDomain := "cluster.local"
-		if this.config.DNSPropagation == DNSMODE_DNS {
-			if this.dnsInfo.DnsIP != nil {
-				ip = this.dnsInfo.DnsIP.String()
-			} else {
-				ip = tcp.SubIP(this.config.ServiceCIDR, CLUSTER_DNS_IP).String()
-			}
-			if this.dnsInfo.ClusterDomain != "" {
-				clusterDomain = this.dnsInfo.ClusterDomain
-			}
-		}
-		corefile += coreEntry(&first, this.config.ClusterName, this.config.MeshDomain, ip, clusterDomain, true)
-	}
-	for _, k := range keys {
-		clusterDomain := "cluster.local"
-		l := this.Links().GetLink(k)
-		if this.config.DNSPropagation == DNSMODE_DNS {
-			if l.DnsIP != nil {
-				ip = l.DnsIP.String()
-			} else {
-				ip = tcp.SubIP(l.ServiceCIDR, CLUSTER_DNS_IP).String()
-			}
-			if l.ClusterDomain != "" {
-				clusterDomain = l.ClusterDomain
-			}
-
-		}
-		corefile += coreEntry(&first, k, this.config.MeshDomain, ip, clusterDomain, false)
-	}
-	data["Corefile"] = []byte(corefile)
 
 	name := resources.NewObjectName(this.Controller().GetEnvironment().Namespace(), this.config.CoreDNSSecret)
 	_, mod, err := this.secretResource.CreateOrModifyByName(name,
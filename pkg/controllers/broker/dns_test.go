@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func testDNSLinks(t *testing.T) []*kubelink.Link {
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+		testLink("b", "192.168.0.11/24", "100.64.2.0/24"),
+	)
+	m.links.UpdateLinkInfo(logger.New(), "a",
+		&kubelink.LinkAccessInfo{CACert: "ca", Token: "token"},
+		&kubelink.LinkDNSInfo{ClusterDomain: "a.local", DnsIP: net.ParseIP("100.64.1.53")}, false)
+	return m.links.GetLinks("b", "a")
+}
+
+func TestGenerateCoreDNSConfigDNS(t *testing.T) {
+	_, service, _ := net.ParseCIDR("10.96.0.0/16")
+	settings := CoreDNSSettings{
+		Mode:        DNSMODE_DNS,
+		ClusterName: "local",
+		MeshDomain:  "kubelink",
+		ServiceCIDR: service,
+	}
+	data, err := GenerateCoreDNSConfig(settings, testDNSLinks(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corefile := string(data["Corefile"])
+	expected := []string{
+		".:8053 {",
+		"forward . 10.96.0.10",
+		"a.kubelink:8053 {",
+		`rewrite name regex (.*)\.a\.kubelink\. {1}.a.local. answer name (.*)\.a\.local\. {1}.a.kubelink.`,
+		"forward . 100.64.1.53",
+		"b.kubelink:8053 {",
+		"forward . 100.64.2.10",
+	}
+	last := -1
+	for _, e := range expected {
+		i := strings.Index(corefile, e)
+		if i < 0 {
+			t.Errorf("corefile misses %q:\n%s", e, corefile)
+			continue
+		}
+		if i < last {
+			t.Errorf("%q not in expected order", e)
+		}
+		last = i
+	}
+}
+
+func TestGenerateCoreDNSConfigKubernetes(t *testing.T) {
+	settings := CoreDNSSettings{
+		Mode:       DNSMODE_KUBERNETES,
+		MeshDomain: "kubelink",
+	}
+	data, err := GenerateCoreDNSConfig(settings, testDNSLinks(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corefile := string(data["Corefile"])
+	if !strings.Contains(corefile, "kubeconfig /etc/coredns/kubeconfig a") {
+		t.Errorf("corefile misses kubernetes plugin for link a:\n%s", corefile)
+	}
+	if strings.Contains(corefile, "b.kubelink") {
+		t.Errorf("link b without api access included:\n%s", corefile)
+	}
+	kubeconfig := string(data["kubeconfig"])
+	if !strings.Contains(kubeconfig, "https://100.64.1.1") || !strings.Contains(kubeconfig, "token") {
+		t.Errorf("kubeconfig misses cluster a:\n%s", kubeconfig)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"time"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// IsDNSPropagated reports whether an active connection for the given
+// cluster address received dns info with its hello.
+func (this *Mux) IsDNSPropagated(ip net.IP) bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	for _, t := range this.byClusterIP[ip.String()] {
+		t.lock.RLock()
+		propagated := t.dnsPropagated
+		t.lock.RUnlock()
+		if propagated {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDNSExpiry periodically refreshes the propagated dns info of links
+// with an active connection still propagating it and triggers an update
+// of the DNS configuration if dns info expired.
+func (this *reconciler) handleDNSExpiry(retention time.Duration) {
+	ticker := time.NewTicker(retention / 2)
+	defer ticker.Stop()
+
+	expired := map[string]bool{}
+	for {
+		select {
+		case <-this.Controller().GetContext().Done():
+			return
+		case <-ticker.C:
+		}
+		var links []*kubelink.Link
+		this.Links().Visit(func(l *kubelink.Link) bool {
+			if !l.DNSExpiry.IsZero() {
+				links = append(links, l)
+			}
+			return true
+		})
+		update := false
+		for _, l := range links {
+			if this.mux.IsDNSPropagated(l.ClusterAddress.IP) {
+				this.Links().RefreshDNSInfo(l.Name, time.Now().Add(retention))
+				if expired[l.Name] {
+					delete(expired, l.Name)
+					update = true
+				}
+				continue
+			}
+			if l.DNSInfoExpired() && !expired[l.Name] {
+				this.Controller().Infof("dns info for link %s expired", l.Name)
+				expired[l.Name] = true
+				update = true
+			}
+		}
+		if update {
+			this.TriggerUpdate()
+		}
+	}
+}
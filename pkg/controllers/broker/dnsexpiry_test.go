@@ -0,0 +1,71 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func TestDNSInfoExpiry(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	dns := &kubelink.LinkDNSInfo{ClusterDomain: "cluster.local", DnsIP: net.ParseIP("100.64.1.53")}
+	m.links.UpdateLinkInfo(logger.New(), "a", nil, dns, false)
+	history := len(m.links.History().Entries())
+
+	settings := CoreDNSSettings{Mode: DNSMODE_DNS, MeshDomain: "kubelink"}
+	forwarded := func() bool {
+		var links []*kubelink.Link
+		m.links.Visit(func(l *kubelink.Link) bool {
+			links = append(links, l)
+			return true
+		})
+		data, err := GenerateCoreDNSConfig(settings, links)
+		if err != nil {
+			t.Fatalf("cannot generate corefile: %s", err)
+		}
+		return strings.Contains(string(data["Corefile"]), "100.64.1.53")
+	}
+
+	if !forwarded() {
+		t.Errorf("dns info without expiry not used")
+	}
+	for i := 0; i < 3; i++ {
+		m.links.RefreshDNSInfo("a", time.Now().Add(time.Minute))
+	}
+	if m.links.GetLink("a").DNSInfoExpired() || !forwarded() {
+		t.Errorf("refreshed dns info not used")
+	}
+	m.links.RefreshDNSInfo("a", time.Now().Add(-time.Second))
+	if !m.links.GetLink("a").DNSInfoExpired() {
+		t.Errorf("dns info not expired")
+	}
+	if forwarded() {
+		t.Errorf("expired dns info still used for forwarding")
+	}
+	if n := len(m.links.History().Entries()); n != history {
+		t.Errorf("refreshes recorded in history: %d entries, expected %d", n, history)
+	}
+}
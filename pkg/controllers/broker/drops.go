@@ -0,0 +1,148 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+const DROP_SAMPLES = 100
+
+// DropSample describes a packet dropped by a tunnel connection.
+type DropSample struct {
+	Time     time.Time `json:"time"`
+	Link     string    `json:"link,omitempty"`
+	Remote   string    `json:"remote"`
+	Reason   string    `json:"reason"`
+	Source   string    `json:"source,omitempty"`
+	Dest     string    `json:"destination,omitempty"`
+	Protocol int       `json:"protocol,omitempty"`
+	Length   int       `json:"length,omitempty"`
+}
+
+// DropSamples keeps the most recent drop samples.
+type DropSamples struct {
+	lock    sync.Mutex
+	samples []DropSample
+	next    int
+}
+
+func NewDropSamples(size int) *DropSamples {
+	return &DropSamples{samples: make([]DropSample, 0, size)}
+}
+
+func (this *DropSamples) Add(s DropSample) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if len(this.samples) < cap(this.samples) {
+		this.samples = append(this.samples, s)
+		return
+	}
+	this.samples[this.next] = s
+	this.next = (this.next + 1) % len(this.samples)
+}
+
+// Get returns the recorded samples, oldest first.
+func (this *DropSamples) Get() []DropSample {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	result := make([]DropSample, 0, len(this.samples))
+	result = append(result, this.samples[this.next:]...)
+	return append(result, this.samples[:this.next]...)
+}
+
+// recordDrop counts a dropped packet for the link of the connection
+// and keeps a sample of it. The header is nil if the packet could
+// not be parsed.
+func (this *TunnelConnection) recordDrop(reason kubelink.DropReason, header *ipv4.Header) {
+	if header != nil {
+		this.recordPacketDrop(reason, header.Src, header.Dst, header.Protocol, header.TotalLen)
+	} else {
+		this.recordPacketDrop(reason, nil, nil, 0, 0)
+	}
+}
+
+// recordDrop6 is the IPv6 variant of recordDrop.
+func (this *TunnelConnection) recordDrop6(reason kubelink.DropReason, header *ipv6.Header) {
+	if header != nil {
+		this.recordPacketDrop(reason, header.Src, header.Dst, header.NextHeader, ipv6.HeaderLen+header.PayloadLen)
+	} else {
+		this.recordPacketDrop(reason, nil, nil, 0, 0)
+	}
+}
+
+func (this *TunnelConnection) recordPacketDrop(reason kubelink.DropReason, src, dst net.IP, protocol int, length int) {
+	sample := DropSample{
+		Time:   time.Now(),
+		Remote: this.remoteAddress,
+		Reason: reason.String(),
+	}
+	if src != nil {
+		sample.Source = src.String()
+		sample.Dest = dst.String()
+		sample.Protocol = protocol
+		sample.Length = length
+		this.mux.warnPacket(this, "  dropping packet %s->%s: %s", src, dst, reason)
+	} else if reason == kubelink.DROP_DUPLICATE {
+		// duplicates are expected on links with redundant paths
+		this.Debugf("  dropping packet: %s", reason)
+	} else {
+		this.mux.warnPacket(this, "  dropping packet: %s", reason)
+	}
+	name, stats := this.linkInfo()
+	sample.Link = name
+	stats.CountDrop(reason)
+	this.mux.drops.Add(sample)
+}
+
+func (this *reconciler) handleDrops(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(this.mux.drops.Get())
+}
+
+func (this *reconciler) collectDropMetrics(w *metrics.Writer) {
+	stats := this.Links().Stats()
+	if len(stats) == 0 {
+		return
+	}
+	names := make([]string, 0, len(stats))
+	for n := range stats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	w.Describe("kubelink_link_dropped_packets_total", metrics.COUNTER, "Number of packets of a link dropped, by reason")
+	for _, n := range names {
+		for r := kubelink.DropReason(0); r < kubelink.DROP_REASONS; r++ {
+			w.Value("kubelink_link_dropped_packets_total", metrics.Labels{"link": n, "reason": r.String()}, float64(stats[n].Dropped[r]))
+		}
+	}
+}
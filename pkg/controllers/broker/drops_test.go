@@ -0,0 +1,122 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"golang.org/x/net/ipv4"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func testDropPacket(t *testing.T, src, dst string) []byte {
+	h := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + 8,
+		TTL:      64,
+		Protocol: kubelink.PROTO_TCP,
+		Src:      net.ParseIP(src),
+		Dst:      net.ParseIP(dst),
+	}
+	data, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, 0, 0, 0, 80, 0, 0, 0, 0)
+}
+
+func TestDropReasons(t *testing.T) {
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Ingress = []string{"10.1.0.0/16"}
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.LogContext = logger.New()
+	m.drops = NewDropSamples(DROP_SAMPLES)
+
+	c1, c2 := net.Pipe()
+	conn := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1, reader: c1, clusterCIDR: m.links.GetLink("a").ClusterAddress}
+	conn.updateStats()
+	peer := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c2}
+
+	type packet struct {
+		ty     byte
+		data   []byte
+		reason kubelink.DropReason
+	}
+	packets := []packet{
+		{PACKET_TYPE_DATA, testDropPacket(t, "192.168.0.99", "100.64.0.1"), kubelink.DROP_UNKNOWN_SOURCE},
+		{PACKET_TYPE_DATA, testDropPacket(t, "192.168.0.10", "10.2.0.1"), kubelink.DROP_INGRESS_DENIED},
+		{PACKET_TYPE_DATA, testDropPacket(t, "100.64.1.5", "10.1.0.1"), kubelink.DROP_WRONG_DESTINATION},
+		{0x7f, []byte{1, 2, 3}, kubelink.DROP_UNKNOWN_TYPE},
+		{PACKET_TYPE_DATA, []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 0, 0}, kubelink.DROP_INVALID_HEADER},
+	}
+	go func() {
+		for _, p := range packets {
+			if peer.WritePacket(p.ty, p.data) != nil {
+				return
+			}
+		}
+		c2.Close()
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- conn.serve() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection not finished")
+	}
+	c1.Close()
+
+	stats := m.links.Stats()["a"]
+	for _, p := range packets {
+		if n := stats.Dropped[p.reason]; n != 1 {
+			t.Errorf("%s: %d packets counted", p.reason, n)
+		}
+	}
+	samples := m.drops.Get()
+	if len(samples) != len(packets) {
+		t.Fatalf("expected %d samples, found %d", len(packets), len(samples))
+	}
+	for i, p := range packets {
+		s := samples[i]
+		if s.Reason != p.reason.String() || s.Link != "a" {
+			t.Errorf("sample %d: got reason %s for link %q, expected %s", i, s.Reason, s.Link, p.reason)
+		}
+	}
+	if s := samples[1]; s.Source != "192.168.0.10" || s.Dest != "10.2.0.1" || s.Protocol != kubelink.PROTO_TCP {
+		t.Errorf("unexpected header of sample: %+v", s)
+	}
+}
+
+func TestDropSamples(t *testing.T) {
+	samples := NewDropSamples(3)
+	for i := 0; i < 5; i++ {
+		samples.Add(DropSample{Remote: fmt.Sprintf("r%d", i)})
+	}
+	got := samples.Get()
+	if len(got) != 3 || got[0].Remote != "r2" || got[2].Remote != "r4" {
+		t.Errorf("unexpected samples %+v", got)
+	}
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// SetDSCP marks all packets sent on the underlying tcp connection
+// with the given DSCP value.
+func SetDSCP(conn net.Conn, dscp int) error {
+	conn = tcp.RawConn(conn)
+	addr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("no tcp connection")
+	}
+	if addr.IP.To4() != nil {
+		return ipv4.NewConn(conn).SetTOS(dscp << 2)
+	}
+	return ipv6.NewConn(conn).SetTrafficClass(dscp << 2)
+}
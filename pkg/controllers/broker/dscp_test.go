@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"golang.org/x/net/ipv4"
+)
+
+func testTOS(t *testing.T, conn net.Conn) int {
+	tos, err := ipv4.NewConn(conn).TOS()
+	if err != nil {
+		t.Fatalf("cannot get tos: %s", err)
+	}
+	return tos
+}
+
+func TestSetDSCP(t *testing.T) {
+	l, _ := testListener(t)
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := SetDSCP(conn, 46); err != nil {
+		t.Fatalf("cannot set dscp: %s", err)
+	}
+	if tos := testTOS(t, conn); tos != 46<<2 {
+		t.Errorf("socket tos is %d, expected %d", tos, 46<<2)
+	}
+}
+
+func TestLinkDSCP(t *testing.T) {
+	peer := testMux(t, "192.168.0.10/24", testLink("b", "192.168.0.1/24", "100.64.0.0/24"))
+	peer.LogContext = logger.New()
+	l := testPeer(t, peer)
+	defer l.Close()
+
+	dscp := 46
+	cases := map[string]struct {
+		link *int
+		tos  int
+	}{
+		"default":  {tos: 10 << 2},
+		"per link": {link: &dscp, tos: 46 << 2},
+	}
+	for name, c := range cases {
+		kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+		kl.Spec.Endpoint = l.Addr().String()
+		kl.Spec.DSCP = c.link
+		m := testMux(t, "192.168.0.1/24", kl)
+		m.LogContext = logger.New()
+		m.helloTimeout = 2 * time.Second
+		m.SetDSCP(10)
+
+		conn, err := m.AssureTunnel(m, m.links.GetLink("a"))
+		if err != nil {
+			t.Fatalf("%s: connection failed: %s", name, err)
+		}
+		if tos := testTOS(t, conn.conn); tos != c.tos {
+			t.Errorf("%s: socket tos is %d, expected %d", name, tos, c.tos)
+		}
+		conn.Close()
+	}
+}
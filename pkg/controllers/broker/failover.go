@@ -0,0 +1,151 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/resources"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/tracing"
+)
+
+// EndpointHandler is notified about a change of the endpoint
+// used to connect a link.
+type EndpointHandler interface {
+	UpdateActiveEndpoint(name string, endpoint string)
+}
+
+func (this *Mux) SetEndpointHandler(handler EndpointHandler) {
+	this.endpointHandler = handler
+}
+
+// activateEndpoint records the endpoint of a successfully dialed
+// tunnel connection. Because dialing always starts with the primary
+// endpoint, a link returns to it with the next reconnect once it
+// is reachable again. Established connections to a failover endpoint
+// are moved back by FailBack.
+func (this *Mux) activateEndpoint(link *kubelink.Link, endpoint string) {
+	if !this.links.SetActiveEndpoint(link.Name, endpoint) {
+		return
+	}
+	if endpoint != link.Endpoint {
+		this.Warnf("link %s connected to failover endpoint %s", link.Name, endpoint)
+	}
+	if this.endpointHandler != nil {
+		go this.endpointHandler.UpdateActiveEndpoint(link.Name, endpoint)
+	}
+}
+
+// UpdateActiveEndpoint reflects the endpoint used for the tunnel
+// connection of a link in its status.
+func (this *reconciler) UpdateActiveEndpoint(name string, endpoint string) {
+	this.Controller().Infof("update active endpoint for link %s: %s", name, endpoint)
+	_, _, err := this.linkResource.ModifyStatusByName(resources.NewObjectName(name),
+		func(odata resources.ObjectData) (bool, error) {
+			klink := odata.(*v1alpha1.KubeLink)
+			if klink.Status.Endpoint == endpoint {
+				return false, nil
+			}
+			klink.Status.Endpoint = endpoint
+			return true, nil
+		})
+	if err != nil {
+		this.Controller().Errorf("cannot update active endpoint for link %s: %s", name, err)
+	}
+}
+
+// FailBack probes the primary endpoint of all links connected via
+// a failover endpoint. If it is reachable again, the tunnel is moved
+// back to the primary endpoint and the failover connection is drained.
+func (this *Mux) FailBack() {
+	var links []*kubelink.Link
+	this.links.Visit(func(l *kubelink.Link) bool {
+		if l.ActiveEndpoint != "" && l.ActiveEndpoint != l.Endpoint {
+			links = append(links, l)
+		}
+		return true
+	})
+	for _, l := range links {
+		this.failBack(l)
+	}
+}
+
+func (this *Mux) failBack(link *kubelink.Link) {
+	if this.IsShuttingDown() {
+		return
+	}
+	span := tracing.StartSpan("kubelink.failback", "kubelink.direction", "outbound", "kubelink.link", link.Name,
+		"kubelink.endpoint", link.Endpoint, "kubelink.cluster_address", link.ClusterAddress.IP.String())
+	t, err := this.dialEndpoint(link, link.Endpoint, span)
+	if err != nil {
+		this.Debugf("primary endpoint %s of link %s still unreachable: %s", link.Endpoint, link.Name, err)
+		span.Finish(err)
+		return
+	}
+
+	this.lock.Lock()
+	old, _ := this.queryClusterConnection(link.ClusterAddress.IP)
+	if old != nil {
+		this.removeTunnelEntry(old)
+	}
+	if !this.addTunnel(t) {
+		this.lock.Unlock()
+		t.Close()
+		span.Finish(fmt.Errorf("redundant connection"))
+		return
+	}
+	this.lock.Unlock()
+	span.Finish(nil)
+
+	this.Infof("link %s failed back to primary endpoint %s", link.Name, link.Endpoint)
+	this.activateEndpoint(link, link.Endpoint)
+	go func() {
+		defer t.mux.RemoveTunnel(t)
+		t.Serve()
+	}()
+	if old != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(this.ctx, 10*time.Second)
+			defer cancel()
+			if err := old.goodbye(ctx); err != nil {
+				old.Close()
+			}
+		}()
+	}
+}
+
+// handleFailBack periodically moves links back to their primary
+// endpoint.
+func (this *reconciler) handleFailBack(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.Controller().GetContext().Done():
+			return
+		case <-ticker.C:
+		}
+		this.mux.FailBack()
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+// testListener accepts connections but never answers the hello.
+func testListener(t *testing.T) (net.Listener, *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := int32(0)
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			conns = append(conns, c)
+		}
+	}()
+	return l, &accepted
+}
+
+// testRefusedEndpoint returns an address nobody is listening on.
+func testRefusedEndpoint(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestAssureTunnelDialUnlocked(t *testing.T) {
+	l, accepted := testListener(t)
+	defer l.Close()
+
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = l.Addr().String() + "," + testRefusedEndpoint(t)
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.LogContext = logger.New()
+	m.helloTimeout = 500 * time.Millisecond
+	link := m.links.GetLink("a")
+
+	results := make(chan error, 2)
+	dial := func() {
+		_, err := m.AssureTunnel(m, link)
+		results <- err
+	}
+	go dial()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(accepted) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("first endpoint not dialed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the mux must stay usable while the dial is pending
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.GetClusterAddress()
+		m.QueryConnectionForIP(net.ParseIP("100.64.1.1"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("mux locked during pending dial")
+	}
+
+	go dial()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			if err == nil {
+				t.Errorf("dial succeeded without a peer")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dial not finished")
+		}
+	}
+	if n := atomic.LoadInt32(accepted); n != 1 {
+		t.Errorf("pending dial repeated: %d connections", n)
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if len(m.dialing) != 0 {
+		t.Errorf("pending dial not cleared")
+	}
+	if m.errors["192.168.0.10"] == nil {
+		t.Errorf("dial error not recorded")
+	}
+}
+
+// testPeer serves tunnel connections for a peer mux.
+func testPeer(t *testing.T, m *Mux) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go m.ServeConnection(context.Background(), c)
+		}
+	}()
+	return l
+}
+
+func TestFailoverEndpointList(t *testing.T) {
+	peer := testMux(t, "192.168.0.10/24", testLink("b", "192.168.0.1/24", "100.64.0.0/24"))
+	peer.LogContext = logger.New()
+	l := testPeer(t, peer)
+	defer l.Close()
+
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = testRefusedEndpoint(t) + ", " + l.Addr().String()
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.LogContext = logger.New()
+	m.helloTimeout = 2 * time.Second
+
+	link := m.links.GetLink("a")
+	if len(link.Endpoints) != 2 || link.Endpoints[1] != l.Addr().String() {
+		t.Fatalf("unexpected endpoints %v", link.Endpoints)
+	}
+	c, err := m.AssureTunnel(m, link)
+	if err != nil {
+		t.Fatalf("failover failed: %s", err)
+	}
+	defer c.Close()
+	if active := m.links.GetLink("a").ActiveEndpoint; active != l.Addr().String() {
+		t.Errorf("active endpoint %q, expected failover endpoint %s", active, l.Addr())
+	}
+}
+
+func TestHelloTimeout(t *testing.T) {
+	l, accepted := testListener(t)
+	defer l.Close()
+
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = l.Addr().String()
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.LogContext = logger.New()
+	m.dialTimeout = 10 * time.Second
+	m.helloTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	_, err := m.AssureTunnel(m, m.links.GetLink("a"))
+	if err == nil {
+		t.Fatalf("dial succeeded without hello")
+	}
+	if !strings.Contains(err.Error(), "hello timeout") {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("hello timeout not applied: failed after %s", d)
+	}
+	if atomic.LoadInt32(accepted) != 1 {
+		t.Errorf("tcp connection not established")
+	}
+}
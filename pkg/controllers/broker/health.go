@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// healthProbePrefix is the start of an http health request. It never
+// matches the start of a connection hello.
+var healthProbePrefix = []byte("GET ")
+
+const healthProbeTimeout = 5 * time.Second
+
+// SetHealthProbe enables answering http health probes on plaintext
+// connections to the broker port.
+func (this *Mux) SetHealthProbe(enabled bool) {
+	this.healthProbe = enabled
+}
+
+// IsReady checks whether the broker is ready to accept tunnel connections.
+// A broker draining its connections is not ready anymore.
+func (this *Mux) IsReady() bool {
+	select {
+	case <-this.ctx.Done():
+		return false
+	default:
+		return this.GetTun() != nil && !this.IsShuttingDown()
+	}
+}
+
+// handleHealthProbe answers an http health probe on a plaintext
+// connection. It returns the connection to continue with and whether
+// the connection has been handled as health probe.
+func (this *Mux) handleHealthProbe(conn net.Conn) (net.Conn, bool) {
+	if !this.healthProbe {
+		return conn, false
+	}
+	if _, ok := conn.(*tls.Conn); ok {
+		return conn, false
+	}
+	conn = tcp.Peekable(conn)
+	conn.SetReadDeadline(time.Now().Add(healthProbeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	data, err := tcp.Peek(conn, len(healthProbePrefix))
+	if err != nil || !bytes.Equal(data, healthProbePrefix) {
+		return conn, false
+	}
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return conn, true
+	}
+	status := http.StatusOK
+	if !this.IsReady() {
+		status = http.StatusServiceUnavailable
+	}
+	body := http.StatusText(status)
+	fmt.Fprintf(conn, "HTTP/1.0 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", status, body, len(body))
+	if req.Method != http.MethodHead {
+		conn.Write([]byte(body))
+	}
+	return conn, true
+}
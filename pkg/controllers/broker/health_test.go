@@ -0,0 +1,61 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func testHealthProbe(t *testing.T, m *Mux) int {
+	l := testPeer(t, m)
+	defer l.Close()
+	resp, err := http.Get("http://" + l.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("health probe failed: %s", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHealthProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := testMux(t, "192.168.0.1/24")
+	m.ctx = ctx
+	m.SetHealthProbe(true)
+
+	if code := testHealthProbe(t, m); code != http.StatusServiceUnavailable {
+		t.Errorf("broker without tun: got status %d", code)
+	}
+	m.tun.Store(&Tun{})
+	if code := testHealthProbe(t, m); code != http.StatusOK {
+		t.Errorf("ready broker: got status %d", code)
+	}
+	m.shutdown = 1
+	if code := testHealthProbe(t, m); code != http.StatusServiceUnavailable {
+		t.Errorf("draining broker: got status %d", code)
+	}
+	m.shutdown = 0
+	cancel()
+	if code := testHealthProbe(t, m); code != http.StatusServiceUnavailable {
+		t.Errorf("stopped broker: got status %d", code)
+	}
+}
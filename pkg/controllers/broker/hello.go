@@ -21,6 +21,7 @@ package broker
 import (
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 
 	"github.com/gardener/controller-manager-library/pkg/logger"
@@ -30,6 +31,29 @@ import (
 
 const EXT_APIACCESS = 1
 const EXT_DNS = 2
+const EXT_SERVICES = 3
+const EXT_MTU = 4
+const EXT_KEEPALIVE = 5
+const EXT_COMPRESSION = 6
+const EXT_CIDRS = 7
+
+var extensionNames = map[byte]string{
+	EXT_APIACCESS:   "apiaccess",
+	EXT_DNS:         "dns",
+	EXT_SERVICES:    "services",
+	EXT_MTU:         "mtu",
+	EXT_KEEPALIVE:   "keepalive",
+	EXT_COMPRESSION: "compression",
+	EXT_CIDRS:       "cidrs",
+}
+
+// ExtensionName returns a readable name for a hello extension id.
+func ExtensionName(id byte) string {
+	if n, ok := extensionNames[id]; ok {
+		return n
+	}
+	return fmt.Sprintf("extension-%d", id)
+}
 
 type ConnectionHelloExtensionHandler interface {
 	Parse(id byte, data []byte) (ConnectionHelloExtension, error)
@@ -50,6 +74,14 @@ func RegisterExtension(id byte, c ConnectionHelloExtensionHandler) {
 	registry[id] = c
 }
 
+// KnownExtension reports whether a handler is registered for
+// the given extension id.
+func KnownExtension(id byte) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return registry[id] != nil
+}
+
 func GetExtension(id byte, data []byte) (ConnectionHelloExtension, error) {
 	lock.RLock()
 	defer lock.RUnlock()
@@ -66,6 +98,9 @@ type ConnectionHello struct {
 	ConnectionHelloHeader
 	Extensions map[byte]ConnectionHelloExtension
 	Raw        map[byte][]byte
+	// Unknown lists the ids of received extensions without registered
+	// handler. Their raw data is preserved.
+	Unknown []byte
 }
 
 func NewConnectionHello() *ConnectionHello {
@@ -93,6 +128,12 @@ func ParseConnectionHello(logger logger.LogContext, header *ConnectionHelloHeade
 		}
 		raw := data[start+3 : start+3+el]
 		hello.Raw[id] = raw
+		if !KnownExtension(id) {
+			logger.Infof("unknown hello extension %d (%d bytes)", id, el)
+			hello.Unknown = append(hello.Unknown, id)
+			start = start + 3 + el
+			continue
+		}
 		ext, err := GetExtension(id, raw)
 		if err != nil {
 			logger.Errorf("extension %d: %s", id, err)
@@ -106,6 +147,30 @@ func ParseConnectionHello(logger logger.LogContext, header *ConnectionHelloHeade
 	return hello, nil
 }
 
+// NegotiatedExtensions describes the hello extensions received from
+// a peer: the understood ones and the ones offered without a
+// registered handler.
+type NegotiatedExtensions struct {
+	Accepted []string `json:"accepted,omitempty"`
+	Unknown  []string `json:"unknown,omitempty"`
+}
+
+func (this *ConnectionHello) Negotiated() NegotiatedExtensions {
+	var ids []int
+	for id := range this.Extensions {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	result := NegotiatedExtensions{}
+	for _, id := range ids {
+		result.Accepted = append(result.Accepted, ExtensionName(byte(id)))
+	}
+	for _, id := range this.Unknown {
+		result.Unknown = append(result.Unknown, ExtensionName(id))
+	}
+	return result
+}
+
 func (this *ConnectionHello) Data() []byte {
 	var ext []byte
 
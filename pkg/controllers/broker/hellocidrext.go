@@ -0,0 +1,108 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+func init() {
+	RegisterExtension(EXT_CIDRS, &CIDRsExtensionHandler{})
+}
+
+// CIDRsExtension carries all cidrs advertised to a peer. The hello
+// header only provides space for the first one.
+type CIDRsExtension tcp.CIDRList
+
+var _ ConnectionHelloExtension = (*CIDRsExtension)(nil)
+
+func (this *CIDRsExtension) Id() byte {
+	return EXT_CIDRS
+}
+
+func (this *CIDRsExtension) Data() []byte {
+	var data []byte
+	for _, c := range *this {
+		data = append(data, c.IP.To16()...)
+		data = append(data, net.IP(c.Mask).To16()...)
+	}
+	return data
+}
+
+func (this *CIDRsExtension) String() string {
+	list := tcp.CIDRList(*this)
+	return list.String()
+}
+
+type CIDRsExtensionHandler struct{}
+
+var _ ConnectionHelloExtensionHandler = &CIDRsExtensionHandler{}
+
+func (this *CIDRsExtensionHandler) Parse(id byte, data []byte) (ConnectionHelloExtension, error) {
+	if id != EXT_CIDRS {
+		return nil, fmt.Errorf("invalid extension %d for cidrs", id)
+	}
+	if len(data)%(net.IPv6len*2) != 0 {
+		return nil, fmt.Errorf("invalid cidrs extension length %d", len(data))
+	}
+	ext := CIDRsExtension{}
+	for start := 0; start < len(data); start += net.IPv6len * 2 {
+		ip := net.IP(append([]byte{}, data[start:start+net.IPv6len]...))
+		mask := net.IPMask(append([]byte{}, data[start+net.IPv6len:start+net.IPv6len*2]...))
+		if ip.To4() != nil {
+			ip = ip.To4()
+			mask = mask[net.IPv6len-net.IPv4len:]
+		}
+		ext = append(ext, &net.IPNet{IP: ip, Mask: mask})
+	}
+	return &ext, nil
+}
+
+// Add does nothing, the advertised cidrs depend on the link
+// and are set when the hello for a connection is created.
+func (this *CIDRsExtensionHandler) Add(hello *ConnectionHello, mux *Mux) {
+}
+
+// SetCIDRs sets the advertised cidrs. The first one is additionally
+// stored in the header for peers not supporting the cidrs extension.
+func (this *ConnectionHello) SetCIDRs(cidrs tcp.CIDRList) {
+	if len(cidrs) == 0 {
+		return
+	}
+	this.SetCIDR(cidrs[0])
+	if len(cidrs) > 1 {
+		ext := CIDRsExtension(cidrs)
+		this.Extensions[EXT_CIDRS] = &ext
+	}
+}
+
+// GetCIDRs returns the cidrs advertised by the peer.
+func (this *ConnectionHello) GetCIDRs() tcp.CIDRList {
+	if ext := this.Extensions[EXT_CIDRS]; ext != nil {
+		return tcp.CIDRList(*ext.(*CIDRsExtension))
+	}
+	cidr := this.GetCIDR()
+	if cidr.IP.IsUnspecified() {
+		return nil
+	}
+	return tcp.CIDRList{cidr}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+func TestHelloAdvertisedCIDRs(t *testing.T) {
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Advertise = []string{"10.0.0.0/8"}
+	m := testMux(t, "192.168.0.1/24", kl)
+	for _, s := range []string{"172.16.0.0/16", "10.1.0.0/16", "10.2.0.0/16"} {
+		_, c, _ := net.ParseCIDR(s)
+		m.local.Add(c)
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	conn := &TunnelConnection{mux: m, conn: c1}
+
+	hello := conn.createHello(m.links.GetLink("a"))
+	var header ConnectionHelloHeader
+	data := hello.Data()
+	copy(header[:], data)
+	remote, err := ParseConnectionHello(m, &header, data[len(header):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := remote.GetCIDRs()
+	expected := tcp.CIDRList{}
+	for _, s := range []string{"10.1.0.0/16", "10.2.0.0/16"} {
+		_, c, _ := net.ParseCIDR(s)
+		expected.Add(c)
+	}
+	if got.String() != expected.String() {
+		t.Errorf("advertised %s, expected %s", got.String(), expected.String())
+	}
+	if remote.GetCIDR().String() != "10.1.0.0/16" {
+		t.Errorf("header cidr %s, expected 10.1.0.0/16", remote.GetCIDR())
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+const testUnknownExtension = 250
+
+func TestUnknownHelloExtensions(t *testing.T) {
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.LogContext = logger.New()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	conn := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1}
+
+	hello := conn.createHello(m.links.GetLink("a"))
+	known := len(hello.Extensions)
+	hello.Raw[testUnknownExtension] = []byte("future")
+	data := hello.Data()
+
+	for name, strict := range map[string]bool{"lenient": false, "strict": true} {
+		m.SetStrictExtensions(strict)
+		remote, err := conn.parseHelloPacket(data)
+		if strict {
+			if err == nil {
+				t.Errorf("%s: hello with unknown extension accepted", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(remote.Unknown) != 1 || remote.Unknown[0] != testUnknownExtension {
+			t.Errorf("%s: unexpected unknown extensions %v", name, remote.Unknown)
+		}
+		if !bytes.Equal(remote.Raw[testUnknownExtension], []byte("future")) {
+			t.Errorf("%s: unknown extension not preserved", name)
+		}
+		if len(remote.Extensions) != known {
+			t.Errorf("%s: %d known extensions parsed, expected %d", name, len(remote.Extensions), known)
+		}
+	}
+
+	// without unknown extensions strict mode accepts the hello
+	m.SetStrictExtensions(true)
+	delete(hello.Raw, testUnknownExtension)
+	if _, err := conn.parseHelloPacket(hello.Data()); err != nil {
+		t.Errorf("strict: hello without unknown extensions rejected: %s", err)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// DEFAULT_MTU is assumed for peers not announcing their tun MTU.
+const DEFAULT_MTU = 1500
+
+func init() {
+	RegisterExtension(EXT_MTU, &MTUExtensionHandler{})
+}
+
+type MTUExtension uint16
+
+var _ ConnectionHelloExtension = (*MTUExtension)(nil)
+
+func (this *MTUExtension) Id() byte {
+	return EXT_MTU
+}
+
+func (this *MTUExtension) Data() []byte {
+	return tcp.HtoNs(uint16(*this))
+}
+
+func (this *MTUExtension) String() string {
+	return fmt.Sprintf("%d", *this)
+}
+
+type MTUExtensionHandler struct{}
+
+var _ ConnectionHelloExtensionHandler = &MTUExtensionHandler{}
+
+func (this *MTUExtensionHandler) Parse(id byte, data []byte) (ConnectionHelloExtension, error) {
+	if id != EXT_MTU {
+		return nil, fmt.Errorf("invalid extension %d for MTU", id)
+	}
+	if len(data) != 2 {
+		return nil, fmt.Errorf("invalid MTU extension length %d", len(data))
+	}
+	ext := MTUExtension(tcp.NtoHs(data))
+	return &ext, nil
+}
+
+func (this *MTUExtensionHandler) Add(hello *ConnectionHello, mux *Mux) {
+	ext := MTUExtension(mux.MTU())
+	hello.Extensions[EXT_MTU] = &ext
+}
+
+// GetMTU returns the tun MTU announced by the peer or the
+// default for peers not supporting the MTU extension.
+func (this *ConnectionHello) GetMTU() int {
+	if ext := this.Extensions[EXT_MTU]; ext != nil {
+		if mtu := int(*ext.(*MTUExtension)); mtu > 0 {
+			return mtu
+		}
+	}
+	return DEFAULT_MTU
+}
+
+// MTU returns the MTU of the local tun device.
+func (this *Mux) MTU() int {
+	tun := this.GetTun()
+	if tun == nil || tun.mtu <= 0 {
+		return DEFAULT_MTU
+	}
+	return tun.mtu
+}
+
+// negotiateMTU determines the MTU usable for a connection, which is
+// the minimum of the local and the remote tun MTU. Peers not announcing
+// their MTU are assumed to use DEFAULT_MTU. The routes to the link are
+// limited accordingly (see ApplyMTU).
+func (this *TunnelConnection) negotiateMTU(remote *ConnectionHello) {
+	mtu := this.mux.MTU()
+	if r := remote.GetMTU(); r < mtu {
+		mtu = r
+	}
+	this.mtu = mtu
+	this.Infof("using MTU %d (local %d, remote %d)", mtu, this.mux.MTU(), remote.GetMTU())
+}
+
+// ApplyMTU sets the MTU of routes to destinations served by connections
+// with a negotiated MTU below the tun MTU. The tun device is shared by
+// all links, so the kernel applies the minimum of both MTUs per route
+// and reports it to the senders of forwarded packets.
+func (this *Mux) ApplyMTU(routes kubelink.Routes) kubelink.Routes {
+	for i, r := range routes {
+		if r.Dst != nil && r.LinkIndex > 0 {
+			routes[i].MTU = this.routeMTU(r.Dst)
+		}
+	}
+	return routes
+}
+
+// routeMTU returns the MTU required for routes to the given destination
+// or 0 if the MTU of the tun device can be used.
+func (this *Mux) routeMTU(dst *net.IPNet) int {
+	t, _ := this.QueryConnectionForIP(dst.IP)
+	if t == nil || t.mtu <= 0 || t.mtu >= this.MTU() {
+		return 0
+	}
+	return t.mtu
+}
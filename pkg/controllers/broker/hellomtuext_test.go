@@ -0,0 +1,103 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/vishvananda/netlink"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func testMTUHello(mtu int) *ConnectionHello {
+	hello := NewConnectionHello()
+	if mtu > 0 {
+		ext := MTUExtension(mtu)
+		hello.Extensions[EXT_MTU] = &ext
+	}
+	return hello
+}
+
+func TestNegotiateMTU(t *testing.T) {
+	cases := []struct {
+		name     string
+		local    int
+		remote   int
+		expected int
+	}{
+		{"remote smaller", 9000, 1400, 1400},
+		{"local smaller", 1400, 9000, 1400},
+		{"legacy peer", 9000, 0, DEFAULT_MTU},
+		{"legacy peer with smaller tun", 1300, 0, 1300},
+		{"no tun", 0, 0, DEFAULT_MTU},
+		{"no tun with smaller remote", 0, 1200, 1200},
+	}
+	for _, c := range cases {
+		m := testMux(t, "192.168.0.1/24")
+		if c.local > 0 {
+			tun := testTun(newTestQueue(nil))
+			tun.mtu = c.local
+			m.ReplaceTun(tun)
+		}
+		conn := &TunnelConnection{LogContext: logger.New(), mux: m}
+		conn.negotiateMTU(testMTUHello(c.remote))
+		if conn.mtu != c.expected {
+			t.Errorf("%s: negotiated MTU %d, expected %d", c.name, conn.mtu, c.expected)
+		}
+	}
+}
+
+func TestApplyMTU(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+		testLink("b", "192.168.0.11/24", "100.64.2.0/24"),
+		testLink("c", "192.168.0.12/24", "100.64.3.0/24"),
+	)
+	m.LogContext = logger.New()
+	tun := testTun(newTestQueue(nil))
+	tun.mtu = 9000
+	m.ReplaceTun(tun)
+
+	// a legacy peer limits the routes to its link to the default MTU
+	a := testConnection(t, m, "a", true)
+	a.negotiateMTU(testMTUHello(0))
+	m.AddTunnel(a)
+	b := testConnection(t, m, "b", true)
+	b.negotiateMTU(testMTUHello(9000))
+	m.AddTunnel(b)
+
+	route := func(cidr string) netlink.Route {
+		_, dst, _ := net.ParseCIDR(cidr)
+		return netlink.Route{Dst: dst, LinkIndex: 5, Protocol: kubelink.BROKER_ROUTE_PROTOCOL}
+	}
+	routes := m.ApplyMTU(kubelink.Routes{route("100.64.1.0/24"), route("100.64.2.0/24"), route("100.64.3.0/24")})
+	for i, expected := range []int{DEFAULT_MTU, 0, 0} {
+		if routes[i].MTU != expected {
+			t.Errorf("route %s: MTU %d, expected %d", routes[i].Dst, routes[i].MTU, expected)
+		}
+	}
+
+	// a changed MTU requires a new route
+	if routes.Lookup(route("100.64.1.0/24")) >= 0 {
+		t.Errorf("route without MTU matches the route with MTU")
+	}
+}
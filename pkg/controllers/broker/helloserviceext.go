@@ -0,0 +1,95 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+const serviceEntrySize = net.IPv6len + 3
+
+func init() {
+	RegisterExtension(EXT_SERVICES, &ServiceExtensionHandler{})
+}
+
+// ServiceHandler is notified about the service endpoints
+// advertised by the cluster with the given cluster address.
+type ServiceHandler interface {
+	UpdateServices(clusterAddress net.IP, services kubelink.ServiceEndpoints)
+}
+
+type ServiceExtension kubelink.ServiceEndpoints
+
+var _ ConnectionHelloExtension = &ServiceExtension{}
+
+func (this *ServiceExtension) Id() byte {
+	return EXT_SERVICES
+}
+
+func (this *ServiceExtension) Data() []byte {
+	var d []byte
+	for _, ep := range *this {
+		d = append(d, ep.IP.To16()...)
+		d = append(d, tcp.HtoNs(ep.Port)...)
+		d = append(d, ep.Protocol)
+	}
+	return d
+}
+
+func (this *ServiceExtension) String() string {
+	return kubelink.ServiceEndpoints(*this).String()
+}
+
+type ServiceExtensionHandler struct{}
+
+var _ ConnectionHelloExtensionHandler = &ServiceExtensionHandler{}
+
+func (this *ServiceExtensionHandler) Parse(id byte, data []byte) (ConnectionHelloExtension, error) {
+	if id != EXT_SERVICES {
+		return nil, fmt.Errorf("invalid extension %d for services", id)
+	}
+	if len(data)%serviceEntrySize != 0 {
+		return nil, fmt.Errorf("invalid service extension length %d", len(data))
+	}
+	ext := ServiceExtension{}
+	for start := 0; start < len(data); start += serviceEntrySize {
+		ip := tcp.CloneIP(data[start : start+net.IPv6len])
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		ext = append(ext, &kubelink.ServiceEndpoint{
+			IP:       ip,
+			Port:     tcp.NtoHs(data[start+net.IPv6len:]),
+			Protocol: data[start+net.IPv6len+2],
+		})
+	}
+	return &ext, nil
+}
+
+func (this *ServiceExtensionHandler) Add(hello *ConnectionHello, mux *Mux) {
+	if len(mux.services) > 0 {
+		mux.Infof("adding services %s", mux.services)
+		ext := ServiceExtension(mux.services)
+		hello.Extensions[EXT_SERVICES] = &ext
+	}
+}
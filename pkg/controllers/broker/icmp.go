@@ -0,0 +1,214 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const ICMP_PROTOCOL = 1
+const ICMP_DEST_UNREACHABLE = 3
+const ICMP_TIME_EXCEEDED = 11
+
+// ICMP_FRAGMENTATION_NEEDED is the destination unreachable code
+// used to report a packet exceeding the MTU.
+const ICMP_FRAGMENTATION_NEEDED = 4
+
+const ICMPV6_PROTOCOL = 58
+const ICMPV6_PACKET_TOO_BIG = 2
+const ICMPV6_TIME_EXCEEDED = 3
+
+// IPV6_MIN_MTU is the minimum MTU of an IPv6 link, which limits the
+// size of ICMPv6 error messages.
+const IPV6_MIN_MTU = 1280
+
+// IPV6_FRAGMENT_HEADER is the next header value of the IPv6
+// fragment extension header.
+const IPV6_FRAGMENT_HEADER = 44
+
+// ICMP_RATE limits the number of ICMP error messages generated
+// by the broker per second.
+const ICMP_RATE = 100
+
+// allowICMP reports whether the ICMP rate limit permits another
+// error message.
+func (this *Mux) allowICMP() bool {
+	ok, _ := this.icmpLimit.Allow(time.Now())
+	return ok
+}
+
+// icmpErrorAllowed checks whether an ICMP error message may be sent
+// for an ipv4 packet. ICMP error messages and non-initial fragments
+// are never answered.
+func icmpErrorAllowed(header *ipv4.Header, packet []byte) bool {
+	if header.FragOff != 0 {
+		// only the first fragment is answered
+		return false
+	}
+	if header.Protocol == ICMP_PROTOCOL && len(packet) > header.Len {
+		switch packet[header.Len] {
+		case ICMP_DEST_UNREACHABLE, 4, 5, ICMP_TIME_EXCEEDED, 12:
+			return false
+		}
+	}
+	return true
+}
+
+// icmp6ErrorAllowed checks whether an ICMPv6 error message may be sent
+// for an ipv6 packet. ICMPv6 error messages and non-initial fragments
+// are never answered.
+func icmp6ErrorAllowed(header *ipv6.Header, packet []byte) bool {
+	if header.NextHeader == IPV6_FRAGMENT_HEADER && len(packet) >= ipv6.HeaderLen+8 {
+		// only the first fragment is answered
+		return binary.BigEndian.Uint16(packet[ipv6.HeaderLen+2:])&0xfff8 == 0
+	}
+	if header.NextHeader == ICMPV6_PROTOCOL && len(packet) > ipv6.HeaderLen {
+		// error messages use types below 128
+		return packet[ipv6.HeaderLen] >= 128
+	}
+	return true
+}
+
+// icmpError creates an ipv4 packet with an ICMP error message of the
+// given type and code for the given packet. value is stored in the
+// second half of the ICMP header.
+func icmpError(src, dst net.IP, packet []byte, typ, code byte, value uint16) []byte {
+	src = src.To4()
+	dst = dst.To4()
+	if src == nil || dst == nil {
+		return nil
+	}
+	// original header plus 8 bytes of the payload
+	quoted := packet
+	if hlen := int(packet[0]&0x0f) * 4; len(quoted) > hlen+8 {
+		quoted = quoted[:hlen+8]
+	}
+	msg := make([]byte, ipv4.HeaderLen+8+len(quoted))
+	msg[0] = 0x45
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+	msg[8] = 64
+	msg[9] = ICMP_PROTOCOL
+	copy(msg[12:16], src)
+	copy(msg[16:20], dst)
+	binary.BigEndian.PutUint16(msg[10:], checksum(msg[:ipv4.HeaderLen]))
+
+	icmp := msg[ipv4.HeaderLen:]
+	icmp[0] = typ
+	icmp[1] = code
+	binary.BigEndian.PutUint16(icmp[6:], value)
+	copy(icmp[8:], quoted)
+	binary.BigEndian.PutUint16(icmp[2:], checksum(icmp))
+	return msg
+}
+
+// FragmentationNeeded creates an ipv4 packet with an ICMP destination
+// unreachable message (fragmentation needed) reporting the given mtu
+// for the given packet.
+func FragmentationNeeded(src, dst net.IP, packet []byte, mtu int) []byte {
+	return icmpError(src, dst, packet, ICMP_DEST_UNREACHABLE, ICMP_FRAGMENTATION_NEEDED, uint16(mtu))
+}
+
+// PacketTooBig creates an ipv6 packet with an ICMPv6 packet too big
+// message reporting the given mtu for the given packet.
+func PacketTooBig(src, dst net.IP, packet []byte, mtu int) []byte {
+	return icmp6Error(src, dst, packet, ICMPV6_PACKET_TOO_BIG, 0, uint32(mtu))
+}
+
+// icmp6Error creates an ipv6 packet with an ICMPv6 error message of the
+// given type and code for the given packet. value is stored in the
+// second half of the ICMPv6 header.
+func icmp6Error(src, dst net.IP, packet []byte, typ, code byte, value uint32) []byte {
+	if src.To4() != nil || dst.To4() != nil {
+		return nil
+	}
+	src = src.To16()
+	dst = dst.To16()
+	if src == nil || dst == nil {
+		return nil
+	}
+	// as much of the original packet as possible
+	quoted := packet
+	if max := IPV6_MIN_MTU - ipv6.HeaderLen - 8; len(quoted) > max {
+		quoted = quoted[:max]
+	}
+	msg := make([]byte, ipv6.HeaderLen+8+len(quoted))
+	msg[0] = ipv6.Version << 4
+	binary.BigEndian.PutUint16(msg[4:], uint16(8+len(quoted)))
+	msg[6] = ICMPV6_PROTOCOL
+	msg[7] = 64
+	copy(msg[8:24], src)
+	copy(msg[24:40], dst)
+
+	icmp := msg[ipv6.HeaderLen:]
+	icmp[0] = typ
+	icmp[1] = code
+	binary.BigEndian.PutUint32(icmp[4:], value)
+	copy(icmp[8:], quoted)
+
+	// checksum includes the ipv6 pseudo header
+	pseudo := make([]byte, 40+len(icmp))
+	copy(pseudo[0:16], src)
+	copy(pseudo[16:32], dst)
+	binary.BigEndian.PutUint32(pseudo[32:], uint32(len(icmp)))
+	pseudo[39] = ICMPV6_PROTOCOL
+	copy(pseudo[40:], icmp)
+	binary.BigEndian.PutUint16(icmp[2:], checksum(pseudo))
+	return msg
+}
+
+// tooBig creates an ICMP message reporting the given mtu to the source
+// of a packet exceeding it. It returns nil if no message must be sent.
+func (this *Mux) tooBig(packet []byte, mtu int) []byte {
+	src := this.GetClusterAddress().IP
+	switch int(packet[0]) >> 4 {
+	case ipv4.Version:
+		header, err := ipv4.ParseHeader(packet)
+		if err != nil || !icmpErrorAllowed(header, packet) || !this.allowICMP() {
+			return nil
+		}
+		return FragmentationNeeded(src, header.Src, packet, mtu)
+	case ipv6.Version:
+		header, err := ipv6.ParseHeader(packet)
+		if err != nil || !icmp6ErrorAllowed(header, packet) || !this.allowICMP() {
+			return nil
+		}
+		return PacketTooBig(src, header.Src, packet, mtu)
+	}
+	return nil
+}
+
+// checksum calculates the internet checksum (RFC 1071).
+func checksum(data []byte) uint16 {
+	sum := uint32(0)
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
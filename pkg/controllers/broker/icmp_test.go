@@ -0,0 +1,160 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestFragmentationNeeded(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24")
+	packet, _ := testPacket(t, 0, 80)
+	packet = append(packet, make([]byte, 1000)...)
+
+	msg := m.tooBig(packet, 1400)
+	if msg == nil {
+		t.Fatal("no icmp message")
+	}
+	header, err := ipv4.ParseHeader(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.Src.Equal(net.ParseIP("192.168.0.1")) || !header.Dst.Equal(net.ParseIP("192.168.0.10")) {
+		t.Errorf("unexpected addresses %s->%s", header.Src, header.Dst)
+	}
+	icmp := msg[header.Len:]
+	if icmp[0] != ICMP_DEST_UNREACHABLE || icmp[1] != ICMP_FRAGMENTATION_NEEDED {
+		t.Errorf("unexpected icmp type %d code %d", icmp[0], icmp[1])
+	}
+	if mtu := binary.BigEndian.Uint16(icmp[6:]); mtu != 1400 {
+		t.Errorf("unexpected mtu %d", mtu)
+	}
+	if checksum(icmp) != 0 {
+		t.Errorf("invalid icmp checksum")
+	}
+
+	if m.tooBig(msg, 20) != nil {
+		t.Errorf("icmp error message answered")
+	}
+}
+
+func TestPacketTooBig(t *testing.T) {
+	m := testMux(t, "fd00::1/64")
+	packet := make([]byte, ipv6.HeaderLen+2000)
+	packet[0] = ipv6.Version << 4
+	binary.BigEndian.PutUint16(packet[4:], 2000)
+	packet[6] = 17
+	packet[7] = 64
+	copy(packet[8:24], net.ParseIP("fd00::10"))
+	copy(packet[24:40], net.ParseIP("fd01::1"))
+
+	msg := m.tooBig(packet, 1400)
+	if msg == nil {
+		t.Fatal("no icmp message")
+	}
+	if len(msg) != IPV6_MIN_MTU {
+		t.Errorf("unexpected message size %d", len(msg))
+	}
+	header, err := ipv6.ParseHeader(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.NextHeader != ICMPV6_PROTOCOL || !header.Dst.Equal(net.ParseIP("fd00::10")) {
+		t.Errorf("unexpected header %s", header)
+	}
+	icmp := msg[ipv6.HeaderLen:]
+	if icmp[0] != ICMPV6_PACKET_TOO_BIG || binary.BigEndian.Uint32(icmp[4:]) != 1400 {
+		t.Errorf("unexpected icmp type %d mtu %d", icmp[0], binary.BigEndian.Uint32(icmp[4:]))
+	}
+	pseudo := append(append([]byte{}, msg[8:40]...), 0, 0, byte(len(icmp)>>8), byte(len(icmp)), 0, 0, 0, ICMPV6_PROTOCOL)
+	if checksum(append(pseudo, icmp...)) != 0 {
+		t.Errorf("invalid icmpv6 checksum")
+	}
+
+	if m.tooBig(msg, 1280) != nil {
+		t.Errorf("icmpv6 error message answered")
+	}
+}
+
+func TestICMPFragments(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24")
+	packet, _ := testPacket(t, 185, 80)
+	if m.tooBig(packet, 20) != nil {
+		t.Errorf("non-initial fragment answered")
+	}
+}
+
+func TestICMPRateLimit(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24")
+	packet, _ := testPacket(t, 0, 80)
+	count := 0
+	for i := 0; i < 2*ICMP_RATE; i++ {
+		if m.tooBig(packet, 20) != nil {
+			count++
+		}
+	}
+	if count != ICMP_RATE {
+		t.Errorf("unexpected number of icmp messages %d", count)
+	}
+}
+
+func TestRelayTimeExceeded(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	m.relay = true
+	m.relayTimeExceeded = true
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	conn := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1, clusterCIDR: m.links.GetLink("a").ClusterAddress}
+
+	packet, header := testPacket(t, 0, 80)
+	packet[8] = 1
+	header.TTL = 1
+	go func() {
+		if conn.relayPacket(header, packet) {
+			t.Errorf("packet with expired ttl relayed")
+		}
+	}()
+
+	frame := make([]byte, 1024)
+	if _, err := io.ReadFull(c2, frame[:frameHeaderSize]); err != nil {
+		t.Fatal(err)
+	}
+	if frame[2] != PACKET_TYPE_DATA {
+		t.Fatalf("unexpected frame header %v", frame[:frameHeaderSize])
+	}
+	msg := frame[frameHeaderSize : frameHeaderSize+binary.BigEndian.Uint16(frame)]
+	if _, err := io.ReadFull(c2, msg); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := ipv4.ParseHeader(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Dst.Equal(header.Src) || reply.Protocol != ICMP_PROTOCOL || msg[reply.Len] != ICMP_TIME_EXCEEDED {
+		t.Errorf("unexpected reply %s", reply)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// testServePacket feeds a single packet through the serve loop of a
+// connection for the given link.
+func testServePacket(t *testing.T, m *Mux, link string, packet []byte) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	conn := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1, reader: c1, clusterCIDR: m.links.GetLink(link).ClusterAddress}
+	conn.updateStats()
+	peer := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c2}
+	go func() {
+		peer.WritePacket(PACKET_TYPE_DATA, packet)
+		c2.Close()
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- conn.serve() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection for %s not finished", link)
+	}
+}
+
+func TestIngressMode(t *testing.T) {
+	cases := map[string]struct {
+		dflt    string
+		enforce string
+		audit   string
+	}{
+		"enforce by default": {kubelink.INGRESS_ENFORCE, "", kubelink.INGRESS_AUDIT},
+		"audit by default":   {kubelink.INGRESS_AUDIT, kubelink.INGRESS_ENFORCE, ""},
+	}
+	for name, c := range cases {
+		enforced := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+		enforced.Spec.Ingress = []string{"10.1.0.0/16"}
+		if c.enforce != "" {
+			enforced.Annotations = map[string]string{kubelink.ANNOTATION_INGRESS_MODE: c.enforce}
+		}
+		audited := testLink("b", "192.168.0.11/24", "100.64.2.0/24")
+		audited.Spec.Ingress = []string{"10.1.0.0/16"}
+		if c.audit != "" {
+			audited.Annotations = map[string]string{kubelink.ANNOTATION_INGRESS_MODE: c.audit}
+		}
+		m := testMux(t, "192.168.0.1/24", enforced, audited)
+		m.LogContext = logger.New()
+		m.drops = NewDropSamples(DROP_SAMPLES)
+		m.SetIngressMode(c.dflt)
+		queue := newTestQueue(nil)
+		m.ReplaceTun(testTun(queue))
+
+		testServePacket(t, m, "a", testDropPacket(t, "192.168.0.10", "10.2.0.1"))
+		if queue.Written() != 0 {
+			t.Errorf("%s: denied packet of enforced link forwarded", name)
+		}
+		testServePacket(t, m, "b", testDropPacket(t, "192.168.0.11", "10.2.0.1"))
+		if queue.Written() != 1 {
+			t.Errorf("%s: denied packet of audited link not forwarded", name)
+		}
+		// granted packets are not audited
+		testServePacket(t, m, "b", testDropPacket(t, "192.168.0.11", "10.1.0.1"))
+		if queue.Written() != 2 {
+			t.Errorf("%s: granted packet of audited link not forwarded", name)
+		}
+
+		stats := m.links.Stats()
+		if a := stats["a"]; a.Dropped[kubelink.DROP_INGRESS_DENIED] != 1 || a.Audited != 0 {
+			t.Errorf("%s: enforced link: %d dropped, %d audited", name, a.Dropped[kubelink.DROP_INGRESS_DENIED], a.Audited)
+		}
+		if b := stats["b"]; b.Dropped[kubelink.DROP_INGRESS_DENIED] != 0 || b.Audited != 1 {
+			t.Errorf("%s: audited link: %d dropped, %d audited", name, b.Dropped[kubelink.DROP_INGRESS_DENIED], b.Audited)
+		}
+		queue.Close()
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"golang.org/x/net/ipv4"
+)
+
+// PacketInterceptor handles packets sent to the local cluster address
+// on a dedicated port instead of forwarding them. Packets received on a
+// tunnel connection are passed with this connection, packets read from
+// the tun device are passed without connection. The packet buffer is
+// reused after the call, so it must be copied if it is required
+// afterwards.
+type PacketInterceptor interface {
+	InterceptPacket(t *TunnelConnection, header *ipv4.Header, packet []byte)
+}
+
+type PacketInterceptorFunc func(t *TunnelConnection, header *ipv4.Header, packet []byte)
+
+func (this PacketInterceptorFunc) InterceptPacket(t *TunnelConnection, header *ipv4.Header, packet []byte) {
+	this(t, header, packet)
+}
+
+func interceptorKey(proto byte, port uint16) uint32 {
+	return uint32(proto)<<16 | uint32(port)
+}
+
+// Intercept registers an interceptor for packets sent to the given
+// protocol port of the local cluster address. A nil interceptor
+// removes a former registration.
+func (this *Mux) Intercept(proto byte, port uint16, h PacketInterceptor) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if h == nil {
+		delete(this.interceptors, interceptorKey(proto, port))
+		return
+	}
+	if this.interceptors == nil {
+		this.interceptors = map[uint32]PacketInterceptor{}
+	}
+	this.interceptors[interceptorKey(proto, port)] = h
+}
+
+func (this *Mux) interceptPacket(t *TunnelConnection, header *ipv4.Header, packet []byte) bool {
+	if !this.IsLocalAddress(header.Dst) {
+		return false
+	}
+	this.lock.RLock()
+	h := this.interceptors[interceptorKey(byte(header.Protocol), destinationPort(packet, header))]
+	this.lock.RUnlock()
+	if h == nil {
+		return false
+	}
+	h.InterceptPacket(t, header, packet)
+	return true
+}
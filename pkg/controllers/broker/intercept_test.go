@@ -0,0 +1,80 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"golang.org/x/net/ipv4"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func TestInterceptTun(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24")
+	var intercepted []uint16
+	m.Intercept(kubelink.PROTO_TCP, 9000, PacketInterceptorFunc(func(t *TunnelConnection, header *ipv4.Header, packet []byte) {
+		intercepted = append(intercepted, destinationPort(packet, header))
+	}))
+
+	packet, _ := testPacketTo(t, "192.168.0.1", 0, 9000)
+	if m.FindConnection(logger.New(), packet) != nil || len(intercepted) != 1 {
+		t.Errorf("management packet not intercepted")
+	}
+	packet, _ = testPacketTo(t, "192.168.0.1", 0, 80)
+	m.FindConnection(logger.New(), packet)
+	if len(intercepted) != 1 {
+		t.Errorf("packet for other port intercepted")
+	}
+	m.Intercept(kubelink.PROTO_TCP, 9000, nil)
+	packet, _ = testPacketTo(t, "192.168.0.1", 0, 9000)
+	m.FindConnection(logger.New(), packet)
+	if len(intercepted) != 1 {
+		t.Errorf("packet intercepted after removal")
+	}
+}
+
+func TestInterceptConnection(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	m.drops = NewDropSamples(4)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1, reader: c1, clusterCIDR: m.links.GetLink("a").ClusterAddress}
+
+	var from *TunnelConnection
+	m.Intercept(kubelink.PROTO_TCP, 9000, PacketInterceptorFunc(func(t *TunnelConnection, header *ipv4.Header, packet []byte) {
+		from = t
+	}))
+
+	done := make(chan error)
+	go func() { done <- conn.serve() }()
+
+	packet, _ := testPacketTo(t, "192.168.0.1", 0, 9000)
+	frame := append([]byte{byte(len(packet) >> 8), byte(len(packet)), PACKET_TYPE_DATA}, packet...)
+	if _, err := c2.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+	<-done
+	if from != conn {
+		t.Errorf("management packet not intercepted on connection")
+	}
+}
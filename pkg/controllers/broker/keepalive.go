@@ -0,0 +1,61 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// KeepAlive describes the tcp keepalive settings for tunnel connections.
+type KeepAlive struct {
+	Enabled bool
+	// Idle is the idle time before the first probe is sent
+	Idle time.Duration
+	// Interval is the time between two probes
+	Interval time.Duration
+	// Count is the number of unanswered probes before the connection is dropped
+	Count int
+}
+
+// SetKeepAlive configures tcp keepalive probing for the underlying
+// tcp connection.
+func SetKeepAlive(conn net.Conn, keepalive KeepAlive) error {
+	c, ok := tcp.RawConn(conn).(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("no tcp connection")
+	}
+	if !keepalive.Enabled {
+		return c.SetKeepAlive(false)
+	}
+	err := c.SetKeepAlive(true)
+	if err != nil {
+		return err
+	}
+	if keepalive.Idle > 0 {
+		err = c.SetKeepAlivePeriod(keepalive.Idle)
+		if err != nil {
+			return err
+		}
+	}
+	return setKeepAliveProbes(c, keepalive.Interval, keepalive.Count)
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testSockopt(t *testing.T, conn *net.TCPConn, level, opt int) int {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		value, serr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if err != nil || serr != nil {
+		t.Fatalf("cannot get socket option %d: %v %v", opt, err, serr)
+	}
+	return value
+}
+
+func TestSetKeepAlive(t *testing.T) {
+	l, _ := testListener(t)
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := conn.(*net.TCPConn)
+
+	keepalive := KeepAlive{Enabled: true, Idle: 30 * time.Second, Interval: 5 * time.Second, Count: 4}
+	if err := SetKeepAlive(conn, keepalive); err != nil {
+		t.Fatalf("cannot set keepalive: %s", err)
+	}
+	if v := testSockopt(t, c, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); v == 0 {
+		t.Errorf("keepalive not enabled")
+	}
+	if v := testSockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); v != 30 {
+		t.Errorf("keepalive idle is %d, expected 30", v)
+	}
+	if v := testSockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL); v != 5 {
+		t.Errorf("keepalive interval is %d, expected 5", v)
+	}
+	if v := testSockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT); v != 4 {
+		t.Errorf("keepalive count is %d, expected 4", v)
+	}
+
+	if err := SetKeepAlive(conn, KeepAlive{}); err != nil {
+		t.Fatalf("cannot disable keepalive: %s", err)
+	}
+	if v := testSockopt(t, c, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); v != 0 {
+		t.Errorf("keepalive not disabled")
+	}
+}
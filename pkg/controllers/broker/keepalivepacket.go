@@ -0,0 +1,145 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	RegisterExtension(EXT_KEEPALIVE, &KeepAliveExtensionHandler{})
+}
+
+// KeepAliveExtension announces the support of keepalive packets
+// together with the interval (in milliseconds) the sender uses to
+// send them. An interval of 0 means no keepalives are sent.
+type KeepAliveExtension uint32
+
+var _ ConnectionHelloExtension = (*KeepAliveExtension)(nil)
+
+func (this *KeepAliveExtension) Id() byte {
+	return EXT_KEEPALIVE
+}
+
+func (this *KeepAliveExtension) Data() []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(*this))
+	return data
+}
+
+func (this *KeepAliveExtension) Interval() time.Duration {
+	return time.Duration(*this) * time.Millisecond
+}
+
+func (this *KeepAliveExtension) String() string {
+	return this.Interval().String()
+}
+
+type KeepAliveExtensionHandler struct{}
+
+var _ ConnectionHelloExtensionHandler = &KeepAliveExtensionHandler{}
+
+func (this *KeepAliveExtensionHandler) Parse(id byte, data []byte) (ConnectionHelloExtension, error) {
+	if id != EXT_KEEPALIVE {
+		return nil, fmt.Errorf("invalid extension %d for keepalive", id)
+	}
+	if len(data) != 4 {
+		return nil, fmt.Errorf("invalid keepalive extension length %d", len(data))
+	}
+	ext := KeepAliveExtension(binary.BigEndian.Uint32(data))
+	return &ext, nil
+}
+
+func (this *KeepAliveExtensionHandler) Add(hello *ConnectionHello, mux *Mux) {
+	ext := KeepAliveExtension(mux.keepaliveInterval / time.Millisecond)
+	hello.Extensions[EXT_KEEPALIVE] = &ext
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SetKeepAlivePackets configures the interval for sending keepalive
+// packets on tunnel connections and the time without any received
+// packet after which a connection is considered dead. An interval of
+// 0 disables sending keepalives.
+func (this *Mux) SetKeepAlivePackets(interval, timeout time.Duration) {
+	this.keepaliveInterval = interval
+	this.keepaliveTimeout = timeout
+}
+
+// received records the time of the last packet received on the connection.
+func (this *TunnelConnection) received() {
+	atomic.StoreInt64(&this.lastReceived, time.Now().UnixNano())
+}
+
+// startKeepAlive starts sending keepalive packets if supported by the
+// peer and watches for the keepalives announced by the peer.
+func (this *TunnelConnection) startKeepAlive(hello *ConnectionHello) {
+	ext, ok := hello.Extensions[EXT_KEEPALIVE].(*KeepAliveExtension)
+	if !ok {
+		return
+	}
+	interval := this.mux.keepaliveInterval
+	timeout := time.Duration(0)
+	if remote := ext.Interval(); remote > 0 && this.mux.keepaliveTimeout > 0 {
+		timeout = this.mux.keepaliveTimeout
+		if timeout < 2*remote {
+			timeout = 2 * remote
+		}
+	}
+	if interval <= 0 && timeout <= 0 {
+		return
+	}
+	this.received()
+	go this.keepalive(interval, timeout)
+}
+
+func (this *TunnelConnection) keepalive(interval, timeout time.Duration) {
+	tick := interval
+	if timeout > 0 && (tick <= 0 || timeout/2 < tick) {
+		tick = timeout / 2
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	next := time.Now().Add(interval)
+	for range ticker.C {
+		now := time.Now()
+		if timeout > 0 {
+			last := time.Unix(0, atomic.LoadInt64(&this.lastReceived))
+			if now.Sub(last) > timeout {
+				err := fmt.Errorf("keepalive timeout: no packet received for %s", now.Sub(last).Truncate(time.Second))
+				this.Errorf("%s", err)
+				this.lock.Lock()
+				this.abort = err
+				this.lock.Unlock()
+				this.Close()
+				return
+			}
+		}
+		if interval > 0 && !now.Before(next) {
+			if err := this.WritePacket(PACKET_TYPE_KEEPALIVE, nil); err != nil {
+				return
+			}
+			next = now.Add(interval)
+		}
+	}
+}
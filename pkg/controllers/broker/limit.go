@@ -0,0 +1,79 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LinkLimit bounds the number of links served by a broker. Links are
+// admitted in the order they are requested, a released slot can be
+// used by another link.
+type LinkLimit struct {
+	lock   sync.Mutex
+	max    int
+	served map[string]struct{}
+}
+
+func NewLinkLimit(max int) *LinkLimit {
+	return &LinkLimit{
+		max:    max,
+		served: map[string]struct{}{},
+	}
+}
+
+// Admit checks whether the given link may be served. A link already
+// admitted keeps its slot.
+func (this *LinkLimit) Admit(name string) error {
+	if this == nil || this.max <= 0 {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.served[name]; ok {
+		return nil
+	}
+	if len(this.served) >= this.max {
+		return fmt.Errorf("link limit reached: broker serves at most %d links", this.max)
+	}
+	this.served[name] = struct{}{}
+	return nil
+}
+
+// IsAdmitted reports whether the given link is served.
+func (this *LinkLimit) IsAdmitted(name string) bool {
+	if this == nil || this.max <= 0 {
+		return true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, ok := this.served[name]
+	return ok
+}
+
+// Release frees the slot of a link.
+func (this *LinkLimit) Release(name string) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.served, name)
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLinkLimit(t *testing.T) {
+	limit := NewLinkLimit(2)
+	for _, n := range []string{"a", "b", "a"} {
+		if err := limit.Admit(n); err != nil {
+			t.Errorf("link %s within limit rejected: %s", n, err)
+		}
+	}
+	if err := limit.Admit("c"); err == nil {
+		t.Errorf("link c beyond limit admitted")
+	}
+	for n, served := range map[string]bool{"a": true, "b": true, "c": false} {
+		if limit.IsAdmitted(n) != served {
+			t.Errorf("link %s: served %t, expected %t", n, !served, served)
+		}
+	}
+
+	limit.Release("a")
+	if err := limit.Admit("c"); err != nil {
+		t.Errorf("released slot not reused: %s", err)
+	}
+	if limit.IsAdmitted("a") {
+		t.Errorf("released link a still served")
+	}
+	if err := limit.Admit("a"); err == nil {
+		t.Errorf("released link a readmitted beyond limit")
+	}
+
+	for name, limit := range map[string]*LinkLimit{"unlimited": NewLinkLimit(0), "none": nil} {
+		for i := 0; i < 10; i++ {
+			n := fmt.Sprintf("l%d", i)
+			if err := limit.Admit(n); err != nil || !limit.IsAdmitted(n) {
+				t.Errorf("%s: link %s not served", name, n)
+			}
+		}
+	}
+}
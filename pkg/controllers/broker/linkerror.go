@@ -0,0 +1,95 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+)
+
+// LinkError is the last connection error of a link together with the
+// time it occurred.
+type LinkError struct {
+	Err  error
+	Time time.Time
+}
+
+func (this *LinkError) Error() string {
+	return this.Err.Error()
+}
+
+func (this *LinkError) Unwrap() error {
+	return this.Err
+}
+
+// Timestamp returns the time the error occurred.
+func (this *LinkError) Timestamp() time.Time {
+	return this.Time
+}
+
+// setError records the actual connection error for a cluster address.
+// A nil error clears a recorded one.
+func (this *Mux) setError(ips string, err error) {
+	if err == nil {
+		this.errors[ips] = nil
+		return
+	}
+	if _, ok := err.(*LinkError); !ok {
+		err = &LinkError{Err: err, Time: time.Now()}
+	}
+	this.errors[ips] = err
+}
+
+// LinkErrorInfo is the last error of a link exposed by the debug endpoint.
+type LinkErrorInfo struct {
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// GetLinkErrors returns the actual connection errors of all failing links.
+func (this *Mux) GetLinkErrors() map[string]LinkErrorInfo {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	result := map[string]LinkErrorInfo{}
+	for ips, err := range this.errors {
+		if err == nil {
+			continue
+		}
+		name := ips
+		if l := this.links.GetLinkForClusterAddress(net.ParseIP(ips)); l != nil {
+			name = l.Name
+		}
+		info := LinkErrorInfo{Error: err.Error()}
+		if e, ok := err.(*LinkError); ok {
+			info.Time = e.Time
+		}
+		result[name] = info
+	}
+	return result
+}
+
+func (this *reconciler) handleErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(this.mux.GetLinkErrors())
+}
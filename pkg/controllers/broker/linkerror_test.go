@@ -0,0 +1,67 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+func TestLinkErrors(t *testing.T) {
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = testRefusedEndpoint(t)
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.LogContext = logger.New()
+	m.helloTimeout = 2 * time.Second
+
+	start := time.Now()
+	if _, err := m.AssureTunnel(m, m.links.GetLink("a")); err == nil {
+		t.Fatalf("dial succeeded without a peer")
+	}
+	info, ok := m.GetLinkErrors()["a"]
+	if !ok || info.Error == "" {
+		t.Fatalf("dial error not recorded: %v", m.GetLinkErrors())
+	}
+	if info.Time.Before(start) || info.Time.After(time.Now()) {
+		t.Errorf("unexpected error time %s", info.Time)
+	}
+	if _, ok := m.errors["192.168.0.10"].(*LinkError); !ok {
+		t.Errorf("recorded error without timestamp: %T", m.errors["192.168.0.10"])
+	}
+
+	peer := testMux(t, "192.168.0.10/24", testLink("b", "192.168.0.1/24", "100.64.0.0/24"))
+	peer.LogContext = logger.New()
+	l := testPeer(t, peer)
+	defer l.Close()
+
+	kl.Spec.Endpoint = l.Addr().String()
+	if _, err := m.links.UpdateLink(logger.New(), kl); err != nil {
+		t.Fatal(err)
+	}
+	c, err := m.AssureTunnel(m, m.links.GetLink("a"))
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer c.Close()
+	if errs := m.GetLinkErrors(); len(errs) != 0 {
+		t.Errorf("error not cleared after connect: %v", errs)
+	}
+}
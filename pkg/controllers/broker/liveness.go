@@ -0,0 +1,246 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The UDP liveness channel exchanges tiny heartbeat datagrams with the
+// peer of an outbound tunnel connection. It detects a dead path
+// independently of the head-of-line blocking of the tcp stream.
+// Peers not answering heartbeats are never considered dead, so the
+// channel can be enabled gradually in a mesh.
+
+const LIVENESS_ALIVE = "alive"
+const LIVENESS_LOST = "lost"
+
+const heartbeatMagic = "KLHB"
+const heartbeatSize = 17
+
+const HEARTBEAT_PING = 0
+const HEARTBEAT_PONG = 1
+
+func heartbeat(ty byte, seq uint32, ts int64) []byte {
+	data := make([]byte, heartbeatSize)
+	copy(data, heartbeatMagic)
+	data[4] = ty
+	binary.BigEndian.PutUint32(data[5:], seq)
+	binary.BigEndian.PutUint64(data[9:], uint64(ts))
+	return data
+}
+
+func parseHeartbeat(data []byte) (byte, uint32, int64, bool) {
+	if len(data) != heartbeatSize || string(data[:4]) != heartbeatMagic {
+		return 0, 0, 0, false
+	}
+	return data[4], binary.BigEndian.Uint32(data[5:]), int64(binary.BigEndian.Uint64(data[9:])), true
+}
+
+// LivenessMonitor tracks the heartbeat answers of a peer.
+type LivenessMonitor struct {
+	lock    sync.Mutex
+	timeout time.Duration
+	pending map[uint32]time.Time
+	last    time.Time
+	rtt     time.Duration
+}
+
+func NewLivenessMonitor(timeout time.Duration) *LivenessMonitor {
+	return &LivenessMonitor{timeout: timeout, pending: map[uint32]time.Time{}}
+}
+
+// Ping records a heartbeat sent at the given time. Heartbeats not
+// answered within the timeout are forgotten.
+func (this *LivenessMonitor) Ping(seq uint32, now time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for s, sent := range this.pending {
+		if now.Sub(sent) > this.timeout {
+			delete(this.pending, s)
+		}
+	}
+	this.pending[seq] = now
+}
+
+// Pong records a heartbeat answer received at the given time. It is
+// only accepted for an outstanding heartbeat sent within the timeout.
+func (this *LivenessMonitor) Pong(now time.Time, seq uint32) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	sent, ok := this.pending[seq]
+	if !ok {
+		return false
+	}
+	delete(this.pending, seq)
+	if now.Sub(sent) > this.timeout {
+		return false
+	}
+	this.last = now
+	this.rtt = now.Sub(sent)
+	return true
+}
+
+// State returns the liveness state (alive or lost) at the given time.
+// It is empty as long as the peer did not answer any heartbeat.
+func (this *LivenessMonitor) State(now time.Time) string {
+	if this == nil {
+		return ""
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.last.IsZero() {
+		return ""
+	}
+	if now.Sub(this.last) > this.timeout {
+		return LIVENESS_LOST
+	}
+	return LIVENESS_ALIVE
+}
+
+// RTT returns the round trip time of the last answered heartbeat.
+func (this *LivenessMonitor) RTT() time.Duration {
+	if this == nil {
+		return 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.rtt
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SetUDPLiveness enables the UDP liveness channel on the given port.
+// Heartbeats are sent with the given interval and a peer not
+// answering for the timeout is considered dead. A port of 0 disables
+// the channel.
+func (this *Mux) SetUDPLiveness(port int, interval, timeout time.Duration) {
+	this.livenessPort = port
+	this.livenessInterval = interval
+	this.livenessTimeout = timeout
+}
+
+// ServeLiveness answers the heartbeats of peers on the liveness port.
+func (this *Mux) ServeLiveness() error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: this.livenessPort})
+	if err != nil {
+		return fmt.Errorf("cannot listen for udp liveness on port %d: %s", this.livenessPort, err)
+	}
+	go func() {
+		<-this.ctx.Done()
+		conn.Close()
+	}()
+	this.Infof("serving udp liveness on port %d", this.livenessPort)
+	buf := make([]byte, 64)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if this.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		ty, seq, ts, ok := parseHeartbeat(buf[:n])
+		if !ok || ty != HEARTBEAT_PING {
+			continue
+		}
+		conn.WriteToUDP(heartbeat(HEARTBEAT_PONG, seq, ts), addr)
+	}
+}
+
+// liveness sends heartbeats to the peer of an outbound connection
+// until the stop channel is closed and aborts the connection if the
+// peer stops answering.
+func (this *TunnelConnection) liveness(stop <-chan struct{}) {
+	host, _, err := net.SplitHostPort(this.remoteAddress)
+	if err != nil {
+		return
+	}
+	peer, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(this.mux.livenessPort)))
+	if err != nil {
+		this.Warnf("cannot open udp liveness channel: %s", err)
+		return
+	}
+	conn, err := net.DialUDP("udp", nil, peer)
+	if err != nil {
+		this.Warnf("cannot open udp liveness channel: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	monitor := NewLivenessMonitor(this.mux.livenessTimeout)
+	this.lock.Lock()
+	this.livenessMonitor = monitor
+	this.lock.Unlock()
+
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// for example icmp port unreachable for peers without
+				// liveness channel
+				time.Sleep(this.mux.livenessInterval)
+				continue
+			}
+			if !addr.IP.Equal(peer.IP) || addr.Port != peer.Port {
+				continue
+			}
+			ty, seq, _, ok := parseHeartbeat(buf[:n])
+			if ok && ty == HEARTBEAT_PONG {
+				if !monitor.Pong(time.Now(), seq) {
+					this.Debugf("ignoring heartbeat answer for unknown sequence %d", seq)
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(this.mux.livenessInterval)
+	defer ticker.Stop()
+	seq := uint32(0)
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if monitor.State(now) == LIVENESS_LOST {
+				err := fmt.Errorf("udp liveness lost: no heartbeat answer for %s", this.mux.livenessTimeout)
+				this.Errorf("%s", err)
+				this.lock.Lock()
+				this.abort = err
+				this.lock.Unlock()
+				this.Close()
+				return
+			}
+			seq++
+			monitor.Ping(seq, now)
+			conn.Write(heartbeat(HEARTBEAT_PING, seq, now.UnixNano()))
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLivenessMonitor(t *testing.T) {
+	m := NewLivenessMonitor(3 * time.Second)
+	start := time.Unix(1000, 0)
+
+	if s := m.State(start); s != "" {
+		t.Errorf("state before first answer: got %q", s)
+	}
+	m.Ping(1, start)
+	if m.Pong(start.Add(5*time.Millisecond), 2) {
+		t.Errorf("answer for unknown sequence accepted")
+	}
+	if !m.Pong(start.Add(10*time.Millisecond), 1) {
+		t.Errorf("answer for outstanding sequence rejected")
+	}
+	if m.Pong(start.Add(20*time.Millisecond), 1) {
+		t.Errorf("duplicate answer accepted")
+	}
+	if rtt := m.RTT(); rtt != 10*time.Millisecond {
+		t.Errorf("unexpected rtt %s", rtt)
+	}
+	if s := m.State(start.Add(time.Second)); s != LIVENESS_ALIVE {
+		t.Errorf("liveness not detected: got %q", s)
+	}
+
+	// further heartbeats are not answered anymore
+	for i := 2; i <= 5; i++ {
+		m.Ping(uint32(i), start.Add(time.Duration(i-1)*time.Second))
+	}
+	if s := m.State(start.Add(5 * time.Second)); s != LIVENESS_LOST {
+		t.Errorf("lost liveness not detected: got %q", s)
+	}
+	if m.Pong(start.Add(6*time.Second), 2) {
+		t.Errorf("answer for expired heartbeat accepted")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// PACKET_WARN_RATE limits the number of per packet warnings per second.
+const PACKET_WARN_RATE = 10
+
+// LogSampler limits the number of log entries per second.
+type LogSampler struct {
+	lock       sync.Mutex
+	rate       int
+	window     time.Time
+	count      int
+	suppressed int
+}
+
+// NewLogSampler creates a sampler for at most rate entries per second.
+// A rate of zero suppresses all entries.
+func NewLogSampler(rate int) *LogSampler {
+	return &LogSampler{rate: rate}
+}
+
+// Allow reports whether a log entry may be emitted at the given time.
+// It returns the number of entries suppressed since the last emitted one.
+func (this *LogSampler) Allow(now time.Time) (bool, int) {
+	if this == nil || this.rate <= 0 {
+		return false, 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if now.Sub(this.window) >= time.Second {
+		this.window = now
+		this.count = 0
+	}
+	if this.count >= this.rate {
+		this.suppressed++
+		return false, 0
+	}
+	this.count++
+	suppressed := this.suppressed
+	this.suppressed = 0
+	return true, suppressed
+}
+
+// logPacket emits a per packet debug log entry limited by the
+// packet log rate. Without debug logging it returns immediately.
+func (this *Mux) logPacket(log logger.LogContext, msg string, args ...interface{}) {
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	if ok, suppressed := this.packetLog.Allow(time.Now()); ok {
+		if suppressed > 0 {
+			msg += " (%d suppressed)"
+			args = append(args, suppressed)
+		}
+		log.Debugf(msg, args...)
+	}
+}
+
+// warnPacket emits a per packet warning limited by PACKET_WARN_RATE.
+// In contrast to debug logs warnings are always enabled, so they must
+// not flood the log under high packet volume.
+func (this *Mux) warnPacket(log logger.LogContext, msg string, args ...interface{}) {
+	if ok, suppressed := this.packetWarn.Allow(time.Now()); ok {
+		if suppressed > 0 {
+			msg += " (%d suppressed)"
+			args = append(args, suppressed)
+		}
+		log.Warnf(msg, args...)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogPacketLevel(t *testing.T) {
+	level := logrus.GetLevel()
+	defer logrus.SetLevel(level)
+
+	m := &Mux{packetLog: NewLogSampler(1)}
+	logrus.SetLevel(logrus.InfoLevel)
+	m.logPacket(logger.New(), "packet")
+	if m.packetLog.count != 0 {
+		t.Errorf("packet log rate consumed without debug logging")
+	}
+	logrus.SetLevel(logrus.DebugLevel)
+	m.logPacket(logger.New(), "packet")
+	if m.packetLog.count != 1 {
+		t.Errorf("packet not logged with debug logging")
+	}
+}
+
+func TestWarnPacketSampled(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	m.LogContext = logger.New()
+	m.packetWarn = NewLogSampler(2)
+	m.relay = true
+	conn := testConnection(t, m, "a", false)
+	defer conn.Close()
+
+	packet, header := testPacketTo(t, "100.64.2.5", 0, 80)
+	header.TTL = 1
+	for i := 0; i < 10; i++ {
+		conn.relayPacket(header, packet)
+		m.FindConnection(m, packet)
+	}
+	if m.packetWarn.count != 2 || m.packetWarn.suppressed != 18 {
+		t.Errorf("per packet warnings not sampled: %d emitted, %d suppressed", m.packetWarn.count, m.packetWarn.suppressed)
+	}
+}
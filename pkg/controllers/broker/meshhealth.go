@@ -0,0 +1,65 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+var meshStates = []string{kubelink.MESH_HEALTHY, kubelink.MESH_DEGRADED, kubelink.MESH_CRITICAL}
+
+func (this *reconciler) meshHealth() []kubelink.MeshHealth {
+	state := func(l *kubelink.Link) string {
+		s, _ := this.mux.GetConnectionState(l.ClusterAddress.IP)
+		return s
+	}
+	return this.Links().GetMeshes().Health(state, this.config.MeshHealth)
+}
+
+func (this *reconciler) handleMeshHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(this.meshHealth())
+}
+
+func (this *reconciler) collectMeshHealthMetrics(w *metrics.Writer) {
+	health := this.meshHealth()
+	if len(health) == 0 {
+		return
+	}
+	w.Describe("kubelink_mesh_links_degraded", metrics.GAUGE, "Number of links per mesh connected via a failover endpoint")
+	for _, h := range health {
+		w.Value("kubelink_mesh_links_degraded", metrics.Labels{"mesh": h.Mesh}, float64(h.Degraded))
+	}
+	w.Describe("kubelink_mesh_health", metrics.GAUGE, "Aggregated health status of a mesh")
+	for _, h := range health {
+		for _, s := range meshStates {
+			v := 0.0
+			if h.Status == s {
+				v = 1
+			}
+			w.Value("kubelink_mesh_health", metrics.Labels{"mesh": h.Mesh, "status": s}, v)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+func TestMeshRangeMismatch(t *testing.T) {
+	cases := map[string]struct {
+		peer     string
+		reject   bool
+		accepted bool
+	}{
+		"same":            {"192.168.0.10/24", true, true},
+		"narrower":        {"192.168.0.10/25", false, true},
+		"wider":           {"192.168.0.10/16", false, true},
+		"narrower reject": {"192.168.0.10/25", true, false},
+		"wider reject":    {"192.168.0.10/16", true, false},
+	}
+	for name, c := range cases {
+		peer := testMux(t, c.peer, testLink("b", "192.168.0.1/24", "100.64.0.0/24"))
+		peer.LogContext = logger.New()
+		l := testPeer(t, peer)
+
+		kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+		kl.Spec.Endpoint = l.Addr().String()
+		m := testMux(t, "192.168.0.1/24", kl)
+		m.LogContext = logger.New()
+		m.helloTimeout = 2 * time.Second
+		m.SetRejectMaskMismatch(c.reject)
+
+		conn, err := m.AssureTunnel(m, m.links.GetLink("a"))
+		if c.accepted {
+			if err != nil {
+				t.Errorf("%s: connection rejected: %s", name, err)
+			} else {
+				conn.Close()
+			}
+		} else {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%s: connection accepted", name)
+			} else if !strings.Contains(err.Error(), "mesh range mismatch") {
+				t.Errorf("%s: unexpected error: %s", name, err)
+			}
+		}
+		l.Close()
+	}
+}
+
+func TestPrefixLen(t *testing.T) {
+	cases := map[string]struct {
+		cidr   *net.IPNet
+		prefix int
+	}{
+		"ipv4":       {&net.IPNet{IP: net.ParseIP("192.168.0.1").To4(), Mask: net.CIDRMask(24, 32)}, 24},
+		"ipv4 in v6": {&net.IPNet{IP: net.ParseIP("192.168.0.1"), Mask: net.CIDRMask(120, 128)}, 24},
+		"ipv6":       {&net.IPNet{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(64, 128)}, 64},
+	}
+	for name, c := range cases {
+		if p := prefixLen(c.cidr); p != c.prefix {
+			t.Errorf("%s: got prefix length %d, expected %d", name, p, c.prefix)
+		}
+	}
+}
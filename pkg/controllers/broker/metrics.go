@@ -0,0 +1,148 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"sort"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+const DIRECTION_INBOUND = "inbound"
+const DIRECTION_OUTBOUND = "outbound"
+
+var handshakeFailures = metrics.NewCounterVec("kubelink_handshake_failures_total",
+	"Number of failed tunnel handshakes", "direction")
+
+func init() {
+	metrics.Register("handshake_failures", handshakeFailures)
+}
+
+func (this *reconciler) collectLinkMetrics(w *metrics.Writer) {
+	tunnels := 0
+	this.mux.lock.RLock()
+	for _, list := range this.mux.byClusterIP {
+		tunnels += len(list)
+	}
+	this.mux.lock.RUnlock()
+	w.Describe("kubelink_tunnels", metrics.GAUGE, "Number of established tunnel connections")
+	w.Value("kubelink_tunnels", nil, float64(tunnels))
+
+	up := map[string]bool{}
+	this.Links().Visit(func(l *kubelink.Link) bool {
+		s, _ := this.mux.GetConnectionState(l.ClusterAddress.IP)
+		up[l.Name] = s == v1alpha1.STATE_UP
+		return true
+	})
+	stats := this.Links().Stats()
+	names := make([]string, 0, len(stats))
+	for n := range stats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return
+	}
+	w.Describe("kubelink_link_up", metrics.GAUGE, "Connection state of a link (1 for an established connection)")
+	for _, n := range names {
+		v := 0.0
+		if up[n] {
+			v = 1
+		}
+		w.Value("kubelink_link_up", metrics.Labels{"link": n}, v)
+	}
+	w.Describe("kubelink_link_bytes_total", metrics.COUNTER, "Number of bytes transferred per link")
+	for _, n := range names {
+		w.Value("kubelink_link_bytes_total", metrics.Labels{"link": n, "direction": "in"}, float64(stats[n].BytesIn))
+		w.Value("kubelink_link_bytes_total", metrics.Labels{"link": n, "direction": "out"}, float64(stats[n].BytesOut))
+	}
+	w.Describe("kubelink_link_ingress_audited_total", metrics.COUNTER, "Number of packets denied by the ingress of a link but forwarded in audit mode")
+	for _, n := range names {
+		w.Value("kubelink_link_ingress_audited_total", metrics.Labels{"link": n}, float64(stats[n].Audited))
+	}
+	w.Describe("kubelink_link_packets_total", metrics.COUNTER, "Number of packets transferred per link")
+	for _, n := range names {
+		w.Value("kubelink_link_packets_total", metrics.Labels{"link": n, "direction": "in"}, float64(stats[n].PacketsIn))
+		w.Value("kubelink_link_packets_total", metrics.Labels{"link": n, "direction": "out"}, float64(stats[n].PacketsOut))
+	}
+}
+
+func (this *reconciler) collectMeshMetrics(w *metrics.Writer) {
+	state := func(l *kubelink.Link) string {
+		s, _ := this.mux.GetConnectionState(l.ClusterAddress.IP)
+		return s
+	}
+	meshes := this.Links().GetMeshes()
+	stats := map[string]kubelink.MeshStats{}
+	for _, n := range meshes.Names() {
+		stats[n] = meshes[n].Stats(state)
+	}
+
+	w.Describe("kubelink_mesh_links", metrics.GAUGE, "Number of links per mesh")
+	for _, n := range meshes.Names() {
+		w.Value("kubelink_mesh_links", metrics.Labels{"mesh": n}, float64(stats[n].Links))
+	}
+	w.Describe("kubelink_mesh_links_active", metrics.GAUGE, "Number of links with an established connection per mesh")
+	for _, n := range meshes.Names() {
+		w.Value("kubelink_mesh_links_active", metrics.Labels{"mesh": n}, float64(stats[n].Active))
+	}
+	w.Describe("kubelink_mesh_links_failed", metrics.GAUGE, "Number of failed links per mesh")
+	for _, n := range meshes.Names() {
+		w.Value("kubelink_mesh_links_failed", metrics.Labels{"mesh": n}, float64(stats[n].Failed))
+	}
+	w.Describe("kubelink_mesh_bytes_total", metrics.COUNTER, "Number of bytes transferred per mesh")
+	for _, n := range meshes.Names() {
+		w.Value("kubelink_mesh_bytes_total", metrics.Labels{"mesh": n, "direction": "in"}, float64(stats[n].BytesIn))
+		w.Value("kubelink_mesh_bytes_total", metrics.Labels{"mesh": n, "direction": "out"}, float64(stats[n].BytesOut))
+	}
+	w.Describe("kubelink_mesh_packets_total", metrics.COUNTER, "Number of packets transferred per mesh")
+	for _, n := range meshes.Names() {
+		w.Value("kubelink_mesh_packets_total", metrics.Labels{"mesh": n, "direction": "in"}, float64(stats[n].PacketsIn))
+		w.Value("kubelink_mesh_packets_total", metrics.Labels{"mesh": n, "direction": "out"}, float64(stats[n].PacketsOut))
+	}
+}
+
+func (this *reconciler) collectTunMetrics(w *metrics.Writer) {
+	tun := this.mux.GetTun()
+	if tun == nil {
+		return
+	}
+	stats, err := tun.Statistics()
+	if err != nil {
+		this.Controller().Warnf("cannot read tun statistics: %s", err)
+		return
+	}
+	labels := func(dir string) metrics.Labels {
+		return metrics.Labels{"device": tun.tun.String(), "direction": dir}
+	}
+	w.Describe("kubelink_tun_bytes_total", metrics.COUNTER, "Number of bytes transferred by the tun device")
+	w.Value("kubelink_tun_bytes_total", labels("in"), float64(stats.RxBytes))
+	w.Value("kubelink_tun_bytes_total", labels("out"), float64(stats.TxBytes))
+	w.Describe("kubelink_tun_packets_total", metrics.COUNTER, "Number of packets transferred by the tun device")
+	w.Value("kubelink_tun_packets_total", labels("in"), float64(stats.RxPackets))
+	w.Value("kubelink_tun_packets_total", labels("out"), float64(stats.TxPackets))
+	w.Describe("kubelink_tun_errors_total", metrics.COUNTER, "Number of errors of the tun device")
+	w.Value("kubelink_tun_errors_total", labels("in"), float64(stats.RxErrors))
+	w.Value("kubelink_tun_errors_total", labels("out"), float64(stats.TxErrors))
+	w.Describe("kubelink_tun_dropped_total", metrics.COUNTER, "Number of packets dropped by the tun device")
+	w.Value("kubelink_tun_dropped_total", labels("in"), float64(stats.RxDropped))
+	w.Value("kubelink_tun_dropped_total", labels("out"), float64(stats.TxDropped))
+}
@@ -19,6 +19,7 @@
 package broker
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
@@ -27,14 +28,22 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
 	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/taptun"
 	"github.com/mandelsoft/kubelink/pkg/tcp"
+	"github.com/mandelsoft/kubelink/pkg/tracing"
 )
 
+const STATE_IDLE = "Idle"
+
 type ConnectionHandler interface {
 	UpdateAccess(hello *ConnectionHello)
 	GetAccess() kubelink.LinkAccessInfo
@@ -52,38 +61,170 @@ type Mux struct {
 	certInfo    *CertInfo
 	byClusterIP map[string][]*TunnelConnection
 	errors      map[string]error
-
-	port        uint16
-	clusterAddr *net.IPNet
-	links       *kubelink.Links
-	local       tcp.CIDRList
-	tun         *Tun
-	handlers    []LinkStateHandler
-
-	connectionHandler ConnectionHandler
-	autoconnect       bool
+	dialing     map[string]chan struct{}
+
+	port          uint16
+	portOverrides PortOverrides
+	clusterAddr   *net.IPNet
+	previousAddr  *net.IPNet
+	rotating      bool
+	addresses     AddressManager
+	links         *kubelink.Links
+	local         tcp.CIDRList
+	tun           atomic.Value
+	handlers      []LinkStateHandler
+
+	connectionHandler  ConnectionHandler
+	autoconnect        bool
+	dialTimeout        time.Duration
+	dialInterface      string
+	helloTimeout       time.Duration
+	dscp               int
+	keepalive          KeepAlive
+	keepaliveInterval  time.Duration
+	keepaliveTimeout   time.Duration
+	buffersizes        BufferSizes
+	healthProbe        bool
+	relay              bool
+	strictExtensions   bool
+	prematureData      string
+	ingressMode        string
+	rejectMaskMismatch bool
+	tunRecovery        int32
+	shutdown           int32
+
+	services         kubelink.ServiceEndpoints
+	serviceHandler   ServiceHandler
+	addressHandler   AddressHandler
+	endpointHandler  EndpointHandler
+	trustPeerAddress bool
+	buffers          *BufferPool
+	interceptors     map[uint32]PacketInterceptor
+	drops            *DropSamples
+	dedupLock        sync.Mutex
+	dedup            map[string]*dedupFilter
+	packetLog        *LogSampler
+	packetWarn       *LogSampler
+
+	compression          byte
+	compressionThreshold int
+	livenessPort         int
+	livenessInterval     time.Duration
+	livenessTimeout      time.Duration
+	relayTimeExceeded    bool
+	icmpLimit            *LogSampler
 }
 
 func NewMux(ctx context.Context, logger logger.LogContext, certInfo *CertInfo, port uint16, addr *net.IPNet, localCIDRs tcp.CIDRList, tun *Tun, links *kubelink.Links, handlers ...LinkStateHandler) *Mux {
-	return &Mux{
+	mux := &Mux{
 		LogContext:  logger,
 		ctx:         ctx,
 		certInfo:    certInfo,
 		links:       links,
 		byClusterIP: map[string][]*TunnelConnection{},
 		errors:      map[string]error{},
-		tun:         tun,
 		port:        port,
 		clusterAddr: addr,
 		local:       localCIDRs,
 		handlers:    append(handlers[:0:0], handlers...),
+		buffers:     NewBufferPool(true),
+		drops:       NewDropSamples(DROP_SAMPLES),
+		dedup:       map[string]*dedupFilter{},
+		icmpLimit:   NewLogSampler(ICMP_RATE),
+		packetWarn:  NewLogSampler(PACKET_WARN_RATE),
 	}
+	if tun != nil {
+		mux.tun.Store(tun)
+	}
+	return mux
 }
 
 func (this *Mux) SetAutoConnect(b bool) {
 	this.autoconnect = b
 }
 
+// SetPortOverrides configures the advertised port for dedicated
+// links or peer networks.
+func (this *Mux) SetPortOverrides(overrides PortOverrides) {
+	this.portOverrides = overrides
+}
+
+// SetTimeouts configures the timeouts used to dial tunnel
+// connections and to exchange the connection hello.
+func (this *Mux) SetTimeouts(dial, hello time.Duration) {
+	this.dialTimeout = dial
+	this.helloTimeout = hello
+}
+
+// SetServices configures the local service endpoints advertised
+// to the peers and accepted from them.
+func (this *Mux) SetServices(services kubelink.ServiceEndpoints, handler ServiceHandler) {
+	this.services = services
+	this.serviceHandler = handler
+}
+
+// SetBufferPooling enables or disables the reuse of packet buffers.
+func (this *Mux) SetBufferPooling(enabled bool) {
+	this.buffers = NewBufferPool(enabled)
+}
+
+// SetKeepAlive configures the tcp keepalive settings for tunnel connections.
+func (this *Mux) SetKeepAlive(keepalive KeepAlive) {
+	this.keepalive = keepalive
+}
+
+// SetPrematureData configures the handling of data packets received
+// before the hello handshake completed (reject or drop).
+func (this *Mux) SetPrematureData(mode string) {
+	this.prematureData = mode
+}
+
+// SetIngressMode sets the default handling of packets denied by the
+// ingress of a link (enforce or audit).
+func (this *Mux) SetIngressMode(mode string) {
+	this.ingressMode = mode
+}
+
+// auditIngress reports whether packets denied by the ingress of the
+// given link are only audited instead of being dropped.
+func (this *Mux) auditIngress(l *kubelink.Link) bool {
+	mode := l.IngressMode
+	if mode == "" {
+		mode = this.ingressMode
+	}
+	return mode == kubelink.INGRESS_AUDIT
+}
+
+// SetPacketLogRate limits the per packet debug logs to the given
+// number of entries per second (0 disables them).
+func (this *Mux) SetPacketLogRate(rate int) {
+	this.packetLog = NewLogSampler(rate)
+}
+
+// SetStrictExtensions rejects tunnel connections whose hello
+// contains extensions not understood by this broker.
+func (this *Mux) SetStrictExtensions(strict bool) {
+	this.strictExtensions = strict
+}
+
+// SetRejectMaskMismatch rejects tunnel connections of peers advertising
+// a mesh range with a prefix length different from the local one.
+// Otherwise such a mismatch is only reported.
+func (this *Mux) SetRejectMaskMismatch(reject bool) {
+	this.rejectMaskMismatch = reject
+}
+
+// SetBufferSizes configures the default socket and read buffer
+// sizes for tunnel connections.
+func (this *Mux) SetBufferSizes(sizes BufferSizes) {
+	this.buffersizes = sizes
+}
+
+// SetDSCP configures the default DSCP value for tunnel connections.
+func (this *Mux) SetDSCP(dscp int) {
+	this.dscp = dscp
+}
+
 func (this *Mux) GetError(ip net.IP) error {
 	if this == nil {
 		return nil
@@ -94,6 +235,35 @@ func (this *Mux) GetError(ip net.IP) error {
 	return this.errors[ip.String()]
 }
 
+// UnreachableRoutes replaces the routes to links with a failed tunnel
+// connection by unreachable routes. Senders get an immediate error
+// instead of silently dropped packets until the link is reconnected.
+func (this *Mux) UnreachableRoutes(routes kubelink.Routes) kubelink.Routes {
+	for i, r := range routes {
+		l := this.links.GetLinkForIP(r.Dst.IP)
+		if l != nil && this.GetError(l.ClusterAddress.IP) != nil {
+			routes[i] = kubelink.NewUnreachableRoute(r.Dst, kubelink.BROKER_ROUTE_PROTOCOL)
+		}
+	}
+	return routes
+}
+
+// GetConnectionState returns the state of the tunnel connection
+// for the given cluster address together with the last connection error.
+func (this *Mux) GetConnectionState(ip net.IP) (string, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	ips := ip.String()
+	if len(this.byClusterIP[ips]) > 0 {
+		return v1alpha1.STATE_UP, nil
+	}
+	if err := this.errors[ips]; err != nil {
+		return v1alpha1.STATE_ERROR, err
+	}
+	return STATE_IDLE, nil
+}
+
 func (this *Mux) RegisterFailHandler(handlers ...LinkStateHandler) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
@@ -135,21 +305,60 @@ func (this *Mux) GetConnectionForIP(ip net.IP) (*TunnelConnection, error) {
 	return this.AssureTunnel(this, l)
 }
 
+// AssureTunnel returns the tunnel connection for a link and dials it
+// if required. The mux lock is not held while dialing, concurrent
+// requests for the same link wait for the pending dial.
 func (this *Mux) AssureTunnel(logger logger.LogContext, link *kubelink.Link) (*TunnelConnection, error) {
 	this.lock.Lock()
-	defer this.lock.Unlock()
-
 	t, ips := this.queryClusterConnection(link.ClusterAddress.IP)
 	if t != nil {
+		this.lock.Unlock()
+		return t, nil
+	}
+	if this.IsShuttingDown() {
+		this.lock.Unlock()
+		return nil, fmt.Errorf("broker is shutting down")
+	}
+	if pending := this.dialing[ips]; pending != nil {
+		this.lock.Unlock()
+		<-pending
+		this.lock.RLock()
+		defer this.lock.RUnlock()
+		t, _ = this.queryClusterConnection(link.ClusterAddress.IP)
+		if t == nil {
+			return nil, fmt.Errorf("cannot connect to %s: %s", link, this.errors[ips])
+		}
 		return t, nil
 	}
-	t, err := this.dialTunnelConnection(link)
+	if this.dialing == nil {
+		this.dialing = map[string]chan struct{}{}
+	}
+	pending := make(chan struct{})
+	this.dialing[ips] = pending
+	this.lock.Unlock()
+
+	span := tracing.StartSpan("kubelink.connect", "kubelink.direction", "outbound", "kubelink.link", link.Name,
+		"kubelink.endpoint", link.Endpoint, "kubelink.cluster_address", link.ClusterAddress.IP.String())
+	t, err := this.dialTunnelConnection(link, span)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.dialing, ips)
+	defer close(pending)
 	if err != nil {
-		this.errors[ips] = err
+		this.setError(ips, err)
 		logger.Errorf("cannot initialize connection to %s: %s", link, err)
+		span.Finish(err)
 		return nil, err
 	}
-	this.addTunnel(t)
+	if !this.addTunnel(t) {
+		t.Close()
+		span.Finish(fmt.Errorf("redundant connection"))
+		t, _ = this.queryClusterConnection(link.ClusterAddress.IP)
+		return t, nil
+	}
+	span.AddEvent("connected")
+	span.Finish(nil)
 	go func() {
 		defer t.mux.RemoveTunnel(t)
 		this.Infof("serving connection to %s", t.String())
@@ -158,18 +367,54 @@ func (this *Mux) AssureTunnel(logger logger.LogContext, link *kubelink.Link) (*T
 	return t, nil
 }
 
-func (this *Mux) dialTunnelConnection(link *kubelink.Link) (*TunnelConnection, error) {
+func (this *Mux) dialTunnelConnection(link *kubelink.Link, span *tracing.Span) (*TunnelConnection, error) {
+	var err error
+	for i, endpoint := range link.Endpoints {
+		if i > 0 {
+			this.Warnf("failing over to endpoint %s for %s: %s", endpoint, link.Name, err)
+		}
+		var t *TunnelConnection
+		t, err = this.dialEndpoint(link, endpoint, span)
+		if err == nil {
+			this.activateEndpoint(link, endpoint)
+			return t, nil
+		}
+	}
+	return nil, err
+}
+
+func (this *Mux) dialEndpoint(link *kubelink.Link, endpoint string, span *tracing.Span) (*TunnelConnection, error) {
 	if this.certInfo.UseTLS() {
-		this.Infof("dialing for %s to %s with client certificate", link.Name, link.Endpoint)
+		this.Infof("dialing for %s to %s with client certificate", link.Name, endpoint)
 	} else {
-		this.Infof("dialing for %s to %s", link.Name, link.Endpoint)
+		this.Infof("dialing for %s to %s", link.Name, endpoint)
+	}
+	certInfo := this.certInfo
+	if link.Plaintext {
+		this.Infof("using plaintext connection for %s", link.Name)
+		certInfo = nil
+	}
+	local, err := this.dialSource(link, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if local != nil {
+		this.Infof("dialing for %s from %s", link.Name, local.IP)
 	}
-	conn, err := this.certInfo.Dial(link.Endpoint)
+	dial := span.StartChild("kubelink.dial", "kubelink.endpoint", endpoint, "kubelink.tls", fmt.Sprintf("%t", certInfo.UseTLS()))
+	conn, err := certInfo.Dial(endpoint, link.GetServerNameFor(endpoint), this.dialTimeout, local)
 	if err != nil {
-		return nil, fmt.Errorf("dialing failed: %s", err)
+		err = fmt.Errorf("dialing failed: %s", err)
+		dial.Finish(err)
+		return nil, err
 	}
-	t, hello, err := NewTunnelConnection(this, conn, link)
+	dial.Finish(nil)
+	span.SetAttributes("kubelink.connection", ConnectionID(conn))
+	handshake := span.StartChild("kubelink.handshake", "kubelink.connection", ConnectionID(conn))
+	t, hello, err := NewTunnelConnection(this, conn, link, true)
+	handshake.Finish(err)
 	if err != nil {
+		handshakeFailures.Inc(DIRECTION_OUTBOUND)
 		conn.Close()
 		return nil, err
 	}
@@ -177,27 +422,63 @@ func (this *Mux) dialTunnelConnection(link *kubelink.Link) (*TunnelConnection, e
 	return t, nil
 }
 
-func (this *Mux) AddTunnel(t *TunnelConnection) {
+// UpdateLinkStats resolves the traffic counters of the tunnel
+// connections again after links have been updated.
+func (this *Mux) UpdateLinkStats() {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for _, list := range this.byClusterIP {
+		for _, t := range list {
+			t.updateStats()
+		}
+	}
+}
+
+// AddTunnel adds a tunnel connection to the mux. It returns false if the
+// connection is redundant and has been rejected in favor of an existing one.
+func (this *Mux) AddTunnel(t *TunnelConnection) bool {
 	this.lock.Lock()
 	defer this.lock.Unlock()
-	this.addTunnel(t)
+	return this.addTunnel(t)
 }
 
-func (this *Mux) addTunnel(t *TunnelConnection) {
-	if t.clusterCIDR != nil {
-		ips := t.clusterCIDR.IP.String()
+func (this *Mux) addTunnel(t *TunnelConnection) bool {
+	if cidr := t.ClusterCIDR(); cidr != nil {
+		ips := cidr.IP.String()
 		delete(this.errors, ips)
 		list := this.byClusterIP[ips]
 		for _, c := range list {
 			if c == t {
-				return
+				return true
+			}
+		}
+		for _, c := range list {
+			if c.outbound != t.outbound {
+				if t.outbound != this.preferOutbound(cidr.IP) {
+					this.Infof("dropping redundant connection %s for %s", t, ips)
+					return false
+				}
+				this.Infof("dropping redundant connection %s for %s", c, ips)
+				this.removeTunnel(c)
+				list = this.byClusterIP[ips]
+				break
 			}
 		}
-		this.errors[ips] = nil
+		this.setError(ips, nil)
 		this.byClusterIP[ips] = append(list, t)
-		l := this.links.GetLinkForClusterAddress(t.clusterCIDR.IP)
+		l := this.links.GetLinkForClusterAddress(cidr.IP)
 		this.notify(l, nil)
 	}
+	return true
+}
+
+// preferOutbound decides which of two concurrent connections between the
+// local cluster and a peer survives. Both sides come to the same decision:
+// the connection dialed by the cluster with the lower address is kept.
+// It must be called with the mux lock held.
+func (this *Mux) preferOutbound(remote net.IP) bool {
+	local := this.clusterAddr.IP.To16()
+	return bytes.Compare(local, remote.To16()) < 0
 }
 
 func (this *Mux) RemoveTunnel(t *TunnelConnection) {
@@ -207,8 +488,12 @@ func (this *Mux) RemoveTunnel(t *TunnelConnection) {
 }
 
 func (this *Mux) removeTunnel(t *TunnelConnection) {
-	ips := t.clusterCIDR.IP.String()
 	t.Close()
+	this.removeTunnelEntry(t)
+}
+
+func (this *Mux) removeTunnelEntry(t *TunnelConnection) {
+	ips := t.ClusterCIDR().IP.String()
 	list := this.byClusterIP[ips]
 	if len(list) > 0 {
 		for i, c := range list {
@@ -225,19 +510,40 @@ func (this *Mux) removeTunnel(t *TunnelConnection) {
 	}
 }
 
+// Notify handles a state change of a tunnel connection. Connections
+// not registered anymore, for example the loser of a simultaneous
+// connect, are ignored to keep the state of their successor.
 func (this *Mux) Notify(t *TunnelConnection, err error) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
 
-	this.errors[t.clusterCIDR.IP.String()] = err
+	cidr := t.ClusterCIDR()
+	if !this.isRegistered(t) {
+		if err != nil {
+			this.Infof("replaced connection %s aborted: %s", t, err)
+		}
+		return
+	}
+	this.setError(cidr.IP.String(), err)
 	if err != nil {
 		this.Errorf("connection %s aborted: %s", t, err)
 		this.removeTunnel(t)
 	}
-	l := this.links.GetLinkForIP(t.clusterCIDR.IP)
+	l := this.links.GetLinkForIP(cidr.IP)
 	this.notify(l, err)
 }
 
+// isRegistered checks whether a tunnel connection is registered for its
+// cluster address. It must be called with the mux lock held.
+func (this *Mux) isRegistered(t *TunnelConnection) bool {
+	for _, c := range this.byClusterIP[t.ClusterCIDR().IP.String()] {
+		if c == t {
+			return true
+		}
+	}
+	return false
+}
+
 func (this *Mux) notify(l *kubelink.Link, err error) {
 	if l != nil {
 		for _, h := range this.handlers {
@@ -274,31 +580,122 @@ func (this *Mux) FindConnection(log logger.LogContext, packet []byte) *TunnelCon
 			return nil
 		}
 
+		if this.interceptPacket(nil, header, packet) {
+			return nil
+		}
+		t, _ := this.GetConnectionForIP(header.Dst)
+		if t != nil {
+			this.logPacket(log, "receiving ipv4[%d]: (%d) hdr: %d, total: %d, prot: %d,  %s->%s to %s", header.Version, len(packet), header.Len, header.TotalLen, header.Protocol, header.Src, header.Dst, t.remoteAddress)
+			return t
+		}
+		this.warnPacket(log, "drop unknown dest: ipv4[%d]: (%d) hdr: %d, total: %d, prot: %d,  %s->%s", header.Version, len(packet), header.Len, header.TotalLen, header.Protocol, header.Src, header.Dst)
+	} else if vers == ipv6.Version {
+		header, err := ipv6.ParseHeader(packet)
+		if err != nil {
+			log.Errorf("err: %s", err)
+			return nil
+		}
 		t, _ := this.GetConnectionForIP(header.Dst)
 		if t != nil {
-			log.Infof("receiving ipv4[%d]: (%d) hdr: %d, total: %d, prot: %d,  %s->%s to %s", header.Version, len(packet), header.Len, header.TotalLen, header.Protocol, header.Src, header.Dst, t.remoteAddress)
+			this.logPacket(log, "receiving ipv6[%d]: (%d) payload: %d, next: %d,  %s->%s to %s", header.Version, len(packet), header.PayloadLen, header.NextHeader, header.Src, header.Dst, t.remoteAddress)
 			return t
 		}
-		log.Warnf("drop unknown dest: ipv4[%d]: (%d) hdr: %d, total: %d, prot: %d,  %s->%s", header.Version, len(packet), header.Len, header.TotalLen, header.Protocol, header.Src, header.Dst)
+		this.warnPacket(log, "drop unknown dest: ipv6[%d]: (%d) payload: %d, next: %d,  %s->%s", header.Version, len(packet), header.PayloadLen, header.NextHeader, header.Src, header.Dst)
 	} else {
-		log.Warnf("drop unknown packet (type %d)", vers)
+		this.warnPacket(log, "drop unknown packet (type %d)", vers)
 	}
 	return nil
 }
 
+// RecoverTun requests the recreation of the tun device after a failure
+// affecting all connections. The actual tun device is closed to abort
+// the tun handling.
+func (this *Mux) RecoverTun(err error) {
+	select {
+	case <-this.ctx.Done():
+		return
+	default:
+	}
+	if !atomic.CompareAndSwapInt32(&this.tunRecovery, 0, 1) {
+		return
+	}
+	this.Errorf("tun failure: %s -> recreating tun device", err)
+	for _, q := range this.GetTun().Queues() {
+		q.Close()
+	}
+}
+
+// GetTun returns the actual tun device.
+func (this *Mux) GetTun() *Tun {
+	tun, _ := this.tun.Load().(*Tun)
+	return tun
+}
+
+// ReplaceTun replaces the tun device after a recovery.
+func (this *Mux) ReplaceTun(tun *Tun) {
+	this.tun.Store(tun)
+	atomic.StoreInt32(&this.tunRecovery, 0)
+}
+
+// ServeTun handles the packets of the tun device until it finally
+// fails. After a recovery the tun device is recreated by the given
+// function.
+func (this *Mux) ServeTun(create func() (*Tun, error)) error {
+	for {
+		err := this.HandleTun()
+		if err != nil {
+			return err
+		}
+		this.GetTun().Close()
+		if this.ctx.Err() != nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+		this.Infof("recreating tun device")
+		tun, err := create()
+		if err != nil {
+			return fmt.Errorf("cannot setup tun device: %s", err)
+		}
+		this.ReplaceTun(tun)
+	}
+}
+
+// HandleTun handles packets read from the tun device. For a multi queue
+// device every additional queue is served by a separate go routine.
+// If an additional queue fails, the tun device is recovered, which
+// finally restarts all queues.
 func (this *Mux) HandleTun() error {
-	log := this.NewContext("source", "tun")
-	var buffer [BufferSize]byte
-	bytes := buffer[:]
+	queues := this.GetTun().Queues()
+	for i, q := range queues[1:] {
+		go func(log logger.LogContext, q *taptun.Tun) {
+			err := this.handleTunQueue(log, q)
+			if err != nil {
+				log.Errorf("tun queue aborted: %s", err)
+				this.RecoverTun(err)
+			}
+		}(this.NewContext("source", fmt.Sprintf("tun[%d]", i+1)), q)
+	}
+	return this.handleTunQueue(this.NewContext("source", "tun"), queues[0])
+}
+
+func (this *Mux) handleTunQueue(log logger.LogContext, tun *taptun.Tun) error {
+	buffer := this.buffers.Get()
+	defer this.buffers.Put(buffer)
+	// keep room for the frame header to forward packets without copying
+	bytes := buffer[frameHeaderSize:]
 	working := false
 	for {
-		n, err := this.tun.Read(bytes)
+		n, err := tun.Read(bytes)
 		if n < 0 || err != nil {
+			if atomic.LoadInt32(&this.tunRecovery) != 0 {
+				log.Infof("tun closed for recovery")
+				return nil
+			}
 			if err.Error() == "read /dev/net/tun: not pollable" {
 				if working {
 					log.Errorf("handle tun: err=%s", err)
 				}
-				this.tun.tun.ReadWriteCloser.(*os.File).Close()
+				tun.ReadWriteCloser.(*os.File).Close()
 				return nil
 			}
 			if working {
@@ -316,10 +713,24 @@ func (this *Mux) HandleTun() error {
 		packet := bytes[:n]
 		t := this.FindConnection(log, packet)
 		if t != nil {
-			err = t.WritePacket(PACKET_TYPE_DATA, packet)
+			if t.mtu > 0 && n > t.mtu {
+				this.logPacket(log, "dropping packet of size %d exceeding MTU %d", n, t.mtu)
+				t.linkStats().CountDrop(kubelink.DROP_OVERSIZED)
+				if msg := this.tooBig(packet, t.mtu); msg != nil {
+					if _, err := tun.Write(msg); err != nil {
+						log.Warnf("cannot report MTU %d: %s", t.mtu, err)
+					}
+				}
+				continue
+			}
+			err = t.writeFrame(buffer[:frameHeaderSize+n])
 			if err != nil {
-				return err
+				// a broken connection is handled by its serve loop,
+				// the tun device is still usable for other connections.
+				this.logPacket(log, "cannot write packet to %s: %s", t, err)
+				continue
 			}
+			t.linkStats().CountOut(n)
 		}
 	}
 }
@@ -330,6 +741,15 @@ func (this *Mux) ServeConnection(ctx context.Context, conn net.Conn) {
 	fqdn := ""
 	remote := conn.RemoteAddr().String()
 
+	conn, handled := this.handleHealthProbe(conn)
+	if handled {
+		return
+	}
+	if this.IsShuttingDown() {
+		this.Infof("rejecting tunnel connection from %s: broker is shutting down", remote)
+		return
+	}
+
 	tlsConn, ok := conn.(*tls.Conn)
 	if ok {
 		state := tlsConn.ConnectionState()
@@ -344,45 +764,74 @@ func (this *Mux) ServeConnection(ctx context.Context, conn net.Conn) {
 				}
 				this.Infof("tunnel connection requested for %s from %s-> using auto-connect", fqdn, remote)
 			} else {
+				if link.Plaintext {
+					this.Errorf("tls connection for plaintext link %s from %s rejected", link.Name, remote)
+					return
+				}
 				this.Infof("tunnel connection for %s (%s[%s]) requested from %s", link.Name, fqdn, link.ClusterAddress.IP, remote)
 			}
 		}
 	} else {
+		if this.certInfo.UseTLS() && !this.links.HasPlaintextLinks() {
+			this.Errorf("plaintext connection from %s rejected: no plaintext links", remote)
+			return
+		}
 		this.Infof("tunnel connection requested from %s", remote)
 	}
-	t, hello, err := NewTunnelConnection(this, conn, link)
+	span := tracing.StartSpan("kubelink.accept", "kubelink.direction", "inbound", "kubelink.connection", ConnectionID(conn), "kubelink.remote", remote)
+	defer span.Finish(fmt.Errorf("connection rejected"))
+	if link != nil {
+		span.SetAttributes("kubelink.link", link.Name)
+	}
+	t, hello, err := NewTunnelConnection(this, conn, link, false)
+	tcp.HandshakeDone(ctx)
 	if err != nil {
+		handshakeFailures.Inc(DIRECTION_INBOUND)
 		this.Errorf("initiating tunnel from %s failed: %s", remote, err)
+		span.Finish(err)
 		return
 	}
+	span.AddEvent("handshake")
 	cidr := hello.GetClusterCIDR()
-	if t.clusterCIDR != nil {
-		if !t.clusterCIDR.Contains(cidr.IP) {
-			this.Errorf("remote cluster (%s) not in local range %s", cidr.IP, t.clusterCIDR)
+	span.SetAttributes("kubelink.cluster_address", cidr.IP.String())
+	if own := t.ClusterCIDR(); own != nil {
+		if !own.Contains(cidr.IP) {
+			this.Errorf("remote cluster (%s) not in local range %s", cidr.IP, own)
+			return
+		}
+		if !cidr.Contains(own.IP) {
+			this.Errorf("local cluster (%s) not in remote range %s", own.IP, cidr)
 			return
 		}
-		if !cidr.Contains(t.clusterCIDR.IP) {
-			this.Errorf("local cluster (%s) not in remote range %s", t.clusterCIDR.IP, cidr)
+		if !this.AddTunnel(t) {
 			return
 		}
 		defer this.RemoveTunnel(t)
-		this.AddTunnel(t)
 	} else {
 		if this.autoconnect {
 			adjusted := *cidr
-			adjusted.Mask = this.clusterAddr.Mask
+			adjusted.Mask = this.GetClusterAddress().Mask
 			if hello.GetPort() > 0 {
 				fqdn = fmt.Sprintf("%s:%d", fqdn, hello.GetPort())
 			}
-			l, err := this.links.RegisterLink(DefaultLinkName(cidr.IP), &adjusted, fqdn, hello.GetCIDR())
+			cidrs := hello.GetCIDRs()
+			if len(cidrs) == 0 {
+				cidrs = tcp.CIDRList{hello.GetCIDR()}
+			}
+			l, err := this.links.RegisterLink(this, DefaultLinkName(cidr.IP), &adjusted, fqdn, cidrs[0], cidrs[1:]...)
 			if err != nil {
 				this.Errorf("cannot auto-connect cluster %s: %s", cidr.IP, err)
 				return
 			}
 			this.Infof("auto-connected %s", l)
-			t.clusterCIDR = t.clusterCIDR
+			t.lock.Lock()
+			t.clusterCIDR = l.ClusterAddress
+			t.lock.Unlock()
+			t.updateStats()
 		}
 	}
+	span.AddEvent("connected")
+	span.Finish(nil)
 	t.Serve()
 }
 
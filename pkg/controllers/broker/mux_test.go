@@ -0,0 +1,346 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/taptun"
+)
+
+type testStateHandler struct {
+	errors []error
+}
+
+func (this *testStateHandler) Notify(l *kubelink.Link, err error) {
+	this.errors = append(this.errors, err)
+}
+
+func testConnection(t *testing.T, m *Mux, link string, outbound bool) *TunnelConnection {
+	c1, c2 := net.Pipe()
+	go io.Copy(ioutil.Discard, c2)
+	c := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1, reader: c1, outbound: outbound, clusterCIDR: m.links.GetLink(link).ClusterAddress}
+	c.updateStats()
+	return c
+}
+
+func TestSimultaneousConnect(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	m.LogContext = logger.New()
+	handler := &testStateHandler{}
+	m.handlers = []LinkStateHandler{handler}
+
+	// the local cluster has the lower address, so the outbound
+	// connection wins.
+	inbound := testConnection(t, m, "a", false)
+	outbound := testConnection(t, m, "a", true)
+	if !m.AddTunnel(inbound) {
+		t.Fatalf("first connection rejected")
+	}
+	if !m.AddTunnel(outbound) {
+		t.Fatalf("preferred connection rejected")
+	}
+
+	// the serve loop of the dropped connection fails
+	m.Notify(inbound, fmt.Errorf("connection closed"))
+
+	ip := net.ParseIP("192.168.0.10")
+	if err := m.GetError(ip); err != nil {
+		t.Errorf("link marked as failed by dropped connection: %s", err)
+	}
+	for _, err := range handler.errors {
+		if err != nil {
+			t.Errorf("link failure notified for dropped connection: %s", err)
+		}
+	}
+	if list := m.byClusterIP[ip.String()]; len(list) != 1 || list[0] != outbound {
+		t.Errorf("preferred connection not registered: %v", list)
+	}
+
+	// a failure of the registered connection is still reported
+	m.Notify(outbound, fmt.Errorf("connection closed"))
+	if m.GetError(ip) == nil {
+		t.Errorf("failure of registered connection not recorded")
+	}
+	if len(handler.errors) == 0 || handler.errors[len(handler.errors)-1] == nil {
+		t.Errorf("failure of registered connection not notified")
+	}
+}
+
+// testQueue is a tun queue delivering the given packets. Afterwards
+// it fails with the given error or blocks until it is closed.
+type testQueue struct {
+	packets  chan []byte
+	err      error
+	closed   chan struct{}
+	once     sync.Once
+	writeErr error
+	lock     sync.Mutex
+	written  int
+}
+
+func newTestQueue(err error, packets ...[]byte) *testQueue {
+	q := &testQueue{packets: make(chan []byte, len(packets)), err: err, closed: make(chan struct{})}
+	for _, p := range packets {
+		q.packets <- p
+	}
+	return q
+}
+
+func (this *testQueue) Read(buf []byte) (int, error) {
+	select {
+	case p := <-this.packets:
+		return copy(buf, p), nil
+	default:
+	}
+	if this.err != nil {
+		return 0, this.err
+	}
+	<-this.closed
+	return 0, fmt.Errorf("queue closed")
+}
+
+func (this *testQueue) Write(buf []byte) (int, error) {
+	if this.writeErr != nil {
+		return 0, this.writeErr
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.written++
+	return len(buf), nil
+}
+
+func (this *testQueue) Written() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.written
+}
+
+func (this *testQueue) Close() error {
+	this.once.Do(func() { close(this.closed) })
+	return nil
+}
+
+func testTun(queues ...*testQueue) *Tun {
+	tun := &Tun{}
+	for i, q := range queues {
+		if i == 0 {
+			tun.tun = &taptun.Tun{ReadWriteCloser: q}
+		} else {
+			tun.queues = append(tun.queues, &taptun.Tun{ReadWriteCloser: q})
+		}
+	}
+	return tun
+}
+
+func handleTun(t *testing.T, m *Mux) error {
+	done := make(chan error, 1)
+	go func() { done <- m.HandleTun() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatalf("tun handling not finished")
+	}
+	return nil
+}
+
+func TestTunQueueFailure(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24")
+	m.LogContext = logger.New()
+	m.ctx = context.Background()
+	primary := newTestQueue(nil)
+	m.ReplaceTun(testTun(primary, newTestQueue(fmt.Errorf("queue failure"))))
+
+	// the failed additional queue recovers the device, which
+	// finishes the handling of the primary queue for a restart
+	if err := handleTun(t, m); err != nil {
+		t.Errorf("tun handling aborted: %s", err)
+	}
+	if atomic.LoadInt32(&m.tunRecovery) == 0 {
+		t.Errorf("tun recovery not requested")
+	}
+}
+
+func TestTunConnectionWriteFailure(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	m.LogContext = logger.New()
+	m.ctx = context.Background()
+	conn := testConnection(t, m, "a", true)
+	m.AddTunnel(conn)
+	conn.Close()
+
+	packet, _ := testPacketTo(t, "100.64.1.5", 0, 80)
+	m.ReplaceTun(testTun(newTestQueue(io.EOF, packet, packet)))
+
+	// the write failures on the broken connection must not abort
+	// the tun handling, it ends with the end of the queue.
+	if err := handleTun(t, m); err != io.EOF {
+		t.Errorf("tun handling aborted by connection failure: %v", err)
+	}
+	if atomic.LoadInt32(&m.tunRecovery) != 0 {
+		t.Errorf("tun recovery requested for connection failure")
+	}
+}
+
+func serveTun(m *Mux, tuns ...*Tun) (chan error, *int32) {
+	created := new(int32)
+	done := make(chan error, 1)
+	go func() {
+		done <- m.ServeTun(func() (*Tun, error) {
+			n := atomic.AddInt32(created, 1)
+			if int(n) > len(tuns) {
+				return nil, fmt.Errorf("no more tun devices")
+			}
+			return tuns[n-1], nil
+		})
+	}()
+	return done, created
+}
+
+func TestServeTunRecoverReadError(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24")
+	m.LogContext = logger.New()
+	m.ctx = context.Background()
+	m.ReplaceTun(testTun(newTestQueue(nil), newTestQueue(fmt.Errorf("queue failure"))))
+	recreated := testTun(newTestQueue(io.EOF))
+
+	done, created := serveTun(m, recreated)
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("unexpected end of tun handling: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("tun handling not finished")
+	}
+	if atomic.LoadInt32(created) != 1 || m.GetTun() != recreated {
+		t.Errorf("tun device not recreated after read error")
+	}
+	if atomic.LoadInt32(&m.tunRecovery) != 0 {
+		t.Errorf("tun recovery not finished")
+	}
+}
+
+func TestServeTunRecoverWriteError(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	m.LogContext = logger.New()
+	m.ctx = context.Background()
+	m.drops = NewDropSamples(4)
+	failing := newTestQueue(nil)
+	failing.writeErr = fmt.Errorf("write failure")
+	m.ReplaceTun(testTun(failing))
+	queue := newTestQueue(nil)
+	recreated := testTun(queue)
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn := &TunnelConnection{LogContext: logger.New(), mux: m, conn: c1, reader: c1, clusterCIDR: m.links.GetLink("a").ClusterAddress}
+	go conn.serve()
+
+	done, created := serveTun(m, recreated)
+	packet, _ := testPacketTo(t, "192.168.0.1", 0, 80)
+	frame := append([]byte{byte(len(packet) >> 8), byte(len(packet)), PACKET_TYPE_DATA}, packet...)
+
+	// the write error of the connection recovers the tun device
+	if _, err := c2.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; m.GetTun() != recreated; i++ {
+		if i > 200 {
+			t.Fatalf("tun device not recreated after write error")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the connection continues with the new device
+	if _, err := c2.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; queue.Written() == 0; i++ {
+		if i > 200 {
+			t.Fatalf("packet not written to recreated tun device")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	queue.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("tun handling not finished")
+	}
+	if atomic.LoadInt32(created) != 1 {
+		t.Errorf("tun device recreated %d times", atomic.LoadInt32(created))
+	}
+}
+
+func TestLinkStats(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+		testLink("b", "192.168.0.11/24", "100.64.2.0/24"),
+	)
+	m.LogContext = logger.New()
+	m.ctx = context.Background()
+	m.drops = NewDropSamples(4)
+	a := testConnection(t, m, "a", true)
+	b := testConnection(t, m, "b", true)
+	m.AddTunnel(a)
+	m.AddTunnel(b)
+	defer a.Close()
+	defer b.Close()
+
+	toA, _ := testPacketTo(t, "100.64.1.5", 0, 80)
+	toB, _ := testPacketTo(t, "100.64.2.5", 0, 80)
+	m.ReplaceTun(testTun(newTestQueue(io.EOF, toA, toB, toA)))
+	if err := handleTun(t, m); err != io.EOF {
+		t.Fatalf("unexpected end of tun handling: %v", err)
+	}
+
+	stats := m.links.Stats()
+	if s := stats["a"]; s.PacketsOut != 2 || s.BytesOut != uint64(2*len(toA)) {
+		t.Errorf("unexpected stats for link a: %+v", s)
+	}
+	if s := stats["b"]; s.PacketsOut != 1 || s.BytesOut != uint64(len(toB)) {
+		t.Errorf("unexpected stats for link b: %+v", s)
+	}
+
+	// a recreated link gets new counters, which must be used
+	// by the connection after the link update.
+	m.links.RemoveLink("a")
+	if _, err := m.links.UpdateLink(logger.New(), testLink("a", "192.168.0.10/24", "100.64.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	m.UpdateLinkStats()
+	a.recordDrop(kubelink.DROP_OVERSIZED, nil)
+	if s := m.links.Stats()["a"]; s.PacketsOut != 0 || s.Dropped[kubelink.DROP_OVERSIZED] != 1 {
+		t.Errorf("connection stats not refreshed on link update: %+v", s)
+	}
+}
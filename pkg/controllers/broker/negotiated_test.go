@@ -0,0 +1,86 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+func TestNegotiatedExtensions(t *testing.T) {
+	hello := NewConnectionHello()
+	dns := DNSExtension{}
+	mtu := MTUExtension(1400)
+	hello.Extensions[EXT_MTU] = &mtu
+	hello.Extensions[EXT_DNS] = &dns
+	hello.Unknown = []byte{testUnknownExtension}
+
+	expected := NegotiatedExtensions{
+		Accepted: []string{"dns", "mtu"},
+		Unknown:  []string{"extension-250"},
+	}
+	if n := hello.Negotiated(); !reflect.DeepEqual(n, expected) {
+		t.Errorf("got %+v, expected %+v", n, expected)
+	}
+}
+
+func TestConnectionExtensions(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24", testLink("a", "192.168.0.10/24", "100.64.1.0/24"))
+	m.LogContext = logger.New()
+	m.SetCompression(CODEC_DEFLATE, DEFAULT_COMPRESSION_THRESHOLD)
+	conn := testConnection(t, m, "a", true)
+	defer conn.conn.Close()
+
+	hello := conn.createHello(m.links.GetLink("a"))
+	hello.Raw[testUnknownExtension] = []byte("future")
+	remote, err := conn.parseHelloPacket(hello.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.handleHello(remote)
+	if !m.AddTunnel(conn) {
+		t.Fatalf("connection rejected")
+	}
+
+	infos := m.GetConnections()
+	if len(infos) != 1 {
+		t.Fatalf("got %d connections, expected 1", len(infos))
+	}
+	ext := infos[0].Extensions
+	if !contains(ext.Accepted, "compression") {
+		t.Errorf("compression not reported as accepted: %v", ext.Accepted)
+	}
+	if contains(ext.Accepted, "dns") || contains(ext.Accepted, "apiaccess") {
+		t.Errorf("credentials reported for unencrypted connection: %v", ext.Accepted)
+	}
+	if !reflect.DeepEqual(ext.Unknown, []string{"extension-250"}) {
+		t.Errorf("unexpected unknown extensions %v", ext.Unknown)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/controllers"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// handleOnboard generates the KubeLink object for a new member of the
+// local mesh. The cluster address is allocated from the free addresses
+// of the mesh, never using the local cluster address. Requests must be
+// authorized by the admin token.
+func (this *reconciler) handleOnboard(w http.ResponseWriter, r *http.Request) {
+	if !controllers.BearerAuthorized(r, this.config.AdminToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	id := kubelink.ClusterIdentity{
+		Name:       query.Get("name"),
+		Endpoint:   query.Get("endpoint"),
+		ServerName: query.Get("servername"),
+	}
+	if v := query.Get("cidr"); v != "" {
+		_, cidr, err := net.ParseCIDR(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid cidr %q: %s", v, err), http.StatusBadRequest)
+			return
+		}
+		id.CIDR = cidr
+	}
+	klink, err := this.Links().NewKubeLink(this.mux.GetClusterAddress(), id, this.mux.LocalAddresses()...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	klink.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("KubeLink"))
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(klink)
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOnboardAuthorization(t *testing.T) {
+	r := &reconciler{
+		config: &Config{AccessToken: "access", AdminToken: "admin"},
+		mux:    testMux(t, "192.168.0.1/24"),
+	}
+	for _, token := range []string{"", "access", "other"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/onboard?name=new&endpoint=new.example.com:80", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.handleOnboard(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("onboarding with token %q: got status %d", token, w.Code)
+		}
+	}
+}
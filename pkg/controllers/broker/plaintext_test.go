@@ -0,0 +1,106 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+// testTLSPeer serves tls tunnel connections for a peer mux.
+func testTLSPeer(t *testing.T, m *Mux) net.Listener {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", m.certInfo.ServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if err := c.(*tls.Conn).Handshake(); err != nil {
+					c.Close()
+					return
+				}
+				m.ServeConnection(context.Background(), c)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestPlaintextModes(t *testing.T) {
+	certs := testCertInfo(t, "peer.example.com")
+
+	cases := map[string]struct {
+		serverTLS   bool
+		serverPlain bool
+		clientPlain bool
+		valid       bool
+	}{
+		"plaintext both":        {serverTLS: false, serverPlain: true, clientPlain: true, valid: true},
+		"tls both":              {serverTLS: true, serverPlain: false, clientPlain: false, valid: true},
+		"plaintext to tls":      {serverTLS: false, serverPlain: false, clientPlain: true, valid: false},
+		"tls to plaintext":      {serverTLS: true, serverPlain: true, clientPlain: false, valid: false},
+		"tls to plain listener": {serverTLS: false, serverPlain: true, clientPlain: false, valid: false},
+	}
+	for name, c := range cases {
+		server := testLink("b", "192.168.0.1/24", "100.64.0.0/24")
+		server.Spec.Endpoint = "peer.example.com:80"
+		server.Spec.Plaintext = c.serverPlain
+		peer := testMux(t, "192.168.0.10/24", server)
+		peer.LogContext = logger.New()
+		peer.certInfo = certs
+		var l net.Listener
+		if c.serverTLS {
+			l = testTLSPeer(t, peer)
+		} else {
+			l = testPeer(t, peer)
+		}
+
+		client := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+		client.Spec.Endpoint = l.Addr().String()
+		client.Spec.ServerName = "peer.example.com"
+		client.Spec.Plaintext = c.clientPlain
+		m := testMux(t, "192.168.0.1/24", client)
+		m.LogContext = logger.New()
+		m.certInfo = certs
+		m.helloTimeout = 2 * time.Second
+		m.dialTimeout = 2 * time.Second
+
+		conn, err := m.AssureTunnel(m, m.links.GetLink("a"))
+		if err == nil {
+			conn.Close()
+		}
+		if c.valid && err != nil {
+			t.Errorf("%s: connection failed: %s", name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: mode mismatch not rejected", name)
+		}
+		l.Close()
+	}
+}
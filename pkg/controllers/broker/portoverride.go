@@ -0,0 +1,103 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// PortOverride overrides the advertised broker port for a dedicated
+// link or for peers connecting from a dedicated network.
+type PortOverride struct {
+	Link string
+	CIDR *net.IPNet
+	Port uint16
+}
+
+func (this PortOverride) String() string {
+	if this.CIDR != nil {
+		return fmt.Sprintf("%s=%d", this.CIDR, this.Port)
+	}
+	return fmt.Sprintf("%s=%d", this.Link, this.Port)
+}
+
+// ParsePortOverride parses a port override of the form <link>=<port>
+// or <cidr>=<port>.
+func ParsePortOverride(s string) (PortOverride, error) {
+	result := PortOverride{}
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return result, fmt.Errorf("invalid port override %q: expected <link or cidr>=<port>", s)
+	}
+	port, err := strconv.ParseUint(s[i+1:], 10, 16)
+	if err != nil || port == 0 {
+		return result, fmt.Errorf("invalid port in port override %q", s)
+	}
+	result.Port = uint16(port)
+	key := strings.TrimSpace(s[:i])
+	if strings.Contains(key, "/") {
+		_, result.CIDR, err = net.ParseCIDR(key)
+		if err != nil {
+			return result, fmt.Errorf("invalid cidr in port override %q: %s", s, err)
+		}
+	} else {
+		result.Link = key
+	}
+	return result, nil
+}
+
+type PortOverrides []PortOverride
+
+func ParsePortOverrides(list []string) (PortOverrides, error) {
+	var result PortOverrides
+	for _, s := range list {
+		o, err := ParsePortOverride(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, o)
+	}
+	return result, nil
+}
+
+// Lookup determines the port to advertise to a peer. An override for the
+// link has precedence over an override for the network of the remote
+// address. If no override matches, 0 is returned.
+func (this PortOverrides) Lookup(link *kubelink.Link, remote net.IP) uint16 {
+	if link != nil {
+		for _, o := range this {
+			if o.Link == link.Name {
+				return o.Port
+			}
+		}
+	}
+	if remote != nil {
+		for _, o := range this {
+			if o.CIDR != nil && o.CIDR.Contains(remote) {
+				return o.Port
+			}
+		}
+	}
+	return 0
+}
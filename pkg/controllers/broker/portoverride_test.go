@@ -0,0 +1,76 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParsePortOverrides(t *testing.T) {
+	overrides, err := ParsePortOverrides([]string{"a=8443", "10.1.0.0/16=9443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 2 || overrides[0].String() != "a=8443" || overrides[1].String() != "10.1.0.0/16=9443" {
+		t.Errorf("unexpected overrides %v", overrides)
+	}
+	for _, s := range []string{"a", "=80", "a=0", "a=70000", "a=port", "10.1.0.0/33=80"} {
+		if _, err := ParsePortOverride(s); err == nil {
+			t.Errorf("invalid override %q accepted", s)
+		}
+	}
+}
+
+func TestHelloPortOverride(t *testing.T) {
+	l, _ := testListener(t)
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+		testLink("b", "192.168.0.11/24", "100.64.2.0/24"),
+	)
+	m.port = 80
+	overrides, err := ParsePortOverrides([]string{"a=8443", "127.0.0.0/8=9443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &TunnelConnection{mux: m, conn: conn}
+
+	cases := map[string]uint16{
+		"":  9443, // unknown peer from overridden network
+		"a": 8443, // link override has precedence
+		"b": 9443,
+	}
+	m.SetPortOverrides(overrides)
+	for name, port := range cases {
+		if p := c.createHello(m.links.GetLink(name)).GetPort(); p != port {
+			t.Errorf("link %q: advertised port %d, expected %d", name, p, port)
+		}
+	}
+	m.SetPortOverrides(overrides[:1])
+	if p := c.createHello(m.links.GetLink("b")).GetPort(); p != 80 {
+		t.Errorf("advertised port %d, expected default port", p)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"time"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+// TCPInfo describes the kernel statistics of a tcp connection.
+type TCPInfo struct {
+	RTT         time.Duration
+	RTTVar      time.Duration
+	Retransmits uint64
+}
+
+// ConnectionQuality describes the last sampled underlay quality
+// of a tunnel connection.
+type ConnectionQuality struct {
+	TCPInfo
+	// RetransmitRate is the number of retransmits per second since
+	// the previous sample
+	RetransmitRate float64
+	Time           time.Time
+}
+
+// readTCPInfo reads the tcp info of a connection.
+var readTCPInfo = ReadTCPInfo
+
+// sampleQuality reads the tcp info of the tunnel connection and
+// updates the connection quality.
+func (this *TunnelConnection) sampleQuality(now time.Time) error {
+	info, err := readTCPInfo(this.conn)
+	if err != nil {
+		return err
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	q := &ConnectionQuality{TCPInfo: *info, Time: now}
+	if old := this.quality; old != nil && now.After(old.Time) && info.Retransmits >= old.Retransmits {
+		q.RetransmitRate = float64(info.Retransmits-old.Retransmits) / now.Sub(old.Time).Seconds()
+	}
+	this.quality = q
+	return nil
+}
+
+// Quality returns the last sampled connection quality or nil.
+func (this *TunnelConnection) Quality() *ConnectionQuality {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.quality
+}
+
+// SampleConnectionQuality samples the tcp info of all tunnel connections.
+func (this *Mux) SampleConnectionQuality() {
+	var conns []*TunnelConnection
+	this.lock.RLock()
+	for _, list := range this.byClusterIP {
+		conns = append(conns, list...)
+	}
+	this.lock.RUnlock()
+
+	now := time.Now()
+	for _, t := range conns {
+		if err := t.sampleQuality(now); err != nil {
+			t.Debugf("cannot read tcp info: %s", err)
+		}
+	}
+}
+
+// GetConnectionQuality returns the connection quality of the
+// active tunnel connection for the given cluster address.
+func (this *Mux) GetConnectionQuality(ip net.IP) *ConnectionQuality {
+	this.lock.RLock()
+	t, _ := this.queryClusterConnection(ip)
+	this.lock.RUnlock()
+	if t == nil {
+		return nil
+	}
+	return t.Quality()
+}
+
+// handleConnectionQuality periodically samples the quality of
+// the tunnel connections.
+func (this *reconciler) handleConnectionQuality(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.Controller().GetContext().Done():
+			return
+		case <-ticker.C:
+		}
+		this.mux.SampleConnectionQuality()
+	}
+}
+
+func (this *reconciler) collectQualityMetrics(w *metrics.Writer) {
+	type entry struct {
+		labels  metrics.Labels
+		quality *ConnectionQuality
+	}
+	var entries []entry
+	this.mux.links.Visit(func(l *kubelink.Link) bool {
+		if q := this.mux.GetConnectionQuality(l.ClusterAddress.IP); q != nil {
+			entries = append(entries, entry{metrics.Labels{"link": l.Name}, q})
+		}
+		return true
+	})
+	if len(entries) == 0 {
+		return
+	}
+	w.Describe("kubelink_link_rtt_seconds", metrics.GAUGE, "Smoothed round trip time of the tunnel connection of a link")
+	for _, e := range entries {
+		w.Value("kubelink_link_rtt_seconds", e.labels, e.quality.RTT.Seconds())
+	}
+	w.Describe("kubelink_link_rtt_variance_seconds", metrics.GAUGE, "Round trip time variance of the tunnel connection of a link")
+	for _, e := range entries {
+		w.Value("kubelink_link_rtt_variance_seconds", e.labels, e.quality.RTTVar.Seconds())
+	}
+	w.Describe("kubelink_link_retransmits_total", metrics.COUNTER, "Number of tcp retransmits of the tunnel connection of a link")
+	for _, e := range entries {
+		w.Value("kubelink_link_retransmits_total", e.labels, float64(e.quality.Retransmits))
+	}
+	w.Describe("kubelink_link_retransmit_rate", metrics.GAUGE, "Tcp retransmits per second of the tunnel connection of a link")
+	for _, e := range entries {
+		w.Value("kubelink_link_retransmit_rate", e.labels, e.quality.RetransmitRate)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+func TestConnectionQualityMetrics(t *testing.T) {
+	info := &TCPInfo{RTT: 20 * time.Millisecond, RTTVar: 5 * time.Millisecond, Retransmits: 10}
+	readTCPInfo = func(net.Conn) (*TCPInfo, error) {
+		i := *info
+		return &i, nil
+	}
+	defer func() { readTCPInfo = ReadTCPInfo }()
+
+	peer := testMux(t, "192.168.0.10/24", testLink("b", "192.168.0.1/24", "100.64.0.0/24"))
+	peer.LogContext = logger.New()
+	l := testPeer(t, peer)
+	defer l.Close()
+
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = l.Addr().String()
+	m := testMux(t, "192.168.0.1/24", kl, testLink("c", "192.168.0.11/24", "100.64.2.0/24"))
+	m.LogContext = logger.New()
+	m.helloTimeout = 2 * time.Second
+	r := &reconciler{mux: m}
+
+	c, err := m.AssureTunnel(m, m.links.GetLink("a"))
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer c.Close()
+
+	w := metrics.NewWriter()
+	r.collectQualityMetrics(w)
+	if len(w.Bytes()) != 0 {
+		t.Errorf("metrics exported before sampling: %s", w.Bytes())
+	}
+
+	m.SampleConnectionQuality()
+	q := m.GetConnectionQuality(net.ParseIP("192.168.0.10"))
+	if q == nil || q.RTT != info.RTT || q.Retransmits != 10 || q.RetransmitRate != 0 {
+		t.Fatalf("unexpected connection quality %+v", q)
+	}
+
+	info.Retransmits = 30
+	if err := c.sampleQuality(q.Time.Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	w = metrics.NewWriter()
+	r.collectQualityMetrics(w)
+	out := string(w.Bytes())
+	for _, expected := range []string{
+		`kubelink_link_rtt_seconds{link="a"} 0.02`,
+		`kubelink_link_rtt_variance_seconds{link="a"} 0.005`,
+		`kubelink_link_retransmits_total{link="a"} 30`,
+		`kubelink_link_retransmit_rate{link="a"} 2`,
+	} {
+		if !strings.Contains(out, expected+"\n") {
+			t.Errorf("missing %q in\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, `link="c"`) {
+		t.Errorf("metrics exported for unconnected link:\n%s", out)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"time"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// handleLinkReaping periodically removes auto-registered links whose
+// connection has been down for longer than the grace period.
+// Manually created links are never removed.
+func (this *reconciler) handleLinkReaping(grace time.Duration) {
+	interval := grace / 4
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	down := map[string]time.Time{}
+	for {
+		select {
+		case <-this.Controller().GetContext().Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		var links []*kubelink.Link
+		this.Links().Visit(func(l *kubelink.Link) bool {
+			if l.AutoRegistered {
+				links = append(links, l)
+			}
+			return true
+		})
+		found := map[string]bool{}
+		for _, l := range links {
+			found[l.Name] = true
+			if s, _ := this.mux.GetConnectionState(l.ClusterAddress.IP); s == v1alpha1.STATE_UP {
+				delete(down, l.Name)
+				continue
+			}
+			since, ok := down[l.Name]
+			if !ok {
+				down[l.Name] = now
+				continue
+			}
+			if now.Sub(since) < grace {
+				continue
+			}
+			this.Controller().Infof("removing auto-registered link %s: down since %s", l.Name, since.Format(time.RFC3339))
+			if err := this.Links().UnregisterLink(l.Name); err != nil {
+				this.Controller().Errorf("cannot remove link %s: %s", l.Name, err)
+				continue
+			}
+			delete(down, l.Name)
+		}
+		for n := range down {
+			if !found[n] {
+				delete(down, n)
+			}
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/gardener/controller-manager-library/pkg/ctxutil"
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"github.com/gardener/controller-manager-library/pkg/resources"
+	"github.com/gardener/controller-manager-library/pkg/server"
 	"github.com/vishvananda/netlink"
 	_apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
@@ -38,6 +40,7 @@ import (
 	"github.com/mandelsoft/kubelink/pkg/controllers"
 	"github.com/mandelsoft/kubelink/pkg/iptables"
 	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/metrics"
 	"github.com/mandelsoft/kubelink/pkg/tcp"
 )
 
@@ -57,6 +60,7 @@ type reconciler struct {
 	access  kubelink.LinkAccessInfo
 	dnsInfo kubelink.LinkDNSInfo
 	mux     *Mux
+	limit   *LinkLimit
 
 	lock            sync.RWMutex
 	requiredSecrets map[resources.ObjectName]resources.ObjectNameSet
@@ -77,13 +81,16 @@ func (this *reconciler) Gateway(obj *v1alpha1.KubeLink) (net.IP, error) {
 	if !match {
 		return nil, nil
 	}
+	if err := this.limit.Admit(obj.Name); err != nil {
+		return nil, err
+	}
 	return gateway, this.mux.GetError(ip)
 }
 
 func (this *reconciler) UpdateGateway(link *v1alpha1.KubeLink) *string {
 	gateway := this.NodeInterface().IP
 	match, _ := this.config.MatchLink(link)
-	if !match {
+	if !match || !this.limit.IsAdmitted(link.Name) {
 		gateway = nil
 	}
 
@@ -95,7 +102,10 @@ func (this *reconciler) UpdateGateway(link *v1alpha1.KubeLink) *string {
 }
 
 func (this *reconciler) IsManagedRoute(route *netlink.Route, routes kubelink.Routes) bool {
-	if route.LinkIndex == this.mux.tun.link.Attrs().Index {
+	if kubelink.IsKubelinkRoute(route, kubelink.BROKER_ROUTE_PROTOCOL) {
+		return true
+	}
+	if link := this.tunLink(); link != nil && route.LinkIndex == link.Attrs().Index {
 		return true
 	}
 	if route.Dst != nil {
@@ -109,12 +119,42 @@ func (this *reconciler) IsManagedRoute(route *netlink.Route, routes kubelink.Rou
 }
 
 func (this *reconciler) RequiredRoutes() kubelink.Routes {
-	routes := this.Links().GetRoutesToLink(this.NodeInterface(), this.mux.tun.link)
-	return append(routes, netlink.Route{LinkIndex: this.mux.tun.link.Attrs().Index, Dst: this.config.ClusterCIDR})
+	link := this.tunLink()
+	if link == nil {
+		return nil
+	}
+	routes := this.mux.ApplyMTU(this.Links().GetRoutesToLink(this.NodeInterface(), link))
+	if this.config.UnreachableOnFailure {
+		routes = this.mux.UnreachableRoutes(routes)
+	}
+	return append(routes, netlink.Route{LinkIndex: link.Attrs().Index, Dst: this.config.ClusterCIDR, Protocol: kubelink.BROKER_ROUTE_PROTOCOL})
+}
+
+// tunLink returns the tun device in the actual network namespace.
+// Link indices are specific for a namespace, therefore the device is
+// looked up by name if a network namespace is configured. It returns
+// nil if the device is not found.
+func (this *reconciler) tunLink() netlink.Link {
+	if this.config.NetNS == "" {
+		return this.mux.GetTun().link
+	}
+	link, err := netlink.LinkByName(this.mux.GetTun().link.Attrs().Name)
+	if err != nil {
+		this.Controller().Errorf("tun device not found in network namespace %q: %s", this.config.NetNS, err)
+		return nil
+	}
+	return link
 }
 
 func (this *reconciler) RequiredSNATRules() iptables.Requests {
-	return nil
+	if !this.config.AntiSpoofing || this.config.DisableBridge {
+		return nil
+	}
+	mesh := this.config.MeshCIDR
+	if mesh == nil {
+		mesh = this.config.ClusterCIDR
+	}
+	return this.Links().GetAntiSpoofingRules(this.mux.GetTun().link.Attrs().Name, mesh)
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -133,8 +173,20 @@ func (this *reconciler) Setup() {
 		panic(fmt.Errorf("cannot setup tls: %s", err))
 	}
 
+	SetupTracing(this.Controller().GetContext(), this.config.TracingEndpoint, this.config.TracingService)
+	this.Links().SetServiceCIDR(this.config.ServiceCIDR, this.config.ServiceCIDROverlap)
+	this.Links().SetEndpointAllowlist(this.config.EndpointAllowlist)
+	this.limit = NewLinkLimit(this.config.MaxLinks)
 	this.Reconciler.Setup()
 
+	declared := this.config.MeshCIDR
+	if declared == nil {
+		declared = this.config.ClusterAddress
+	}
+	for _, c := range this.Links().GetMeshes().CheckConsistency(declared) {
+		this.Controller().Warnf("inconsistent mesh membership: %s", c)
+	}
+
 	if this.config.DisableBridge {
 		return
 	}
@@ -142,7 +194,7 @@ func (this *reconciler) Setup() {
 	if this.config.IPIP != controllers.IPIP_NONE {
 		this.WaitIPIP()
 	}
-	tun, err := NewTun(this.Controller(), this.config.Interface, this.config.ClusterAddress)
+	tun, err := NewTun(this.Controller(), this.config.Interface, this.config.ClusterAddress, this.config.TunOptions())
 	if err != nil {
 		panic(fmt.Errorf("cannot setup tun device: %s", err))
 	}
@@ -152,6 +204,9 @@ func (this *reconciler) Setup() {
 			panic(fmt.Errorf("no TLS certificate: %s", err))
 		}
 		this.certInfo = NewCertInfo(this.Controller(), certificate)
+		if err := this.certInfo.SetSessionTickets(this.config.TLSSessionTickets, this.config.TLSTicketKeyRotation); err != nil {
+			panic(err)
+		}
 	}
 
 	var local tcp.CIDRList
@@ -160,53 +215,116 @@ func (this *reconciler) Setup() {
 	}
 	mux := NewMux(this.Controller().GetContext(), this.Controller(), this.certInfo, uint16(this.config.AdvertisedPort), this.config.ClusterAddress, local, tun, this.Links(), this)
 
+	mux.SetTimeouts(this.config.DialTimeout, this.config.HelloTimeout)
+	mux.SetDialInterface(this.config.DialInterface)
+	mux.SetPortOverrides(this.config.AdvertisedPortOverrides)
+	mux.SetServices(this.config.AdvertisedServices, this)
+	mux.SetDSCP(this.config.DSCP)
+	mux.SetKeepAlive(this.config.KeepAlive)
+	mux.SetKeepAlivePackets(this.config.KeepAlivePacketInterval, this.config.KeepAlivePacketTimeout)
+	mux.SetUDPLiveness(this.config.UDPLivenessPort, this.config.UDPLivenessInterval, this.config.UDPLivenessTimeout)
+	mux.SetBufferSizes(this.config.BufferSizes)
+	mux.SetHealthProbe(this.config.HealthProbe)
+	mux.SetRelay(this.config.Relay)
+	mux.SetRelayTimeExceeded(this.config.RelayTimeExceeded)
+	mux.SetStrictExtensions(this.config.StrictHelloExtensions)
+	mux.SetPrematureData(this.config.PrematureData)
+	mux.SetIngressMode(this.config.IngressMode)
+	mux.SetCompression(this.config.CompressionCodec, this.config.CompressionThreshold)
+	mux.SetPacketLogRate(this.config.PacketLogRate)
+	mux.SetRejectMaskMismatch(this.config.RejectMeshMismatch)
+	mux.SetBufferPooling(this.config.BufferPool)
+	mux.SetAddressHandler(this)
+	mux.SetEndpointHandler(this)
+	mux.SetTrustPeerAddress(this.config.TrustPeerAddress)
 	if this.config.DNSAdvertisement {
 		mux.connectionHandler = &DefaultConnectionHandler{this}
 	}
 
 	go func() {
 		<-this.Controller().GetContext().Done()
+		tun := this.mux.GetTun()
 		this.Controller().Infof("closing tun device %q", tun)
 		tun.Close()
 	}()
 	this.mux = mux
+
+	server.Register("/topology.dot", this.handleTopology)
+	server.Register("/trace", this.handleTrace)
+	server.Register("/errors", this.handleErrors)
+	server.Register("/connections", this.handleConnections)
+	server.Register("/drops", this.handleDrops)
+	server.Register("/policies", this.handlePolicies)
+	server.Register("/meshhealth", this.handleMeshHealth)
+	if this.config.AccessToken != "" {
+		access := NewAccessHandler(this.Links(), this.config.AccessToken)
+		server.RegisterHandler("/access", access)
+		server.RegisterHandler("/access/", access)
+	}
+	if this.config.AccessToken != "" || this.config.AdminToken != "" {
+		server.Register("/clusteraddress", this.handleClusterAddress)
+	}
+	if this.config.AdminToken != "" {
+		server.Register("/onboard", this.handleOnboard)
+	}
+	metrics.Register("meshes", metrics.CollectorFunc(this.collectMeshMetrics))
+	metrics.Register("links", metrics.CollectorFunc(this.collectLinkMetrics))
+	metrics.Register("meshhealth", metrics.CollectorFunc(this.collectMeshHealthMetrics))
+	metrics.Register("tun", metrics.CollectorFunc(this.collectTunMetrics))
+	metrics.Register("quality", metrics.CollectorFunc(this.collectQualityMetrics))
+	metrics.Register("security", metrics.CollectorFunc(this.collectSecurityMetrics))
+	metrics.Register("drops", metrics.CollectorFunc(this.collectDropMetrics))
 }
 
 func (this *reconciler) Start() {
 	if !this.config.DisableBridge {
-		NewServer("broker", this.mux).Start(this.certInfo, "", this.config.Port)
+		NewServer("broker", this.mux).
+			SetHandshakeLimit(this.config.MaxHandshakes, this.config.HandshakeQueueTimeout).
+			SetHandoff(this.config.HandoffSocket, this.config.DrainTimeout).
+			Start(this.certInfo, "", this.config.Port)
+		if this.config.UDPLivenessPort > 0 {
+			go func() {
+				if err := this.mux.ServeLiveness(); err != nil {
+					this.Controller().Errorf("udp liveness aborted: %s", err)
+				}
+			}()
+		}
 		go func() {
 			defer ctxutil.Cancel(this.Controller().GetContext())
 			this.Controller().Infof("starting tun server")
-			for {
-				err := this.mux.HandleTun()
-				if err != nil {
-					if err == io.EOF {
-						this.Controller().Errorf("tun server finished")
-					} else {
-						this.Controller().Errorf("tun handling aborted: %s", err)
-					}
-					break
-				} else {
-					this.mux.tun.Close()
-					time.Sleep(100 * time.Millisecond)
-					this.Controller().Infof("recreating tun device")
-					this.mux.tun, err = NewTun(this.Controller(), this.config.Interface, this.config.ClusterAddress)
-					if err != nil {
-						panic(fmt.Errorf("cannot setup tun device: %s", err))
-					}
-				}
+			err := this.mux.ServeTun(func() (*Tun, error) {
+				return NewTun(this.Controller(), this.config.Interface, this.mux.GetClusterAddress(), this.config.TunOptions())
+			})
+			if err == io.EOF {
+				this.Controller().Errorf("tun server finished")
+			} else if err != nil {
+				this.Controller().Errorf("tun handling aborted: %s", err)
 			}
 		}()
 	}
 	if this.config.CoreDNSConfigure {
 		this.ConnectCoredns()
 	}
+	if this.config.DNSInfoRetention > 0 && this.config.DNSPropagation == DNSMODE_DNS {
+		go this.handleDNSExpiry(this.config.DNSInfoRetention)
+	}
+	if this.config.ReapAfter > 0 && this.config.AutoConnect {
+		go this.handleLinkReaping(this.config.ReapAfter)
+	}
+	if this.config.TCPInfoInterval > 0 && !this.config.DisableBridge {
+		go this.handleConnectionQuality(this.config.TCPInfoInterval)
+	}
+	if this.config.FailBackInterval > 0 && !this.config.DisableBridge {
+		go this.handleFailBack(this.config.FailBackInterval)
+	}
 	this.Reconciler.Start()
 }
 
 func (this *reconciler) Command(logger logger.LogContext, cmd string) reconcile.Status {
-	if !this.config.DisableBridge {
+	this.Limiter().Acquire()
+	defer this.Limiter().Release()
+	start := time.Now()
+	if !this.config.DisableBridge && !this.IsPaused() {
 		logger.Debug("update tun")
 		this.reconcileTun(logger)
 	}
@@ -223,31 +341,47 @@ func (this *reconciler) Command(logger logger.LogContext, cmd string) reconcile.
 		}
 	}
 	this.updateCorefile(logger)
-	return this.Reconciler.Command(logger, cmd)
+	return this.Observe("command", start, this.UpdateNetwork(logger, cmd))
 }
 
 func (this *reconciler) Reconcile(logger logger.LogContext, obj resources.Object) reconcile.Status {
-	return this.ReconcileLink(logger, obj, this.handleLinkAccess)
+	status := this.ReconcileLink(logger, obj, this.handleLinkAccess)
+	this.mux.UpdateLinkStats()
+	this.updateRouteStatus(logger, obj.GetName())
+	return status
+}
+
+func (this *reconciler) Delete(logger logger.LogContext, obj resources.Object) reconcile.Status {
+	this.limit.Release(obj.GetName())
+	this.mux.releaseDedup(obj.GetName())
+	status := this.Reconciler.Delete(logger, obj)
+	this.mux.UpdateLinkStats()
+	return status
 }
 
 func (this *reconciler) Deleted(logger logger.LogContext, key resources.ClusterObjectKey) reconcile.Status {
 	this.secrets.ReleaseSecretForLink(key.ObjectName())
-	return this.Reconciler.Deleted(logger, key)
+	this.limit.Release(key.Name())
+	this.mux.releaseDedup(key.Name())
+	status := this.Reconciler.Deleted(logger, key)
+	this.mux.UpdateLinkStats()
+	return status
 }
 
 func (this *reconciler) reconcileTun(logger logger.LogContext) {
-	tun := this.mux.tun
+	tun := this.mux.GetTun()
+	addr := this.mux.GetClusterAddress()
 
 	addrs, err := netlink.AddrList(tun.link, netlink.FAMILY_V4)
 
 	for _, a := range addrs {
-		if a.IP.Equal(this.config.ClusterAddress.IP) {
+		if a.IP.Equal(addr.IP) {
 			logger.Debugf("address still set for %q", tun)
 			return
 		}
 	}
 
-	err = SetLinkAddress(logger, tun.link, this.config.ClusterAddress)
+	err = SetLinkAddress(logger, tun.link, addr)
 	if err != nil {
 		logger.Errorf("%s", err)
 	}
@@ -262,6 +396,61 @@ func (this *reconciler) Notify(l *kubelink.Link, err error) {
 	this.Controller().EnqueueKey(resources.NewClusterKey(this.Controller().GetMainCluster().GetId(), v1alpha1.KUBELINK, "", l.Name))
 }
 
+// UpdateServices persists the service endpoints advertised by a peer
+// in the status of its link to propagate them to all routers.
+func (this *reconciler) UpdateServices(clusterAddress net.IP, services kubelink.ServiceEndpoints) {
+	link := this.Links().GetLinkForClusterAddress(clusterAddress)
+	if link == nil {
+		this.Controller().Infof("local link not found for cluster address %s", clusterAddress)
+		return
+	}
+	if link.Services.Equal(services) {
+		return
+	}
+	this.Controller().Infof("update advertised services for link %s: %s", link.Name, services)
+	_, _, err := this.linkResource.ModifyStatusByName(resources.NewObjectName(link.Name),
+		func(odata resources.ObjectData) (bool, error) {
+			klink := odata.(*v1alpha1.KubeLink)
+			new := services.Strings()
+			if strings.Join(klink.Status.Services, ",") == strings.Join(new, ",") {
+				return false, nil
+			}
+			klink.Status.Services = new
+			return true, nil
+		})
+	if err != nil {
+		this.Controller().Errorf("cannot update services for link %s: %s", link.Name, err)
+	}
+}
+
+// UpdateClusterAddress adapts the cluster address of a link after
+// the peer announced an address rotation.
+func (this *reconciler) UpdateClusterAddress(old, new net.IP) {
+	link := this.Links().GetLinkForClusterAddress(old)
+	if link == nil {
+		this.Controller().Infof("local link not found for cluster address %s", old)
+		return
+	}
+	addr := tcp.CIDRIP(link.ClusterAddress, new).String()
+	this.Controller().Infof("update cluster address for link %s: %s", link.Name, addr)
+	obj, mod, err := this.linkResource.ModifyByName(resources.NewObjectName(link.Name),
+		func(odata resources.ObjectData) (bool, error) {
+			klink := odata.(*v1alpha1.KubeLink)
+			if klink.Spec.ClusterAddress == addr {
+				return false, nil
+			}
+			klink.Spec.ClusterAddress = addr
+			return true, nil
+		})
+	if err != nil {
+		this.Controller().Errorf("cannot update cluster address for link %s: %s", link.Name, err)
+		return
+	}
+	if mod {
+		obj.Eventf(core.EventTypeNormal, "ClusterAddress", "cluster address changed by peer from %s to %s", old, new)
+	}
+}
+
 func (this *reconciler) getServiceAccountToken() (*kubelink.LinkAccessInfo, error) {
 	if this.config.ServiceAccount == nil {
 		return nil, fmt.Errorf("no service accound specified")
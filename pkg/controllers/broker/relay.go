@@ -0,0 +1,199 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// SetRelay enables relaying packets not destined for the local cluster
+// to another link providing an appropriate egress.
+func (this *Mux) SetRelay(enabled bool) {
+	this.relay = enabled
+}
+
+// SetRelayTimeExceeded enables sending an ICMP time exceeded message
+// to the source of a relayed packet dropped because of an expired ttl
+// or hop limit.
+func (this *Mux) SetRelayTimeExceeded(enabled bool) {
+	this.relayTimeExceeded = enabled
+}
+
+// relayPacket forwards a packet received on a tunnel connection to the
+// link responsible for its destination. To prevent loops packets are never
+// sent back to the link they are received from and the ttl is decremented.
+// Relaying never dials a connection, packets for links without an
+// established connection are dropped.
+// It returns false if the packet cannot be relayed.
+func (this *TunnelConnection) relayPacket(header *ipv4.Header, packet []byte) bool {
+	if !this.mux.relay {
+		return false
+	}
+	if header.TTL <= 1 {
+		this.mux.warnPacket(this, "  dropping relayed packet to %s because of expired ttl", header.Dst)
+		if this.mux.relayTimeExceeded {
+			this.sendTimeExceeded(header, packet)
+		}
+		return false
+	}
+	return this.relay(header.Src, header.Dst, packet, decrementTTL)
+}
+
+// relayPacket6 is the IPv6 variant of relayPacket, it decrements
+// the hop limit.
+func (this *TunnelConnection) relayPacket6(header *ipv6.Header, packet []byte) bool {
+	if !this.mux.relay {
+		return false
+	}
+	if header.HopLimit <= 1 {
+		this.mux.warnPacket(this, "  dropping relayed packet to %s because of exceeded hop limit", header.Dst)
+		if this.mux.relayTimeExceeded {
+			this.sendTimeExceeded6(header, packet)
+		}
+		return false
+	}
+	return this.relay(header.Src, header.Dst, packet, decrementHopLimit)
+}
+
+func (this *TunnelConnection) relay(src, dst net.IP, packet []byte, decrement func([]byte)) bool {
+	t, l := this.mux.QueryConnectionForIP(dst)
+	if l == nil && t == nil {
+		return false
+	}
+	if cidr := this.ClusterCIDR(); t == this || (l != nil && cidr != nil && l.ClusterAddress.IP.Equal(cidr.IP)) {
+		this.mux.warnPacket(this, "  dropping packet to %s: relay would loop back", dst)
+		return false
+	}
+	if t == nil {
+		this.mux.warnPacket(this, "  dropping packet to %s: no relay connection", dst)
+		return false
+	}
+	decrement(packet)
+	this.mux.logPacket(this, "  relaying packet %s->%s to %s", src, dst, t)
+	if err := t.WritePacket(PACKET_TYPE_DATA, packet); err != nil {
+		this.mux.warnPacket(this, "  relaying packet to %s failed: %s", t, err)
+		return true
+	}
+	t.linkStats().CountOut(len(packet))
+	return true
+}
+
+// allowRelay checks a packet to be relayed against the ingress of the
+// link it is received from. Denied packets are dropped and recorded
+// unless the ingress of the link is audited.
+func (this *TunnelConnection) allowRelay(header *ipv4.Header, packet []byte) bool {
+	l := this.link()
+	if l == nil {
+		this.recordDrop(kubelink.DROP_UNKNOWN_SOURCE, header)
+		return false
+	}
+	if granted, _ := l.AllowIngress(header.Dst, byte(header.Protocol), destinationPort(packet, header)); !granted {
+		if !this.mux.auditIngress(l) {
+			this.recordDrop(kubelink.DROP_INGRESS_DENIED, header)
+			return false
+		}
+		l.Stats.CountAudit()
+		this.mux.logPacket(this, "ingress audit: relaying %s->%s not granted", header.Src, header.Dst)
+	}
+	return true
+}
+
+// allowRelay6 is the IPv6 variant of allowRelay.
+func (this *TunnelConnection) allowRelay6(header *ipv6.Header, packet []byte) bool {
+	l := this.link()
+	if l == nil {
+		this.recordDrop6(kubelink.DROP_UNKNOWN_SOURCE, header)
+		return false
+	}
+	if granted, _ := l.AllowIngress(header.Dst, byte(header.NextHeader), destinationPort6(packet, header)); !granted {
+		if !this.mux.auditIngress(l) {
+			this.recordDrop6(kubelink.DROP_INGRESS_DENIED, header)
+			return false
+		}
+		l.Stats.CountAudit()
+		this.mux.logPacket(this, "ingress audit: relaying %s->%s not granted", header.Src, header.Dst)
+	}
+	return true
+}
+
+// decrementTTL decrements the ttl of an ipv4 packet and incrementally
+// updates the header checksum (RFC 1624).
+func decrementTTL(packet []byte) {
+	packet[8]--
+	sum := uint32(packet[10])<<8 | uint32(packet[11])
+	sum += 0x0100
+	sum = (sum & 0xffff) + (sum >> 16)
+	packet[10] = byte(sum >> 8)
+	packet[11] = byte(sum)
+}
+
+// decrementHopLimit decrements the hop limit of an ipv6 packet, which
+// has no header checksum.
+func decrementHopLimit(packet []byte) {
+	packet[7]--
+}
+
+// sendTimeExceeded returns an ICMP time exceeded message for a packet
+// to its source over the connection the packet has been received from.
+func (this *TunnelConnection) sendTimeExceeded(header *ipv4.Header, packet []byte) {
+	if !icmpErrorAllowed(header, packet) || !this.mux.allowICMP() {
+		return
+	}
+	if header.TotalLen > 0 && header.TotalLen < len(packet) {
+		packet = packet[:header.TotalLen]
+	}
+	msg := TimeExceeded(this.mux.GetClusterAddress().IP, header.Src, packet)
+	if msg == nil {
+		return
+	}
+	if err := this.WritePacket(PACKET_TYPE_DATA, msg); err != nil {
+		this.mux.warnPacket(this, "  cannot send time exceeded to %s: %s", header.Src, err)
+	}
+}
+
+// sendTimeExceeded6 is the IPv6 variant of sendTimeExceeded.
+func (this *TunnelConnection) sendTimeExceeded6(header *ipv6.Header, packet []byte) {
+	if !icmp6ErrorAllowed(header, packet) || !this.mux.allowICMP() {
+		return
+	}
+	msg := TimeExceeded6(this.mux.GetClusterAddress().IP, header.Src, packet)
+	if msg == nil {
+		return
+	}
+	if err := this.WritePacket(PACKET_TYPE_DATA, msg); err != nil {
+		this.mux.warnPacket(this, "  cannot send time exceeded to %s: %s", header.Src, err)
+	}
+}
+
+// TimeExceeded creates an ipv4 packet with an ICMP time exceeded
+// message (ttl exceeded in transit) for the given packet.
+func TimeExceeded(src, dst net.IP, packet []byte) []byte {
+	return icmpError(src, dst, packet, ICMP_TIME_EXCEEDED, 0, 0)
+}
+
+// TimeExceeded6 creates an ipv6 packet with an ICMPv6 time exceeded
+// message (hop limit exceeded in transit) for the given packet.
+func TimeExceeded6(src, dst net.IP, packet []byte) []byte {
+	return icmp6Error(src, dst, packet, ICMPV6_TIME_EXCEEDED, 0, 0)
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/controllers"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// DEFAULT_ROTATION_WINDOW is the time the old cluster address is kept
+// after a rotation if not specified otherwise.
+const DEFAULT_ROTATION_WINDOW = 2 * time.Minute
+
+// AddressManager maintains the local cluster addresses of the tun device.
+type AddressManager interface {
+	AddAddress(logger logger.LogContext, addr *net.IPNet) error
+	RemoveAddress(logger logger.LogContext, addr *net.IPNet) error
+}
+
+// AddressHandler is notified about a cluster address rotation
+// announced by a peer.
+type AddressHandler interface {
+	UpdateClusterAddress(old, new net.IP)
+}
+
+func (this *Mux) SetAddressHandler(handler AddressHandler) {
+	this.addressHandler = handler
+}
+
+// SetTrustPeerAddress configures whether a cluster address mismatch
+// of an authenticated peer updates the expected address of the link
+// instead of rejecting the connection.
+func (this *Mux) SetTrustPeerAddress(b bool) {
+	this.trustPeerAddress = b
+}
+
+// GetClusterAddress returns the actual local cluster address.
+func (this *Mux) GetClusterAddress() *net.IPNet {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.clusterAddr
+}
+
+// IsLocalAddress checks whether the given ip is the actual local cluster
+// address or the previous one still valid during a rotation.
+func (this *Mux) IsLocalAddress(ip net.IP) bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.clusterAddr.IP.Equal(ip) || (this.previousAddr != nil && this.previousAddr.IP.Equal(ip))
+}
+
+// LocalAddresses returns the actual and, during a rotation, the
+// previous local cluster address.
+func (this *Mux) LocalAddresses() []net.IP {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	result := []net.IP{this.clusterAddr.IP}
+	if this.previousAddr != nil {
+		result = append(result, this.previousAddr.IP)
+	}
+	return result
+}
+
+// RotateClusterAddress switches the local cluster address to a new
+// address of the same mesh. The new address is announced to all
+// connected peers by a hello update. The old address is kept
+// for the given transition window before it is finally removed.
+func (this *Mux) RotateClusterAddress(logger logger.LogContext, addr *net.IPNet, window time.Duration) error {
+	this.lock.Lock()
+	old := this.clusterAddr
+	if old.IP.Equal(addr.IP) {
+		this.lock.Unlock()
+		return nil
+	}
+	if !tcp.EqualCIDR(tcp.CIDRNet(old), tcp.CIDRNet(addr)) {
+		this.lock.Unlock()
+		return fmt.Errorf("new cluster address %s not in mesh %s", addr, tcp.CIDRNet(old))
+	}
+	if this.previousAddr != nil {
+		this.lock.Unlock()
+		return fmt.Errorf("rotation from %s still pending", this.previousAddr.IP)
+	}
+	if this.rotating {
+		this.lock.Unlock()
+		return fmt.Errorf("rotation from %s in progress", old.IP)
+	}
+	if l := this.links.GetLinkForClusterAddress(addr.IP); l != nil {
+		this.lock.Unlock()
+		return fmt.Errorf("cluster address %s already used by link %s", addr.IP, l.Name)
+	}
+	// the device is configured without holding the lock to keep
+	// the packet flow running.
+	this.rotating = true
+	addresses := this.addressManager()
+	this.lock.Unlock()
+
+	err := addresses.AddAddress(logger, addr)
+
+	this.lock.Lock()
+	this.rotating = false
+	if err != nil {
+		this.lock.Unlock()
+		return err
+	}
+	logger.Infof("rotating cluster address %s -> %s (transition window %s)", old.IP, addr.IP, window)
+	this.previousAddr = old
+	this.clusterAddr = addr
+	var conns []*TunnelConnection
+	for _, list := range this.byClusterIP {
+		conns = append(conns, list...)
+	}
+	this.lock.Unlock()
+
+	for _, t := range conns {
+		if err := t.writeHello(t.createHello(t.link())); err != nil {
+			logger.Errorf("cannot announce new cluster address to %s: %s", t, err)
+		}
+	}
+
+	time.AfterFunc(window, func() {
+		this.lock.Lock()
+		if this.previousAddr == old {
+			this.previousAddr = nil
+		}
+		this.lock.Unlock()
+		if err := addresses.RemoveAddress(logger, old); err != nil {
+			logger.Errorf("%s", err)
+		}
+		logger.Infof("rotation of cluster address %s -> %s finished", old.IP, addr.IP)
+	})
+	return nil
+}
+
+// addressManager returns the manager used to configure the local
+// cluster addresses. The tun device is used if not overridden.
+func (this *Mux) addressManager() AddressManager {
+	if this.addresses != nil {
+		return this.addresses
+	}
+	return this.GetTun()
+}
+
+// UpdateRemoteAddress rekeys a tunnel connection after its peer
+// announced a new cluster address.
+func (this *Mux) UpdateRemoteAddress(t *TunnelConnection, ip net.IP) {
+	this.lock.Lock()
+	old := t.ClusterCIDR()
+	this.removeTunnelEntry(t)
+	t.lock.Lock()
+	t.previous = old
+	t.clusterCIDR = tcp.CIDRIP(old, ip)
+	t.lock.Unlock()
+	this.byClusterIP[ip.String()] = append(this.byClusterIP[ip.String()], t)
+	this.lock.Unlock()
+	t.updateStats()
+
+	this.Infof("peer %s rotated cluster address to %s", old.IP, ip)
+	if this.addressHandler != nil {
+		this.addressHandler.UpdateClusterAddress(old.IP, ip)
+	}
+}
+
+// checkPeerAddress checks whether a peer may change its cluster address
+// from old to ip. This is only accepted for peers authenticated by a
+// certificate if peer addresses are trusted.
+func (this *Mux) checkPeerAddress(authenticated bool, old, ip net.IP) error {
+	if !this.trustPeerAddress || !authenticated {
+		return fmt.Errorf("cluster address mismatch: got %s but expected %s", ip, old)
+	}
+	if l := this.links.GetLinkForClusterAddress(ip); l != nil && !l.ClusterAddress.IP.Equal(old) {
+		return fmt.Errorf("announced cluster address %s already used by link %s", ip, l.Name)
+	}
+	if this.IsLocalAddress(ip) {
+		return fmt.Errorf("announced cluster address %s is a local address", ip)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// handleClusterAddress reports the actual local cluster address. A POST
+// request with the query parameter address starts a rotation to the
+// given address keeping the old one for the optional duration window.
+// Reading requests must be authorized by the access or the admin token,
+// a rotation requires the admin token.
+func (this *reconciler) handleClusterAddress(w http.ResponseWriter, r *http.Request) {
+	if !controllers.BearerAuthorized(r, this.config.AdminToken) &&
+		(r.Method == http.MethodPost || !controllers.BearerAuthorized(r, this.config.AccessToken)) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodPost {
+		query := r.URL.Query()
+		ip := net.ParseIP(query.Get("address"))
+		if ip == nil {
+			http.Error(w, fmt.Sprintf("invalid address %q", query.Get("address")), http.StatusBadRequest)
+			return
+		}
+		window := DEFAULT_ROTATION_WINDOW
+		if v := query.Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				http.Error(w, fmt.Sprintf("invalid window %q", v), http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+		err := this.mux.RotateClusterAddress(this.Controller(), tcp.CIDRIP(this.mux.GetClusterAddress(), ip), window)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]string{"clusterAddress": this.mux.GetClusterAddress().String()})
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"golang.org/x/net/ipv4"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func testMux(t *testing.T, addr string, links ...*v1alpha1.KubeLink) *Mux {
+	ip, cidr, err := net.ParseCIDR(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cidr.IP = ip
+	m := &Mux{
+		clusterAddr: cidr,
+		links:       kubelink.NewLinks(nil),
+		byClusterIP: map[string][]*TunnelConnection{},
+		errors:      map[string]error{},
+		buffers:     NewBufferPool(true),
+		icmpLimit:   NewLogSampler(ICMP_RATE),
+		packetWarn:  NewLogSampler(PACKET_WARN_RATE),
+	}
+	for _, kl := range links {
+		if _, err := m.links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatalf("cannot add link %s: %s", kl.Name, err)
+		}
+	}
+	return m
+}
+
+func testLink(name, addr, cidr string) *v1alpha1.KubeLink {
+	kl := &v1alpha1.KubeLink{}
+	kl.Name = name
+	kl.Spec.ClusterAddress = addr
+	kl.Spec.CIDR = cidr
+	kl.Spec.Endpoint = name + ".example.com:80"
+	kl.Status.Gateway = "10.0.0.1"
+	return kl
+}
+
+func TestCheckPeerAddress(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+		testLink("b", "192.168.0.11/24", "100.64.2.0/24"),
+	)
+	old := net.ParseIP("192.168.0.10")
+
+	if err := m.checkPeerAddress(true, old, net.ParseIP("192.168.0.20")); err == nil {
+		t.Errorf("rotation accepted without trusting peer addresses")
+	}
+	m.trustPeerAddress = true
+	if err := m.checkPeerAddress(false, old, net.ParseIP("192.168.0.20")); err == nil {
+		t.Errorf("rotation accepted for unauthenticated peer")
+	}
+	if err := m.checkPeerAddress(true, old, net.ParseIP("192.168.0.11")); err == nil {
+		t.Errorf("rotation accepted to address of another link")
+	}
+	if err := m.checkPeerAddress(true, old, net.ParseIP("192.168.0.1")); err == nil {
+		t.Errorf("rotation accepted to local address")
+	}
+	if err := m.checkPeerAddress(true, old, net.ParseIP("192.168.0.20")); err != nil {
+		t.Errorf("rotation of authenticated peer rejected: %s", err)
+	}
+}
+
+type testAddresses struct {
+	lock    sync.Mutex
+	entered chan struct{}
+	block   chan struct{}
+	added   []string
+	removed []string
+}
+
+func (this *testAddresses) AddAddress(logger logger.LogContext, addr *net.IPNet) error {
+	if this.block != nil {
+		this.entered <- struct{}{}
+		<-this.block
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.added = append(this.added, addr.IP.String())
+	return nil
+}
+
+func (this *testAddresses) RemoveAddress(logger logger.LogContext, addr *net.IPNet) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.removed = append(this.removed, addr.IP.String())
+	return nil
+}
+
+func (this *testAddresses) Removed() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]string{}, this.removed...)
+}
+
+func TestRotateClusterAddressTraffic(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24")
+	addresses := &testAddresses{entered: make(chan struct{}), block: make(chan struct{})}
+	m.addresses = addresses
+
+	var lock sync.Mutex
+	var intercepted []string
+	m.Intercept(kubelink.PROTO_TCP, 9000, PacketInterceptorFunc(func(t *TunnelConnection, header *ipv4.Header, packet []byte) {
+		lock.Lock()
+		defer lock.Unlock()
+		intercepted = append(intercepted, header.Dst.String())
+	}))
+	deliver := func(dst string) bool {
+		packet, _ := testPacketTo(t, dst, 0, 9000)
+		header, err := ipv4.ParseHeader(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.interceptPacket(nil, header, packet)
+	}
+
+	_, addr, _ := net.ParseCIDR("192.168.0.2/24")
+	addr.IP = net.ParseIP("192.168.0.2")
+	window := 200 * time.Millisecond
+	done := make(chan error)
+	go func() { done <- m.RotateClusterAddress(logger.New(), addr, window) }()
+	<-addresses.entered
+
+	// packets are processed while the device is configured
+	processed := make(chan bool)
+	go func() { processed <- deliver("192.168.0.1") }()
+	select {
+	case ok := <-processed:
+		if !ok {
+			t.Errorf("packet for actual address not delivered during device setup")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("packet processing blocked by rotation")
+	}
+	if err := m.RotateClusterAddress(logger.New(), addr, window); err == nil {
+		t.Errorf("concurrent rotation accepted")
+	}
+	close(addresses.block)
+	if err := <-done; err != nil {
+		t.Fatalf("rotation failed: %s", err)
+	}
+
+	if !m.GetClusterAddress().IP.Equal(addr.IP) {
+		t.Errorf("cluster address not rotated: %s", m.GetClusterAddress())
+	}
+	if !deliver("192.168.0.1") || !deliver("192.168.0.2") {
+		t.Errorf("packets not delivered for both addresses during transition window")
+	}
+	if len(addresses.Removed()) != 0 {
+		t.Errorf("old address removed during transition window")
+	}
+
+	time.Sleep(2 * window)
+	if deliver("192.168.0.1") {
+		t.Errorf("packet for old address delivered after transition window")
+	}
+	if !deliver("192.168.0.2") {
+		t.Errorf("packet for new address not delivered after transition window")
+	}
+	if r := addresses.Removed(); len(r) != 1 || r[0] != "192.168.0.1" {
+		t.Errorf("old address not removed: %v", r)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(intercepted) != 4 {
+		t.Errorf("unexpected intercepted packets: %v", intercepted)
+	}
+}
+
+func TestClusterAddressAuthorization(t *testing.T) {
+	r := &reconciler{
+		config: &Config{AccessToken: "access", AdminToken: "admin"},
+		mux:    testMux(t, "192.168.0.1/24"),
+	}
+	request := func(method, token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/clusteraddress?address=192.168.0.2", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.handleClusterAddress(w, req)
+		return w.Code
+	}
+
+	if code := request(http.MethodGet, ""); code != http.StatusUnauthorized {
+		t.Errorf("get without token: got status %d", code)
+	}
+	if code := request(http.MethodGet, "access"); code != http.StatusOK {
+		t.Errorf("get with access token: got status %d", code)
+	}
+	if code := request(http.MethodPost, "access"); code != http.StatusUnauthorized {
+		t.Errorf("rotation with access token: got status %d", code)
+	}
+	if !r.mux.GetClusterAddress().IP.Equal(net.ParseIP("192.168.0.1")) {
+		t.Errorf("cluster address rotated without admin token")
+	}
+}
+
+type testAddressHandler chan [2]string
+
+func (this testAddressHandler) UpdateClusterAddress(old, new net.IP) {
+	this <- [2]string{old.String(), new.String()}
+}
+
+func TestPeerAddressChange(t *testing.T) {
+	certs := testCertInfo(t, "peer.example.com")
+	server := testLink("b", "192.168.0.1/24", "100.64.0.0/24")
+	server.Spec.Endpoint = "peer.example.com:80"
+	// the peer renumbered from 192.168.0.10 to 192.168.0.20
+	peer := testMux(t, "192.168.0.20/24", server)
+	peer.LogContext = logger.New()
+	peer.certInfo = certs
+	l := testTLSPeer(t, peer)
+	defer l.Close()
+
+	for _, trust := range []bool{false, true} {
+		kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+		kl.Spec.Endpoint = l.Addr().String()
+		kl.Spec.ServerName = "peer.example.com"
+		m := testMux(t, "192.168.0.1/24", kl)
+		m.LogContext = logger.New()
+		m.certInfo = certs
+		m.helloTimeout = 2 * time.Second
+		m.SetTrustPeerAddress(trust)
+		handler := make(testAddressHandler, 1)
+		m.SetAddressHandler(handler)
+
+		conn, err := m.AssureTunnel(m, m.links.GetLink("a"))
+		if !trust {
+			if err == nil {
+				conn.Close()
+				t.Errorf("strict mode: changed peer address accepted")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("trusted mode: changed peer address rejected: %s", err)
+		}
+		if c := conn.ClusterCIDR(); !c.IP.Equal(net.ParseIP("192.168.0.20")) {
+			t.Errorf("trusted mode: connection uses address %s", c.IP)
+		}
+		select {
+		case update := <-handler:
+			if update != [2]string{"192.168.0.10", "192.168.0.20"} {
+				t.Errorf("trusted mode: unexpected address update %v", update)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("trusted mode: link address not updated")
+		}
+		conn.Close()
+	}
+}
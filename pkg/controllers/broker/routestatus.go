@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/resources"
+
+	api "github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// MAX_STATUS_ROUTES is the maximum number of routes listed in the
+// status of a link, further routes are summarized.
+const MAX_STATUS_ROUTES = 10
+
+// effectiveRoutes returns the routes maintained for a link.
+func (this *reconciler) effectiveRoutes(name string) kubelink.Routes {
+	var routes kubelink.Routes
+	err := this.InNetworkNamespace(func() {
+		if link := this.tunLink(); link != nil {
+			routes = this.Links().GetRoutesForLink(this.NodeInterface(), link, name)
+		}
+	})
+	if err != nil {
+		this.Controller().Errorf("%s", err)
+	}
+	if this.config.UnreachableOnFailure {
+		routes = this.mux.UnreachableRoutes(routes)
+	}
+	return routes
+}
+
+// updateRouteStatus reflects the routes maintained for a link in
+// its status. Routes refused because they would shadow a protected
+// network are listed separately.
+func (this *reconciler) updateRouteStatus(logger logger.LogContext, name string) {
+	if this.config.DisableBridge {
+		return
+	}
+	routes, refused := routeStatus(this.effectiveRoutes(name), this.ProtectedCIDRs())
+	_, _, err := this.linkResource.ModifyStatusByName(resources.NewObjectName(name),
+		func(odata resources.ObjectData) (bool, error) {
+			return setRouteStatus(odata.(*api.KubeLink), routes, refused), nil
+		})
+	if err != nil {
+		logger.Errorf("cannot update routes for link %s: %s", name, err)
+	}
+}
+
+// routeStatus describes the routes of a link and the routes refused
+// because they would shadow a protected network.
+func routeStatus(routes kubelink.Routes, protected tcp.CIDRList) ([]string, []string) {
+	allowed, refused, _ := routes.Protect(protected)
+	return allowed.Describe(MAX_STATUS_ROUTES), refused.Describe(MAX_STATUS_ROUTES)
+}
+
+// setRouteStatus sets the route status of a link and reports whether
+// it has been changed.
+func setRouteStatus(klink *api.KubeLink, routes, refused []string) bool {
+	if strings.Join(klink.Status.Routes, ",") == strings.Join(routes, ",") &&
+		strings.Join(klink.Status.RefusedRoutes, ",") == strings.Join(refused, ",") {
+		return false
+	}
+	klink.Status.Routes = routes
+	klink.Status.RefusedRoutes = refused
+	return true
+}
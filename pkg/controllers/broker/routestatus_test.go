@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/vishvananda/netlink"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+func TestRouteStatus(t *testing.T) {
+	ifce := &kubelink.NodeInterface{Name: "eth0", Index: 2, IP: net.ParseIP("10.0.0.1")}
+	tun := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "kubelink", Index: 7}}
+	_, protected, _ := net.ParseCIDR("10.250.0.10/32")
+
+	a := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	a.Spec.Egress = []string{"10.250.0.0/16"}
+	b := testLink("b", "192.168.0.11/24", "100.64.2.0/24")
+	b.Status.Gateway = "10.0.0.2"
+	c := testLink("c", "192.168.0.12/24", "100.64.3.0/24")
+	for i := 0; i < MAX_STATUS_ROUTES+2; i++ {
+		c.Spec.Egress = append(c.Spec.Egress, fmt.Sprintf("100.65.%d.0/24", i))
+	}
+
+	links := kubelink.NewLinks(nil)
+	for _, kl := range []*v1alpha1.KubeLink{a, b, c} {
+		if _, err := links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]struct {
+		routes  []string
+		refused []string
+	}{
+		"a": {
+			[]string{"100.64.1.0/24 table main priority 0"},
+			[]string{"10.250.0.0/16 table main priority 0"},
+		},
+		// routes are only maintained for links with a local gateway
+		"b": {nil, nil},
+		"c": {
+			testRouteDescriptions("100.64.3.0/24", MAX_STATUS_ROUTES-1, "... and 3 more routes"),
+			nil,
+		},
+	}
+	for name, e := range cases {
+		routes, refused := routeStatus(links.GetRoutesForLink(ifce, tun, name), tcp.CIDRList{protected})
+		if !reflect.DeepEqual(routes, e.routes) {
+			t.Errorf("%s: got routes %v, expected %v", name, routes, e.routes)
+		}
+		if !reflect.DeepEqual(refused, e.refused) {
+			t.Errorf("%s: got refused routes %v, expected %v", name, refused, e.refused)
+		}
+
+		kl := &v1alpha1.KubeLink{}
+		if setRouteStatus(kl, routes, refused) != (routes != nil || refused != nil) {
+			t.Errorf("%s: unexpected status modification", name)
+		}
+		if !reflect.DeepEqual(kl.Status.Routes, routes) || !reflect.DeepEqual(kl.Status.RefusedRoutes, refused) {
+			t.Errorf("%s: status not updated: %+v", name, kl.Status)
+		}
+		if setRouteStatus(kl, routes, refused) {
+			t.Errorf("%s: unchanged status modified", name)
+		}
+	}
+}
+
+// testRouteDescriptions describes the route for the cidr of link c
+// followed by n of its egress routes and the summary.
+func testRouteDescriptions(cidr string, n int, summary string) []string {
+	result := []string{cidr + " table main priority 0"}
+	for i := 0; i < n; i++ {
+		result = append(result, fmt.Sprintf("100.65.%d.0/24 table main priority 0", i))
+	}
+	return append(result, summary)
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS1.0",
+	tls.VersionTLS11: "TLS1.1",
+	tls.VersionTLS12: "TLS1.2",
+	tls.VersionTLS13: "TLS1.3",
+}
+
+var cipherSuites = map[uint16]string{
+	tls.TLS_RSA_WITH_RC4_128_SHA:                "TLS_RSA_WITH_RC4_128_SHA",
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:           "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:            "TLS_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:            "TLS_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:         "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:        "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:          "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:     "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:   "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305:    "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305",
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305:  "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
+	tls.TLS_AES_128_GCM_SHA256:                  "TLS_AES_128_GCM_SHA256",
+	tls.TLS_AES_256_GCM_SHA384:                  "TLS_AES_256_GCM_SHA384",
+	tls.TLS_CHACHA20_POLY1305_SHA256:            "TLS_CHACHA20_POLY1305_SHA256",
+}
+
+func tlsVersionName(v uint16) string {
+	if n, ok := tlsVersions[v]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func cipherSuiteName(c uint16) string {
+	if n, ok := cipherSuites[c]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%04x", c)
+}
+
+// ConnectionSecurity describes the negotiated security parameters
+// of a tunnel connection.
+type ConnectionSecurity struct {
+	TLS         bool   `json:"tls"`
+	Version     string `json:"version,omitempty"`
+	CipherSuite string `json:"cipherSuite,omitempty"`
+	DidResume   bool   `json:"didResume,omitempty"`
+}
+
+// NewConnectionSecurity captures the security parameters from the
+// state of a completed tls handshake. A nil state describes a
+// plaintext connection.
+func NewConnectionSecurity(state *tls.ConnectionState) ConnectionSecurity {
+	if state == nil {
+		return ConnectionSecurity{}
+	}
+	return ConnectionSecurity{
+		TLS:         true,
+		Version:     tlsVersionName(state.Version),
+		CipherSuite: cipherSuiteName(state.CipherSuite),
+		DidResume:   state.DidResume,
+	}
+}
+
+// ConnectionInfo describes an active tunnel connection exposed by
+// the debug endpoint.
+type ConnectionInfo struct {
+	Link           string               `json:"link,omitempty"`
+	ClusterAddress string               `json:"clusterAddress"`
+	Remote         string               `json:"remote"`
+	Outbound       bool                 `json:"outbound"`
+	Security       ConnectionSecurity   `json:"security"`
+	Extensions     NegotiatedExtensions `json:"extensions"`
+	MTU            int                  `json:"mtu,omitempty"`
+	Liveness       string               `json:"liveness,omitempty"`
+}
+
+// GetConnections returns the info of all active tunnel connections.
+func (this *Mux) GetConnections() []ConnectionInfo {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	result := []ConnectionInfo{}
+	for ips, list := range this.byClusterIP {
+		name := ""
+		if l := this.links.GetLinkForClusterAddress(list[0].ClusterCIDR().IP); l != nil {
+			name = l.Name
+		}
+		for _, t := range list {
+			t.lock.RLock()
+			ext := t.extensions
+			liveness := t.livenessMonitor.State(time.Now())
+			t.lock.RUnlock()
+			result = append(result, ConnectionInfo{
+				Link:           name,
+				ClusterAddress: ips,
+				Remote:         t.remoteAddress,
+				Outbound:       t.outbound,
+				Security:       t.security,
+				Extensions:     ext,
+				MTU:            t.mtu,
+				Liveness:       liveness,
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ClusterAddress < result[j].ClusterAddress })
+	return result
+}
+
+func (this *reconciler) handleConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(this.mux.GetConnections())
+}
+
+func (this *reconciler) collectSecurityMetrics(w *metrics.Writer) {
+	conns := this.mux.GetConnections()
+	if len(conns) == 0 {
+		return
+	}
+	w.Describe("kubelink_link_tls_info", metrics.GAUGE, "Negotiated tls parameters of the tunnel connections of a link")
+	for _, c := range conns {
+		link := c.Link
+		if link == "" {
+			link = c.ClusterAddress
+		}
+		direction := "inbound"
+		if c.Outbound {
+			direction = "outbound"
+		}
+		labels := metrics.Labels{
+			"link":      link,
+			"direction": direction,
+			"tls":       fmt.Sprintf("%t", c.Security.TLS),
+			"version":   c.Security.Version,
+			"cipher":    c.Security.CipherSuite,
+			"resumed":   fmt.Sprintf("%t", c.Security.DidResume),
+		}
+		w.Value("kubelink_link_tls_info", labels, 1)
+	}
+}
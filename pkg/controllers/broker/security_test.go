@@ -0,0 +1,100 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+func TestConnectionSecurity(t *testing.T) {
+	certs := testCertInfo(t, "peer.example.com")
+	server := testLink("b", "192.168.0.1/24", "100.64.0.0/24")
+	server.Spec.Endpoint = "peer.example.com:80"
+	peer := testMux(t, "192.168.0.10/24", server)
+	peer.LogContext = logger.New()
+	peer.certInfo = certs
+	l := testTLSPeer(t, peer)
+	defer l.Close()
+
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = l.Addr().String()
+	kl.Spec.ServerName = "peer.example.com"
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.LogContext = logger.New()
+	m.certInfo = certs
+	m.helloTimeout = 2 * time.Second
+	r := &reconciler{mux: m}
+
+	c, err := m.AssureTunnel(m, m.links.GetLink("a"))
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer c.Close()
+
+	w := httptest.NewRecorder()
+	r.handleConnections(w, httptest.NewRequest(http.MethodGet, "/connections", nil))
+	var conns []ConnectionInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &conns); err != nil {
+		t.Fatalf("invalid connection list: %s", err)
+	}
+	if len(conns) != 1 || conns[0].Link != "a" || !conns[0].Outbound {
+		t.Fatalf("unexpected connections %+v", conns)
+	}
+	sec := conns[0].Security
+	if !sec.TLS || sec.Version != "TLS1.3" || !strings.HasPrefix(sec.CipherSuite, "TLS_") || sec.DidResume {
+		t.Errorf("unexpected security parameters %+v", sec)
+	}
+
+	mw := metrics.NewWriter()
+	r.collectSecurityMetrics(mw)
+	out := string(mw.Bytes())
+	for _, expected := range []string{`link="a"`, `direction="outbound"`, `tls="true"`, `version="TLS1.3"`, `cipher="` + sec.CipherSuite + `"`, `resumed="false"`} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("missing %s in\n%s", expected, out)
+		}
+	}
+
+	// the accepting side records the parameters, too
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if conns := peer.GetConnections(); len(conns) == 1 {
+			if conns[0].Outbound || conns[0].Security != sec {
+				t.Errorf("inbound connection: unexpected parameters %+v", conns[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("inbound connection not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if s := NewConnectionSecurity(nil); s != (ConnectionSecurity{}) {
+		t.Errorf("plaintext connection: unexpected parameters %+v", s)
+	}
+}
@@ -21,7 +21,9 @@ package broker
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gardener/controller-manager-library/pkg/ctxutil"
@@ -35,6 +37,13 @@ type Server struct {
 
 	name string
 	mux  *Mux
+
+	maxHandshakes    int
+	handshakeTimeout time.Duration
+
+	handoffSocket string
+	drainTimeout  time.Duration
+	drain         sync.WaitGroup
 }
 
 func NewServer(name string, mux *Mux) *Server {
@@ -45,6 +54,53 @@ func NewServer(name string, mux *Mux) *Server {
 	}
 }
 
+// SetHandshakeLimit limits the number of concurrently pending
+// connection handshakes. Excess connections are queued for at most
+// the given timeout before they are rejected.
+func (this *Server) SetHandshakeLimit(max int, timeout time.Duration) *Server {
+	this.maxHandshakes = max
+	this.handshakeTimeout = timeout
+	return this
+}
+
+// SetHandoff enables the listener handoff to a successor process
+// requesting it on the given unix socket. After the handoff the
+// server stops accepting connections and waits at most the drain
+// timeout for the active connections to finish before shutting down.
+// On start the server inherits the listener of a predecessor serving
+// handoffs on the socket.
+func (this *Server) SetHandoff(socket string, drainTimeout time.Duration) *Server {
+	this.handoffSocket = socket
+	this.drainTimeout = drainTimeout
+	return this
+}
+
+// listen creates the listener for the server, inheriting it from
+// a predecessor process if possible.
+func (this *Server) listen(listenAddress string) (net.Listener, error) {
+	ln, err := tcp.InheritListener(this.handoffSocket, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if ln != nil {
+		this.Infof("inherited listener %s for server %q from predecessor", ln.Addr(), this.name)
+		return ln, nil
+	}
+	return net.Listen("tcp", listenAddress)
+}
+
+// handoff drains the server after its listener has been passed to
+// a successor process and shuts down the process.
+func (this *Server) handoff(server *tcp.Server) {
+	this.drain.Add(1)
+	defer this.drain.Done()
+	this.Infof("listener of server %q handed off: draining connections for %s", this.name, this.drainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), this.drainTimeout)
+	defer cancel()
+	server.Drain(ctx)
+	this.Infof("server %q drained", this.name)
+}
+
 // Start starts a  server.
 func (this *Server) Start(certInfo *CertInfo, bindAddress string, port int) {
 	listenAddress := fmt.Sprintf("%s:%d", bindAddress, port)
@@ -54,16 +110,43 @@ func (this *Server) Start(certInfo *CertInfo, bindAddress string, port int) {
 		this.Infof("starting %s as unsecured server (serving on %s)", this.name, listenAddress)
 	}
 	server := &tcp.Server{
-		Addr:      listenAddress,
-		Handler:   this.mux,
-		TLSConfig: certInfo.ServerConfig(),
+		Addr:           listenAddress,
+		Handler:        this.mux,
+		TLSConfig:      certInfo.ServerConfig(),
+		AllowPlaintext: true,
+
+		MaxHandshakes:         this.maxHandshakes,
+		HandshakeQueueTimeout: this.handshakeTimeout,
+		HandshakeTimeout:      this.mux.helloTimeout,
+	}
+
+	if this.handoffSocket != "" {
+		ln, err := this.listen(listenAddress)
+		if err != nil {
+			logger.Errorf("cannot start server %q: %s", this.name, err)
+			ctxutil.Cancel(this.mux.ctx)
+			return
+		}
+		server.Listener = ln
+		if tl, ok := ln.(*net.TCPListener); ok {
+			err = tcp.ServeHandoff(this.mux.ctx, this.handoffSocket, tl, func() { this.handoff(server) })
+		} else {
+			err = fmt.Errorf("no tcp listener")
+		}
+		if err != nil {
+			this.Errorf("cannot serve listener handoff on %s: %s", this.handoffSocket, err)
+		}
 	}
 
 	ctxutil.WaitGroupAdd(this.mux.ctx)
 	go func() {
 		<-this.mux.ctx.Done()
 		this.Infof("shutting down server %q with timeout", this.name)
-		ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := this.mux.Shutdown(ctx); err != nil {
+			this.Warnf("tunnel connections not drained: %s", err)
+		}
 		server.Shutdown(ctx)
 	}()
 
@@ -78,6 +161,7 @@ func (this *Server) Start(certInfo *CertInfo, bindAddress string, port int) {
 		if err != nil && err != http.ErrServerClosed {
 			logger.Errorf("cannot start server %q: %s", this.name, err)
 		}
+		this.drain.Wait()
 		this.Infof("server %q stopped", this.name)
 		ctxutil.Cancel(this.mux.ctx)
 		ctxutil.WaitGroupDone(this.mux.ctx)
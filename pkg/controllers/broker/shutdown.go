@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Shutdown gracefully drains all tunnel connections. New connections
+// are rejected, every peer is informed by a goodbye packet sent after
+// the pending writes of the connection and finally the connections are
+// closed. Connections not drained until the context is done are closed
+// anyway and the context error is returned.
+func (this *Mux) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&this.shutdown, 0, 1) {
+		return nil
+	}
+	this.lock.RLock()
+	var list []*TunnelConnection
+	for _, tunnels := range this.byClusterIP {
+		list = append(list, tunnels...)
+	}
+	this.lock.RUnlock()
+
+	this.Infof("shutting down %d tunnel connection(s)", len(list))
+	wg := sync.WaitGroup{}
+	for _, t := range list {
+		wg.Add(1)
+		go func(t *TunnelConnection) {
+			defer wg.Done()
+			if err := t.goodbye(ctx); err != nil {
+				t.Warnf("cannot send goodbye: %s", err)
+			}
+		}(t)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	for _, t := range list {
+		t.Close()
+	}
+	return err
+}
+
+// IsShuttingDown reports whether the mux has been shut down.
+func (this *Mux) IsShuttingDown() bool {
+	return atomic.LoadInt32(&this.shutdown) != 0
+}
+
+// goodbye sends a goodbye packet after all pending writes of the
+// connection are done and closes the sending side of the connection.
+func (this *TunnelConnection) goodbye(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		this.conn.SetWriteDeadline(deadline)
+	}
+	if err := this.WritePacket(PACKET_TYPE_GOODBYE, nil); err != nil {
+		return err
+	}
+	if c, ok := this.conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// BufferSizes describes the socket and application read buffer sizes
+// of tunnel connections. A size of 0 keeps the default.
+type BufferSizes struct {
+	Socket int
+	Read   int
+}
+
+// ForLink returns the buffer sizes for a link. Sizes configured
+// for the link override the defaults.
+func (this BufferSizes) ForLink(link *kubelink.Link) BufferSizes {
+	if link != nil {
+		if link.SocketBuffer > 0 {
+			this.Socket = link.SocketBuffer
+		}
+		if link.ReadBuffer > 0 {
+			this.Read = link.ReadBuffer
+		}
+	}
+	return this
+}
+
+// SetSocketBuffers sets the send and receive buffer size of
+// the tcp connection underlying the given connection.
+func SetSocketBuffers(conn net.Conn, size int) error {
+	if size <= 0 {
+		return nil
+	}
+	tcpConn, ok := tcp.RawConn(conn).(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("no tcp connection")
+	}
+	if err := tcpConn.SetReadBuffer(size); err != nil {
+		return err
+	}
+	return tcpConn.SetWriteBuffer(size)
+}
+
+// newReader returns the reader used to read packets from a connection.
+func newReader(conn net.Conn, size int) io.Reader {
+	if size <= 0 {
+		return conn
+	}
+	return bufio.NewReaderSize(conn, size)
+}
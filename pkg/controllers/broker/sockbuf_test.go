@@ -0,0 +1,43 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"testing"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func TestBufferSizesForLink(t *testing.T) {
+	defaults := BufferSizes{Socket: 212992, Read: 65536}
+	cases := map[string]struct {
+		link     *kubelink.Link
+		expected BufferSizes
+	}{
+		"none":    {nil, defaults},
+		"default": {&kubelink.Link{}, defaults},
+		"wan":     {&kubelink.Link{SocketBuffer: 12500000}, BufferSizes{Socket: 12500000, Read: 65536}},
+		"read":    {&kubelink.Link{ReadBuffer: 1024 * 1024}, BufferSizes{Socket: 212992, Read: 1024 * 1024}},
+	}
+	for name, c := range cases {
+		if got := defaults.ForLink(c.link); got != c.expected {
+			t.Errorf("%s: got %+v, expected %+v", name, got, c.expected)
+		}
+	}
+}
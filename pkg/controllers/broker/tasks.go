@@ -22,12 +22,15 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller"
 	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller/reconcile"
 	"github.com/gardener/controller-manager-library/pkg/ctxutil"
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"github.com/gardener/controller-manager-library/pkg/utils"
+
+	"github.com/mandelsoft/kubelink/pkg/controllers"
 )
 
 var tasksKey = ctxutil.SimpleKey("tasks")
@@ -98,7 +101,13 @@ func (this *tasks) ScheduleTask(task Task, override bool) {
 
 func (this *tasks) execute(logger logger.LogContext, id string) reconcile.Status {
 	task := this.activate(id)
+	start := time.Now()
 	result := task.Execute(logger)
+	ttype := id
+	if i := strings.Index(id, ":"); i > 0 {
+		ttype = id[:i]
+	}
+	controllers.ObserveReconcile(this.GetName(), "task:"+ttype, start, result)
 	if (!result.IsSucceeded() && !result.IsFailed() && result.Interval != 0) || result.Interval > 0 {
 		this.done(task, true)
 	} else {
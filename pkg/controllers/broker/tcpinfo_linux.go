@@ -0,0 +1,58 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// ReadTCPInfo reads the kernel tcp info of the underlying
+// tcp connection.
+func ReadTCPInfo(conn net.Conn) (*TCPInfo, error) {
+	c, ok := tcp.RawConn(conn).(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("no tcp connection")
+	}
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var info *unix.TCPInfo
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		info, serr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return &TCPInfo{
+		RTT:         time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:      time.Duration(info.Rttvar) * time.Microsecond,
+		Retransmits: uint64(info.Total_retrans),
+	}, nil
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func testResumption(t *testing.T, certs *CertInfo, cfg *tls.Config) []bool {
+	l := testTLSServer(t, certs)
+	defer l.Close()
+	cfg.ServerName = "peer.example.com"
+
+	var resumed []bool
+	for i := 0; i < 2; i++ {
+		c, err := tls.Dial("tcp", l.Addr().String(), cfg)
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		// read until the server closes to receive post handshake tickets
+		ioutil.ReadAll(c)
+		resumed = append(resumed, c.ConnectionState().DidResume)
+		c.Close()
+	}
+	return resumed
+}
+
+func TestSessionResumption(t *testing.T) {
+	certs := testCertInfo(t, "peer.example.com")
+	if err := certs.SetSessionTickets(true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if r := testResumption(t, certs, certs.ClientConfig()); r[0] || !r[1] {
+		t.Errorf("enabled session tickets: got resumption %v", r)
+	}
+
+	if err := certs.SetSessionTickets(false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !certs.ServerConfig().SessionTicketsDisabled {
+		t.Errorf("session tickets not disabled for server config")
+	}
+	if certs.ClientConfig().ClientSessionCache != nil {
+		t.Errorf("session cache used with disabled session tickets")
+	}
+	cfg := certs.ClientConfig()
+	cfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	if r := testResumption(t, certs, cfg); r[0] || r[1] {
+		t.Errorf("disabled session tickets: got resumption %v", r)
+	}
+	if err := certs.RotateTicketKeys(); err != nil || certs.TicketKeys() != nil {
+		t.Errorf("ticket keys provided for disabled session tickets")
+	}
+}
+
+func TestTicketKeyRotation(t *testing.T) {
+	certs := testCertInfo(t, "peer.example.com")
+	if err := certs.SetSessionTickets(true, 0); err != nil {
+		t.Fatal(err)
+	}
+	keys := certs.TicketKeys()
+	if len(keys) != 1 {
+		t.Fatalf("expected one initial key, got %d", len(keys))
+	}
+	if k := certs.TicketKeys(); len(k) != 1 || k[0] != keys[0] {
+		t.Errorf("ticket key rotated without rotation interval")
+	}
+	if err := certs.RotateTicketKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if k := certs.TicketKeys(); len(k) != 2 || k[0] == keys[0] || k[1] != keys[0] {
+		t.Errorf("enforced rotation: previous key not kept behind new key")
+	}
+
+	if err := certs.SetSessionTickets(true, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	keys = certs.TicketKeys()
+	if k := certs.TicketKeys(); len(k) != 1 || k[0] != keys[0] {
+		t.Errorf("ticket key rotated before rotation interval")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if k := certs.TicketKeys(); len(k) != 2 || k[0] == keys[0] || k[1] != keys[0] {
+		t.Errorf("ticket key not rotated after rotation interval")
+	}
+}
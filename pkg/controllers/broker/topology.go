@@ -0,0 +1,111 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// LOCAL_NODE is the node id of the local cluster. It is no valid
+// DNS label, so it cannot clash with the name of a link.
+const LOCAL_NODE = "@local"
+
+// WriteTopology renders the mesh topology as seen by the local broker
+// in Graphviz DOT format. Clusters are rendered as nodes grouped by
+// their mesh, links as edges originating from the local cluster labeled
+// by the given function.
+func WriteTopology(w io.Writer, name string, local *net.IPNet, meshes kubelink.Meshes, edge func(l *kubelink.Link) string) {
+	if name == "" {
+		name = "local"
+	}
+	localMesh := ""
+	if local != nil {
+		localMesh = tcp.CIDRNet(local).String()
+	}
+	fmt.Fprintf(w, "digraph kubelink {\n")
+	found := false
+	for _, n := range meshes.Names() {
+		m := meshes[n]
+		fmt.Fprintf(w, "  subgraph %q {\n", "cluster_"+n)
+		fmt.Fprintf(w, "    label=%q;\n", "mesh "+n)
+		if n == localMesh {
+			found = true
+			fmt.Fprintf(w, "    %q [label=%q, shape=box];\n", LOCAL_NODE, fmt.Sprintf("%s\n%s", name, local.IP))
+		}
+		for _, l := range m.Members {
+			fmt.Fprintf(w, "    %q [label=%q];\n", l.Name, fmt.Sprintf("%s\n%s", l.Name, l.ClusterAddress.IP))
+		}
+		fmt.Fprintf(w, "  }\n")
+	}
+	if !found && local != nil {
+		fmt.Fprintf(w, "  %q [label=%q, shape=box];\n", LOCAL_NODE, fmt.Sprintf("%s\n%s", name, local.IP))
+	}
+	for _, n := range meshes.Names() {
+		for _, l := range meshes[n].Members {
+			fmt.Fprintf(w, "  %q -> %q [label=%q];\n", LOCAL_NODE, l.Name, edge(l))
+		}
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+// TopologyEdge returns the label of the topology edge for a link,
+// which is the connection state and the measured round trip time.
+func (this *Mux) TopologyEdge(l *kubelink.Link) string {
+	state, _ := this.GetConnectionState(l.ClusterAddress.IP)
+	if rtt := this.GetConnectionRTT(l.ClusterAddress.IP); rtt > 0 {
+		return fmt.Sprintf("%s\nrtt %s", state, rtt.Round(10*time.Microsecond))
+	}
+	return state
+}
+
+// GetConnectionRTT returns the round trip time of the active tunnel
+// connection for the given cluster address. The heartbeats of the
+// liveness channel measure the path independently of the tcp stream,
+// so they are preferred to the sampled tcp info. It is 0 if nothing
+// has been measured, yet.
+func (this *Mux) GetConnectionRTT(ip net.IP) time.Duration {
+	this.lock.RLock()
+	t, _ := this.queryClusterConnection(ip)
+	this.lock.RUnlock()
+	if t == nil {
+		return 0
+	}
+	t.lock.RLock()
+	monitor := t.livenessMonitor
+	t.lock.RUnlock()
+	if rtt := monitor.RTT(); rtt > 0 {
+		return rtt
+	}
+	if q := t.Quality(); q != nil {
+		return q.RTT
+	}
+	return 0
+}
+
+func (this *reconciler) handleTopology(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/vnd.graphviz")
+	WriteTopology(w, this.config.ClusterName, this.config.ClusterAddress, this.Links().GetMeshes(), this.mux.TopologyEdge)
+}
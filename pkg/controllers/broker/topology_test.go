@@ -0,0 +1,89 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func TestTopologyLocalNode(t *testing.T) {
+	links := kubelink.NewLinks(nil)
+	if _, err := links.UpdateLink(logger.New(), testLink("local", "192.168.0.10/24", "100.64.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	ip, cidr, _ := net.ParseCIDR("192.168.0.1/24")
+	cidr.IP = ip
+
+	buf := &bytes.Buffer{}
+	WriteTopology(buf, "", cidr, links.GetMeshes(), func(l *kubelink.Link) string { return "up" })
+	out := buf.String()
+	if !strings.Contains(out, `"@local" -> "local"`) {
+		t.Errorf("link named local not distinguished from the local cluster:\n%s", out)
+	}
+	if strings.Contains(out, `"local" -> "local"`) {
+		t.Errorf("self loop for link named local:\n%s", out)
+	}
+}
+
+func TestTopologyEdges(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+		testLink("b", "192.168.0.11/24", "100.64.2.0/24"),
+		testLink("c", "192.168.0.12/24", "100.64.3.0/24"),
+	)
+	m.LogContext = logger.New()
+
+	// rtt sampled from the tcp info
+	a := testConnection(t, m, "a", true)
+	defer a.Close()
+	a.quality = &ConnectionQuality{TCPInfo: TCPInfo{RTT: 12 * time.Millisecond}}
+	m.AddTunnel(a)
+
+	// rtt measured by the heartbeats of the liveness channel
+	b := testConnection(t, m, "b", true)
+	defer b.Close()
+	b.quality = &ConnectionQuality{TCPInfo: TCPInfo{RTT: 12 * time.Millisecond}}
+	b.livenessMonitor = NewLivenessMonitor(time.Minute)
+	now := time.Now()
+	b.livenessMonitor.Ping(1, now)
+	b.livenessMonitor.Pong(now.Add(3*time.Millisecond), 1)
+	m.AddTunnel(b)
+
+	buf := &bytes.Buffer{}
+	WriteTopology(buf, "local", m.GetClusterAddress(), m.links.GetMeshes(), m.TopologyEdge)
+	out := buf.String()
+	for _, edge := range []string{
+		`"@local" -> "a" [label="Up\nrtt 12ms"];`,
+		`"@local" -> "b" [label="Up\nrtt 3ms"];`,
+		`"@local" -> "c" [label="Idle"];`,
+		`"a" [label="a\n192.168.0.10"];`,
+	} {
+		if !strings.Contains(out, edge) {
+			t.Errorf("missing %s in topology:\n%s", edge, out)
+		}
+	}
+}
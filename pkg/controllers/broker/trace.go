@@ -0,0 +1,55 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// handleTrace renders the decision path for a destination address
+// given by the query parameter dst. An optional source address (src)
+// additionally evaluates the ingress rules for packets of the link
+// owning this cluster address.
+func (this *reconciler) handleTrace(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dst := net.ParseIP(query.Get("dst"))
+	if dst == nil {
+		http.Error(w, fmt.Sprintf("invalid or missing destination address %q", query.Get("dst")), http.StatusBadRequest)
+		return
+	}
+	var src net.IP
+	if s := query.Get("src"); s != "" {
+		src = net.ParseIP(s)
+		if src == nil {
+			http.Error(w, fmt.Sprintf("invalid source address %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	trace := this.Links().Trace(src, dst)
+	w.Header().Set("Content-Type", "text/plain")
+	trace.Write(w)
+	if trace.Link != nil && this.mux != nil {
+		if l := this.Links().GetLink(trace.Link.Link); l != nil {
+			state, _ := this.mux.GetConnectionState(l.ClusterAddress.IP)
+			fmt.Fprintf(w, "connection:  %s\n", state)
+		}
+	}
+}
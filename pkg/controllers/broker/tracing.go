@@ -0,0 +1,48 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"context"
+	"net"
+
+	"github.com/mandelsoft/kubelink/pkg/tracing"
+)
+
+// ConnectionID returns an identifier for a tcp connection, which is
+// identical on both ends of the connection (as long as there is no
+// address translation in between).
+func ConnectionID(conn net.Conn) string {
+	local := conn.LocalAddr().String()
+	remote := conn.RemoteAddr().String()
+	if local > remote {
+		local, remote = remote, local
+	}
+	return local + "-" + remote
+}
+
+// SetupTracing configures the export of connection lifecycle spans
+// to an OpenTelemetry collector. An empty endpoint disables tracing.
+func SetupTracing(ctx context.Context, endpoint, service string) {
+	if endpoint == "" {
+		tracing.SetExporter(nil)
+		return
+	}
+	tracing.SetExporter(tracing.NewOTLPExporter(ctx, endpoint, service))
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/tracing"
+)
+
+type testExporter struct {
+	lock  sync.Mutex
+	spans []*tracing.Span
+}
+
+func (this *testExporter) Export(span *tracing.Span) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.spans = append(this.spans, span)
+}
+
+// Wait waits for a finished span with the given name.
+func (this *testExporter) Wait(t *testing.T, name string) *tracing.Span {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		this.lock.Lock()
+		for _, s := range this.spans {
+			if s.Name == name {
+				this.lock.Unlock()
+				return s
+			}
+		}
+		this.lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no span %s exported", name)
+	return nil
+}
+
+func checkAttributes(t *testing.T, span *tracing.Span, attrs ...string) {
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if v := span.Attributes[attrs[i]]; v != attrs[i+1] {
+			t.Errorf("span %s: attribute %s is %q, expected %q", span.Name, attrs[i], v, attrs[i+1])
+		}
+	}
+}
+
+func TestConnectionSpans(t *testing.T) {
+	if span := tracing.StartSpan("kubelink.connect"); span != nil {
+		t.Fatalf("span recorded without exporter")
+	}
+	exporter := &testExporter{}
+	tracing.SetExporter(exporter)
+	defer tracing.SetExporter(nil)
+
+	peer := testMux(t, "192.168.0.10/24", testLink("b", "192.168.0.1/24", "100.64.0.0/24"))
+	peer.LogContext = logger.New()
+	l := testPeer(t, peer)
+	defer l.Close()
+
+	kl := testLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = l.Addr().String()
+	m := testMux(t, "192.168.0.1/24", kl)
+	m.LogContext = logger.New()
+	m.helloTimeout = 2 * time.Second
+
+	c, err := m.AssureTunnel(m, m.links.GetLink("a"))
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer c.Close()
+	id := ConnectionID(c.conn)
+
+	connect := exporter.Wait(t, "kubelink.connect")
+	checkAttributes(t, connect, "kubelink.direction", "outbound", "kubelink.link", "a",
+		"kubelink.endpoint", l.Addr().String(), "kubelink.cluster_address", "192.168.0.10", "kubelink.connection", id)
+	if connect.Error != "" || len(connect.Events) != 1 || connect.Events[0].Name != "connected" {
+		t.Errorf("unexpected outcome of connect span: error %q, events %v", connect.Error, connect.Events)
+	}
+	for _, name := range []string{"kubelink.dial", "kubelink.handshake"} {
+		child := exporter.Wait(t, name)
+		if child.TraceID != connect.TraceID || child.ParentID != connect.SpanID {
+			t.Errorf("span %s not a child of the connect span", name)
+		}
+		if child.Error != "" {
+			t.Errorf("span %s failed: %s", name, child.Error)
+		}
+	}
+	checkAttributes(t, exporter.Wait(t, "kubelink.handshake"), "kubelink.connection", id)
+
+	accept := exporter.Wait(t, "kubelink.accept")
+	checkAttributes(t, accept, "kubelink.direction", "inbound", "kubelink.connection", id,
+		"kubelink.cluster_address", "192.168.0.1")
+	if accept.Error != "" {
+		t.Errorf("accept span failed: %s", accept.Error)
+	}
+
+	// a failing dial
+	exporter.lock.Lock()
+	exporter.spans = nil
+	exporter.lock.Unlock()
+	kl = testLink("c", "192.168.0.11/24", "100.64.2.0/24")
+	kl.Spec.Endpoint = testRefusedEndpoint(t)
+	if _, err := m.links.UpdateLink(logger.New(), kl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AssureTunnel(m, m.links.GetLink("c")); err == nil {
+		t.Fatalf("dial succeeded without a peer")
+	}
+	connect = exporter.Wait(t, "kubelink.connect")
+	checkAttributes(t, connect, "kubelink.link", "c")
+	if connect.Error == "" || exporter.Wait(t, "kubelink.dial").Error == "" {
+		t.Errorf("failed dial not recorded")
+	}
+}
@@ -32,9 +32,26 @@ import (
 const IPTAB = "nat"
 const IPCHAIN = "POSTROUTING"
 
+// linkByIndex looks up the netlink link used to read the tun statistics.
+var linkByIndex = netlink.LinkByIndex
+
+// TunOptions describes optional settings for the tun device.
+type TunOptions struct {
+	// Queues is the number of tun queues (multi queue mode for more than one)
+	Queues int
+	// TxQueueLen is the transmit queue length of the device (0 keeps the default)
+	TxQueueLen int
+	// MTU is the MTU of the device (0 keeps the default)
+	MTU int
+}
+
 type Tun struct {
 	tun       *taptun.Tun
+	queues    []*taptun.Tun
 	link      netlink.Link
+	ipt       *iptables.IPTables
+	rule      []string
+	mtu       int
 	finalizer func()
 }
 
@@ -57,15 +74,52 @@ func (this *Tun) Read(buf []byte) (int, error) {
 	return this.tun.Read(buf)
 }
 
+// Statistics reads the actual interface statistics of the tun device.
+func (this *Tun) Statistics() (*netlink.LinkStatistics, error) {
+	link, err := linkByIndex(this.link.Attrs().Index)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get link for %q: %s", this.tun, err)
+	}
+	stats := link.Attrs().Statistics
+	if stats == nil {
+		return nil, fmt.Errorf("no statistics for %q", this.tun)
+	}
+	return stats, nil
+}
+
+// Queues returns all queues of the tun device.
+func (this *Tun) Queues() []*taptun.Tun {
+	return append([]*taptun.Tun{this.tun}, this.queues...)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
-func NewTun(logger logger.LogContext, name string, clusterAddress *net.IPNet) (*Tun, error) {
+func NewTun(logger logger.LogContext, name string, clusterAddress *net.IPNet, opts TunOptions) (*Tun, error) {
+	var tun *taptun.Tun
+	var queues []*taptun.Tun
+	var err error
 
-	tun, err := taptun.NewTun(name)
-	if err != nil {
-		return nil, fmt.Errorf("cannot create tun %q: %s", tun, err)
+	if opts.Queues > 1 {
+		queues, err = taptun.NewTunQueues(name, opts.Queues)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create tun %q with %d queues: %s", name, opts.Queues, err)
+		}
+		tun = queues[0]
+		queues = queues[1:]
+		logger.Infof("created tun device %q with %d queues", tun, opts.Queues)
+	} else {
+		tun, err = taptun.NewTun(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create tun %q: %s", tun, err)
+		}
+		logger.Infof("created tun device %q", tun)
+	}
+	closeQueues := func() {
+		for _, q := range queues {
+			q.Close()
+		}
+		tun.Close()
 	}
-	logger.Infof("created tun device %q", tun)
 
 	ipt, err := iptables.New()
 	if err != nil {
@@ -74,14 +128,14 @@ func NewTun(logger logger.LogContext, name string, clusterAddress *net.IPNet) (*
 
 	link, err := netlink.LinkByName(tun.String())
 	if err != nil {
-		tun.Close()
+		closeQueues()
 		return nil, fmt.Errorf("cannot get link for %q: %s", tun, err)
 	}
 
 	rule := []string{"-o", tun.String(), "-j", "SNAT", "--to-source", clusterAddress.IP.String()}
 	ok, err := ipt.Exists(IPTAB, IPCHAIN, rule...)
 	if err != nil {
-		tun.Close()
+		closeQueues()
 		return nil, fmt.Errorf("cannot check nat: %s", err)
 	}
 
@@ -90,18 +144,39 @@ func NewTun(logger logger.LogContext, name string, clusterAddress *net.IPNet) (*
 	} else {
 		err = ipt.Append(IPTAB, IPCHAIN, rule...)
 		if err != nil {
-			tun.Close()
+			closeQueues()
 			return nil, fmt.Errorf("cannot add nat rule %v: %s", rule, err)
 		}
 		logger.Infof("added nat rule %v", rule)
 	}
 	result := &Tun{
-		tun,
-		link,
-		func() {
-			ipt.Delete(IPTAB, IPCHAIN, rule...)
-			tun.Close()
-		},
+		tun:    tun,
+		queues: queues,
+		link:   link,
+		ipt:    ipt,
+		rule:   rule,
+	}
+	result.finalizer = func() {
+		ipt.Delete(IPTAB, IPCHAIN, result.rule...)
+		closeQueues()
+	}
+
+	if opts.TxQueueLen > 0 {
+		err = netlink.LinkSetTxQLen(link, opts.TxQueueLen)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("cannot set tx queue length for %q: %s", tun, err)
+		}
+		logger.Infof("set tx queue length of %q to %d", tun, opts.TxQueueLen)
+	}
+
+	if opts.MTU > 0 {
+		err = netlink.LinkSetMTU(link, opts.MTU)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("cannot set mtu for %q: %s", tun, err)
+		}
+		logger.Infof("set mtu of %q to %d", tun, opts.MTU)
 	}
 
 	err = SetLinkAddress(logger, link, clusterAddress)
@@ -122,9 +197,42 @@ func NewTun(logger logger.LogContext, name string, clusterAddress *net.IPNet) (*
 		return nil, fmt.Errorf("cannot get addresses for %s: %s", tun, err)
 	}
 	logger.Infof("%s: MTU: %d, Flags: %s, Addr: %v", result, ifce.MTU, ifce.Flags, addrs)
+	result.mtu = ifce.MTU
 	return result, nil
 }
 
+// AddAddress adds an additional cluster address to the tun device
+// and uses it as new source address for outgoing traffic.
+func (this *Tun) AddAddress(logger logger.LogContext, addr *net.IPNet) error {
+	err := SetLinkAddress(logger, this.link, addr)
+	if err != nil {
+		return err
+	}
+	rule := []string{"-o", this.tun.String(), "-j", "SNAT", "--to-source", addr.IP.String()}
+	err = this.ipt.Insert(IPTAB, IPCHAIN, 1, rule...)
+	if err != nil {
+		return fmt.Errorf("cannot add nat rule %v: %s", rule, err)
+	}
+	logger.Infof("added nat rule %v", rule)
+	old := this.rule
+	this.rule = rule
+	err = this.ipt.Delete(IPTAB, IPCHAIN, old...)
+	if err != nil {
+		logger.Errorf("cannot delete nat rule %v: %s", old, err)
+	}
+	return nil
+}
+
+// RemoveAddress removes a cluster address from the tun device.
+func (this *Tun) RemoveAddress(logger logger.LogContext, addr *net.IPNet) error {
+	logger.Infof("removing address %s from %q", addr, this.link.Attrs().Name)
+	err := netlink.AddrDel(this.link, &netlink.Addr{IPNet: addr})
+	if err != nil {
+		return fmt.Errorf("cannot remove addr %q from %s: %s", addr, this.link.Attrs().Name, err)
+	}
+	return nil
+}
+
 func SetLinkAddress(logger logger.LogContext, link netlink.Link, addr *net.IPNet) error {
 	nladdr := &netlink.Addr{
 		IPNet: addr,
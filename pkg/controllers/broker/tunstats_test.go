@@ -0,0 +1,81 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+	"github.com/mandelsoft/kubelink/pkg/taptun"
+)
+
+func TestTunMetrics(t *testing.T) {
+	stats := &netlink.LinkStatistics{
+		RxBytes: 1000, TxBytes: 2000,
+		RxPackets: 10, TxPackets: 20,
+		RxErrors: 1, TxErrors: 2,
+		RxDropped: 3, TxDropped: 4,
+	}
+	read := 0
+	linkByIndex = func(index int) (netlink.Link, error) {
+		read++
+		if index != 7 {
+			return nil, fmt.Errorf("unexpected index %d", index)
+		}
+		return &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Index: index, Statistics: stats}}, nil
+	}
+	defer func() { linkByIndex = netlink.LinkByIndex }()
+
+	r := &reconciler{mux: testMux(t, "192.168.0.1/24")}
+
+	w := metrics.NewWriter()
+	r.collectTunMetrics(w)
+	if read != 0 || len(w.Bytes()) != 0 {
+		t.Errorf("metrics exported without tun device: %s", w.Bytes())
+	}
+
+	r.mux.ReplaceTun(&Tun{
+		tun:  &taptun.Tun{},
+		link: &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Index: 7}},
+	})
+	w = metrics.NewWriter()
+	r.collectTunMetrics(w)
+	if read != 1 {
+		t.Errorf("tun statistics read %d times", read)
+	}
+	out := string(w.Bytes())
+	for _, expected := range []string{
+		`kubelink_tun_bytes_total{device="",direction="in"} 1000`,
+		`kubelink_tun_bytes_total{device="",direction="out"} 2000`,
+		`kubelink_tun_packets_total{device="",direction="in"} 10`,
+		`kubelink_tun_packets_total{device="",direction="out"} 20`,
+		`kubelink_tun_errors_total{device="",direction="in"} 1`,
+		`kubelink_tun_errors_total{device="",direction="out"} 2`,
+		`kubelink_tun_dropped_total{device="",direction="in"} 3`,
+		`kubelink_tun_dropped_total{device="",direction="out"} 4`,
+	} {
+		if !strings.Contains(out, expected+"\n") {
+			t.Errorf("missing %q in\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package broker
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func TestUnreachableRoutes(t *testing.T) {
+	m := testMux(t, "192.168.0.1/24",
+		testLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+		testLink("b", "192.168.0.11/24", "100.64.2.0/24"),
+	)
+	routes := func() kubelink.Routes {
+		var routes kubelink.Routes
+		for _, s := range []string{"100.64.1.0/24", "100.64.2.0/24"} {
+			_, dst, _ := net.ParseCIDR(s)
+			routes = append(routes, netlink.Route{LinkIndex: 7, Dst: dst, Protocol: kubelink.BROKER_ROUTE_PROTOCOL})
+		}
+		return routes
+	}
+	types := func(routes kubelink.Routes) []int {
+		var result []int
+		for _, r := range routes {
+			result = append(result, r.Type)
+		}
+		return result
+	}
+
+	if got := types(m.UnreachableRoutes(routes())); fmt.Sprint(got) != fmt.Sprint([]int{0, 0}) {
+		t.Errorf("healthy links: got route types %v", got)
+	}
+
+	// link a reconnecting
+	m.setError("192.168.0.10", fmt.Errorf("connection aborted"))
+	result := m.UnreachableRoutes(routes())
+	if got := types(result); fmt.Sprint(got) != fmt.Sprint([]int{syscall.RTN_UNREACHABLE, 0}) {
+		t.Errorf("failing link: got route types %v", got)
+	}
+	if r := result[0]; r.LinkIndex != 0 || r.Dst.String() != "100.64.1.0/24" || r.Protocol != kubelink.BROKER_ROUTE_PROTOCOL {
+		t.Errorf("unexpected unreachable route %s", r)
+	}
+	if routes().Lookup(result[0]) >= 0 {
+		t.Errorf("unreachable route matches the regular route")
+	}
+
+	// link a recovered
+	m.setError("192.168.0.10", nil)
+	if got := types(m.UnreachableRoutes(routes())); fmt.Sprint(got) != fmt.Sprint([]int{0, 0}) {
+		t.Errorf("recovered link: got route types %v", got)
+	}
+}
@@ -20,10 +20,14 @@ package controllers
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"strings"
 
 	"github.com/gardener/controller-manager-library/pkg/config"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
 )
 
 const IPIP_NONE = "none"
@@ -31,10 +35,25 @@ const IPIP_SHARED = "shared"
 const IPIP_CONFIGURE = "configure"
 
 type Config struct {
-	nodecidr string
+	nodecidr  string
+	protected []string
+
+	NodeCIDR    *net.IPNet
+	IPIP        string
+	MaxEgress   int
+	NetNS       string
+	HistorySize int
+
+	MaxConcurrentReconciles int
 
-	NodeCIDR *net.IPNet
-	IPIP     string
+	IPTablesRestore bool
+	SetupEvents     bool
+	MaintenanceAPI  bool
+	IngressConflict string
+	ProtectedCIDRs  tcp.CIDRList
+
+	maintenanceTokenFile string
+	MaintenanceToken     string `redact:"true"`
 }
 
 var _ config.OptionSource = &Config{}
@@ -42,6 +61,16 @@ var _ config.OptionSource = &Config{}
 func (this *Config) AddOptionsToSet(set config.OptionSet) {
 	set.AddStringOption(&this.nodecidr, "node-cidr", "", "", "CIDR of node network of cluster")
 	set.AddStringOption(&this.IPIP, "ipip", "", "IPIP_NONE", "ip-ip tunnel mode (none, shared, configure")
+	set.AddIntOption(&this.MaxEgress, "max-egress", "", 0, "Maximum number of egress CIDRs per link (0 for unlimited)")
+	set.AddIntOption(&this.MaxConcurrentReconciles, "max-concurrent-reconciles", "", 0, "Maximum number of reconcile operations executed concurrently (0 for unlimited)")
+	set.AddIntOption(&this.HistorySize, "history-size", "", kubelink.DEFAULT_HISTORY_SIZE, "Maximum number of recorded link changes")
+	set.AddBoolOption(&this.IPTablesRestore, "iptables-restore", "", false, "Apply managed iptables chains atomically using iptables-restore")
+	set.AddBoolOption(&this.SetupEvents, "setup-events", "", false, "Emit events for links that cannot be loaded at startup")
+	set.AddBoolOption(&this.MaintenanceAPI, "maintenance-api", "", false, "Allow pausing network updates by POST requests to the /maintenance endpoint")
+	set.AddStringOption(&this.maintenanceTokenFile, "maintenance-api-token-file", "", "", "File containing the bearer token required for POST requests to the /maintenance endpoint")
+	set.AddStringOption(&this.IngressConflict, "ingress-conflict", "", kubelink.OVERLAP_WARN, "Handling of links sharing the cluster address of another link with a different ingress (ignore, warn or reject)")
+	set.AddStringArrayOption(&this.protected, "protected-cidrs", "", nil, "Networks (for example api server or management network) never shadowed by link routes")
+	set.AddStringOption(&this.NetNS, "netns", "", "", "Network namespace used to maintain routes and firewall rules")
 }
 
 func (this *Config) Prepare() error {
@@ -59,6 +88,41 @@ func (this *Config) Prepare() error {
 	default:
 		return fmt.Errorf("invalid ipip mode: %s", this.IPIP)
 	}
+	if this.MaxEgress < 0 {
+		return fmt.Errorf("invalid maximum egress count: %d", this.MaxEgress)
+	}
+	if this.HistorySize < 0 {
+		return fmt.Errorf("invalid history size: %d", this.HistorySize)
+	}
+	if this.MaxConcurrentReconciles < 0 {
+		return fmt.Errorf("invalid maximum concurrent reconciles: %d", this.MaxConcurrentReconciles)
+	}
+	if this.maintenanceTokenFile != "" {
+		data, err := ioutil.ReadFile(this.maintenanceTokenFile)
+		if err != nil {
+			return fmt.Errorf("cannot read maintenance api token: %s", err)
+		}
+		this.MaintenanceToken = strings.TrimSpace(string(data))
+		if this.MaintenanceToken == "" {
+			return fmt.Errorf("empty maintenance api token in %s", this.maintenanceTokenFile)
+		}
+	}
+	if this.MaintenanceAPI && this.MaintenanceToken == "" {
+		return fmt.Errorf("maintenance-api requires maintenance-api-token-file")
+	}
+	switch this.IngressConflict {
+	case kubelink.OVERLAP_IGNORE, kubelink.OVERLAP_WARN, kubelink.OVERLAP_REJECT:
+	default:
+		return fmt.Errorf("invalid ingress conflict mode %q", this.IngressConflict)
+	}
+	this.ProtectedCIDRs = nil
+	for _, p := range this.protected {
+		_, c, err := net.ParseCIDR(strings.TrimSpace(p))
+		if err != nil {
+			return fmt.Errorf("invalid protected cidr %q: %s", p, err)
+		}
+		this.ProtectedCIDRs.Add(c)
+	}
 	return nil
 }
 
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func TestPrepareHistorySize(t *testing.T) {
+	cfg := &Config{
+		nodecidr:        "10.250.0.0/16",
+		IPIP:            IPIP_NONE,
+		IngressConflict: kubelink.OVERLAP_WARN,
+		HistorySize:     0,
+	}
+	if err := cfg.Prepare(); err != nil {
+		t.Errorf("history size 0 rejected: %s", err)
+	}
+	cfg.HistorySize = -1
+	if err := cfg.Prepare(); err == nil {
+		t.Errorf("negative history size accepted")
+	}
+}
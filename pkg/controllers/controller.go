@@ -60,7 +60,19 @@ func CreateBaseReconciler(controller controller.Interface, impl ReconcilerImplem
 
 	controller.Infof("using cidr for nodes: %s", config.NodeCIDR)
 
-	ifce, err := kubelink.LookupNodeIP(controller, config.NodeCIDR)
+	// link indices are specific for a network namespace, therefore the
+	// node interface is looked up in the namespace used for the routes.
+	var ifce *kubelink.NodeInterface
+	if config.NetNS != "" {
+		nerr := RunInNetworkNamespace(config.NetNS, func() {
+			ifce, err = kubelink.LookupNodeIP(controller, config.NodeCIDR)
+		})
+		if nerr != nil {
+			return nil, nerr
+		}
+	} else {
+		ifce, err = kubelink.LookupNodeIP(controller, config.NodeCIDR)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -70,13 +82,23 @@ func CreateBaseReconciler(controller controller.Interface, impl ReconcilerImplem
 		return nil, fmt.Errorf("cannot create iptables access: %s", err)
 	}
 
+	links := kubelink.GetSharedLinks(controller)
+	links.SetMaxEgress(config.MaxEgress)
+	links.SetNodeCIDR(config.NodeCIDR)
+	links.SetSetupEvents(config.SetupEvents)
+	links.SetIngressConflict(config.IngressConflict)
+	links.History().SetSize(config.HistorySize)
+
 	return &Reconciler{
 		Common:     NewCommon(controller),
 		IPT:        ipt,
 		config:     cfg,
 		baseconfig: config,
 		ifce:       ifce,
-		links:      kubelink.GetSharedLinks(controller),
+		links:      links,
 		impl:       impl,
+		limiter:    NewLimiter(config.MaxConcurrentReconciles),
+		state:      &kernelState{ipt},
+		enterNetNS: RunInNetworkNamespace,
 	}, nil
 }
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gardener/controller-manager-library/pkg/server"
+	"github.com/vishvananda/netlink"
+
+	"github.com/mandelsoft/kubelink/pkg/iptables"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// StateSource provides the actual network state of a node.
+type StateSource interface {
+	ListRoutes() (kubelink.Routes, error)
+	// ListChain returns the actual rules of a chain or nil if the
+	// chain does not exist.
+	ListChain(table, chain string) (*iptables.Chain, error)
+}
+
+// StateApplier modifies the network state of a node.
+type StateApplier interface {
+	StateSource
+	AddRoute(r *netlink.Route) error
+	DeleteRoute(r *netlink.Route) error
+}
+
+type kernelState struct {
+	ipt *iptables.IPTables
+}
+
+func (this *kernelState) ListRoutes() (kubelink.Routes, error) {
+	return kubelink.ListRoutes()
+}
+
+func (this *kernelState) AddRoute(r *netlink.Route) error {
+	return netlink.RouteAdd(r)
+}
+
+func (this *kernelState) DeleteRoute(r *netlink.Route) error {
+	return netlink.RouteDel(r)
+}
+
+func (this *kernelState) ListChain(table, chain string) (*iptables.Chain, error) {
+	chains, err := this.ipt.ListChains(table)
+	if err != nil {
+		return nil, err
+	}
+	if iptables.StringList(chains).Index(chain) < 0 {
+		return nil, nil
+	}
+	return this.ipt.ListChain(table, chain)
+}
+
+// ChainDrift describes the deviation of an iptables chain from the
+// desired state.
+type ChainDrift struct {
+	Table      string   `json:"table"`
+	Chain      string   `json:"chain"`
+	Missing    []string `json:"missing,omitempty"`
+	Unexpected []string `json:"unexpected,omitempty"`
+}
+
+// Drift describes the deviation of the actual network state from the
+// state intended by a controller.
+type Drift struct {
+	MissingRoutes    []string     `json:"missingRoutes,omitempty"`
+	UnexpectedRoutes []string     `json:"unexpectedRoutes,omitempty"`
+	Chains           []ChainDrift `json:"chains,omitempty"`
+	Error            string       `json:"error,omitempty"`
+}
+
+// Drift compares the desired routes and rules with the actual kernel
+// state of the network namespace used by the controller.
+func (this *Reconciler) Drift() (*Drift, error) {
+	var drift *Drift
+	var err error
+	nerr := this.InNetworkNamespace(func() {
+		drift, err = this.DriftOf(this.state)
+	})
+	if nerr != nil {
+		return nil, nerr
+	}
+	return drift, err
+}
+
+// DriftOf compares the desired routes and rules with the state
+// provided by the given source.
+func (this *Reconciler) DriftOf(source StateSource) (*Drift, error) {
+	drift := &Drift{}
+	routes, err := source.ListRoutes()
+	if err != nil {
+		return nil, err
+	}
+	required, _, _ := this.impl.RequiredRoutes().Protect(this.ProtectedCIDRs())
+	for _, r := range routes {
+		if this.impl.IsManagedRoute(&r, required) && required.Lookup(r) < 0 {
+			drift.UnexpectedRoutes = append(drift.UnexpectedRoutes, String(r))
+		}
+	}
+	for _, r := range required {
+		if routes.Lookup(r) < 0 {
+			drift.MissingRoutes = append(drift.MissingRoutes, String(r))
+		}
+	}
+
+	for _, req := range this.impl.RequiredSNATRules() {
+		actual, err := source.ListChain(req.Table, req.Chain.Chain)
+		if err != nil {
+			return nil, err
+		}
+		missing, unexpected := req.Chain.Diff(actual, req.Cleanup)
+		if len(missing) == 0 && len(unexpected) == 0 {
+			continue
+		}
+		drift.Chains = append(drift.Chains, ChainDrift{
+			Table:      req.Table,
+			Chain:      req.Chain.Chain,
+			Missing:    ruleStrings(missing),
+			Unexpected: ruleStrings(unexpected),
+		})
+	}
+	return drift, nil
+}
+
+func ruleStrings(rules iptables.Rules) []string {
+	var result []string
+	for _, r := range rules {
+		result = append(result, strings.Join(r.AsList(), " "))
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+var driftLock sync.Mutex
+var drifts = map[string]*Reconciler{}
+var driftOnce sync.Once
+
+// RegisterDrift registers a controller to be reported by the /drift
+// endpoint.
+func RegisterDrift(name string, r *Reconciler) {
+	driftLock.Lock()
+	defer driftLock.Unlock()
+	drifts[name] = r
+	driftOnce.Do(func() {
+		server.Register("/drift", handleDrift)
+	})
+}
+
+// handleDrift reports the deviation of the actual network state from
+// the desired state for all controllers.
+func handleDrift(w http.ResponseWriter, r *http.Request) {
+	driftLock.Lock()
+	names := []string{}
+	for n := range drifts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	reconcilers := map[string]*Reconciler{}
+	for n, d := range drifts {
+		reconcilers[n] = d
+	}
+	driftLock.Unlock()
+
+	result := map[string]*Drift{}
+	for _, n := range names {
+		drift, err := reconcilers[n].Drift()
+		if err != nil {
+			drift = &Drift{Error: err.Error()}
+		}
+		result[n] = drift
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+}
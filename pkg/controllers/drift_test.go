@@ -0,0 +1,148 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mandelsoft/kubelink/pkg/iptables"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// testState provides a fake kernel state.
+type testState struct {
+	routes kubelink.Routes
+	chains map[string]*iptables.Chain
+}
+
+func (this *testState) ListRoutes() (kubelink.Routes, error) {
+	return this.routes, nil
+}
+
+func (this *testState) ListChain(table, chain string) (*iptables.Chain, error) {
+	return this.chains[table+"/"+chain], nil
+}
+
+func testRule(args ...string) iptables.Rule {
+	return iptables.ParseRule(args)
+}
+
+func TestDrift(t *testing.T) {
+	foreign := testRoute(t, "100.64.9.0/24")
+	foreign.Protocol = 2
+	state := &testState{
+		routes: kubelink.Routes{
+			testRoute(t, "100.64.1.0/24"),
+			testRoute(t, "100.64.3.0/24"),
+			foreign,
+		},
+		chains: map[string]*iptables.Chain{
+			"nat/KUBELINK-SNAT": {
+				Table: "nat",
+				Chain: "KUBELINK-SNAT",
+				Rules: iptables.Rules{
+					testRule("-d", "100.64.1.0/24", "-j", "MASQUERADE"),
+					testRule("-d", "100.64.3.0/24", "-j", "MASQUERADE"),
+				},
+			},
+			"nat/KUBELINK-KEEP": {
+				Table: "nat",
+				Chain: "KUBELINK-KEEP",
+				Rules: iptables.Rules{
+					testRule("-d", "100.64.3.0/24", "-j", "RETURN"),
+				},
+			},
+			"filter/KUBELINK-OK": {
+				Table: "filter",
+				Chain: "KUBELINK-OK",
+				Rules: iptables.Rules{
+					testRule("-j", "ACCEPT"),
+				},
+			},
+		},
+	}
+	impl := &testImpl{
+		ns:       &testNamespaces{},
+		required: kubelink.Routes{testRoute(t, "100.64.1.0/24"), testRoute(t, "100.64.2.0/24")},
+		snat: iptables.Requests{
+			iptables.NewChainRequest("nat", "KUBELINK-SNAT", iptables.Rules{
+				testRule("-d", "100.64.1.0/24", "-j", "MASQUERADE"),
+				testRule("-d", "100.64.2.0/24", "-j", "MASQUERADE"),
+			}, true),
+			// without cleanup additional rules are no drift
+			iptables.NewChainRequest("nat", "KUBELINK-KEEP", iptables.Rules{
+				testRule("-d", "100.64.2.0/24", "-j", "RETURN"),
+			}, false),
+			iptables.NewChainRequest("filter", "KUBELINK-OK", iptables.Rules{
+				testRule("-j", "ACCEPT"),
+			}, true),
+			iptables.NewChainRequest("filter", "KUBELINK-MISSING", iptables.Rules{
+				testRule("-j", "DROP"),
+			}, true),
+		},
+	}
+	r := &Reconciler{
+		baseconfig: &Config{},
+		ifce:       &kubelink.NodeInterface{Name: "eth0", Index: 2, IP: net.ParseIP("10.250.0.1")},
+		impl:       impl,
+	}
+
+	drift, err := r.DriftOf(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Drift{
+		MissingRoutes:    []string{String(testRoute(t, "100.64.2.0/24"))},
+		UnexpectedRoutes: []string{String(testRoute(t, "100.64.3.0/24"))},
+		Chains: []ChainDrift{
+			{
+				Table:      "nat",
+				Chain:      "KUBELINK-SNAT",
+				Missing:    []string{"-d 100.64.2.0/24 -j MASQUERADE"},
+				Unexpected: []string{"-d 100.64.3.0/24 -j MASQUERADE"},
+			},
+			{
+				Table:   "nat",
+				Chain:   "KUBELINK-KEEP",
+				Missing: []string{"-d 100.64.2.0/24 -j RETURN"},
+			},
+			{
+				Table:   "filter",
+				Chain:   "KUBELINK-MISSING",
+				Missing: []string{"-j DROP"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(drift, expected) {
+		t.Errorf("got drift %+v, expected %+v", drift, expected)
+	}
+
+	// no drift for the desired state
+	state.routes = impl.required
+	impl.snat = impl.snat[2:3]
+	drift, err = r.DriftOf(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(drift, &Drift{}) {
+		t.Errorf("unexpected drift %+v", drift)
+	}
+}
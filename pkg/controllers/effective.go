@@ -0,0 +1,132 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/gardener/controller-manager-library/pkg/config"
+	"github.com/gardener/controller-manager-library/pkg/server"
+	"github.com/gardener/controller-manager-library/pkg/utils"
+)
+
+const REDACTED = "<redacted>"
+
+var configLock sync.Mutex
+var configs = map[string]config.OptionSource{}
+var configOnce sync.Once
+
+// RegisterEffectiveConfig registers the prepared configuration of a
+// controller to be served by the /config endpoint.
+func RegisterEffectiveConfig(name string, cfg config.OptionSource) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	configs[name] = cfg
+	configOnce.Do(func() {
+		server.Register("/config", handleEffectiveConfig)
+	})
+}
+
+func handleEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	configLock.Lock()
+	result := map[string]map[string]interface{}{}
+	for n, c := range configs {
+		result[n] = EffectiveConfig(c)
+	}
+	configLock.Unlock()
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+// EffectiveConfig renders the exported fields of a configuration
+// structure. Fields tagged with `redact:"true"` are redacted.
+func EffectiveConfig(cfg interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	v := reflect.ValueOf(cfg)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return result
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		effectiveFields(v, result)
+	}
+	return result
+}
+
+func effectiveFields(v reflect.Value, result map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			effectiveFields(v.Field(i), result)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		value := v.Field(i)
+		if f.Tag.Get("redact") == "true" {
+			if !value.IsZero() {
+				result[f.Name] = REDACTED
+			}
+			continue
+		}
+		result[f.Name] = effectiveValue(value)
+	}
+}
+
+func effectiveValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	switch o := v.Interface().(type) {
+	case utils.StringSet:
+		list := o.AsArray()
+		sort.Strings(list)
+		return list
+	case fmt.Stringer:
+		return o.String()
+	}
+	if v.Kind() == reflect.Struct {
+		result := map[string]interface{}{}
+		effectiveFields(v, result)
+		return result
+	}
+	return v.Interface()
+}
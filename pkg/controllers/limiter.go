@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"sync"
+)
+
+// Limiter bounds the number of concurrently executed reconcile
+// operations. Operations exceeding the limit wait until a running
+// operation is finished. A nil limiter does not limit anything.
+type Limiter struct {
+	lock    sync.Mutex
+	slots   chan struct{}
+	active  int
+	waiting int
+	peak    int
+}
+
+// NewLimiter creates a limiter for the given maximum number of
+// concurrent operations. A non-positive maximum disables the limit.
+func NewLimiter(max int) *Limiter {
+	if max <= 0 {
+		return nil
+	}
+	return &Limiter{slots: make(chan struct{}, max)}
+}
+
+// Acquire waits for a free slot.
+func (this *Limiter) Acquire() {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	this.waiting++
+	this.lock.Unlock()
+
+	this.slots <- struct{}{}
+
+	this.lock.Lock()
+	this.waiting--
+	this.active++
+	if this.active > this.peak {
+		this.peak = this.active
+	}
+	this.lock.Unlock()
+}
+
+// Release frees a slot acquired by Acquire.
+func (this *Limiter) Release() {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	this.active--
+	this.lock.Unlock()
+	<-this.slots
+}
+
+// Max returns the configured limit (0 for unlimited).
+func (this *Limiter) Max() int {
+	if this == nil {
+		return 0
+	}
+	return cap(this.slots)
+}
+
+// State returns the number of running and waiting operations and the
+// maximum number of operations observed running at the same time.
+func (this *Limiter) State() (active, waiting, peak int) {
+	if this == nil {
+		return 0, 0, 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.active, this.waiting, this.peak
+}
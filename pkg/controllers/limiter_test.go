@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	const max = 3
+	const burst = 20
+
+	limiter := NewLimiter(max)
+	if limiter.Max() != max {
+		t.Errorf("got limit %d, expected %d", limiter.Max(), max)
+	}
+
+	running := int32(0)
+	peak := int32(0)
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Acquire()
+			defer limiter.Release()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+
+	// wait for the burst to fill all slots
+	for i := 0; i < 500; i++ {
+		if active, waiting, _ := limiter.State(); active == max && waiting == burst-max {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if active, waiting, _ := limiter.State(); active != max || waiting != burst-max {
+		t.Errorf("got %d active and %d waiting operations, expected %d and %d", active, waiting, max, burst-max)
+	}
+	close(release)
+	wg.Wait()
+
+	if p := atomic.LoadInt32(&peak); p > max {
+		t.Errorf("%d operations running concurrently, limit is %d", p, max)
+	}
+	if active, waiting, p := limiter.State(); active != 0 || waiting != 0 || p != max {
+		t.Errorf("unexpected final state: %d active, %d waiting, peak %d", active, waiting, p)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	limiter := NewLimiter(0)
+	if limiter != nil {
+		t.Fatalf("limiter created for unlimited operations")
+	}
+	for i := 0; i < 10; i++ {
+		limiter.Acquire()
+	}
+	if active, waiting, peak := limiter.State(); active != 0 || waiting != 0 || peak != 0 || limiter.Max() != 0 {
+		t.Errorf("unexpected state of unlimited limiter")
+	}
+}
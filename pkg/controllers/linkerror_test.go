@@ -0,0 +1,48 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type testTimedError struct {
+	time time.Time
+}
+
+func (this *testTimedError) Error() string {
+	return "failed"
+}
+
+func (this *testTimedError) Timestamp() time.Time {
+	return this.time
+}
+
+func TestErrorTime(t *testing.T) {
+	occurred := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if e := errorTime(&testTimedError{occurred}); !e.Time.Equal(occurred) {
+		t.Errorf("error timestamp not used: %s", e)
+	}
+	start := time.Now().Truncate(time.Second)
+	if e := errorTime(fmt.Errorf("failed")); e.Time.Before(start) {
+		t.Errorf("actual time not used for plain error: %s", e)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller/reconcile"
+
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+const OUTCOME_SUCCESS = "success"
+const OUTCOME_REQUEUE = "requeue"
+const OUTCOME_ERROR = "error"
+
+var reconcileDuration = metrics.NewHistogramVec("kubelink_reconcile_duration_seconds",
+	"Duration of reconcile operations", metrics.DefaultBuckets, "controller", "operation")
+var reconcileOutcomes = metrics.NewCounterVec("kubelink_reconcile_total",
+	"Number of reconcile operations by outcome", "controller", "operation", "outcome")
+
+func init() {
+	metrics.Register("reconcile_duration", reconcileDuration)
+	metrics.Register("reconcile_outcome", reconcileOutcomes)
+}
+
+// Outcome classifies the status of a reconcile operation.
+func Outcome(status reconcile.Status) string {
+	switch {
+	case status.Error != nil:
+		return OUTCOME_ERROR
+	case !status.Completed || status.Interval > 0:
+		return OUTCOME_REQUEUE
+	default:
+		return OUTCOME_SUCCESS
+	}
+}
+
+// ObserveReconcile records the duration and outcome of a reconcile
+// operation of a controller started at the given time.
+func ObserveReconcile(controller, operation string, start time.Time, status reconcile.Status) reconcile.Status {
+	reconcileDuration.Observe(time.Since(start).Seconds(), controller, operation)
+	reconcileOutcomes.Inc(controller, operation, Outcome(status))
+	return status
+}
+
+// Observe records the duration and outcome of a reconcile operation
+// of the controller of the reconciler.
+func (this *Common) Observe(operation string, start time.Time, status reconcile.Status) reconcile.Status {
+	return ObserveReconcile(this.controller.GetName(), operation, start, status)
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller/reconcile"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/metrics"
+)
+
+func TestObserveReconcile(t *testing.T) {
+	log := logger.New()
+	cases := map[string]reconcile.Status{
+		OUTCOME_SUCCESS: reconcile.Succeeded(log),
+		OUTCOME_REQUEUE: reconcile.RescheduleAfter(log, time.Minute),
+		OUTCOME_ERROR:   reconcile.Delay(log, fmt.Errorf("failed")),
+	}
+	for outcome, status := range cases {
+		before := reconcileDuration.Get("test", outcome).Count
+		start := time.Now().Add(-time.Second)
+		ObserveReconcile("test", outcome, start, status)
+
+		h := reconcileDuration.Get("test", outcome)
+		if h.Count != before+1 {
+			t.Errorf("%s: reconcile not observed", outcome)
+		}
+		if h.Sum < 1 {
+			t.Errorf("%s: duration %v not recorded", outcome, h.Sum)
+		}
+		if n := reconcileOutcomes.Get("test", outcome, outcome); n != 1 {
+			t.Errorf("%s: outcome counted %v times", outcome, n)
+		}
+	}
+
+	data := string(metrics.Collect())
+	for _, s := range []string{
+		`kubelink_reconcile_total{controller="test",operation="error",outcome="error"} 1`,
+		`kubelink_reconcile_duration_seconds_count{controller="test",operation="success"} 1`,
+	} {
+		if !strings.Contains(data, s) {
+			t.Errorf("metric %q not exported", s)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/vishvananda/netns"
+)
+
+// InNetworkNamespace executes the given function in the configured
+// network namespace. Without a configured namespace it is executed
+// in the actual one.
+func (this *Reconciler) InNetworkNamespace(f func()) error {
+	if this.baseconfig.NetNS == "" {
+		f()
+		return nil
+	}
+	enter := this.enterNetNS
+	if enter == nil {
+		enter = RunInNetworkNamespace
+	}
+	return enter(this.baseconfig.NetNS, f)
+}
+
+// RunInNetworkNamespace executes the given function in the named network
+// namespace. The function is executed by a dedicated goroutine locked
+// to its thread while the namespace is switched. If the original
+// namespace cannot be restored, the thread is kept locked, so that it
+// is discarded together with the goroutine instead of executing other
+// goroutines in the wrong namespace.
+func RunInNetworkNamespace(name string, f func()) error {
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		done <- runInNetworkNamespace(name, f)
+	}()
+	return <-done
+}
+
+func runInNetworkNamespace(name string, f func()) error {
+	orig, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("cannot get actual network namespace: %s", err)
+	}
+	defer orig.Close()
+
+	ns, err := netns.GetFromName(name)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("cannot get network namespace %q: %s", name, err)
+	}
+	defer ns.Close()
+
+	err = netns.Set(ns)
+	if err != nil {
+		if netns.Set(orig) == nil {
+			runtime.UnlockOSThread()
+		}
+		return fmt.Errorf("cannot switch to network namespace %q: %s", name, err)
+	}
+	f()
+	err = netns.Set(orig)
+	if err != nil {
+		return fmt.Errorf("cannot restore network namespace: %s", err)
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
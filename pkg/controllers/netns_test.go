@@ -0,0 +1,142 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/vishvananda/netlink"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/iptables"
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+// testNamespaces simulates switching network namespaces by
+// remembering the actual one.
+type testNamespaces struct {
+	current string
+}
+
+func (this *testNamespaces) enter(name string, f func()) error {
+	old := this.current
+	this.current = name
+	defer func() { this.current = old }()
+	f()
+	return nil
+}
+
+// testApplier records the network namespace of all operations.
+type testApplier struct {
+	ns     *testNamespaces
+	routes kubelink.Routes
+	ops    []string
+}
+
+func (this *testApplier) record(op string) {
+	this.ops = append(this.ops, op+"@"+this.ns.current)
+}
+
+func (this *testApplier) ListRoutes() (kubelink.Routes, error) {
+	this.record("list")
+	return this.routes, nil
+}
+
+func (this *testApplier) ListChain(table, chain string) (*iptables.Chain, error) {
+	this.record("chain")
+	return nil, nil
+}
+
+func (this *testApplier) AddRoute(r *netlink.Route) error {
+	this.record("add " + r.Dst.String())
+	return nil
+}
+
+func (this *testApplier) DeleteRoute(r *netlink.Route) error {
+	this.record("delete " + r.Dst.String())
+	return nil
+}
+
+type testImpl struct {
+	ns       *testNamespaces
+	required kubelink.Routes
+	snat     iptables.Requests
+	calls    []string
+}
+
+func (this *testImpl) IsManagedRoute(r *netlink.Route, routes kubelink.Routes) bool {
+	return kubelink.IsKubelinkRoute(r, kubelink.ROUTE_PROTOCOL)
+}
+
+func (this *testImpl) RequiredRoutes() kubelink.Routes {
+	this.calls = append(this.calls, "routes@"+this.ns.current)
+	return this.required
+}
+
+func (this *testImpl) RequiredSNATRules() iptables.Requests       { return this.snat }
+func (this *testImpl) Config(interface{}) *Config                 { return nil }
+func (this *testImpl) Gateway(*v1alpha1.KubeLink) (net.IP, error) { return nil, nil }
+func (this *testImpl) UpdateGateway(*v1alpha1.KubeLink) *string   { return nil }
+
+func testRoute(t *testing.T, cidr string) netlink.Route {
+	_, dst, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return netlink.Route{Dst: dst, Gw: net.ParseIP("10.250.0.2"), LinkIndex: 2, Protocol: kubelink.ROUTE_PROTOCOL}
+}
+
+func TestUpdateNetworkInNamespace(t *testing.T) {
+	ns := &testNamespaces{current: "host"}
+	applier := &testApplier{ns: ns, routes: kubelink.Routes{testRoute(t, "100.64.1.0/24")}}
+	impl := &testImpl{ns: ns, required: kubelink.Routes{testRoute(t, "100.64.2.0/24")}}
+	r := &Reconciler{
+		baseconfig: &Config{NetNS: "kubelink"},
+		ifce:       &kubelink.NodeInterface{Name: "eth0", Index: 2, IP: net.ParseIP("10.250.0.1")},
+		impl:       impl,
+		limiter:    NewLimiter(0),
+		state:      applier,
+		enterNetNS: ns.enter,
+	}
+
+	r.UpdateNetwork(logger.New(), CMD_UPDATE)
+
+	expected := []string{"list@kubelink", "delete 100.64.1.0/24@kubelink", "add 100.64.2.0/24@kubelink"}
+	if len(applier.ops) != len(expected) {
+		t.Fatalf("unexpected operations: %v", applier.ops)
+	}
+	for i, op := range expected {
+		if applier.ops[i] != op {
+			t.Errorf("operation %d: expected %q, got %q", i, op, applier.ops[i])
+		}
+	}
+	if len(impl.calls) != 1 || impl.calls[0] != "routes@kubelink" {
+		t.Errorf("required routes not determined in namespace: %v", impl.calls)
+	}
+
+	applier.ops = nil
+	if _, err := r.Drift(); err != nil {
+		t.Fatal(err)
+	}
+	if len(applier.ops) != 1 || applier.ops[0] != "list@kubelink" {
+		t.Errorf("drift not determined in namespace: %v", applier.ops)
+	}
+}
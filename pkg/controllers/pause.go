@@ -0,0 +1,146 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gardener/controller-manager-library/pkg/server"
+)
+
+// Pausable is a controller whose network updates can be suspended
+// for maintenance.
+type Pausable interface {
+	Pause()
+	Resume()
+	IsPaused() bool
+}
+
+var pauseLock sync.Mutex
+var pausables = map[string]Pausable{}
+var pauseTokens = map[string]string{}
+var pauseOnce sync.Once
+
+// RegisterPausable registers a controller to be reported by the
+// /maintenance endpoint. Its state can only be changed by requests
+// carrying the given bearer token. An empty token disables changes.
+func RegisterPausable(name string, p Pausable, token string) {
+	pauseLock.Lock()
+	defer pauseLock.Unlock()
+	pausables[name] = p
+	pauseTokens[name] = token
+	pauseOnce.Do(func() {
+		server.Register("/maintenance", handleMaintenance)
+	})
+}
+
+// handleMaintenance reports the pause state of all controllers.
+// A POST request with the query parameter action (pause or resume)
+// changes the state of all controllers or the one given by the
+// query parameter controller, if enabled by option maintenance-api.
+// It must be authorized by the bearer token of the controllers.
+func handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	pauseLock.Lock()
+	defer pauseLock.Unlock()
+
+	if r.Method == http.MethodPost {
+		query := r.URL.Query()
+		name := query.Get("controller")
+		if name != "" && pausables[name] == nil {
+			http.Error(w, fmt.Sprintf("unknown controller %q", name), http.StatusNotFound)
+			return
+		}
+		enabled := false
+		authorized := map[string]Pausable{}
+		for n, p := range pausables {
+			if pauseTokens[n] != "" && (name == "" || n == name) {
+				enabled = true
+				if BearerAuthorized(r, pauseTokens[n]) {
+					authorized[n] = p
+				}
+			}
+		}
+		if !enabled {
+			http.Error(w, "maintenance api not enabled", http.StatusForbidden)
+			return
+		}
+		if len(authorized) == 0 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		action := query.Get("action")
+		if action != "pause" && action != "resume" {
+			http.Error(w, fmt.Sprintf("invalid action %q: use pause or resume", action), http.StatusBadRequest)
+			return
+		}
+		for _, p := range authorized {
+			if action == "pause" {
+				p.Pause()
+			} else {
+				p.Resume()
+			}
+		}
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	names := []string{}
+	for n := range pausables {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := map[string]bool{}
+	for _, n := range names {
+		result[n] = pausables[n].IsPaused()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]interface{}{"paused": result})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Pause suspends the update of routes and firewall rules. Links and
+// connections are still maintained.
+func (this *Reconciler) Pause() {
+	if atomic.CompareAndSwapInt32(&this.paused, 0, 1) {
+		this.controller.Infof("network updates paused")
+	}
+}
+
+// Resume resumes the update of routes and firewall rules and triggers
+// a complete update according to the actual link state.
+func (this *Reconciler) Resume() {
+	if atomic.CompareAndSwapInt32(&this.paused, 1, 0) {
+		this.controller.Infof("network updates resumed")
+		this.TriggerUpdate()
+	}
+}
+
+// IsPaused reports whether network updates are paused.
+func (this *Reconciler) IsPaused() bool {
+	return atomic.LoadInt32(&this.paused) != 0
+}
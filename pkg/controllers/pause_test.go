@@ -0,0 +1,88 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testPausable struct {
+	paused bool
+}
+
+func (this *testPausable) Pause()         { this.paused = true }
+func (this *testPausable) Resume()        { this.paused = false }
+func (this *testPausable) IsPaused() bool { return this.paused }
+
+func maintenance(query, token string) int {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/maintenance?"+query, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	handleMaintenance(w, r)
+	return w.Code
+}
+
+func TestMaintenanceOptIn(t *testing.T) {
+	disabled := &testPausable{}
+	RegisterPausable("disabled", disabled, "")
+
+	if code := maintenance("action=pause", "secret"); code != http.StatusForbidden {
+		t.Errorf("pause without enabled controller: got status %d", code)
+	}
+	if code := maintenance("action=pause&controller=disabled", "secret"); code != http.StatusForbidden {
+		t.Errorf("pause of disabled controller: got status %d", code)
+	}
+	if disabled.IsPaused() {
+		t.Errorf("disabled controller paused")
+	}
+
+	enabled := &testPausable{}
+	RegisterPausable("enabled", enabled, "secret")
+	if code := maintenance("action=pause", "secret"); code != http.StatusOK {
+		t.Errorf("pause: got status %d", code)
+	}
+	if !enabled.IsPaused() || disabled.IsPaused() {
+		t.Errorf("unexpected pause state: enabled %t, disabled %t", enabled.IsPaused(), disabled.IsPaused())
+	}
+}
+
+func TestMaintenanceUnauthorized(t *testing.T) {
+	protected := &testPausable{}
+	RegisterPausable("protected", protected, "token")
+
+	if code := maintenance("action=pause&controller=protected", ""); code != http.StatusUnauthorized {
+		t.Errorf("pause without token: got status %d", code)
+	}
+	if code := maintenance("action=pause&controller=protected", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("pause with wrong token: got status %d", code)
+	}
+	if protected.IsPaused() {
+		t.Errorf("controller paused by unauthorized request")
+	}
+	if code := maintenance("action=pause&controller=protected", "token"); code != http.StatusOK {
+		t.Errorf("pause with token: got status %d", code)
+	}
+	if !protected.IsPaused() {
+		t.Errorf("controller not paused")
+	}
+}
@@ -92,7 +92,15 @@ type Reconciler struct {
 	ifce  *kubelink.NodeInterface
 	links *kubelink.Links
 
-	impl ReconcilerImplementation
+	impl    ReconcilerImplementation
+	limiter *Limiter
+
+	// state is used to read and modify the routes of the node and
+	// enterNetNS to switch to the configured network namespace.
+	state      StateApplier
+	enterNetNS func(name string, f func()) error
+
+	paused int32
 }
 
 var _ reconcile.Interface = &Reconciler{}
@@ -111,10 +119,23 @@ func (this *Reconciler) Links() *kubelink.Links {
 	return this.links
 }
 
+// Limiter returns the limiter bounding the concurrent reconcile
+// operations of the controller.
+func (this *Reconciler) Limiter() *Limiter {
+	return this.limiter
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
 func (this *Reconciler) Setup() {
 	this.links.Setup(this.controller, this.controller.GetMainCluster())
+	RegisterEffectiveConfig(this.controller.GetName(), this.config)
+	token := ""
+	if this.baseconfig.MaintenanceAPI {
+		token = this.baseconfig.MaintenanceToken
+	}
+	RegisterPausable(this.controller.GetName(), this, token)
+	RegisterDrift(this.controller.GetName(), this)
 	this.controller.Infof("setup done")
 }
 
@@ -128,15 +149,22 @@ func (this *Reconciler) Reconcile(logger logger.LogContext, obj resources.Object
 
 func (this *Reconciler) ReconcileLink(logger logger.LogContext, obj resources.Object,
 	updater func(logger logger.LogContext, link *v1alpha1.KubeLink, entry *kubelink.Link) (error, error)) reconcile.Status {
+	this.limiter.Acquire()
+	defer this.limiter.Release()
+	start := time.Now()
 	_, status := this.ReconcileAngGetLink(logger, obj, updater)
-	return status
+	return this.Observe("reconcile", start, status)
 }
 
 func (this *Reconciler) ReconcileAngGetLink(logger logger.LogContext, obj resources.Object,
 	updater func(logger logger.LogContext, link *v1alpha1.KubeLink, entry *kubelink.Link) (error, error)) (*kubelink.Link, reconcile.Status) {
 	orig := obj.Data().(*v1alpha1.KubeLink)
 	link := orig
-	logger.Infof("reconcile cidr %s[gateway %s]", link.Spec.CIDR, link.Status.Gateway)
+	if link.Spec.Description != "" {
+		logger.Infof("reconcile cidr %s[gateway %s] (%s)", link.Spec.CIDR, link.Status.Gateway, link.Spec.Description)
+	} else {
+		logger.Infof("reconcile cidr %s[gateway %s]", link.Spec.CIDR, link.Status.Gateway)
+	}
 
 	gateway, err := this.impl.Gateway(link)
 	if gateway != nil {
@@ -151,7 +179,11 @@ func (this *Reconciler) ReconcileAngGetLink(logger logger.LogContext, obj resour
 	var ldata *kubelink.Link
 	var uerr error
 	if err == nil {
-		ldata, invalid = this.links.UpdateLink(link)
+		ldata, invalid = this.links.UpdateLink(logger, link)
+		if invalid == nil {
+			this.triggerEgressConflicts(link.Name)
+		}
+		this.triggerIngressConflicts(link.Name)
 		if updater != nil {
 			uerr, err = updater(logger, link, ldata)
 		}
@@ -181,6 +213,8 @@ func (this *Reconciler) updateLink(logger logger.LogContext, klink *v1alpha1.Kub
 	msg := klink.Status.Message
 	state := klink.Status.State
 
+	errTime := klink.Status.LastErrorTime
+
 	gw := this.impl.UpdateGateway(klink)
 
 	if err != nil || invalid != nil {
@@ -191,10 +225,17 @@ func (this *Reconciler) updateLink(logger logger.LogContext, klink *v1alpha1.Kub
 			state = v1alpha1.STATE_ERROR
 			msg = err.Error()
 		}
+		if msg != klink.Status.Message || errTime == nil {
+			errTime = errorTime(err)
+		}
 	} else {
 		if gw != nil && *gw != "" {
 			state = v1alpha1.STATE_UP
 			msg = ""
+			errTime = nil
+		}
+		if p := this.links.IngressConflict(klink.Name); p != "" {
+			msg = fmt.Sprintf("ingress conflicts with link %s using the same cluster address", p)
 		}
 	}
 
@@ -216,6 +257,12 @@ func (this *Reconciler) updateLink(logger logger.LogContext, klink *v1alpha1.Kub
 			klink.Status.Message = msg
 		}
 	}
+	if !errTime.Equal(klink.Status.LastErrorTime) {
+		mod = true
+		if update {
+			klink.Status.LastErrorTime = errTime
+		}
+	}
 	if gw != nil && klink.Status.Gateway != *gw {
 		mod = true
 		if logger != nil {
@@ -228,18 +275,55 @@ func (this *Reconciler) updateLink(logger logger.LogContext, klink *v1alpha1.Kub
 	return mod
 }
 
+// errorTime returns the time an error occurred, if provided by the
+// error, or the actual time.
+func errorTime(err error) *meta.Time {
+	if e, ok := err.(interface{ Timestamp() time.Time }); ok {
+		t := meta.NewTime(e.Timestamp())
+		return &t
+	}
+	t := meta.Now()
+	return &t
+}
+
 func (this *Reconciler) Delete(logger logger.LogContext, obj resources.Object) reconcile.Status {
+	this.limiter.Acquire()
+	defer this.limiter.Release()
+	start := time.Now()
 	logger.Infof("delete")
 	this.links.RemoveLink(obj.GetName())
+	this.triggerEgressConflicts(obj.GetName())
+	this.triggerIngressConflicts(obj.GetName())
 	this.TriggerUpdate()
-	return reconcile.Succeeded(logger)
+	return this.Observe("delete", start, reconcile.Succeeded(logger))
 }
 
 func (this *Reconciler) Deleted(logger logger.LogContext, key resources.ClusterObjectKey) reconcile.Status {
+	this.limiter.Acquire()
+	defer this.limiter.Release()
+	start := time.Now()
 	logger.Infof("deleted")
 	this.links.RemoveLink(key.Name())
+	this.triggerEgressConflicts(key.Name())
+	this.triggerIngressConflicts(key.Name())
 	this.TriggerUpdate()
-	return reconcile.Succeeded(logger)
+	return this.Observe("delete", start, reconcile.Succeeded(logger))
+}
+
+// triggerEgressConflicts revalidates the links rejected because of
+// an egress conflict with the given link.
+func (this *Reconciler) triggerEgressConflicts(name string) {
+	for _, n := range this.links.EgressConflicts(name) {
+		this.TriggerLink(n)
+	}
+}
+
+// triggerIngressConflicts updates the status of the links whose
+// ingress conflict has been changed by an update of the given link.
+func (this *Reconciler) triggerIngressConflicts(name string) {
+	for _, n := range this.links.IngressConflictUpdates(name) {
+		this.TriggerLink(n)
+	}
 }
 
 func String(r netlink.Route) string {
@@ -251,6 +335,9 @@ const IPTAB = "nat"
 func (this *Reconciler) updateSNATRules(logger logger.LogContext) error {
 	reqs := this.impl.RequiredSNATRules()
 
+	if this.baseconfig.IPTablesRestore {
+		return this.IPT.Restore(logger, reqs)
+	}
 	for _, r := range reqs {
 		err := this.IPT.Execute(logger, r)
 		if err != nil {
@@ -261,17 +348,43 @@ func (this *Reconciler) updateSNATRules(logger logger.LogContext) error {
 }
 
 func (this *Reconciler) Command(logger logger.LogContext, cmd string) reconcile.Status {
+	this.limiter.Acquire()
+	defer this.limiter.Release()
+	return this.Observe("command", time.Now(), this.UpdateNetwork(logger, cmd))
+}
+
+// UpdateNetwork updates the routes and rules of the node according to
+// the actual link set.
+func (this *Reconciler) UpdateNetwork(logger logger.LogContext, cmd string) reconcile.Status {
+	if this.IsPaused() {
+		logger.Infof("network updates paused -> skip update")
+		return reconcile.Succeeded(logger)
+	}
+	var status reconcile.Status
+	err := this.InNetworkNamespace(func() {
+		status = this.command(logger, cmd)
+	})
+	if err != nil {
+		return reconcile.Delay(logger, err)
+	}
+	return status
+}
+
+func (this *Reconciler) command(logger logger.LogContext, cmd string) reconcile.Status {
 	logger.Debug("update rules")
 	err := this.updateSNATRules(logger)
 	if err != nil {
 		logger.Errorf("cannot update iptables rules: %s", err)
 	}
 	logger.Debug("update routes")
-	routes, err := kubelink.ListRoutes()
+	routes, err := this.state.ListRoutes()
 	if err != nil {
 		return reconcile.Delay(logger, err)
 	}
-	required := this.impl.RequiredRoutes()
+	required, _, err := this.impl.RequiredRoutes().Protect(this.ProtectedCIDRs())
+	if err != nil {
+		logger.Errorf("%s", err)
+	}
 	mcnt := 0
 	dcnt := 0
 	ocnt := 0
@@ -282,9 +395,8 @@ func (this *Reconciler) Command(logger logger.LogContext, cmd string) reconcile.
 			mcnt++
 			if required.Lookup(r) < 0 {
 				dcnt++
-				r.String()
 				n.Add(dcnt > 0, "obsolete    %3d: %s", i, String(r))
-				err := netlink.RouteDel(&r)
+				err := this.state.DeleteRoute(&r)
 				if err != nil {
 					logger.Errorf("cannot delete route %s: %s", String(r), err)
 				}
@@ -301,7 +413,7 @@ func (this *Reconciler) Command(logger logger.LogContext, cmd string) reconcile.
 		if o := routes.Lookup(r); o < 0 {
 			ccnt++
 			n.Add(true, "missing    *%3d: %s", i, String(r))
-			err := netlink.RouteAdd(&r)
+			err := this.state.AddRoute(&r)
 			if err != nil {
 				logger.Errorf("cannot add route %s: %s", String(r), err)
 			}
@@ -313,7 +425,27 @@ func (this *Reconciler) Command(logger logger.LogContext, cmd string) reconcile.
 	return reconcile.Succeeded(logger)
 }
 
+// ProtectedCIDRs returns the networks never shadowed by link routes:
+// the node address and the configured protected networks.
+func (this *Reconciler) ProtectedCIDRs() tcp.CIDRList {
+	ip := this.NodeInterface().IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	protected := tcp.CIDRList{&net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}}
+	return append(protected, this.baseconfig.ProtectedCIDRs...)
+}
+
+// WaitIPIP waits for the shared ip-over-ip device tunl0 in the
+// network namespace used for the routes.
 func (this *Reconciler) WaitIPIP() {
+	err := this.InNetworkNamespace(this.waitIPIP)
+	if err != nil {
+		this.Controller().Errorf("%s", err)
+	}
+}
+
+func (this *Reconciler) waitIPIP() {
 	msg := ""
 	d := 10 * time.Second
 	for {
@@ -348,7 +480,20 @@ func (this *Reconciler) WaitIPIP() {
 	}
 }
 
+// SetupIPIP configures the ip-over-ip device tunl0 in the network
+// namespace used for the routes.
 func (this *Reconciler) SetupIPIP() error {
+	var err error
+	nerr := this.InNetworkNamespace(func() {
+		err = this.setupIPIP()
+	})
+	if nerr != nil {
+		return nerr
+	}
+	return err
+}
+
+func (this *Reconciler) setupIPIP() error {
 	link := &netlink.Iptun{LinkAttrs: netlink.LinkAttrs{Name: "tunl0"}}
 	err := netlink.LinkAdd(link)
 	if err != nil && err != syscall.EEXIST {
@@ -19,7 +19,10 @@
 package router
 
 import (
+	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/gardener/controller-manager-library/pkg/config"
 
@@ -32,11 +35,16 @@ type Config struct {
 	podcidr string
 
 	PodCIDR *net.IPNet
+
+	GatewayUnreachable   string
+	GatewayCheckInterval time.Duration
 }
 
 func (this *Config) AddOptionsToSet(set config.OptionSet) {
 	this.Config.AddOptionsToSet(set)
 	set.AddStringOption(&this.podcidr, "pod-cidr", "", "", "CIDR of pod network of cluster")
+	set.AddStringOption(&this.GatewayUnreachable, "gateway-unreachable", "", GATEWAY_IGNORE, "Handling of link routes whose gateway neighbor is unreachable (ignore, withdraw or blackhole)")
+	set.AddDurationOption(&this.GatewayCheckInterval, "gateway-check-interval", "", 10*time.Second, "Interval for checking the neighbor state of link gateways")
 }
 
 func (this *Config) Prepare() error {
@@ -49,5 +57,14 @@ func (this *Config) Prepare() error {
 	if err != nil {
 		return err
 	}
+	this.GatewayUnreachable = strings.ToLower(this.GatewayUnreachable)
+	switch this.GatewayUnreachable {
+	case GATEWAY_IGNORE, GATEWAY_WITHDRAW, GATEWAY_BLACKHOLE:
+	default:
+		return fmt.Errorf("invalid gateway unreachable mode: %s", this.GatewayUnreachable)
+	}
+	if this.GatewayCheckInterval <= 0 {
+		return fmt.Errorf("invalid gateway check interval %s", this.GatewayCheckInterval)
+	}
 	return nil
 }
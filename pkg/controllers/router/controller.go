@@ -43,6 +43,7 @@ func Create(controller controller.Interface) (reconcile.Interface, error) {
 		return nil, err
 	}
 	this.config = this.Reconciler.Config().(*Config)
+	this.gateways = NewGatewayMonitor(NodeNeighbors(this.NodeInterface().Index), UDPProbe)
 
 	controller.Infof("using cidr for pods:  %s", this.config.PodCIDR)
 	return this, nil
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package router
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+const GATEWAY_IGNORE = "ignore"
+const GATEWAY_WITHDRAW = "withdraw"
+const GATEWAY_BLACKHOLE = "blackhole"
+
+// NeighborSource provides the neighbor entries of the node.
+type NeighborSource func() ([]netlink.Neigh, error)
+
+// GatewayProbe triggers the neighbor resolution of a gateway.
+type GatewayProbe func(ip net.IP)
+
+// NUD_CONFIRMED are the neighbor states confirming a gateway to be
+// reachable again.
+const NUD_CONFIRMED = netlink.NUD_REACHABLE | netlink.NUD_STALE | netlink.NUD_PERMANENT | netlink.NUD_NOARP
+
+// GatewayMonitor tracks gateways whose neighbor resolution failed.
+// Routes using such gateways may be withdrawn, so there is no traffic
+// resolving the neighbor anymore. Therefore unreachable gateways are
+// probed until the neighbor is confirmed to be reachable again.
+type GatewayMonitor struct {
+	lock        sync.RWMutex
+	source      NeighborSource
+	probe       GatewayProbe
+	unreachable map[string]bool
+	used        map[string]bool
+}
+
+func NewGatewayMonitor(source NeighborSource, probe GatewayProbe) *GatewayMonitor {
+	return &GatewayMonitor{
+		source:      source,
+		probe:       probe,
+		unreachable: map[string]bool{},
+		used:        map[string]bool{},
+	}
+}
+
+// NodeNeighbors returns a neighbor source for the given interface.
+func NodeNeighbors(index int) NeighborSource {
+	return func() ([]netlink.Neigh, error) {
+		return netlink.NeighList(index, nl.FAMILY_V4)
+	}
+}
+
+// UDPProbe sends an empty datagram to the discard port of a gateway
+// to trigger its neighbor resolution.
+func UDPProbe(ip net.IP) {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: 9})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write(nil)
+}
+
+// Update reads the actual neighbor states and probes the unreachable
+// gateways still used by routes. It returns whether the set of
+// unreachable gateways changed.
+func (this *GatewayMonitor) Update() (bool, error) {
+	neighs, err := this.source()
+	if err != nil {
+		return false, err
+	}
+	states := map[string]int{}
+	unreachable := map[string]bool{}
+	for _, n := range neighs {
+		if n.IP != nil {
+			states[n.IP.String()] = n.State
+			if n.State&netlink.NUD_FAILED != 0 {
+				unreachable[n.IP.String()] = true
+			}
+		}
+	}
+	this.lock.Lock()
+	for ip := range this.unreachable {
+		if this.used[ip] && states[ip]&NUD_CONFIRMED == 0 {
+			unreachable[ip] = true
+		}
+	}
+	changed := len(unreachable) != len(this.unreachable)
+	for ip := range unreachable {
+		if !this.unreachable[ip] {
+			changed = true
+		}
+	}
+	this.unreachable = unreachable
+	this.lock.Unlock()
+
+	if this.probe != nil {
+		for ip := range unreachable {
+			this.probe(net.ParseIP(ip))
+		}
+	}
+	return changed, nil
+}
+
+// Reachable reports whether a gateway is not known to be unreachable.
+func (this *GatewayMonitor) Reachable(ip net.IP) bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return !this.unreachable[ip.String()]
+}
+
+// Unreachable returns the gateways known to be unreachable.
+func (this *GatewayMonitor) Unreachable() []string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	var result []string
+	for ip := range this.unreachable {
+		result = append(result, ip)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Apply withdraws or blackholes the routes using an unreachable gateway.
+// The gateways used by the routes are kept to be probed while unreachable.
+func (this *GatewayMonitor) Apply(routes kubelink.Routes, mode string) kubelink.Routes {
+	used := map[string]bool{}
+	for _, r := range routes {
+		if r.Gw != nil {
+			used[r.Gw.String()] = true
+		}
+	}
+	this.lock.Lock()
+	this.used = used
+	this.lock.Unlock()
+
+	if mode == GATEWAY_IGNORE {
+		return routes
+	}
+	result := kubelink.Routes{}
+	for _, r := range routes {
+		if r.Gw == nil || this.Reachable(r.Gw) {
+			result = append(result, r)
+			continue
+		}
+		if mode == GATEWAY_BLACKHOLE {
+			result.Add(kubelink.NewBlackholeRoute(r.Dst, kubelink.ROUTE_PROTOCOL))
+		}
+	}
+	return result
+}
+
+// monitorGateways periodically checks the neighbor state of the link
+// gateways and triggers a route update if it changed.
+func (this *reconciler) monitorGateways(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.Controller().GetContext().Done():
+			return
+		case <-ticker.C:
+		}
+		var changed bool
+		var err error
+		nerr := this.InNetworkNamespace(func() {
+			changed, err = this.gateways.Update()
+		})
+		if nerr != nil {
+			err = nerr
+		}
+		if err != nil {
+			this.Controller().Warnf("cannot read neighbor states: %s", err)
+			continue
+		}
+		if changed {
+			if u := this.gateways.Unreachable(); len(u) > 0 {
+				this.Controller().Warnf("unreachable gateways: %s", strings.Join(u, ", "))
+			} else {
+				this.Controller().Infof("all gateways reachable again")
+			}
+			this.TriggerUpdate()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package router
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/mandelsoft/kubelink/pkg/kubelink"
+)
+
+func TestWithdrawnGatewayProbed(t *testing.T) {
+	gw := net.ParseIP("10.250.0.1")
+	var neighs []netlink.Neigh
+	var probed []string
+	m := NewGatewayMonitor(
+		func() ([]netlink.Neigh, error) { return neighs, nil },
+		func(ip net.IP) { probed = append(probed, ip.String()) },
+	)
+	_, dst, _ := net.ParseCIDR("100.64.1.0/24")
+	routes := kubelink.Routes{netlink.Route{Dst: dst, Gw: gw}}
+	m.Apply(routes, GATEWAY_WITHDRAW)
+
+	neighs = []netlink.Neigh{{IP: gw, State: netlink.NUD_FAILED}}
+	if changed, _ := m.Update(); !changed || m.Reachable(gw) {
+		t.Fatalf("failed gateway not detected")
+	}
+	if r := m.Apply(routes, GATEWAY_WITHDRAW); len(r) != 0 {
+		t.Errorf("route not withdrawn")
+	}
+
+	// the neighbor entry expires without traffic
+	neighs = nil
+	probed = nil
+	if changed, _ := m.Update(); changed || m.Reachable(gw) {
+		t.Errorf("withdrawn gateway considered reachable")
+	}
+	if len(probed) != 1 || probed[0] != gw.String() {
+		t.Errorf("withdrawn gateway not probed: %v", probed)
+	}
+
+	neighs = []netlink.Neigh{{IP: gw, State: netlink.NUD_REACHABLE}}
+	if changed, _ := m.Update(); !changed || !m.Reachable(gw) {
+		t.Errorf("gateway not reachable again")
+	}
+}
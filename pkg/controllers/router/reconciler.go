@@ -33,7 +33,8 @@ import (
 
 type reconciler struct {
 	*controllers.Reconciler
-	config *Config
+	config   *Config
+	gateways *GatewayMonitor
 }
 
 var _ reconcile.Interface = &reconciler{}
@@ -54,6 +55,9 @@ func (this *reconciler) UpdateGateway(link *v1alpha1.KubeLink) *string {
 }
 
 func (this *reconciler) IsManagedRoute(route *netlink.Route, routes kubelink.Routes) bool {
+	if kubelink.IsKubelinkRoute(route, kubelink.ROUTE_PROTOCOL) {
+		return true
+	}
 	if route.Dst != nil {
 		if this.config.PodCIDR.Contains(route.Dst.IP) {
 			return false
@@ -71,7 +75,7 @@ func (this *reconciler) IsManagedRoute(route *netlink.Route, routes kubelink.Rou
 }
 
 func (this *reconciler) RequiredRoutes() kubelink.Routes {
-	return this.Links().GetRoutes(this.NodeInterface())
+	return this.gateways.Apply(this.Links().GetRoutes(this.NodeInterface()), this.config.GatewayUnreachable)
 }
 
 func (this *reconciler) RequiredSNATRules() iptables.Requests {
@@ -91,3 +95,10 @@ func (this *reconciler) Setup() {
 	}
 	this.Reconciler.Setup()
 }
+
+func (this *reconciler) Start() {
+	if this.config.GatewayUnreachable != GATEWAY_IGNORE {
+		go this.monitorGateways(this.config.GatewayCheckInterval)
+	}
+	this.Reconciler.Start()
+}
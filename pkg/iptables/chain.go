@@ -39,6 +39,30 @@ func (this *Chain) Add(r Rule) *Chain {
 	return this
 }
 
+// Diff compares the chain with the actual chain. It returns the
+// rules missing in the actual chain and, if unmanaged rules should be
+// cleaned up, the unexpected rules found there. A nil actual chain
+// means the chain does not exist.
+func (this *Chain) Diff(actual *Chain, cleanup bool) (missing Rules, unexpected Rules) {
+	var cur Rules
+	if actual != nil {
+		cur = actual.Rules
+	}
+	for _, r := range this.Rules {
+		if cur.Index(r) < 0 {
+			missing = append(missing, r)
+		}
+	}
+	if cleanup {
+		for _, r := range cur {
+			if this.Rules.Index(r) < 0 {
+				unexpected = append(unexpected, r)
+			}
+		}
+	}
+	return missing, unexpected
+}
+
 func (this *Chain) update(logger logger.LogContext, ipt *IPTables, cleanup bool) error {
 	if this == nil {
 		return nil
@@ -97,3 +121,44 @@ func (this *Chain) update(logger logger.LogContext, ipt *IPTables, cleanup bool)
 	logger.Infof("chain %s/%s: %d managed (%d deleted) and %d created rules", this.Table, this.Chain, len(this.Rules), dcnt, ccnt)
 	return nil
 }
+
+// rebuild assures the chain to contain exactly the rules in the given
+// order. If the actual chain differs it is flushed and completely
+// rebuilt.
+func (this *Chain) rebuild(logger logger.LogContext, ipt *IPTables) error {
+	chains, err := ipt.ListChains(this.Table)
+	if err != nil {
+		return err
+	}
+	if StringList(chains).Index(this.Chain) >= 0 {
+		cur, err := ipt.ListChain(this.Table, this.Chain)
+		if err != nil {
+			return err
+		}
+		if len(cur.Rules) == len(this.Rules) {
+			equal := true
+			for i, r := range this.Rules {
+				if !r.Equals(cur.Rules[i]) {
+					equal = false
+					break
+				}
+			}
+			if equal {
+				logger.Infof("chain %s/%s: %d managed rules up to date", this.Table, this.Chain, len(this.Rules))
+				return nil
+			}
+		}
+	}
+	err = ipt.ClearChain(this.Table, this.Chain)
+	if err != nil {
+		return err
+	}
+	for _, r := range this.Rules {
+		err = ipt.AppendRule(this.Table, this.Chain, r)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Infof("chain %s/%s: rebuilt with %d rules", this.Table, this.Chain, len(this.Rules))
+	return nil
+}
@@ -58,6 +58,9 @@ func (this *IPTables) ListChain(table, chain string) (*Chain, error) {
 }
 
 func (this *IPTables) Execute(logger logger.LogContext, req *ChainRequest) error {
+	if req.Ordered {
+		return req.rebuild(logger, this)
+	}
 	return req.update(logger, this, req.Cleanup)
 }
 
@@ -21,6 +21,9 @@ package iptables
 type ChainRequest struct {
 	*Chain
 	Cleanup bool
+	// Ordered requests the rules to be kept in the given order.
+	// The chain is rebuilt if the actual order differs.
+	Ordered bool
 }
 
 func NewChainRequest(table, chain string, rules Rules, cleanup bool) *ChainRequest {
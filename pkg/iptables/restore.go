@@ -0,0 +1,112 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+// Split separates the requests into those completely defining their
+// chain (cleanup), which can be applied by a restore, and the rest,
+// which must be applied incrementally to keep foreign rules.
+func (this Requests) Split() (restore Requests, incremental Requests) {
+	for _, r := range this {
+		if r.Cleanup {
+			restore = append(restore, r)
+		} else {
+			incremental = append(incremental, r)
+		}
+	}
+	return
+}
+
+// RestoreData serializes the requests in iptables-restore format.
+// It is intended to be used with the --noflush option, which
+// only flushes the declared chains.
+func (this Requests) RestoreData() []byte {
+	buf := &bytes.Buffer{}
+	var tables []string
+	chains := map[string]Requests{}
+	for _, r := range this {
+		if _, ok := chains[r.Table]; !ok {
+			tables = append(tables, r.Table)
+		}
+		chains[r.Table] = append(chains[r.Table], r)
+	}
+	for _, t := range tables {
+		fmt.Fprintf(buf, "*%s\n", t)
+		for _, r := range chains[t] {
+			fmt.Fprintf(buf, ":%s - [0:0]\n", r.Chain.Chain)
+		}
+		for _, r := range chains[t] {
+			for _, rule := range r.Rules {
+				fmt.Fprintf(buf, "-A %s", r.Chain.Chain)
+				for _, a := range rule.AsList() {
+					fmt.Fprintf(buf, " %s", quote(a))
+				}
+				fmt.Fprintf(buf, "\n")
+			}
+		}
+		fmt.Fprintf(buf, "COMMIT\n")
+	}
+	return buf.Bytes()
+}
+
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'") {
+		return s
+	}
+	return "\"" + strings.ReplaceAll(s, "\"", "\\\"") + "\""
+}
+
+// Restore applies the requests. Chains completely defined by a request
+// are atomically replaced with a single iptables-restore call, the
+// other requests are executed incrementally.
+func (this *IPTables) Restore(logger logger.LogContext, reqs Requests) error {
+	restore, incremental := reqs.Split()
+	if len(restore) > 0 {
+		cmd := "iptables-restore"
+		if this.Proto() == iptables.ProtocolIPv6 {
+			cmd = "ip6tables-restore"
+		}
+		data := restore.RestoreData()
+		stderr := &bytes.Buffer{}
+		c := exec.Command(cmd, "--noflush")
+		c.Stdin = bytes.NewReader(data)
+		c.Stderr = stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("%s failed: %s: %s", cmd, err, strings.TrimSpace(stderr.String()))
+		}
+		for _, r := range restore {
+			logger.Infof("chain %s/%s: restored %d rules", r.Table, r.Chain.Chain, len(r.Rules))
+		}
+	}
+	for _, r := range incremental {
+		if err := this.Execute(logger, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
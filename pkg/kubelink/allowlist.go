@@ -0,0 +1,106 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// EndpointAllowlist restricts the endpoint hosts a link may direct
+// the broker to. Entries are CIDRs, IP addresses, host names or
+// domain wildcards (*.<domain>).
+type EndpointAllowlist struct {
+	cidrs   tcp.CIDRList
+	hosts   map[string]bool
+	domains []string
+}
+
+func ParseEndpointAllowlist(entries []string) (*EndpointAllowlist, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	list := &EndpointAllowlist{hosts: map[string]bool{}}
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if strings.Contains(e, "/") {
+			_, cidr, err := net.ParseCIDR(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowlist cidr %q: %s", e, err)
+			}
+			list.cidrs.Add(cidr)
+			continue
+		}
+		if ip := net.ParseIP(e); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			list.cidrs.Add(&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if strings.HasPrefix(e, "*.") {
+			if len(e) == 2 {
+				return nil, fmt.Errorf("invalid allowlist domain %q", e)
+			}
+			list.domains = append(list.domains, e[1:])
+			continue
+		}
+		list.hosts[strings.TrimSuffix(e, ".")] = true
+	}
+	return list, nil
+}
+
+// Allows checks whether the given endpoint host (without port) is
+// covered by the allowlist. A nil allowlist allows all hosts.
+// Host names are only matched by name, IP literals only by address.
+func (this *EndpointAllowlist) Allows(host string) bool {
+	if this == nil {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return this.cidrs.Contains(ip)
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if this.hosts[host] {
+		return true
+	}
+	for _, d := range this.domains {
+		if strings.HasSuffix(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// EndpointHost returns the host part of an endpoint.
+func EndpointHost(endpoint string) string {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return strings.Trim(endpoint, "[]")
+	}
+	return host
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+func TestParseEndpointAllowlist(t *testing.T) {
+	cases := map[string]struct {
+		entries []string
+		valid   bool
+	}{
+		"empty":          {nil, true},
+		"mixed":          {[]string{"10.0.0.0/8", "192.168.1.1", "fd00::1", "a.example.com", "*.example.org"}, true},
+		"blank entry":    {[]string{" ", "a.example.com"}, true},
+		"invalid cidr":   {[]string{"10.0.0.0/33"}, false},
+		"invalid domain": {[]string{"*."}, false},
+	}
+	for name, c := range cases {
+		_, err := ParseEndpointAllowlist(c.entries)
+		if c.valid && err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: invalid allowlist accepted", name)
+		}
+	}
+}
+
+func TestEndpointAllowlist(t *testing.T) {
+	list, err := ParseEndpointAllowlist([]string{"10.0.0.0/8", "192.168.1.1", "fd00::1", "A.example.com.", "*.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.1.2.3":        true,
+		"192.168.1.1":     true,
+		"192.168.1.2":     false,
+		"fd00::1":         true,
+		"fd00::2":         false,
+		"a.example.com":   true,
+		"a.example.com.":  true,
+		"A.EXAMPLE.COM":   true,
+		"b.example.com":   false,
+		"b.example.org":   true,
+		"x.b.example.org": true,
+		"example.org":     false,
+		"badexample.org":  false,
+		"localhost":       false,
+		"127.0.0.1":       false,
+		"169.254.169.254": false,
+	}
+	for host, allowed := range cases {
+		if got := list.Allows(host); got != allowed {
+			t.Errorf("%s: got allowed %t, expected %t", host, got, allowed)
+		}
+	}
+
+	var none *EndpointAllowlist
+	if !none.Allows("169.254.169.254") {
+		t.Errorf("nil allowlist must allow all hosts")
+	}
+}
+
+func TestEndpointHost(t *testing.T) {
+	cases := map[string]string{
+		"a.example.com:80": "a.example.com",
+		"a.example.com":    "a.example.com",
+		"10.0.0.1:8777":    "10.0.0.1",
+		"[fd00::1]:8777":   "fd00::1",
+		"[fd00::1]":        "fd00::1",
+	}
+	for endpoint, host := range cases {
+		if got := EndpointHost(endpoint); got != host {
+			t.Errorf("%s: got host %q, expected %q", endpoint, got, host)
+		}
+	}
+}
+
+func TestLinkEndpointAllowlist(t *testing.T) {
+	list, err := ParseEndpointAllowlist([]string{"10.0.0.0/8", "fd00::/64", "*.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]struct {
+		endpoint string
+		failover []string
+		allowed  bool
+	}{
+		"hostname":          {"a.example.com:80", nil, true},
+		"hostname no port":  {"a.example.com", nil, true},
+		"ip":                {"10.1.1.1:8777", nil, true},
+		"ipv6":              {"[fd00::1]:8777", nil, true},
+		"failover":          {"a.example.com:80,10.1.1.1", []string{"b.example.com:80"}, true},
+		"foreign hostname":  {"a.example.org:80", nil, false},
+		"foreign ip":        {"169.254.169.254:80", nil, false},
+		"foreign ipv6":      {"[fd01::1]:8777", nil, false},
+		"foreign secondary": {"a.example.com:80,127.0.0.1", nil, false},
+		"foreign failover":  {"a.example.com:80", []string{"internal.local:80"}, false},
+	}
+	links := NewLinks(nil)
+	links.SetEndpointAllowlist(list)
+	for name, c := range cases {
+		kl := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+		kl.Spec.Endpoint = c.endpoint
+		kl.Spec.FailoverEndpoints = c.failover
+		_, err := links.LinkFor(logger.New(), kl)
+		if c.allowed && err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if !c.allowed && err == nil {
+			t.Errorf("%s: endpoint %q accepted", name, c.endpoint)
+		}
+	}
+
+	links.SetEndpointAllowlist(nil)
+	kl := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = "169.254.169.254:80"
+	if _, err := links.LinkFor(logger.New(), kl); err != nil {
+		t.Errorf("no allowlist: %s", err)
+	}
+}
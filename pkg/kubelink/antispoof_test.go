@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/iptables"
+)
+
+func testRules(rules iptables.Rules) []string {
+	var result []string
+	for _, r := range rules {
+		result = append(result, strings.Join(r.AsList(), " "))
+	}
+	return result
+}
+
+func TestAntiSpoofingRules(t *testing.T) {
+	links := NewLinks(nil)
+	a := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+	a.Spec.Egress = []string{"10.1.0.0/16"}
+	b := testKubeLink("b", "192.168.0.12/24", "100.64.2.0/24")
+	for _, kl := range []*v1alpha1.KubeLink{b, a} {
+		if _, err := links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, mesh, _ := net.ParseCIDR("192.168.0.1/24")
+
+	cases := map[string]struct {
+		mesh  *net.IPNet
+		rules []string
+	}{
+		"mesh": {mesh, []string{
+			"-i kubelink -s 192.168.0.0/24 -j RETURN",
+			"-i kubelink -s 100.64.1.0/24 -j RETURN",
+			"-i kubelink -s 10.1.0.0/16 -j RETURN",
+			"-i kubelink -s 100.64.2.0/24 -j RETURN",
+			"-i kubelink -j DROP",
+		}},
+		"no mesh": {nil, []string{
+			"-i kubelink -s 100.64.1.0/24 -j RETURN",
+			"-i kubelink -s 10.1.0.0/16 -j RETURN",
+			"-i kubelink -s 100.64.2.0/24 -j RETURN",
+			"-i kubelink -j DROP",
+		}},
+	}
+	for name, c := range cases {
+		reqs := links.GetAntiSpoofingRules("kubelink", c.mesh)
+		if len(reqs) != 2 {
+			t.Fatalf("%s: expected 2 chain requests, found %d", name, len(reqs))
+		}
+		chain := reqs[0]
+		if chain.Table != "raw" || chain.Chain.Chain != ANTISPOOFING_CHAIN || !chain.Cleanup || !chain.Ordered {
+			t.Errorf("%s: unexpected chain request %s/%s (cleanup %t, ordered %t)", name, chain.Table, chain.Chain.Chain, chain.Cleanup, chain.Ordered)
+		}
+		if got := strings.Join(testRules(chain.Rules), "\n"); got != strings.Join(c.rules, "\n") {
+			t.Errorf("%s: unexpected rules:\n%s", name, got)
+		}
+		jump := reqs[1]
+		if jump.Table != "raw" || jump.Chain.Chain != "PREROUTING" || jump.Cleanup {
+			t.Errorf("%s: unexpected jump request %s/%s (cleanup %t)", name, jump.Table, jump.Chain.Chain, jump.Cleanup)
+		}
+		if got := testRules(jump.Rules); len(got) != 1 || got[0] != "-i kubelink -j "+ANTISPOOFING_CHAIN {
+			t.Errorf("%s: unexpected jump rules %v", name, got)
+		}
+	}
+}
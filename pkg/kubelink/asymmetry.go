@@ -0,0 +1,79 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// AsymmetryRisk describes a link whose egress overlaps the cluster
+// address range of another link. Traffic for the other link's cluster
+// address may then be routed via the wrong link, while return
+// traffic takes the expected path.
+type AsymmetryRisk struct {
+	Link         string
+	Egress       *net.IPNet
+	Other        string
+	OtherAddress *net.IPNet
+}
+
+func (this *AsymmetryRisk) String() string {
+	return fmt.Sprintf("egress %s of link %s overlaps cluster address %s of link %s", this.Egress, this.Link, this.OtherAddress, this.Other)
+}
+
+// DetectAsymmetry reports all pairs of links whose egress and
+// cluster address configuration risks asymmetric routing.
+func (this *Links) DetectAsymmetry() []*AsymmetryRisk {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.detectAsymmetry()
+}
+
+func (this *Links) detectAsymmetry() []*AsymmetryRisk {
+	var risks []*AsymmetryRisk
+	for _, l := range this.links {
+		for _, o := range this.links {
+			if l == o {
+				continue
+			}
+			onet := tcp.CIDRNet(o.ClusterAddress)
+			for _, e := range l.Egress {
+				if tcp.OverlappingCIDR(e, onet) {
+					risks = append(risks, &AsymmetryRisk{
+						Link:         l.Name,
+						Egress:       e,
+						Other:        o.Name,
+						OtherAddress: o.ClusterAddress,
+					})
+				}
+			}
+		}
+	}
+	sort.Slice(risks, func(i, j int) bool {
+		if risks[i].Link != risks[j].Link {
+			return risks[i].Link < risks[j].Link
+		}
+		return risks[i].Other < risks[j].Other
+	})
+	return risks
+}
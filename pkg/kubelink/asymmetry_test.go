@@ -0,0 +1,57 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func TestDetectAsymmetry(t *testing.T) {
+	links := NewLinks(nil)
+	for _, kl := range []*v1alpha1.KubeLink{
+		testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24"),
+		testKubeLink("b", "192.168.0.12/24", "100.64.2.0/24"),
+	} {
+		if _, err := links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if risks := links.DetectAsymmetry(); len(risks) != 0 {
+		t.Errorf("clean configuration reported: %v", risks)
+	}
+
+	a := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+	// overlaps the cluster network of link b without containing its
+	// address, which would be rejected as egress conflict
+	a.Spec.Egress = []string{"192.168.0.128/25"}
+	if _, err := links.UpdateLink(logger.New(), a); err != nil {
+		t.Fatal(err)
+	}
+	risks := links.DetectAsymmetry()
+	if len(risks) != 1 {
+		t.Fatalf("expected one risk, found %v", risks)
+	}
+	if r := risks[0]; r.Link != "a" || r.Other != "b" || r.Egress.String() != "192.168.0.128/25" {
+		t.Errorf("unexpected risk %s", r)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+// MAX_BUFFER_SIZE limits buffer sizes derived from the
+// bandwidth-delay product of a link.
+const MAX_BUFFER_SIZE = 64 * 1024 * 1024
+
+// BandwidthDelayProduct returns the amount of bytes in flight for
+// a connection with the given bandwidth (in bits per second) and
+// round trip time, limited to MAX_BUFFER_SIZE.
+func BandwidthDelayProduct(bandwidth int64, rtt time.Duration) int {
+	bdp := float64(bandwidth) / 8 * rtt.Seconds()
+	if bdp > MAX_BUFFER_SIZE {
+		return MAX_BUFFER_SIZE
+	}
+	return int(bdp)
+}
+
+// linkBuffers determines the socket and read buffer sizes of a link.
+// Explicit sizes take precedence over the socket buffer size derived
+// from the bandwidth-delay product. A size of 0 keeps the default.
+func linkBuffers(spec *v1alpha1.KubeLinkBuffers) (socket int, read int, err error) {
+	if spec == nil {
+		return 0, 0, nil
+	}
+	if spec.RTT != "" || spec.Bandwidth != "" {
+		if spec.RTT == "" || spec.Bandwidth == "" {
+			return 0, 0, fmt.Errorf("rtt and bandwidth are required for buffer sizing")
+		}
+		rtt, err := time.ParseDuration(spec.RTT)
+		if err != nil || rtt <= 0 {
+			return 0, 0, fmt.Errorf("invalid rtt %q", spec.RTT)
+		}
+		bw, err := resource.ParseQuantity(spec.Bandwidth)
+		if err != nil || bw.Sign() <= 0 {
+			return 0, 0, fmt.Errorf("invalid bandwidth %q", spec.Bandwidth)
+		}
+		socket = BandwidthDelayProduct(bw.Value(), rtt)
+	}
+	if spec.SocketBufferSize != nil {
+		if *spec.SocketBufferSize < 0 {
+			return 0, 0, fmt.Errorf("invalid socket buffer size %d", *spec.SocketBufferSize)
+		}
+		socket = *spec.SocketBufferSize
+	}
+	if spec.ReadBufferSize != nil {
+		if *spec.ReadBufferSize < 0 {
+			return 0, 0, fmt.Errorf("invalid read buffer size %d", *spec.ReadBufferSize)
+		}
+		read = *spec.ReadBufferSize
+	}
+	return socket, read, nil
+}
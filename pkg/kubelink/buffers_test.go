@@ -0,0 +1,68 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func TestLinkBuffers(t *testing.T) {
+	size := func(n int) *int { return &n }
+	cases := map[string]struct {
+		buffers *v1alpha1.KubeLinkBuffers
+		socket  int
+		read    int
+		valid   bool
+	}{
+		"default":  {nil, 0, 0, true},
+		"lan":      {&v1alpha1.KubeLinkBuffers{RTT: "1ms", Bandwidth: "1G"}, 125000, 0, true},
+		"wan":      {&v1alpha1.KubeLinkBuffers{RTT: "100ms", Bandwidth: "1G"}, 12500000, 0, true},
+		"limited":  {&v1alpha1.KubeLinkBuffers{RTT: "10s", Bandwidth: "100G"}, MAX_BUFFER_SIZE, 0, true},
+		"explicit": {&v1alpha1.KubeLinkBuffers{RTT: "100ms", Bandwidth: "1G", SocketBufferSize: size(65536), ReadBufferSize: size(4096)}, 65536, 4096, true},
+
+		"missing rtt":      {&v1alpha1.KubeLinkBuffers{Bandwidth: "1G"}, 0, 0, false},
+		"invalid rtt":      {&v1alpha1.KubeLinkBuffers{RTT: "-1ms", Bandwidth: "1G"}, 0, 0, false},
+		"invalid bw":       {&v1alpha1.KubeLinkBuffers{RTT: "1ms", Bandwidth: "fast"}, 0, 0, false},
+		"negative socket":  {&v1alpha1.KubeLinkBuffers{SocketBufferSize: size(-1)}, 0, 0, false},
+		"negative reading": {&v1alpha1.KubeLinkBuffers{ReadBufferSize: size(-1)}, 0, 0, false},
+	}
+	links := NewLinks(nil)
+	for name, c := range cases {
+		kl := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+		kl.Spec.Buffers = c.buffers
+		l, err := links.LinkFor(logger.New(), kl)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%s: invalid buffers accepted", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if l.SocketBuffer != c.socket || l.ReadBuffer != c.read {
+			t.Errorf("%s: got buffers %d/%d, expected %d/%d", name, l.SocketBuffer, l.ReadBuffer, c.socket, c.read)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_HISTORY_SIZE = 100
+
+const HISTORY_ADDED = "added"
+const HISTORY_UPDATED = "updated"
+const HISTORY_REMOVED = "removed"
+
+type HistoryEntry struct {
+	Time    time.Time `json:"time"`
+	Link    string    `json:"link"`
+	Action  string    `json:"action"`
+	Changes []string  `json:"changes,omitempty"`
+}
+
+// History keeps a bounded list of the latest changes of the link table.
+type History struct {
+	lock    sync.Mutex
+	size    int
+	entries []HistoryEntry
+}
+
+func NewHistory(size int) *History {
+	return &History{size: size}
+}
+
+func (this *History) SetSize(size int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.size = size
+	this.trim()
+}
+
+func (this *History) trim() {
+	if len(this.entries) > this.size {
+		this.entries = append(this.entries[:0:0], this.entries[len(this.entries)-this.size:]...)
+	}
+}
+
+func (this *History) add(e HistoryEntry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.size <= 0 {
+		return
+	}
+	this.entries = append(this.entries, e)
+	this.trim()
+}
+
+// Record records the change from an old to a new version of a link.
+// A nil old link records the addition, a nil new link the removal.
+func (this *History) Record(old, new *Link) {
+	switch {
+	case old == nil && new != nil:
+		this.add(HistoryEntry{Time: time.Now(), Link: new.Name, Action: HISTORY_ADDED, Changes: []string{new.String()}})
+	case old != nil && new == nil:
+		this.add(HistoryEntry{Time: time.Now(), Link: old.Name, Action: HISTORY_REMOVED})
+	case old != nil:
+		if changes := DiffLinks(old, new); len(changes) > 0 {
+			this.add(HistoryEntry{Time: time.Now(), Link: new.Name, Action: HISTORY_UPDATED, Changes: changes})
+		}
+	}
+}
+
+// Entries returns the recorded history, oldest entry first.
+func (this *History) Entries() []HistoryEntry {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append(this.entries[:0:0], this.entries...)
+}
+
+func (this *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(this.Entries(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DiffLinks describes the differences between two versions of a link.
+func DiffLinks(old, new *Link) []string {
+	var changes []string
+	diff := func(name string, o, n interface{}) {
+		os, ns := fmt.Sprintf("%v", o), fmt.Sprintf("%v", n)
+		if os != ns {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, os, ns))
+		}
+	}
+	diff("clusterAddress", old.ClusterAddress, new.ClusterAddress)
+	diff("serviceCIDR", old.ServiceCIDR, new.ServiceCIDR)
+	if !old.Egress.Equal(new.Egress) {
+		diff("egress", old.Egress.String(), new.Egress.String())
+	}
+	if old.IngressRules.String() != new.IngressRules.String() {
+		diff("ingress", old.IngressRules.String(), new.IngressRules.String())
+	}
+	diff("ingressMode", old.IngressMode, new.IngressMode)
+	if !old.Advertise.Equal(new.Advertise) {
+		diff("advertise", old.Advertise.String(), new.Advertise.String())
+	}
+	if !old.ServiceRanges.Equal(new.ServiceRanges) {
+		diff("serviceRanges", old.ServiceRanges.String(), new.ServiceRanges.String())
+	}
+	diff("gateway", old.Gateway, new.Gateway)
+	diff("endpoint", old.Endpoint, new.Endpoint)
+	diff("endpoints", strings.Join(old.Endpoints, ","), strings.Join(new.Endpoints, ","))
+	diff("activeEndpoint", old.ActiveEndpoint, new.ActiveEndpoint)
+	diff("description", old.Description, new.Description)
+	diff("services", old.Services, new.Services)
+	diff("serverName", old.ServerName, new.ServerName)
+	diff("plaintext", old.Plaintext, new.Plaintext)
+	diff("dscp", dscpString(old.DSCP), dscpString(new.DSCP))
+	diff("socketBuffer", old.SocketBuffer, new.SocketBuffer)
+	diff("readBuffer", old.ReadBuffer, new.ReadBuffer)
+	diff("dedupWindow", old.DedupWindow, new.DedupWindow)
+	diff("autoRegistered", old.AutoRegistered, new.AutoRegistered)
+	diff("interface", old.Interface, new.Interface)
+	diff("dnsInfo", old.LinkDNSInfo, new.LinkDNSInfo)
+	if !old.LinkAccessInfo.Equal(new.LinkAccessInfo) {
+		changes = append(changes, "apiAccess")
+	}
+	return changes
+}
+
+func dscpString(dscp *int) string {
+	if dscp == nil {
+		return "default"
+	}
+	return fmt.Sprintf("%d", *dscp)
+}
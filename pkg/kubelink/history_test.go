@@ -0,0 +1,55 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+func TestDiffLinksEgressOrder(t *testing.T) {
+	links := NewLinks(nil)
+	kl := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+	kl.Spec.Egress = []string{"100.64.2.0/24", "100.64.3.0/24"}
+	old, err := links.LinkFor(logger.New(), kl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kl.Spec.Egress = []string{"100.64.3.0/24", "100.64.2.0/24"}
+	new, err := links.LinkFor(logger.New(), kl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := DiffLinks(old, new); len(changes) != 0 {
+		t.Errorf("reordered egress reported as change: %v", changes)
+	}
+
+	kl.Spec.Egress = []string{"100.64.3.0/24"}
+	new, err = links.LinkFor(logger.New(), kl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := DiffLinks(old, new)
+	if len(changes) != 1 || !strings.HasPrefix(changes[0], "egress:") {
+		t.Errorf("removed egress not reported: %v", changes)
+	}
+}
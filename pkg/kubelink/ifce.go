@@ -29,10 +29,13 @@ type NodeInterface struct {
 	Name  string
 	Index int
 	IP    net.IP
+	// Addresses are the addresses of all interfaces of the node
+	Addresses []net.IP
 }
 
 func LookupNodeIP(logger logger.LogContext, cidr *net.IPNet) (*NodeInterface, error) {
 	var ifce *NodeInterface
+	var all []net.IP
 
 	ifaces, _ := net.Interfaces()
 	for _, i := range ifaces {
@@ -45,6 +48,9 @@ func LookupNodeIP(logger logger.LogContext, cidr *net.IPNet) (*NodeInterface, er
 			case *net.IPAddr:
 				ip = v.IP
 			}
+			if ip != nil {
+				all = append(all, ip)
+			}
 			if cidr.Contains(ip) {
 				if logger != nil {
 					logger.Infof("%s: found node IP %q", i.Name, ip)
@@ -65,8 +71,28 @@ func LookupNodeIP(logger logger.LogContext, cidr *net.IPNet) (*NodeInterface, er
 	if ifce == nil {
 		return nil, fmt.Errorf("no valid node ip found for cidr %s on any interface", cidr)
 	}
+	ifce.Addresses = all
 	if logger != nil {
 		logger.Infof("using node ip %q (on interface %s[%d])", ifce.IP, ifce.Name, ifce.Index)
 	}
 	return ifce, nil
 }
+
+// IsLocalGateway checks whether the given gateway address is served by this
+// node. Besides the primary node interface, all addresses assigned to any
+// other interface of the node are considered, to handle multi-homed
+// gateway nodes.
+func (this *NodeInterface) IsLocalGateway(gateway net.IP) bool {
+	if gateway == nil {
+		return false
+	}
+	if gateway.Equal(this.IP) {
+		return true
+	}
+	for _, a := range this.Addresses {
+		if a.Equal(gateway) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/vishvananda/netlink"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func TestIsLocalGateway(t *testing.T) {
+	ifce := &NodeInterface{
+		Name:      "eth0",
+		IP:        net.ParseIP("10.250.0.10"),
+		Addresses: []net.IP{net.ParseIP("10.250.0.10"), net.ParseIP("192.168.100.5")},
+	}
+	if !ifce.IsLocalGateway(net.ParseIP("10.250.0.10")) {
+		t.Errorf("primary address not detected")
+	}
+	if !ifce.IsLocalGateway(net.ParseIP("192.168.100.5")) {
+		t.Errorf("gateway on secondary interface not detected")
+	}
+	if ifce.IsLocalGateway(net.ParseIP("10.250.0.11")) || ifce.IsLocalGateway(nil) {
+		t.Errorf("foreign gateway detected as local")
+	}
+}
+
+func TestRoutesForGatewayOnSecondaryInterface(t *testing.T) {
+	ifce := &NodeInterface{
+		Name:      "eth0",
+		Index:     2,
+		IP:        net.ParseIP("10.250.0.10"),
+		Addresses: []net.IP{net.ParseIP("10.250.0.10"), net.ParseIP("192.168.100.5")},
+	}
+	links := NewLinks(nil)
+	foreign := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+	foreign.Status.Gateway = "10.250.0.20"
+	local := testKubeLink("b", "192.168.0.12/24", "100.64.2.0/24")
+	local.Status.Gateway = "192.168.100.5"
+	for _, kl := range []*v1alpha1.KubeLink{foreign, local} {
+		if _, err := links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatalf("link %s: %s", kl.Name, err)
+		}
+	}
+
+	routes := links.GetRoutes(ifce)
+	_, egress, _ := net.ParseCIDR("100.64.1.0/24")
+	if routes.Lookup(netlink.Route{Dst: egress, Gw: net.ParseIP("10.250.0.20"), LinkIndex: 2, Protocol: ROUTE_PROTOCOL, Priority: 101}) < 0 {
+		t.Errorf("missing route via foreign gateway: %v", routes.Describe(-1))
+	}
+	for _, r := range routes {
+		if r.Gw.Equal(net.ParseIP("192.168.100.5")) {
+			t.Errorf("unexpected route via local gateway: %s", DescribeRoute(r))
+		}
+	}
+
+	broker := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 7}}
+	routes = links.GetRoutesToLink(ifce, broker)
+	_, egress, _ = net.ParseCIDR("100.64.2.0/24")
+	if routes.Lookup(netlink.Route{Dst: egress, LinkIndex: 7, Protocol: BROKER_ROUTE_PROTOCOL}) < 0 {
+		t.Errorf("missing broker route for local gateway: %v", routes.Describe(-1))
+	}
+	for _, r := range routes {
+		if r.Dst.String() == "100.64.1.0/24" {
+			t.Errorf("unexpected broker route for foreign gateway: %s", DescribeRoute(r))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// IngressRule allows traffic of a link to a destination network,
+// optionally restricted to a protocol and port. A zero port or
+// protocol matches any port or protocol.
+type IngressRule struct {
+	CIDR     *net.IPNet
+	Protocol byte
+	Port     uint16
+}
+
+// ParseIngressRule parses an ingress rule of the form
+// <cidr>[:<protocol>[/<port>]].
+func ParseIngressRule(s string) (*IngressRule, error) {
+	rule := &IngressRule{}
+	cidr := s
+	spec := ""
+	if i := strings.Index(s, "/"); i >= 0 {
+		if j := strings.Index(s[i:], ":"); j >= 0 {
+			cidr = s[:i+j]
+			spec = s[i+j+1:]
+		}
+	}
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ingress cidr %q: %s", cidr, err)
+	}
+	rule.CIDR = n
+	if spec == "" {
+		return rule, nil
+	}
+	proto := spec
+	if i := strings.Index(spec, "/"); i >= 0 {
+		proto = spec[:i]
+		port, err := strconv.ParseUint(spec[i+1:], 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid ingress port %q", spec[i+1:])
+		}
+		rule.Port = uint16(port)
+	}
+	p, ok := protocols[strings.ToLower(proto)]
+	if !ok {
+		return nil, fmt.Errorf("invalid ingress protocol %q", proto)
+	}
+	rule.Protocol = p
+	return rule, nil
+}
+
+func (this *IngressRule) String() string {
+	s := this.CIDR.String()
+	for n, p := range protocols {
+		if p == this.Protocol {
+			s = s + ":" + n
+		}
+	}
+	if this.Port != 0 {
+		s = fmt.Sprintf("%s/%d", s, this.Port)
+	}
+	return s
+}
+
+// Match checks whether a packet for the given destination
+// is allowed by the rule.
+func (this *IngressRule) Match(ip net.IP, proto byte, port uint16) bool {
+	if !this.CIDR.Contains(ip) {
+		return false
+	}
+	if this.Protocol != PROTO_ANY && this.Protocol != proto {
+		return false
+	}
+	return this.Port == 0 || this.Port == port
+}
+
+type IngressRules []*IngressRule
+
+func (this IngressRules) Match(ip net.IP, proto byte, port uint16) bool {
+	for _, r := range this {
+		if r.Match(ip, proto, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// CIDRs returns the destination networks of the rules.
+func (this IngressRules) CIDRs() tcp.CIDRList {
+	var result tcp.CIDRList
+	for _, r := range this {
+		result.Add(r.CIDR)
+	}
+	return result
+}
+
+func (this IngressRules) String() string {
+	var list []string
+	for _, r := range this {
+		list = append(list, r.String())
+	}
+	return strings.Join(list, ",")
+}
+
+// SameIngress checks whether two links use the same ingress configuration.
+func (this *Link) SameIngress(o *Link) bool {
+	return this.Ingress.IsSet() == o.Ingress.IsSet() &&
+		this.IngressMode == o.IngressMode &&
+		this.IngressRules.String() == o.IngressRules.String()
+}
@@ -21,15 +21,21 @@ package kubelink
 import (
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gardener/controller-manager-library/pkg/controllermanager/cluster"
 	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller"
 	"github.com/gardener/controller-manager-library/pkg/ctxutil"
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"github.com/gardener/controller-manager-library/pkg/resources"
+	"github.com/gardener/controller-manager-library/pkg/server"
 	"github.com/vishvananda/netlink"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 
 	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
@@ -39,6 +45,18 @@ import (
 )
 
 const DEFAULT_PORT = 80
+const MAX_DSCP = 63
+
+// ANNOTATION_AUTO_REGISTERED marks links created for incoming
+// connections in auto-connect mode.
+const ANNOTATION_AUTO_REGISTERED = "kubelink.mandelsoft.org/auto-registered"
+
+// ANNOTATION_INGRESS_MODE overrides the handling of packets of a link
+// denied by its ingress (enforce or audit).
+const ANNOTATION_INGRESS_MODE = "kubelink.mandelsoft.org/ingress-mode"
+
+const INGRESS_ENFORCE = "enforce"
+const INGRESS_AUDIT = "audit"
 
 ////////////////////////////////////////////////////////////////////////////////
 
@@ -47,10 +65,28 @@ type Link struct {
 	ServiceCIDR    *net.IPNet
 	Egress         tcp.CIDRList
 	Ingress        tcp.CIDRList
+	IngressRules   IngressRules
+	IngressMode    string
+	Advertise      tcp.CIDRList
+	ServiceRanges  tcp.CIDRList
 	ClusterAddress *net.IPNet
 	Gateway        net.IP
 	Host           string
 	Endpoint       string
+	Endpoints      []string
+	ActiveEndpoint string
+	Description    string
+	Created        time.Time
+	Services       ServiceEndpoints
+	DSCP           *int
+	ServerName     string
+	Plaintext      bool
+	SocketBuffer   int
+	ReadBuffer     int
+	DedupWindow    time.Duration
+	AutoRegistered bool
+	Interface      string
+	Stats          *LinkStats
 	LinkForeignData
 }
 
@@ -60,7 +96,11 @@ type LinkAccessInfo struct {
 }
 
 func (this LinkAccessInfo) String() string {
-	return fmt.Sprintf("{ca:%s..., token:%s...}", utils.ShortenString(this.CACert, 35), utils.ShortenString(this.Token, 35))
+	token := ""
+	if this.Token != "" {
+		token = "<redacted>"
+	}
+	return fmt.Sprintf("{ca:%s..., token:%s}", utils.ShortenString(this.CACert, 35), token)
 }
 
 func (this LinkAccessInfo) Equal(other LinkAccessInfo) bool {
@@ -84,22 +124,128 @@ type LinkForeignData struct {
 	UpdatePending bool
 	LinkAccessInfo
 	LinkDNSInfo
+	// DNSExpiry is the time the dns info propagated by the peer
+	// expires if not refreshed (zero for no expiry).
+	DNSExpiry time.Time
+}
+
+// DNSInfoExpired reports whether the propagated dns info has not been
+// refreshed in time and must not be used anymore.
+func (this *LinkForeignData) DNSInfoExpired() bool {
+	return !this.DNSExpiry.IsZero() && time.Now().After(this.DNSExpiry)
 }
 
 func (this *Link) String() string {
-	return fmt.Sprintf("%s[%s,%s,%s]", this.Name, this.ClusterAddress, this.Egress, this.Endpoint)
+	egress := this.Egress.String()
+	if this.IsHostOnly() {
+		egress = "host-only"
+	}
+	if this.Description != "" {
+		return fmt.Sprintf("%s[%s,%s,%s](%s)", this.Name, this.ClusterAddress, egress, this.Endpoint, this.Description)
+	}
+	return fmt.Sprintf("%s[%s,%s,%s]", this.Name, this.ClusterAddress, egress, this.Endpoint)
+}
+
+// GetServerName returns the name expected for the server certificate
+// of the link endpoint.
+func (this *Link) GetServerName() string {
+	if this.ServerName != "" {
+		return this.ServerName
+	}
+	return this.Host
+}
+
+// GetServerNameFor returns the name expected for the server certificate
+// of the given endpoint of the link. An explicitly configured server
+// name is used for all endpoints.
+func (this *Link) GetServerNameFor(endpoint string) string {
+	if this.ServerName != "" || endpoint == this.Endpoint {
+		return this.GetServerName()
+	}
+	return EndpointHost(endpoint)
+}
+
+// AdvertisedCIDRs filters the local cidrs by the advertise filter
+// of the link. Without filter all local cidrs are advertised.
+func (this *Link) AdvertisedCIDRs(local tcp.CIDRList) tcp.CIDRList {
+	if !this.Advertise.IsSet() {
+		return local
+	}
+	var result tcp.CIDRList
+	for _, c := range local {
+		for _, f := range this.Advertise {
+			ones, _ := c.Mask.Size()
+			fones, _ := f.Mask.Size()
+			if f.Contains(c.IP) && fones <= ones {
+				result.Add(c)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// HasEndpoint checks whether the given endpoint is the primary
+// or one of the failover endpoints of the link.
+func (this *Link) HasEndpoint(endpoint string) bool {
+	for _, e := range this.Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHostOnly reports whether the link provides neither a service cidr
+// nor any egress. Such a link only routes its cluster address.
+func (this *Link) IsHostOnly() bool {
+	return len(this.Egress) == 0
 }
 
-func (this *Link) AllowIngress(ip net.IP) (granted bool, set bool) {
+// AllowIngress checks whether the link may send a packet to the
+// given destination. set reports whether the ingress is restricted.
+func (this *Link) AllowIngress(ip net.IP, proto byte, port uint16) (granted bool, set bool) {
+	if !this.Ingress.IsSet() {
+		return true, false
+	}
+	return this.IngressRules.Match(ip, proto, port), true
+}
+
+// AllowIngressAddress checks whether the link may send packets to
+// the given destination address regardless of protocol and port
+// restrictions.
+func (this *Link) AllowIngressAddress(ip net.IP) (granted bool, set bool) {
 	if !this.Ingress.IsSet() {
 		return true, false
 	}
 	return this.Ingress.Contains(ip), true
 }
 
+// ServiceRoutes returns the host networks of advertised services
+// not already covered by the egress of the link.
+func (this *Link) ServiceRoutes() []*net.IPNet {
+	var result []*net.IPNet
+	for _, s := range this.Services {
+		if !this.Egress.Contains(s.IP) {
+			n := s.HostNet()
+			found := false
+			for _, r := range result {
+				if tcp.EqualCIDR(r, n) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				result = append(result, n)
+			}
+		}
+	}
+	return result
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
-func (this *Links) LinkFor(link *v1alpha1.KubeLink) (*Link, error) {
+func (this *Links) LinkFor(logger logger.LogContext, link *v1alpha1.KubeLink) (*Link, error) {
 	var egress tcp.CIDRList
 	var serviceCIDR *net.IPNet
 
@@ -118,14 +264,54 @@ func (this *Links) LinkFor(link *v1alpha1.KubeLink) (*Link, error) {
 		}
 		egress.Add(cidr)
 	}
-	var ingress tcp.CIDRList
+	if this.maxEgress > 0 && len(egress) > this.maxEgress {
+		return nil, fmt.Errorf("too many egress cidrs (%d): at most %d allowed", len(egress), this.maxEgress)
+	}
+	if this.serviceCIDR != nil && this.serviceOverlap != OVERLAP_IGNORE {
+		for _, c := range egress {
+			if tcp.OverlappingCIDR(c, this.serviceCIDR) {
+				if this.serviceOverlap == OVERLAP_REJECT {
+					return nil, fmt.Errorf("egress %s overlaps local service cidr %s", c, this.serviceCIDR)
+				}
+				logger.Warnf("egress %s of link %s overlaps local service cidr %s", c, link.Name, this.serviceCIDR)
+			}
+		}
+	}
+	var ingress IngressRules
 
 	for _, c := range link.Spec.Ingress {
+		rule, err := ParseIngressRule(strings.TrimSpace(c))
+		if err != nil {
+			return nil, err
+		}
+		ingress = append(ingress, rule)
+	}
+
+	mode := strings.ToLower(strings.TrimSpace(link.Annotations[ANNOTATION_INGRESS_MODE]))
+	switch mode {
+	case "", INGRESS_ENFORCE, INGRESS_AUDIT:
+	default:
+		return nil, fmt.Errorf("invalid ingress mode %q", mode)
+	}
+
+	var advertise tcp.CIDRList
+
+	for _, c := range link.Spec.Advertise {
 		_, cidr, err := net.ParseCIDR(c)
 		if err != nil {
-			return nil, fmt.Errorf("invalid routing cidr %q: %s", link.Spec.CIDR, err)
+			return nil, fmt.Errorf("invalid advertise cidr %q: %s", c, err)
 		}
-		ingress.Add(cidr)
+		advertise.Add(cidr)
+	}
+
+	var serviceRanges tcp.CIDRList
+
+	for _, c := range link.Spec.ServiceRanges {
+		_, cidr, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service range %q: %s", c, err)
+		}
+		serviceRanges.Add(cidr)
 	}
 
 	ip, ccidr, err := net.ParseCIDR(link.Spec.ClusterAddress)
@@ -143,21 +329,74 @@ func (this *Links) LinkFor(link *v1alpha1.KubeLink) (*Link, error) {
 	if gateway == nil {
 		return nil, fmt.Errorf("invalid gateway address %q", link.Status.Gateway)
 	}
-	endpoint := link.Spec.Endpoint
+	if link.Spec.DSCP != nil && (*link.Spec.DSCP < 0 || *link.Spec.DSCP > MAX_DSCP) {
+		return nil, fmt.Errorf("invalid dscp value %d: must be between 0 and %d", *link.Spec.DSCP, MAX_DSCP)
+	}
+	socketBuffer, readBuffer, err := linkBuffers(link.Spec.Buffers)
+	if err != nil {
+		return nil, err
+	}
+	var dedup time.Duration
+	if link.Spec.DeduplicationWindow != "" {
+		dedup, err = time.ParseDuration(link.Spec.DeduplicationWindow)
+		if err != nil || dedup < 0 {
+			return nil, fmt.Errorf("invalid deduplication window %q", link.Spec.DeduplicationWindow)
+		}
+	}
+	services, err := ParseServiceEndpoints(link.Status.Services)
+	if err != nil {
+		return nil, fmt.Errorf("invalid advertised services: %s", err)
+	}
+	// the endpoint may be a comma separated list, additional entries
+	// are handled like failover endpoints
+	failover := strings.Split(link.Spec.Endpoint, ",")
+	endpoint := strings.TrimSpace(failover[0])
 	parts := strings.Split(endpoint, ":")
 	if len(parts) == 1 {
 		endpoint = fmt.Sprintf("%s:%d", endpoint, DEFAULT_PORT)
 	}
+	endpoints := []string{endpoint}
+	for _, e := range append(failover[1:], link.Spec.FailoverEndpoints...) {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			return nil, fmt.Errorf("empty failover endpoint")
+		}
+		if !strings.Contains(e, ":") {
+			e = fmt.Sprintf("%s:%d", e, DEFAULT_PORT)
+		}
+		endpoints = append(endpoints, e)
+	}
+	for _, e := range endpoints {
+		if host := EndpointHost(e); !this.allowlist.Allows(host) {
+			return nil, fmt.Errorf("endpoint host %q not allowed", host)
+		}
+	}
 
 	l := &Link{
 		Name:           link.Name,
 		ServiceCIDR:    serviceCIDR,
 		Egress:         egress,
-		Ingress:        ingress,
+		Ingress:        ingress.CIDRs(),
+		IngressRules:   ingress,
+		IngressMode:    mode,
+		Advertise:      advertise,
+		ServiceRanges:  serviceRanges,
 		ClusterAddress: ccidr,
 		Gateway:        gateway,
 		Host:           parts[0],
 		Endpoint:       endpoint,
+		Endpoints:      endpoints,
+		Description:    link.Spec.Description,
+		Created:        link.CreationTimestamp.Time,
+		Services:       services,
+		DSCP:           link.Spec.DSCP,
+		ServerName:     link.Spec.ServerName,
+		Plaintext:      link.Spec.Plaintext,
+		SocketBuffer:   socketBuffer,
+		ReadBuffer:     readBuffer,
+		DedupWindow:    dedup,
+		AutoRegistered: link.Annotations[ANNOTATION_AUTO_REGISTERED] == "true",
+		Interface:      strings.TrimSpace(link.Spec.Interface),
 	}
 	return l, err
 }
@@ -172,7 +411,10 @@ func GetSharedLinks(controller controller.Interface) *Links {
 		if err != nil {
 			controller.Errorf("cannot get kubelink resource: %s", err)
 		}
-		return NewLinks(resc)
+		links := NewLinks(resc)
+		server.RegisterHandler("/history", links.History())
+		server.Register("/meshes/watch", links.ServeMeshWatch)
+		return links
 	}).(*Links)
 }
 
@@ -183,6 +425,26 @@ type Links struct {
 	links       map[string]*Link
 	endpoints   map[string]*Link
 	clusteraddr map[string]*Link
+	maxEgress   int
+	history     *History
+
+	serviceCIDR    *net.IPNet
+	serviceOverlap string
+	nodeCIDR       *net.IPNet
+	allowlist      *EndpointAllowlist
+	setupEvents    bool
+
+	ingressConflict string
+	// egressConflicts maps links rejected because of an egress
+	// conflict to the link taking precedence.
+	egressConflicts map[string]string
+	// ingressConflicts maps links sharing the cluster address of
+	// another link with a different ingress to the conflicting link.
+	ingressConflicts map[string]string
+	ingressUpdates   map[string]bool
+
+	meshLock    sync.Mutex
+	meshWatches map[*MeshWatch]struct{}
 }
 
 func NewLinks(resc resources.Interface) *Links {
@@ -191,14 +453,80 @@ func NewLinks(resc resources.Interface) *Links {
 		links:       map[string]*Link{},
 		endpoints:   map[string]*Link{},
 		clusteraddr: map[string]*Link{},
+		history:     NewHistory(DEFAULT_HISTORY_SIZE),
+
+		egressConflicts:  map[string]string{},
+		ingressConflicts: map[string]string{},
+		ingressUpdates:   map[string]bool{},
 	}
 }
 
-func (this *Links) Setup(logger logger.LogContext, cluster cluster.Interface) {
+// History returns the change history of the link table.
+func (this *Links) History() *History {
+	return this.history
+}
+
+// SetMaxEgress limits the number of egress CIDRs accepted for a
+// link. A value of 0 disables the limit.
+func (this *Links) SetMaxEgress(max int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.maxEgress = max
+}
+
+const OVERLAP_IGNORE = "ignore"
+const OVERLAP_WARN = "warn"
+const OVERLAP_REJECT = "reject"
+
+// SetServiceCIDR sets the local service cidr and the handling of
+// links with an egress overlapping it (ignore, warn or reject).
+// Such links would attract local service traffic into the tunnel.
+func (this *Links) SetServiceCIDR(cidr *net.IPNet, overlap string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.serviceCIDR = cidr
+	this.serviceOverlap = overlap
+}
+
+// SetNodeCIDR sets the node network. Services advertised by links
+// are never routed into the node network.
+func (this *Links) SetNodeCIDR(cidr *net.IPNet) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
+	this.nodeCIDR = cidr
+}
 
+// SetEndpointAllowlist restricts the endpoints accepted for links.
+// A nil allowlist accepts all endpoints.
+func (this *Links) SetEndpointAllowlist(list *EndpointAllowlist) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.allowlist = list
+}
+
+// SetIngressConflict sets the handling of links sharing the cluster
+// address of another link with a different ingress (ignore, warn or
+// reject). The ingress filter selects the link by the cluster address,
+// therefore only one of the ingress configurations would be effective.
+func (this *Links) SetIngressConflict(mode string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.ingressConflict = mode
+}
+
+// SetSetupEvents enables events for links that cannot be loaded
+// during the initial setup.
+func (this *Links) SetSetupEvents(enabled bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.setupEvents = enabled
+}
+
+func (this *Links) Setup(logger logger.LogContext, cluster cluster.Interface) {
+	failed := map[resources.Object]error{}
+	this.lock.Lock()
 	if this.initialized {
+		this.lock.Unlock()
 		return
 	}
 	this.initialized = true
@@ -209,13 +537,46 @@ func (this *Links) Setup(logger logger.LogContext, cluster cluster.Interface) {
 	list, _ := res.ListCached(labels.Everything())
 
 	for _, l := range list {
-		link, err := this.updateLink(l.Data().(*v1alpha1.KubeLink))
+		link, err := this.updateLink(logger, l.Data().(*v1alpha1.KubeLink))
 		if link != nil {
 			logger.Infof("found link %s", link)
 		}
 		if err != nil {
 			logger.Infof("errorneous link %s: %s", l.GetName(), err)
+			failed[l] = err
+		}
+	}
+	for _, r := range this.detectAsymmetry() {
+		logger.Warnf("asymmetric routing risk: %s", r)
+	}
+	events := this.setupEvents
+	this.lock.Unlock()
+
+	for l, err := range failed {
+		this.recordSetupError(logger, l, err, events)
+	}
+}
+
+// recordSetupError marks a link that cannot be loaded as invalid,
+// so that the failure is visible in its status after startup.
+func (this *Links) recordSetupError(logger logger.LogContext, obj resources.Object, err error, event bool) {
+	msg := err.Error()
+	_, merr := obj.ModifyStatus(func(data resources.ObjectData) (bool, error) {
+		klink := data.(*v1alpha1.KubeLink)
+		if klink.Status.State == v1alpha1.STATE_INVALID && klink.Status.Message == msg {
+			return false, nil
 		}
+		now := meta.Now()
+		klink.Status.State = v1alpha1.STATE_INVALID
+		klink.Status.Message = msg
+		klink.Status.LastErrorTime = &now
+		return true, nil
+	})
+	if merr != nil {
+		logger.Warnf("cannot update status of link %s: %s", obj.GetName(), merr)
+	}
+	if event {
+		obj.Eventf(core.EventTypeWarning, "InvalidLink", "link cannot be loaded: %s", msg)
 	}
 }
 
@@ -287,17 +648,47 @@ func (this *Links) UpdateLinkInfo(logger logger.LogContext, name string, access
 	return old, false
 }
 
+// RefreshDNSInfo sets the expiry time of the dns info of a link.
+func (this *Links) RefreshDNSInfo(name string, expiry time.Time) *Link {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	old := this.links[name]
+	if old == nil {
+		return nil
+	}
+	new := *old
+	new.DNSExpiry = expiry
+	return this.replaceLink(&new)
+}
+
+// SetActiveEndpoint records the endpoint used for the actual
+// connection of a link. It returns whether the endpoint changed.
+func (this *Links) SetActiveEndpoint(name string, endpoint string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	old := this.links[name]
+	if old == nil || old.ActiveEndpoint == endpoint || !old.HasEndpoint(endpoint) {
+		return false
+	}
+	new := *old
+	new.ActiveEndpoint = endpoint
+	this.replaceLink(&new)
+	return true
+}
+
 func (this *Links) replaceLink(link *Link) *Link {
+	this.history.Record(this.links[link.Name], link)
+	this.notifyMeshes(this.links[link.Name], link)
 	this.links[link.Name] = link
 	this.endpoints[link.Host] = link
 	this.clusteraddr[link.ClusterAddress.IP.String()] = link
 	return link
 }
 
-func (this *Links) UpdateLink(klink *v1alpha1.KubeLink) (*Link, error) {
+func (this *Links) UpdateLink(logger logger.LogContext, klink *v1alpha1.KubeLink) (*Link, error) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
-	return this.updateLink(klink)
+	return this.updateLink(logger, klink)
 }
 
 func (this *Links) GetLink(name string) *Link {
@@ -306,11 +697,72 @@ func (this *Links) GetLink(name string) *Link {
 	return this.links[name]
 }
 
-func (this *Links) updateLink(klink *v1alpha1.KubeLink) (*Link, error) {
-	l, err := this.LinkFor(klink)
+// HasPlaintextLinks checks whether any link allows plaintext connections.
+func (this *Links) HasPlaintextLinks() bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for _, l := range this.links {
+		if l.Plaintext {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLinks returns the requested links with a single lookup.
+// Unknown names are omitted.
+func (this *Links) GetLinks(names ...string) []*Link {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	result := make([]*Link, 0, len(names))
+	for _, n := range names {
+		if l := this.links[n]; l != nil {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// Snapshot returns copies of all links sorted by name. Modifying
+// the copies does not affect the link table.
+func (this *Links) Snapshot() []*Link {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	result := make([]*Link, 0, len(this.links))
+	for _, l := range this.links {
+		c := *l
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func (this *Links) updateLink(logger logger.LogContext, klink *v1alpha1.KubeLink) (*Link, error) {
+	l, err := this.LinkFor(logger, klink)
+	if err != nil {
+		return nil, err
+	}
+	if other := this.ingressConflictFor(l); other != nil {
+		this.setIngressConflict(l.Name, other.Name)
+		if this.ingressConflict == OVERLAP_REJECT {
+			return nil, fmt.Errorf("ingress conflicts with link %s using the same cluster address %s", other.Name, l.ClusterAddress.IP)
+		}
+		logger.Warnf("ingress of link %s conflicts with link %s using the same cluster address %s", l.Name, other.Name, l.ClusterAddress.IP)
+	} else if p := this.ingressConflicts[l.Name]; p != "" && this.links[p] != nil {
+		// a rejected conflicting link keeps the conflict until it is updated or removed
+		this.clearIngressConflict(l.Name)
+	}
+	evicted, err := this.checkEgressOverlap(l)
 	if err != nil {
 		return nil, err
 	}
+	delete(this.egressConflicts, l.Name)
+	for _, n := range evicted {
+		logger.Warnf("removing link %s: egress conflicts with link %s", n, l.Name)
+		this.removeLink(n)
+		this.egressConflicts[n] = l.Name
+	}
+	l.Services = this.validServices(logger, l)
 	old := this.links[klink.Name]
 	if old != nil {
 		if old.Host != l.Host {
@@ -320,15 +772,209 @@ func (this *Links) updateLink(klink *v1alpha1.KubeLink) (*Link, error) {
 			delete(this.clusteraddr, old.ClusterAddress.IP.String())
 		}
 		l.LinkForeignData = old.LinkForeignData
+		l.Stats = old.Stats
+		if l.HasEndpoint(old.ActiveEndpoint) {
+			l.ActiveEndpoint = old.ActiveEndpoint
+		}
+	} else {
+		l.Stats = &LinkStats{}
 	}
 	return this.replaceLink(l), nil
 }
 
+// ingressConflictFor returns a link using the cluster address of the
+// given link with a different ingress configuration.
+func (this *Links) ingressConflictFor(l *Link) *Link {
+	if this.ingressConflict == OVERLAP_IGNORE {
+		return nil
+	}
+	names := []string{}
+	for n := range this.links {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		other := this.links[n]
+		if n != l.Name && other.ClusterAddress.IP.Equal(l.ClusterAddress.IP) && !other.SameIngress(l) {
+			return other
+		}
+	}
+	return nil
+}
+
+// setIngressConflict records an ingress conflict for both links.
+func (this *Links) setIngressConflict(a, b string) {
+	if this.ingressConflicts[a] == b && this.ingressConflicts[b] == a {
+		return
+	}
+	this.clearIngressConflict(a)
+	this.clearIngressConflict(b)
+	this.ingressConflicts[a] = b
+	this.ingressConflicts[b] = a
+	this.ingressUpdates[a] = true
+	this.ingressUpdates[b] = true
+}
+
+// clearIngressConflict removes the ingress conflict of a link from both
+// links.
+func (this *Links) clearIngressConflict(name string) {
+	if p := this.ingressConflicts[name]; p != "" {
+		delete(this.ingressConflicts, name)
+		this.ingressUpdates[name] = true
+		if this.ingressConflicts[p] == name {
+			delete(this.ingressConflicts, p)
+			this.ingressUpdates[p] = true
+		}
+	}
+}
+
+// IngressConflict returns the name of the link conflicting with the
+// ingress of the given link using the same cluster address.
+func (this *Links) IngressConflict(name string) string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.ingressConflicts[name]
+}
+
+// IngressConflictUpdates returns the links whose ingress conflict
+// changed since the last call, excluding the given link.
+func (this *Links) IngressConflictUpdates(name string) []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var result []string
+	for n := range this.ingressUpdates {
+		if n != name {
+			result = append(result, n)
+		}
+	}
+	this.ingressUpdates = map[string]bool{}
+	sort.Strings(result)
+	return result
+}
+
+// checkEgressOverlap checks whether the egress of a link overlaps the
+// egress or cluster address of another link. Packets for such
+// destinations could not be assigned to a link unambiguously.
+// Conflicts are resolved independently of the reconcile order: the
+// older link, or the one with the lower name, takes precedence. If the
+// given link takes precedence, the names of the conflicting links to
+// evict are returned, otherwise the conflict is reported as error.
+func (this *Links) checkEgressOverlap(l *Link) ([]string, error) {
+	names := []string{}
+	for n := range this.links {
+		if n != l.Name {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	var evicted []string
+	for _, n := range names {
+		other := this.links[n]
+		if err := egressConflict(l, other); err != nil {
+			if !l.precedes(other) {
+				this.egressConflicts[l.Name] = n
+				return nil, err
+			}
+			evicted = append(evicted, n)
+		}
+	}
+	return evicted, nil
+}
+
+// egressConflict reports an overlap of the egress of a link with the
+// egress or cluster address of another link.
+func egressConflict(l, other *Link) error {
+	for _, c := range l.Egress {
+		for _, o := range other.Egress {
+			if tcp.OverlappingCIDR(c, o) {
+				return fmt.Errorf("egress %s overlaps egress %s of link %s", c, o, other.Name)
+			}
+		}
+		if c.Contains(other.ClusterAddress.IP) {
+			return fmt.Errorf("egress %s contains cluster address %s of link %s", c, other.ClusterAddress.IP, other.Name)
+		}
+	}
+	if other.Egress.Contains(l.ClusterAddress.IP) {
+		return fmt.Errorf("cluster address %s is contained in egress of link %s", l.ClusterAddress.IP, other.Name)
+	}
+	return nil
+}
+
+// precedes reports whether a link takes precedence over another one
+// in case of a conflict.
+func (this *Link) precedes(other *Link) bool {
+	if !this.Created.Equal(other.Created) {
+		return this.Created.Before(other.Created)
+	}
+	return this.Name < other.Name
+}
+
+// EgressConflicts returns the links rejected because of an egress
+// conflict with the given link and forgets about them. They have to be
+// validated again if the given link is removed or changed.
+func (this *Links) EgressConflicts(name string) []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var result []string
+	for n, winner := range this.egressConflicts {
+		if winner == name {
+			result = append(result, n)
+			delete(this.egressConflicts, n)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// validServices returns the services advertised for a link, which
+// may be routed to it. Only services located in the egress or the
+// service ranges of the link are accepted. Services located in the
+// ingress of the link, the node network, the local service cidr or
+// the egress of another link are ignored.
+func (this *Links) validServices(logger logger.LogContext, l *Link) ServiceEndpoints {
+	var result ServiceEndpoints
+outer:
+	for _, s := range l.Services {
+		if !l.Egress.Contains(s.IP) && !l.ServiceRanges.Contains(s.IP) {
+			logger.Warnf("ignoring service %s advertised by link %s: not located in its egress or service ranges", s, l.Name)
+			continue
+		}
+		if this.nodeCIDR != nil && this.nodeCIDR.Contains(s.IP) {
+			logger.Warnf("ignoring service %s advertised by link %s: located in node cidr %s", s, l.Name, this.nodeCIDR)
+			continue
+		}
+		if l.Ingress.Contains(s.IP) {
+			logger.Warnf("ignoring service %s advertised by link %s: located in its ingress", s, l.Name)
+			continue
+		}
+		if this.serviceCIDR != nil && this.serviceCIDR.Contains(s.IP) {
+			logger.Warnf("ignoring service %s advertised by link %s: located in local service cidr %s", s, l.Name, this.serviceCIDR)
+			continue
+		}
+		for n, other := range this.links {
+			if n != l.Name && (other.Egress.Contains(s.IP) || other.ClusterAddress.IP.Equal(s.IP)) {
+				logger.Warnf("ignoring service %s advertised by link %s: located in egress of link %s", s, l.Name, n)
+				continue outer
+			}
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
 func (this *Links) RemoveLink(name string) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
+	delete(this.egressConflicts, name)
+	this.clearIngressConflict(name)
+	this.removeLink(name)
+}
+
+func (this *Links) removeLink(name string) {
 	l := this.links[name]
 	if l != nil {
+		this.history.Record(l, nil)
+		this.notifyMeshes(l, nil)
 		delete(this.links, name)
 		delete(this.endpoints, l.Host)
 		delete(this.clusteraddr, l.ClusterAddress.IP.String())
@@ -345,6 +991,18 @@ func (this *Links) Visit(visitor func(l *Link) bool) {
 	}
 }
 
+// Stats returns a snapshot of the traffic counters of all links
+// keyed by the link name.
+func (this *Links) Stats() map[string]LinkStats {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	result := map[string]LinkStats{}
+	for n, l := range this.links {
+		result[n] = l.Stats.Snapshot()
+	}
+	return result
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 func (this *Links) GetLinkForIP(ip net.IP) *Link {
@@ -354,12 +1012,39 @@ func (this *Links) GetLinkForIP(ip net.IP) *Link {
 	if l := this.clusteraddr[ip.String()]; l != nil {
 		return l
 	}
+	var found *Link
+	best := -1
 	for _, l := range this.links {
-		if l.Egress.Contains(ip) {
-			return l
+		if l.IsHostOnly() && len(l.Services) == 0 {
+			continue
+		}
+		if n := l.matchLength(ip); n > best || (n == best && n >= 0 && l.Name < found.Name) {
+			found = l
+			best = n
 		}
 	}
-	return nil
+	return found
+}
+
+// matchLength returns the prefix length of the most specific egress
+// network or service of the link containing the given address, or -1
+// if there is none.
+func (this *Link) matchLength(ip net.IP) int {
+	best := -1
+	for _, c := range this.Egress {
+		if c.Contains(ip) {
+			if ones, _ := c.Mask.Size(); ones > best {
+				best = ones
+			}
+		}
+	}
+	if this.Services.Contains(ip) {
+		best = len(ip.To16()) * 8
+		if ip.To4() != nil {
+			best = net.IPv4len * 8
+		}
+	}
+	return best
 }
 
 func (this *Links) GetLinkForClusterAddress(ip net.IP) *Link {
@@ -380,7 +1065,7 @@ func (this *Links) GetSNATRules(ifce *NodeInterface) iptables.Requests {
 
 	rules := iptables.Rules{}
 	for _, l := range this.links {
-		if !l.Gateway.Equal(ifce.IP) {
+		if !ifce.IsLocalGateway(l.Gateway) {
 			for _, c := range l.Egress {
 				r := iptables.Rule{
 					iptables.Opt("-d", c.String()),
@@ -395,13 +1080,62 @@ func (this *Links) GetSNATRules(ifce *NodeInterface) iptables.Requests {
 	return iptables.Requests{iptables.NewChainRequest("nat", "kubelink", rules, true)}
 }
 
+const ANTISPOOFING_CHAIN = "kubelink-antispoof"
+
+// GetAntiSpoofingRules returns the firewall rules dropping packets
+// received on the given (tun) interface with a source address neither
+// in the cluster address range of the mesh nor in the egress of a link.
+func (this *Links) GetAntiSpoofingRules(ifce string, mesh *net.IPNet) iptables.Requests {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	rules := iptables.Rules{}
+	if mesh != nil {
+		rules.Add(iptables.Rule{
+			iptables.Opt("-i", ifce),
+			iptables.Opt("-s", tcp.CIDRNet(mesh).String()),
+			iptables.Opt("-j", "RETURN"),
+		})
+	}
+	names := make([]string, 0, len(this.links))
+	for n := range this.links {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		for _, c := range this.links[n].Egress {
+			rules.Add(iptables.Rule{
+				iptables.Opt("-i", ifce),
+				iptables.Opt("-s", c.String()),
+				iptables.Opt("-j", "RETURN"),
+			})
+		}
+	}
+	rules.Add(iptables.Rule{
+		iptables.Opt("-i", ifce),
+		iptables.Opt("-j", "DROP"),
+	})
+	jump := iptables.Rules{
+		iptables.Rule{
+			iptables.Opt("-i", ifce),
+			iptables.Opt("-j", ANTISPOOFING_CHAIN),
+		},
+	}
+	chain := iptables.NewChainRequest("raw", ANTISPOOFING_CHAIN, rules, true)
+	chain.Ordered = true
+	return iptables.Requests{
+		chain,
+		iptables.NewChainRequest("raw", "PREROUTING", jump, false),
+	}
+}
+
 func (this *Links) GetRoutes(ifce *NodeInterface) Routes {
 	this.lock.RLock()
 	defer this.lock.RUnlock()
 
 	var flags netlink.NextHopFlag
 	index := ifce.Index
-	protocol := 0
+	protocol := ROUTE_PROTOCOL
 	i, err := netlink.LinkByName("tunl0")
 	if i != nil && err == nil {
 		attrs := i.Attrs()
@@ -413,7 +1147,7 @@ func (this *Links) GetRoutes(ifce *NodeInterface) Routes {
 	}
 	routes := Routes{}
 	for _, l := range this.links {
-		if !l.Gateway.Equal(ifce.IP) {
+		if !ifce.IsLocalGateway(l.Gateway) {
 			for _, c := range l.Egress {
 				r := netlink.Route{
 					Dst:       c,
@@ -425,6 +1159,17 @@ func (this *Links) GetRoutes(ifce *NodeInterface) Routes {
 				r.SetFlag(flags)
 				routes.Add(r)
 			}
+			for _, s := range l.ServiceRoutes() {
+				r := netlink.Route{
+					Dst:       s,
+					Gw:        l.Gateway,
+					LinkIndex: index,
+					Protocol:  protocol,
+					Priority:  101,
+				}
+				r.SetFlag(flags)
+				routes.Add(r)
+			}
 			r := netlink.Route{
 				Dst:       tcp.CIDRNet(l.ClusterAddress),
 				Gw:        l.Gateway,
@@ -445,28 +1190,75 @@ func (this *Links) GetRoutesToLink(ifce *NodeInterface, link netlink.Link) Route
 
 	routes := Routes{}
 	for _, l := range this.links {
-		if l.Gateway.Equal(ifce.IP) {
-			for _, c := range l.Egress {
-				r := netlink.Route{
-					Dst:       c,
-					LinkIndex: link.Attrs().Index,
-				}
-				routes.Add(r)
-			}
+		if ifce.IsLocalGateway(l.Gateway) {
+			l.addRoutesToLink(&routes, link)
 		}
 	}
 	return routes
 }
 
-func (this *Links) RegisterLink(name string, clusterCIDR *net.IPNet, fqdn string, cidr *net.IPNet) (*Link, error) {
+// GetRoutesForLink returns the routes to the given network link
+// required for the link with the given name.
+func (this *Links) GetRoutesForLink(ifce *NodeInterface, link netlink.Link, name string) Routes {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	routes := Routes{}
+	l := this.links[name]
+	if l != nil && ifce.IsLocalGateway(l.Gateway) {
+		l.addRoutesToLink(&routes, link)
+	}
+	return routes
+}
+
+func (this *Link) addRoutesToLink(routes *Routes, link netlink.Link) {
+	for _, c := range this.Egress {
+		r := netlink.Route{
+			Dst:       c,
+			LinkIndex: link.Attrs().Index,
+			Protocol:  BROKER_ROUTE_PROTOCOL,
+		}
+		routes.Add(r)
+	}
+	for _, s := range this.ServiceRoutes() {
+		r := netlink.Route{
+			Dst:       s,
+			LinkIndex: link.Attrs().Index,
+			Protocol:  BROKER_ROUTE_PROTOCOL,
+		}
+		routes.Add(r)
+	}
+}
+
+func (this *Links) RegisterLink(logger logger.LogContext, name string, clusterCIDR *net.IPNet, fqdn string, cidr *net.IPNet, egress ...*net.IPNet) (*Link, error) {
 	kl := &v1alpha1.KubeLink{}
 	kl.Name = name
+	kl.Annotations = map[string]string{ANNOTATION_AUTO_REGISTERED: "true"}
 	kl.Spec.ClusterAddress = clusterCIDR.IP.String()
 	kl.Spec.Endpoint = fqdn
 	kl.Spec.CIDR = cidr.String()
+	for _, e := range egress {
+		kl.Spec.Egress = append(kl.Spec.Egress, e.String())
+	}
 	_, err := this.resource.Create(kl)
 	if err != nil {
 		return nil, err
 	}
-	return this.UpdateLink(kl)
+	return this.UpdateLink(logger, kl)
+}
+
+// UnregisterLink deletes the KubeLink object of an auto-registered link.
+func (this *Links) UnregisterLink(name string) error {
+	l := this.GetLink(name)
+	if l == nil {
+		return nil
+	}
+	if !l.AutoRegistered {
+		return fmt.Errorf("link %s is not auto-registered", name)
+	}
+	err := this.resource.DeleteByName(resources.NewObjectName(name))
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
 }
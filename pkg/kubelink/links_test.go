@@ -0,0 +1,190 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func testKubeLink(name, addr, cidr string) *v1alpha1.KubeLink {
+	kl := &v1alpha1.KubeLink{}
+	kl.Name = name
+	kl.Spec.ClusterAddress = addr
+	kl.Spec.CIDR = cidr
+	kl.Spec.Endpoint = name + ".example.com:80"
+	kl.Status.Gateway = "10.0.0.1"
+	return kl
+}
+
+func TestServerNameForEndpoint(t *testing.T) {
+	links := NewLinks(nil)
+	kl := testKubeLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Endpoint = "a.example.com:80,backup.example.org:8080"
+	l, err := links.UpdateLink(logger.New(), kl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := l.GetServerNameFor(l.Endpoints[0]); n != "a.example.com" {
+		t.Errorf("primary endpoint: got server name %q", n)
+	}
+	if n := l.GetServerNameFor(l.Endpoints[1]); n != "backup.example.org" {
+		t.Errorf("failover endpoint: got server name %q", n)
+	}
+
+	kl.Spec.ServerName = "peer.example.com"
+	l, err = links.UpdateLink(logger.New(), kl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := l.GetServerNameFor(l.Endpoints[1]); n != "peer.example.com" {
+		t.Errorf("failover endpoint with server name: got %q", n)
+	}
+}
+
+func TestEgressConflictOrder(t *testing.T) {
+	for _, order := range [][]string{{"a", "b"}, {"b", "a"}} {
+		links := NewLinks(nil)
+		klinks := map[string]*v1alpha1.KubeLink{
+			"a": testKubeLink("a", "192.168.0.10/24", "100.64.1.0/24"),
+			"b": testKubeLink("b", "192.168.0.11/24", "100.64.0.0/16"),
+		}
+		for _, n := range order {
+			links.UpdateLink(logger.New(), klinks[n])
+		}
+		if links.GetLink("a") == nil || links.GetLink("b") != nil {
+			t.Errorf("order %v: wrong link accepted", order)
+		}
+		if c := links.EgressConflicts("a"); len(c) != 1 || c[0] != "b" {
+			t.Errorf("order %v: unexpected conflicts %v", order, c)
+		}
+	}
+}
+
+func TestEgressConflictRevalidate(t *testing.T) {
+	links := NewLinks(nil)
+	a := testKubeLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	b := testKubeLink("b", "192.168.0.11/24", "100.64.0.0/16")
+	if _, err := links.UpdateLink(logger.New(), a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := links.UpdateLink(logger.New(), b); err == nil {
+		t.Fatalf("conflicting link accepted")
+	}
+	links.RemoveLink("a")
+	c := links.EgressConflicts("a")
+	if len(c) != 1 || c[0] != "b" {
+		t.Fatalf("unexpected conflicts %v", c)
+	}
+	if _, err := links.UpdateLink(logger.New(), b); err != nil {
+		t.Errorf("link not accepted after removal of conflicting link: %s", err)
+	}
+}
+
+func TestIngressConflictBothLinks(t *testing.T) {
+	for _, mode := range []string{OVERLAP_WARN, OVERLAP_REJECT} {
+		links := NewLinks(nil)
+		links.SetIngressConflict(mode)
+		a := testKubeLink("a", "192.168.0.10/24", "100.64.1.0/24")
+		b := testKubeLink("b", "192.168.0.10/24", "100.64.2.0/24")
+		b.Spec.Ingress = []string{"10.0.0.0/24"}
+		if _, err := links.UpdateLink(logger.New(), a); err != nil {
+			t.Fatal(err)
+		}
+		_, err := links.UpdateLink(logger.New(), b)
+		if (err != nil) != (mode == OVERLAP_REJECT) {
+			t.Errorf("%s: unexpected result %v", mode, err)
+		}
+		if links.IngressConflict("a") != "b" || links.IngressConflict("b") != "a" {
+			t.Errorf("%s: conflict not reported on both links", mode)
+		}
+		if u := links.IngressConflictUpdates("b"); len(u) != 1 || u[0] != "a" {
+			t.Errorf("%s: unexpected updates %v", mode, u)
+		}
+
+		links.RemoveLink("b")
+		if links.IngressConflict("a") != "" {
+			t.Errorf("%s: conflict kept after removal", mode)
+		}
+		if u := links.IngressConflictUpdates("b"); len(u) != 1 || u[0] != "a" {
+			t.Errorf("%s: unexpected updates after removal %v", mode, u)
+		}
+	}
+}
+
+func TestMaxEgress(t *testing.T) {
+	links := NewLinks(nil)
+	kl := testKubeLink("a", "192.168.0.10/24", "100.64.1.0/24")
+	kl.Spec.Egress = []string{"100.64.2.0/24", "100.64.3.0/24"}
+
+	links.SetMaxEgress(3)
+	if _, err := links.LinkFor(logger.New(), kl); err != nil {
+		t.Errorf("exact limit rejected: %s", err)
+	}
+
+	kl.Spec.Egress = append(kl.Spec.Egress, "100.64.4.0/24")
+	if _, err := links.LinkFor(logger.New(), kl); err == nil {
+		t.Errorf("limit exceeded by one not rejected")
+	}
+
+	links.SetMaxEgress(0)
+	if _, err := links.LinkFor(logger.New(), kl); err != nil {
+		t.Errorf("disabled limit rejected link: %s", err)
+	}
+}
+
+func TestHostOnlyLink(t *testing.T) {
+	links := NewLinks(nil)
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	h, err := links.UpdateLink(logger.New(), testKubeLink("h", "192.168.0.12/24", ""))
+	if err != nil {
+		t.Fatalf("host-only link rejected: %s", err)
+	}
+	if !h.IsHostOnly() || links.GetLink("a").IsHostOnly() {
+		t.Errorf("host-only state not detected")
+	}
+	if l := links.GetLinkForClusterAddress(net.ParseIP("192.168.0.12")); l == nil || l.Name != "h" {
+		t.Errorf("host-only link not found for its cluster address")
+	}
+	if l := links.GetLinkForIP(net.ParseIP("192.168.0.12")); l == nil || l.Name != "h" {
+		t.Errorf("host-only link not used for its cluster address")
+	}
+	if l := links.GetLinkForIP(net.ParseIP("10.1.2.3")); l != nil {
+		t.Errorf("arbitrary address matched by link %s", l.Name)
+	}
+	if l := links.GetLinkForIP(net.ParseIP("100.64.1.5")); l == nil || l.Name != "a" {
+		t.Errorf("egress address not matched by link a")
+	}
+
+	ifce := &NodeInterface{Name: "eth0", Index: 2, IP: net.ParseIP("10.250.0.10")}
+	for _, r := range links.GetRoutes(ifce) {
+		if r.Gw.Equal(net.ParseIP("10.0.0.1")) && r.Dst.Contains(net.ParseIP("192.168.0.12")) {
+			continue
+		}
+		if r.Dst.String() != "100.64.1.0/24" {
+			t.Errorf("unexpected route %s", DescribeRoute(r))
+		}
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// Mesh describes the set of links sharing the same cluster mesh network.
+type Mesh struct {
+	CIDR    *net.IPNet
+	Members []*Link
+}
+
+func (this *Mesh) Name() string {
+	return this.CIDR.String()
+}
+
+func (this *Mesh) GetMember(name string) *Link {
+	for _, l := range this.Members {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// MeshStats aggregates the link states and traffic counters of
+// the members of a mesh.
+type MeshStats struct {
+	Links  int
+	Active int
+	Failed int
+	LinkStats
+}
+
+// Stats sums up the stats of all mesh members. The state function
+// is used to determine the connection state of a member link.
+func (this *Mesh) Stats(state func(l *Link) string) MeshStats {
+	stats := MeshStats{}
+	for _, l := range this.Members {
+		stats.Links++
+		switch state(l) {
+		case v1alpha1.STATE_UP:
+			stats.Active++
+		case v1alpha1.STATE_ERROR:
+			stats.Failed++
+		}
+		stats.Add(l.Stats.Snapshot())
+	}
+	return stats
+}
+
+type Meshes map[string]*Mesh
+
+// Names returns the ordered list of mesh names.
+func (this Meshes) Names() []string {
+	names := []string{}
+	for n := range this {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetMeshForIP returns the mesh whose network contains the given ip.
+func (this Meshes) GetMeshForIP(ip net.IP) *Mesh {
+	for _, m := range this {
+		if m.CIDR.Contains(ip) {
+			return m
+		}
+	}
+	return nil
+}
+
+// MeshConflict describes a mesh member whose mesh network disagrees
+// with the declared mesh network.
+type MeshConflict struct {
+	Member   *Link
+	CIDR     *net.IPNet
+	Declared *net.IPNet
+}
+
+func (this MeshConflict) String() string {
+	return fmt.Sprintf("link %s uses mesh %s, but mesh is declared as %s", this.Member.Name, this.CIDR, this.Declared)
+}
+
+// CheckConsistency reports the members of meshes overlapping with, but
+// differing from the declared mesh network. If no mesh network is
+// declared, overlapping meshes are checked against each other and the
+// members of the mesh with fewer members are reported.
+func (this Meshes) CheckConsistency(declared *net.IPNet) []MeshConflict {
+	var conflicts []MeshConflict
+	report := func(m *Mesh, declared *net.IPNet) {
+		for _, l := range m.Members {
+			conflicts = append(conflicts, MeshConflict{Member: l, CIDR: m.CIDR, Declared: declared})
+		}
+	}
+	names := this.Names()
+	if declared != nil {
+		declared = tcp.CIDRNet(declared)
+		for _, n := range names {
+			m := this[n]
+			if !tcp.EqualCIDR(m.CIDR, declared) && tcp.OverlappingCIDR(m.CIDR, declared) {
+				report(m, declared)
+			}
+		}
+		return conflicts
+	}
+	for i, n := range names {
+		for _, o := range names[i+1:] {
+			a, b := this[n], this[o]
+			if !tcp.OverlappingCIDR(a.CIDR, b.CIDR) {
+				continue
+			}
+			if len(a.Members) < len(b.Members) {
+				report(a, b.CIDR)
+			} else {
+				report(b, a.CIDR)
+			}
+		}
+	}
+	return conflicts
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetMeshes aggregates the actual links by the mesh network
+// of their cluster address.
+func (this *Links) GetMeshes() Meshes {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	meshes := Meshes{}
+	for _, l := range this.links {
+		cidr := tcp.CIDRNet(l.ClusterAddress)
+		m := meshes[cidr.String()]
+		if m == nil {
+			m = &Mesh{CIDR: cidr}
+			meshes[cidr.String()] = m
+		}
+		m.Members = append(m.Members, l)
+	}
+	for _, m := range meshes {
+		sort.Slice(m.Members, func(i, j int) bool { return m.Members[i].Name < m.Members[j].Name })
+	}
+	return meshes
+}
+
+// AllocateClusterAddress returns the first address of the given mesh
+// network not used as cluster address by any link and not contained in
+// the excluded addresses (e.g. the local cluster address). The network
+// and broadcast addresses are never returned.
+func (this *Links) AllocateClusterAddress(meshCIDR *net.IPNet, exclude ...net.IP) (net.IP, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	cidr := tcp.CIDRNet(meshCIDR)
+	ones, bits := cidr.Mask.Size()
+	if bits-ones < 2 {
+		return nil, fmt.Errorf("mesh %s too small for cluster addresses", cidr)
+	}
+	max := len(this.clusteraddr) + len(exclude) + 1
+	if bits-ones < 32 {
+		if size := 1<<uint(bits-ones) - 2; size < max {
+			max = size
+		}
+	}
+	for n := 1; n <= max; n++ {
+		ip := tcp.SubIP(cidr, n)
+		if this.clusteraddr[ip.String()] == nil && !containsIP(exclude, ip) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no free cluster address left in mesh %s", cidr)
+}
+
+func containsIP(list []net.IP, ip net.IP) bool {
+	for _, e := range list {
+		if e.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClusterIdentity describes a cluster to be added to a mesh.
+type ClusterIdentity struct {
+	Name     string
+	Endpoint string
+	// CIDR is the optional service network of the cluster
+	CIDR *net.IPNet
+	// ServerName is the optional name expected in the server certificate
+	ServerName string
+}
+
+// NewKubeLink generates a KubeLink for a new member of the mesh. The
+// cluster address is allocated from the free addresses of the mesh
+// omitting the excluded addresses.
+func (this *Links) NewKubeLink(meshCIDR *net.IPNet, id ClusterIdentity, exclude ...net.IP) (*v1alpha1.KubeLink, error) {
+	if id.Name == "" {
+		return nil, fmt.Errorf("cluster name required")
+	}
+	if id.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint required for cluster %s", id.Name)
+	}
+	if this.GetLink(id.Name) != nil {
+		return nil, fmt.Errorf("link %s already exists", id.Name)
+	}
+	if id.CIDR != nil {
+		for _, m := range this.GetMeshes() {
+			for _, l := range m.Members {
+				if l.ServiceCIDR != nil && tcp.OverlappingCIDR(l.ServiceCIDR, id.CIDR) {
+					return nil, fmt.Errorf("cidr %s of cluster %s overlaps with cidr %s of link %s", id.CIDR, id.Name, l.ServiceCIDR, l.Name)
+				}
+			}
+		}
+	}
+	ip, err := this.AllocateClusterAddress(meshCIDR, exclude...)
+	if err != nil {
+		return nil, err
+	}
+	klink := &v1alpha1.KubeLink{}
+	klink.Name = id.Name
+	klink.Spec.ClusterAddress = tcp.CIDRIP(tcp.CIDRNet(meshCIDR), ip).String()
+	klink.Spec.Endpoint = id.Endpoint
+	klink.Spec.ServerName = id.ServerName
+	if id.CIDR != nil {
+		klink.Spec.CIDR = id.CIDR.String()
+	}
+	return klink, nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func TestAllocateClusterAddress(t *testing.T) {
+	links := NewLinks(nil)
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("a", "192.168.0.1/29", "100.64.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	_, mesh, _ := net.ParseCIDR("192.168.0.0/29")
+	local := net.ParseIP("192.168.0.2")
+
+	ip, err := links.AllocateClusterAddress(mesh, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("192.168.0.3")) {
+		t.Errorf("unexpected address %s", ip)
+	}
+
+	for i := 3; i <= 6; i++ {
+		klink, err := links.NewKubeLink(mesh, ClusterIdentity{Name: fmt.Sprintf("c%d", i), Endpoint: "c.example.com:80"}, local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if klink.Spec.ClusterAddress == "192.168.0.2/29" {
+			t.Fatalf("local address allocated")
+		}
+		klink.Status.Gateway = "10.0.0.1"
+		if _, err := links.UpdateLink(logger.New(), klink); err != nil {
+			t.Fatalf("generated link %s invalid: %s", klink.Name, err)
+		}
+	}
+	if ip, err := links.AllocateClusterAddress(mesh, local); err == nil {
+		t.Errorf("exhausted mesh returned %s", ip)
+	}
+}
+
+func TestCheckMeshConsistency(t *testing.T) {
+	links := NewLinks(nil)
+	for _, kl := range []*v1alpha1.KubeLink{
+		testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24"),
+		testKubeLink("b", "192.168.0.12/24", "100.64.2.0/24"),
+	} {
+		if _, err := links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, declared, _ := net.ParseCIDR("192.168.0.0/24")
+	if c := links.GetMeshes().CheckConsistency(declared); len(c) != 0 {
+		t.Errorf("consistent mesh reported: %v", c)
+	}
+	if c := links.GetMeshes().CheckConsistency(nil); len(c) != 0 {
+		t.Errorf("consistent mesh reported without declaration: %v", c)
+	}
+
+	// a divergent member using a wider mesh network
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("c", "192.168.0.13/16", "100.64.3.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	// an independent mesh
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("d", "10.10.0.1/24", "100.64.4.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*net.IPNet{declared, nil} {
+		conflicts := links.GetMeshes().CheckConsistency(d)
+		if len(conflicts) != 1 {
+			t.Fatalf("declared %v: expected one conflict, found %v", d, conflicts)
+		}
+		c := conflicts[0]
+		if c.Member.Name != "c" || c.CIDR.String() != "192.168.0.0/16" || c.Declared.String() != "192.168.0.0/24" {
+			t.Errorf("declared %v: unexpected conflict %s", d, c)
+		}
+	}
+}
+
+func TestNewKubeLink(t *testing.T) {
+	links := NewLinks(nil)
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("a", "192.168.0.1/24", "100.64.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	_, mesh, _ := net.ParseCIDR("192.168.0.0/24")
+	_, cidr, _ := net.ParseCIDR("100.64.2.0/24")
+
+	klink, err := links.NewKubeLink(mesh, ClusterIdentity{Name: "b", Endpoint: "b.example.com:80", CIDR: cidr, ServerName: "b.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if klink.Name != "b" || klink.Spec.ClusterAddress != "192.168.0.2/24" || klink.Spec.Endpoint != "b.example.com:80" ||
+		klink.Spec.CIDR != "100.64.2.0/24" || klink.Spec.ServerName != "b.example.org" {
+		t.Errorf("unexpected spec %+v", klink.Spec)
+	}
+	klink.Status.Gateway = "10.0.0.1"
+	if _, err := links.LinkFor(logger.New(), klink); err != nil {
+		t.Errorf("generated link invalid: %s", err)
+	}
+
+	_, overlap, _ := net.ParseCIDR("100.64.1.128/25")
+	for name, id := range map[string]ClusterIdentity{
+		"missing name":     {Endpoint: "c.example.com:80"},
+		"missing endpoint": {Name: "c"},
+		"existing link":    {Name: "a", Endpoint: "a.example.com:80"},
+		"overlapping cidr": {Name: "c", Endpoint: "c.example.com:80", CIDR: overlap},
+	} {
+		if klink, err := links.NewKubeLink(mesh, id); err == nil {
+			t.Errorf("%s: generated %+v", name, klink.Spec)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+const MESH_HEALTHY = "Healthy"
+const MESH_DEGRADED = "Degraded"
+const MESH_CRITICAL = "Critical"
+
+// HealthThresholds are the percentages of failed mesh members for
+// a mesh to be considered degraded or critical.
+type HealthThresholds struct {
+	Degraded int
+	Critical int
+}
+
+// MeshHealth is the aggregated health of the members of a mesh.
+// Degraded members are connected via a failover endpoint.
+type MeshHealth struct {
+	Mesh      string `json:"mesh"`
+	Members   int    `json:"members"`
+	Connected int    `json:"connected"`
+	Degraded  int    `json:"degraded"`
+	Failed    int    `json:"failed"`
+	Status    string `json:"status"`
+}
+
+// Health classifies the state of the mesh members. The state function
+// is used to determine the connection state of a member link.
+func (this *Mesh) Health(state func(l *Link) string, thresholds HealthThresholds) MeshHealth {
+	health := MeshHealth{Mesh: this.Name()}
+	for _, l := range this.Members {
+		health.Members++
+		switch state(l) {
+		case v1alpha1.STATE_UP:
+			health.Connected++
+			if l.ActiveEndpoint != "" && l.ActiveEndpoint != l.Endpoint {
+				health.Degraded++
+			}
+		case v1alpha1.STATE_ERROR:
+			health.Failed++
+		}
+	}
+	health.Status = MESH_HEALTHY
+	if health.Members == 0 {
+		return health
+	}
+	failed := health.Failed * 100
+	switch {
+	case health.Failed > 0 && failed >= thresholds.Critical*health.Members:
+		health.Status = MESH_CRITICAL
+	case health.Failed > 0 && failed > thresholds.Degraded*health.Members, health.Degraded > 0:
+		health.Status = MESH_DEGRADED
+	}
+	return health
+}
+
+// Health returns the health of all meshes ordered by name.
+func (this Meshes) Health(state func(l *Link) string, thresholds HealthThresholds) []MeshHealth {
+	result := []MeshHealth{}
+	for _, n := range this.Names() {
+		result = append(result, this[n].Health(state, thresholds))
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func TestMeshHealth(t *testing.T) {
+	thresholds := HealthThresholds{Degraded: 20, Critical: 50}
+	_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+
+	cases := map[string]struct {
+		states   []string
+		failover int
+		expected MeshHealth
+	}{
+		"empty": {nil, 0, MeshHealth{Status: MESH_HEALTHY}},
+		"healthy": {[]string{v1alpha1.STATE_UP, v1alpha1.STATE_UP, v1alpha1.STATE_UP, v1alpha1.STATE_UP, v1alpha1.STATE_UP}, 0,
+			MeshHealth{Members: 5, Connected: 5, Status: MESH_HEALTHY}},
+		"failover": {[]string{v1alpha1.STATE_UP, v1alpha1.STATE_UP}, 1,
+			MeshHealth{Members: 2, Connected: 2, Degraded: 1, Status: MESH_DEGRADED}},
+		"failed below threshold": {[]string{v1alpha1.STATE_UP, v1alpha1.STATE_UP, v1alpha1.STATE_UP, v1alpha1.STATE_UP, v1alpha1.STATE_ERROR}, 0,
+			MeshHealth{Members: 5, Connected: 4, Failed: 1, Status: MESH_HEALTHY}},
+		"failed above threshold": {[]string{v1alpha1.STATE_UP, v1alpha1.STATE_UP, v1alpha1.STATE_ERROR, v1alpha1.STATE_INVALID}, 0,
+			MeshHealth{Members: 4, Connected: 2, Failed: 1, Status: MESH_DEGRADED}},
+		"critical": {[]string{v1alpha1.STATE_UP, v1alpha1.STATE_ERROR, v1alpha1.STATE_ERROR, v1alpha1.STATE_UP}, 0,
+			MeshHealth{Members: 4, Connected: 2, Failed: 2, Status: MESH_CRITICAL}},
+	}
+	for name, c := range cases {
+		mesh := &Mesh{CIDR: cidr}
+		states := map[string]string{}
+		for i, s := range c.states {
+			l := &Link{Name: fmt.Sprintf("l%d", i), Endpoint: "primary:80", ActiveEndpoint: "primary:80"}
+			if i < c.failover {
+				l.ActiveEndpoint = "backup:80"
+			}
+			mesh.Members = append(mesh.Members, l)
+			states[l.Name] = s
+		}
+		health := mesh.Health(func(l *Link) string { return states[l.Name] }, thresholds)
+		c.expected.Mesh = "192.168.0.0/24"
+		if health != c.expected {
+			t.Errorf("%s: got %+v, expected %+v", name, health, c.expected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+const MESH_MEMBER_ADDED = "added"
+const MESH_MEMBER_REMOVED = "removed"
+
+// DEFAULT_MESH_WATCH_BUFFER is the number of events buffered for
+// a mesh watch.
+const DEFAULT_MESH_WATCH_BUFFER = 100
+
+// MeshEvent describes a membership change of a mesh.
+type MeshEvent struct {
+	Time           time.Time `json:"time"`
+	Mesh           string    `json:"mesh"`
+	Action         string    `json:"action"`
+	Member         string    `json:"member"`
+	ClusterAddress string    `json:"clusterAddress"`
+}
+
+// MeshWatch streams the membership events of one or all meshes.
+// It starts with an added event for every actual member. If the
+// consumer does not keep up with the events the watch is closed
+// and has to be restarted.
+type MeshWatch struct {
+	links  *Links
+	mesh   string
+	events chan MeshEvent
+	closed bool
+}
+
+// Events returns the event channel, which is closed when the watch
+// is stopped or overflows.
+func (this *MeshWatch) Events() <-chan MeshEvent {
+	return this.events
+}
+
+// Stop stops the watch.
+func (this *MeshWatch) Stop() {
+	this.links.meshLock.Lock()
+	defer this.links.meshLock.Unlock()
+	this.close()
+}
+
+func (this *MeshWatch) close() {
+	if !this.closed {
+		this.closed = true
+		close(this.events)
+		delete(this.links.meshWatches, this)
+	}
+}
+
+func (this *MeshWatch) send(e MeshEvent) {
+	if this.closed || (this.mesh != "" && this.mesh != e.Mesh) {
+		return
+	}
+	select {
+	case this.events <- e:
+	default:
+		this.close()
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// WatchMeshes starts a watch for the membership events of the given
+// mesh (or all meshes for an empty name).
+func (this *Links) WatchMeshes(mesh string, buffer int) *MeshWatch {
+	if buffer <= 0 {
+		buffer = DEFAULT_MESH_WATCH_BUFFER
+	}
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	this.meshLock.Lock()
+	defer this.meshLock.Unlock()
+
+	w := &MeshWatch{
+		links:  this,
+		mesh:   mesh,
+		events: make(chan MeshEvent, buffer+len(this.links)),
+	}
+	now := time.Now()
+	for _, l := range this.links {
+		w.send(meshEvent(now, MESH_MEMBER_ADDED, l))
+	}
+	if this.meshWatches == nil {
+		this.meshWatches = map[*MeshWatch]struct{}{}
+	}
+	this.meshWatches[w] = struct{}{}
+	return w
+}
+
+func meshEvent(now time.Time, action string, l *Link) MeshEvent {
+	return MeshEvent{
+		Time:           now,
+		Mesh:           tcp.CIDRNet(l.ClusterAddress).String(),
+		Action:         action,
+		Member:         l.Name,
+		ClusterAddress: l.ClusterAddress.IP.String(),
+	}
+}
+
+// notifyMeshes propagates the membership changes caused by the
+// change from an old to a new version of a link to the mesh watches.
+func (this *Links) notifyMeshes(old, new *Link) {
+	this.meshLock.Lock()
+	defer this.meshLock.Unlock()
+	if len(this.meshWatches) == 0 {
+		return
+	}
+	var events []MeshEvent
+	now := time.Now()
+	if old != nil && new != nil && tcp.EqualCIDR(tcp.CIDRNet(old.ClusterAddress), tcp.CIDRNet(new.ClusterAddress)) {
+		return
+	}
+	if old != nil {
+		events = append(events, meshEvent(now, MESH_MEMBER_REMOVED, old))
+	}
+	if new != nil {
+		events = append(events, meshEvent(now, MESH_MEMBER_ADDED, new))
+	}
+	for w := range this.meshWatches {
+		for _, e := range events {
+			w.send(e)
+		}
+	}
+}
+
+// ServeMeshWatch streams the mesh membership events as json lines.
+// The query parameter mesh restricts the events to a dedicated mesh.
+func (this *Links) ServeMeshWatch(w http.ResponseWriter, r *http.Request) {
+	watch := this.WatchMeshes(r.URL.Query().Get("mesh"), 0)
+	defer watch.Stop()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-watch.Events():
+			if !ok {
+				return
+			}
+			if enc.Encode(e) != nil {
+				return
+			}
+			if flusher != nil && len(watch.Events()) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+// testMeshEvents reads the pending events of a watch as
+// action/mesh/member strings.
+func testMeshEvents(t *testing.T, w *MeshWatch, n int) []string {
+	var result []string
+	for i := 0; i < n; i++ {
+		select {
+		case e, ok := <-w.Events():
+			if !ok {
+				t.Fatalf("watch closed")
+			}
+			result = append(result, e.Action+" "+e.Mesh+" "+e.Member)
+		case <-time.After(time.Second):
+			t.Fatalf("missing event %d", i)
+		}
+	}
+	select {
+	case e, ok := <-w.Events():
+		if ok {
+			t.Errorf("unexpected event %+v", e)
+		}
+	default:
+	}
+	return result
+}
+
+func checkMeshEvents(t *testing.T, name string, got []string, expected ...string) {
+	if len(got) != len(expected) {
+		t.Errorf("%s: got events %v, expected %v", name, got, expected)
+		return
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("%s: event %d: got %q, expected %q", name, i, got[i], expected[i])
+		}
+	}
+}
+
+func TestMeshWatch(t *testing.T) {
+	links := NewLinks(nil)
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+
+	all := links.WatchMeshes("", 0)
+	defer all.Stop()
+	mesh := links.WatchMeshes("10.1.0.0/16", 0)
+	defer mesh.Stop()
+	checkMeshEvents(t, "initial", testMeshEvents(t, all, 1), "added 192.168.0.0/24 a")
+	checkMeshEvents(t, "initial mesh", testMeshEvents(t, mesh, 0))
+
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("b", "10.1.0.12/16", "100.64.2.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	checkMeshEvents(t, "join", testMeshEvents(t, all, 1), "added 10.1.0.0/16 b")
+	checkMeshEvents(t, "join mesh", testMeshEvents(t, mesh, 1), "added 10.1.0.0/16 b")
+
+	// changes not affecting the membership are not reported
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("b", "10.1.0.13/16", "100.64.2.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	checkMeshEvents(t, "update", testMeshEvents(t, all, 0))
+
+	// moving a member to another mesh
+	if _, err := links.UpdateLink(logger.New(), testKubeLink("a", "10.1.0.11/16", "100.64.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	checkMeshEvents(t, "move", testMeshEvents(t, all, 2), "removed 192.168.0.0/24 a", "added 10.1.0.0/16 a")
+	checkMeshEvents(t, "move mesh", testMeshEvents(t, mesh, 1), "added 10.1.0.0/16 a")
+
+	links.RemoveLink("b")
+	checkMeshEvents(t, "leave", testMeshEvents(t, all, 1), "removed 10.1.0.0/16 b")
+	checkMeshEvents(t, "leave mesh", testMeshEvents(t, mesh, 1), "removed 10.1.0.0/16 b")
+
+	mesh.Stop()
+	if _, ok := <-mesh.Events(); ok {
+		t.Errorf("stopped watch not closed")
+	}
+	links.RemoveLink("a")
+	checkMeshEvents(t, "stopped", testMeshEvents(t, all, 1), "removed 10.1.0.0/16 a")
+}
+
+func TestMeshWatchOverflow(t *testing.T) {
+	links := NewLinks(nil)
+	w := links.WatchMeshes("", 1)
+	defer w.Stop()
+
+	links.UpdateLink(logger.New(), testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24"))
+	links.UpdateLink(logger.New(), testKubeLink("b", "192.168.0.12/24", "100.64.2.0/24"))
+
+	<-w.Events()
+	if _, ok := <-w.Events(); ok {
+		t.Errorf("overflowing watch not closed")
+	}
+}
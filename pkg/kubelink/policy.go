@@ -0,0 +1,137 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"net"
+	"sort"
+
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// LinkPolicy is a read-only NetworkPolicy like view of the traffic
+// a link is allowed to send into the local cluster. The rules use
+// destinations instead of pod selectors, because the broker filters
+// by destination address.
+type LinkPolicy struct {
+	Link string                         `json:"link"`
+	From []networking.NetworkPolicyPeer `json:"from"`
+	// Rules are the allowed destinations, traffic not matching any
+	// rule is dropped.
+	Rules []LinkPolicyRule `json:"rules"`
+}
+
+// LinkPolicyRule allows traffic to the given destinations
+// and ports. Empty ports match all ports.
+type LinkPolicyRule struct {
+	To    []networking.NetworkPolicyPeer `json:"to"`
+	Ports []networking.NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+var policyProtocols = map[byte]core.Protocol{
+	PROTO_TCP: core.ProtocolTCP,
+	PROTO_UDP: core.ProtocolUDP,
+}
+
+func policyPorts(proto byte, port uint16) []networking.NetworkPolicyPort {
+	if port == 0 && proto == PROTO_ANY {
+		return nil
+	}
+	p := networking.NetworkPolicyPort{}
+	if n, ok := policyProtocols[proto]; ok {
+		p.Protocol = &n
+	}
+	if port != 0 {
+		v := intstr.FromInt(int(port))
+		p.Port = &v
+	}
+	return []networking.NetworkPolicyPort{p}
+}
+
+func ipBlock(cidr *net.IPNet) networking.NetworkPolicyPeer {
+	return networking.NetworkPolicyPeer{IPBlock: &networking.IPBlock{CIDR: tcp.CIDRNet(cidr).String()}}
+}
+
+// Policy returns the policy view of the ingress configuration of the
+// link. Without explicit ingress the local networks are allowed, or
+// any destination if there are none. Service endpoints exposed to the
+// mesh are always allowed.
+func (this *Link) Policy(local tcp.CIDRList, services ServiceEndpoints) *LinkPolicy {
+	policy := &LinkPolicy{
+		Link: this.Name,
+		From: []networking.NetworkPolicyPeer{ipBlock((&ServiceEndpoint{IP: this.ClusterAddress.IP}).HostNet())},
+	}
+	var allowed tcp.CIDRList
+	if this.Ingress.IsSet() {
+		for _, r := range this.IngressRules {
+			if r.Port == 0 && r.Protocol == PROTO_ANY {
+				allowed.Add(r.CIDR)
+			}
+		}
+	} else {
+		allowed = local
+		if len(allowed) == 0 {
+			_, all, _ := net.ParseCIDR("0.0.0.0/0")
+			allowed = tcp.CIDRList{all}
+		}
+	}
+	if len(allowed) > 0 {
+		rule := LinkPolicyRule{}
+		for _, c := range allowed {
+			rule.To = append(rule.To, ipBlock(c))
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+	for _, r := range this.IngressRules {
+		if r.Port != 0 || r.Protocol != PROTO_ANY {
+			policy.Rules = append(policy.Rules, LinkPolicyRule{
+				To:    []networking.NetworkPolicyPeer{ipBlock(r.CIDR)},
+				Ports: policyPorts(r.Protocol, r.Port),
+			})
+		}
+	}
+	for _, s := range services {
+		policy.Rules = append(policy.Rules, LinkPolicyRule{
+			To:    []networking.NetworkPolicyPeer{ipBlock(s.HostNet())},
+			Ports: policyPorts(s.Protocol, s.Port),
+		})
+	}
+	return policy
+}
+
+// Policies returns the policy views of all links sorted by name.
+func (this *Links) Policies(local tcp.CIDRList, services ServiceEndpoints) []*LinkPolicy {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	names := make([]string, 0, len(this.links))
+	for n := range this.links {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := []*LinkPolicy{}
+	for _, n := range names {
+		result = append(result, this.links[n].Policy(local, services))
+	}
+	return result
+}
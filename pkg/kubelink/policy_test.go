@@ -0,0 +1,80 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+func TestLinkPolicy(t *testing.T) {
+	links := NewLinks(nil)
+	kl := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+	kl.Spec.Ingress = []string{"10.1.0.0/16", "10.2.0.0/16:tcp/443", "10.3.0.0/16:udp"}
+	l, err := links.UpdateLink(logger.New(), kl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services, err := ParseServiceEndpoints([]string{"10.96.0.10:53/udp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, local, _ := net.ParseCIDR("10.0.0.0/8")
+
+	cases := map[string]struct {
+		ingress []string
+		policy  string
+	}{
+		"ingress": {kl.Spec.Ingress, `{"link":"a","from":[{"ipBlock":{"cidr":"192.168.0.11/32"}}],"rules":[` +
+			`{"to":[{"ipBlock":{"cidr":"10.1.0.0/16"}}]},` +
+			`{"to":[{"ipBlock":{"cidr":"10.2.0.0/16"}}],"ports":[{"protocol":"TCP","port":443}]},` +
+			`{"to":[{"ipBlock":{"cidr":"10.3.0.0/16"}}],"ports":[{"protocol":"UDP"}]},` +
+			`{"to":[{"ipBlock":{"cidr":"10.96.0.10/32"}}],"ports":[{"protocol":"UDP","port":53}]}]}`},
+		"no ingress": {nil, `{"link":"a","from":[{"ipBlock":{"cidr":"192.168.0.11/32"}}],"rules":[` +
+			`{"to":[{"ipBlock":{"cidr":"10.0.0.0/8"}}]},` +
+			`{"to":[{"ipBlock":{"cidr":"10.96.0.10/32"}}],"ports":[{"protocol":"UDP","port":53}]}]}`},
+	}
+	for name, c := range cases {
+		kl.Spec.Ingress = c.ingress
+		if l, err = links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(l.Policy(tcp.CIDRList{local}, services))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.policy {
+			t.Errorf("%s: unexpected policy\n%s", name, data)
+		}
+	}
+
+	kl.Spec.Ingress = nil
+	if l, err = links.UpdateLink(logger.New(), kl); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(l.Policy(nil, nil))
+	if expected := `{"link":"a","from":[{"ipBlock":{"cidr":"192.168.0.11/32"}}],"rules":[{"to":[{"ipBlock":{"cidr":"0.0.0.0/0"}}]}]}`; string(data) != expected {
+		t.Errorf("without local networks: unexpected policy\n%s", data)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func TestRestoreData(t *testing.T) {
+	links := NewLinks(nil)
+	a := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+	a.Spec.Egress = []string{"10.1.0.0/16"}
+	// served by the local node, therefore no SNAT rule
+	b := testKubeLink("b", "192.168.0.12/24", "100.64.2.0/24")
+	b.Status.Gateway = "10.0.0.2"
+	for _, kl := range []*v1alpha1.KubeLink{a, b} {
+		if _, err := links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, mesh, _ := net.ParseCIDR("192.168.0.0/24")
+	ifce := &NodeInterface{Name: "eth0", IP: net.ParseIP("10.0.0.2")}
+
+	reqs := append(links.GetAntiSpoofingRules("kubelink", mesh), links.GetSNATRules(ifce)...)
+	restore, incremental := reqs.Split()
+	if len(incremental) != 1 || incremental[0].Chain.Chain != "PREROUTING" {
+		t.Errorf("unexpected incremental requests %v", incremental)
+	}
+	expected := `*raw
+:kubelink-antispoof - [0:0]
+-A kubelink-antispoof -i kubelink -s 192.168.0.0/24 -j RETURN
+-A kubelink-antispoof -i kubelink -s 100.64.1.0/24 -j RETURN
+-A kubelink-antispoof -i kubelink -s 10.1.0.0/16 -j RETURN
+-A kubelink-antispoof -i kubelink -s 100.64.2.0/24 -j RETURN
+-A kubelink-antispoof -i kubelink -j DROP
+COMMIT
+*nat
+:kubelink - [0:0]
+-A kubelink -d 100.64.1.0/24 -o eth0 -j SNAT --to-source 10.0.0.2
+-A kubelink -d 10.1.0.0/16 -o eth0 -j SNAT --to-source 10.0.0.2
+COMMIT
+`
+	if data := string(restore.RestoreData()); data != expected {
+		t.Errorf("unexpected restore data:\n%s", data)
+	}
+}
@@ -20,6 +20,9 @@ package kubelink
 
 import (
 	"fmt"
+	"net"
+	"strings"
+	"syscall"
 
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"github.com/vishvananda/netlink"
@@ -28,12 +31,91 @@ import (
 	"github.com/mandelsoft/kubelink/pkg/tcp"
 )
 
+// ROUTE_PROTOCOL is the routing protocol used to tag all routes
+// maintained by the kubelink router. It is used to identify stale
+// routes left by former instances.
+const ROUTE_PROTOCOL = 75
+
+// BROKER_ROUTE_PROTOCOL is the routing protocol used to tag all routes
+// maintained by the kubelink broker. It differs from the router's
+// protocol, so that both components may share a network namespace
+// without pruning each other's routes.
+const BROKER_ROUTE_PROTOCOL = 76
+
+// IsKubelinkRoute checks whether a route has been created by the
+// kubelink component using the given routing protocol.
+func IsKubelinkRoute(route *netlink.Route, protocol int) bool {
+	return route.Protocol == protocol
+}
+
+// NewUnreachableRoute returns a kubelink route rejecting all packets
+// for the given destination with an unreachable error.
+func NewUnreachableRoute(dst *net.IPNet, protocol int) netlink.Route {
+	return netlink.Route{
+		Dst:      dst,
+		Type:     syscall.RTN_UNREACHABLE,
+		Protocol: protocol,
+	}
+}
+
+// NewBlackholeRoute returns a kubelink route silently discarding
+// all packets for the given destination.
+func NewBlackholeRoute(dst *net.IPNet, protocol int) netlink.Route {
+	return netlink.Route{
+		Dst:      dst,
+		Type:     syscall.RTN_BLACKHOLE,
+		Protocol: protocol,
+	}
+}
+
+func routeType(r netlink.Route) int {
+	if r.Type == 0 {
+		return syscall.RTN_UNICAST
+	}
+	return r.Type
+}
+
 type Routes []netlink.Route
 
+// Protect splits the routes into the routes allowed to be installed
+// and the routes refused because they would shadow one of the protected
+// networks. The returned error describes the refused routes.
+func (this Routes) Protect(protected tcp.CIDRList) (Routes, Routes, error) {
+	var allowed Routes
+	var refused Routes
+	var msgs []string
+	for _, r := range this {
+		if r.Dst != nil {
+			if p := protectedBy(r.Dst, protected); p != nil {
+				refused = append(refused, r)
+				msgs = append(msgs, fmt.Sprintf("%s (shadows %s)", r.Dst, p))
+				continue
+			}
+		}
+		allowed = append(allowed, r)
+	}
+	if len(refused) > 0 {
+		return allowed, refused, fmt.Errorf("refusing routes shadowing protected networks: %s", strings.Join(msgs, ", "))
+	}
+	return allowed, nil, nil
+}
+
+func protectedBy(dst *net.IPNet, protected tcp.CIDRList) *net.IPNet {
+	for _, p := range protected {
+		if tcp.OverlappingCIDR(dst, p) {
+			return p
+		}
+	}
+	return nil
+}
+
 func (this Routes) Lookup(route netlink.Route) int {
 	for i, r := range this {
 		if r.LinkIndex == route.LinkIndex &&
+			routeType(r) == routeType(route) &&
 			r.Flags == route.Flags &&
+			r.Protocol == route.Protocol &&
+			r.MTU == route.MTU &&
 			r.Gw.Equal(route.Gw) &&
 			tcp.EqualCIDR(r.Dst, route.Dst) &&
 			tcp.EqualIP(r.Src, route.Src) {
@@ -53,6 +135,10 @@ func (this Routes) LookupAndLogMismatchReason(logger logger.LogContext, route ne
 			logger.Infof("gateway mismatch for %s (%s!=%s)", r, r.Gw, route.Gw.String())
 			continue
 		}
+		if r.MTU != route.MTU {
+			logger.Infof("mtu mismatch for %s (%d!=%d)", r, r.MTU, route.MTU)
+			continue
+		}
 		if r.Flags != route.Flags {
 			logger.Infof("flag mismatch for %s (%x!=%x)", r, r.Flags, route.Flags)
 			continue
@@ -66,6 +152,48 @@ func (this Routes) LookupAndLogMismatchReason(logger logger.LogContext, route ne
 	return -1
 }
 
+// Describe returns a textual description of the routes (destination,
+// gateway, table and priority). If there are more than max routes
+// (max > 0) the remaining routes are summarized in a final entry.
+func (this Routes) Describe(max int) []string {
+	var result []string
+	for i, r := range this {
+		if max > 0 && i >= max {
+			result = append(result, fmt.Sprintf("... and %d more routes", len(this)-max))
+			break
+		}
+		result = append(result, DescribeRoute(r))
+	}
+	return result
+}
+
+// DescribeRoute returns a textual description of a route.
+func DescribeRoute(r netlink.Route) string {
+	var s string
+	switch routeType(r) {
+	case syscall.RTN_UNREACHABLE:
+		s = "unreachable "
+	case syscall.RTN_BLACKHOLE:
+		s = "blackhole "
+	}
+	if r.Dst != nil {
+		s += r.Dst.String()
+	} else {
+		s += "default"
+	}
+	if r.Gw != nil {
+		s += " via " + r.Gw.String()
+	}
+	if r.MTU > 0 {
+		s += fmt.Sprintf(" mtu %d", r.MTU)
+	}
+	table := "main"
+	if r.Table != 0 && r.Table != syscall.RT_TABLE_MAIN {
+		table = fmt.Sprintf("%d", r.Table)
+	}
+	return fmt.Sprintf("%s table %s priority %d", s, table, r.Priority)
+}
+
 func (this *Routes) Add(route netlink.Route) Routes {
 	if this.Lookup(route) < 0 {
 		*this = append(*this, route)
@@ -118,5 +246,15 @@ func ListRoutes() (Routes, error) {
 		routes = append(routes, r...)
 
 	}
+	// kubelink routes without device, like unreachable routes
+	r, err := netlink.RouteList(nil, nl.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get routes: %s", err)
+	}
+	for _, route := range r {
+		if route.LinkIndex == 0 && (IsKubelinkRoute(&route, ROUTE_PROTOCOL) || IsKubelinkRoute(&route, BROKER_ROUTE_PROTOCOL)) {
+			routes = append(routes, route)
+		}
+	}
 	return routes, nil
 }
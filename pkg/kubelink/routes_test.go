@@ -0,0 +1,61 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+func TestRouteProtocols(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("100.64.1.0/24")
+
+	broker := NewUnreachableRoute(dst, BROKER_ROUTE_PROTOCOL)
+	router := NewBlackholeRoute(dst, ROUTE_PROTOCOL)
+
+	if IsKubelinkRoute(&broker, ROUTE_PROTOCOL) {
+		t.Errorf("broker route claimed by router")
+	}
+	if IsKubelinkRoute(&router, BROKER_ROUTE_PROTOCOL) {
+		t.Errorf("router route claimed by broker")
+	}
+	if !IsKubelinkRoute(&broker, BROKER_ROUTE_PROTOCOL) || !IsKubelinkRoute(&router, ROUTE_PROTOCOL) {
+		t.Errorf("own routes not recognized")
+	}
+}
+
+func TestProtectRoutes(t *testing.T) {
+	_, shadowing, _ := net.ParseCIDR("10.250.0.0/16")
+	_, other, _ := net.ParseCIDR("100.64.1.0/24")
+	_, protected, _ := net.ParseCIDR("10.250.0.10/32")
+	routes := Routes{{Dst: shadowing}, {Dst: other}}
+
+	allowed, refused, err := routes.Protect(tcp.CIDRList{protected})
+	if err == nil {
+		t.Errorf("no error for refused route")
+	}
+	if len(allowed) != 1 || allowed[0].Dst != other {
+		t.Errorf("unexpected allowed routes %v", allowed)
+	}
+	if len(refused) != 1 || refused[0].Dst != shadowing {
+		t.Errorf("unexpected refused routes %v", refused)
+	}
+}
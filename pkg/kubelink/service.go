@@ -0,0 +1,191 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const PROTO_ANY = 0
+const PROTO_TCP = 6
+const PROTO_UDP = 17
+
+var protocols = map[string]byte{
+	"tcp": PROTO_TCP,
+	"udp": PROTO_UDP,
+}
+
+// ServiceEndpoint describes a dedicated service of a cluster
+// exposed to the mesh. A zero port or protocol matches any
+// port or protocol.
+type ServiceEndpoint struct {
+	IP       net.IP
+	Port     uint16
+	Protocol byte
+}
+
+// ParseServiceEndpoint parses a service endpoint of the
+// form <ip>[:<port>[/<protocol>]].
+func ParseServiceEndpoint(s string) (*ServiceEndpoint, error) {
+	ep := &ServiceEndpoint{}
+	host := s
+	if strings.HasPrefix(s, "[") {
+		i := strings.Index(s, "]")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid service endpoint %q", s)
+		}
+		host = s[1:i]
+		s = s[i+1:]
+		if s != "" && !strings.HasPrefix(s, ":") {
+			return nil, fmt.Errorf("invalid service endpoint %q", s)
+		}
+	} else {
+		if i := strings.Index(s, ":"); i >= 0 {
+			host = s[:i]
+		}
+		s = s[len(host):]
+	}
+	ep.IP = net.ParseIP(host)
+	if ep.IP == nil {
+		return nil, fmt.Errorf("invalid service ip %q", host)
+	}
+	if s == "" {
+		return ep, nil
+	}
+	s = s[1:]
+	if i := strings.Index(s, "/"); i >= 0 {
+		p, ok := protocols[strings.ToLower(s[i+1:])]
+		if !ok {
+			return nil, fmt.Errorf("invalid service protocol %q", s[i+1:])
+		}
+		ep.Protocol = p
+		s = s[:i]
+	}
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service port %q: %s", s, err)
+	}
+	ep.Port = uint16(port)
+	return ep, nil
+}
+
+func (this *ServiceEndpoint) String() string {
+	s := this.IP.String()
+	if this.IP.To4() == nil {
+		s = "[" + s + "]"
+	}
+	if this.Port == 0 && this.Protocol == PROTO_ANY {
+		return s
+	}
+	s = fmt.Sprintf("%s:%d", s, this.Port)
+	for n, p := range protocols {
+		if p == this.Protocol {
+			s = s + "/" + n
+		}
+	}
+	return s
+}
+
+func (this *ServiceEndpoint) Equal(other *ServiceEndpoint) bool {
+	return this.IP.Equal(other.IP) && this.Port == other.Port && this.Protocol == other.Protocol
+}
+
+// Match checks whether a packet for the given destination
+// is covered by the service endpoint.
+func (this *ServiceEndpoint) Match(ip net.IP, proto byte, port uint16) bool {
+	if !this.IP.Equal(ip) {
+		return false
+	}
+	if this.Protocol != PROTO_ANY && this.Protocol != proto {
+		return false
+	}
+	return this.Port == 0 || this.Port == port
+}
+
+// HostNet returns the single host network for the service ip.
+func (this *ServiceEndpoint) HostNet() *net.IPNet {
+	if ip := this.IP.To4(); ip != nil {
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: this.IP, Mask: net.CIDRMask(128, 128)}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type ServiceEndpoints []*ServiceEndpoint
+
+func ParseServiceEndpoints(list []string) (ServiceEndpoints, error) {
+	var result ServiceEndpoints
+	for _, s := range list {
+		ep, err := ParseServiceEndpoint(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ep)
+	}
+	return result, nil
+}
+
+func (this ServiceEndpoints) Strings() []string {
+	var result []string
+	for _, ep := range this {
+		result = append(result, ep.String())
+	}
+	return result
+}
+
+func (this ServiceEndpoints) String() string {
+	return "[" + strings.Join(this.Strings(), ", ") + "]"
+}
+
+func (this ServiceEndpoints) Equal(other ServiceEndpoints) bool {
+	if len(this) != len(other) {
+		return false
+	}
+	for i, ep := range this {
+		if !ep.Equal(other[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains checks whether any endpoint uses the given ip.
+func (this ServiceEndpoints) Contains(ip net.IP) bool {
+	for _, ep := range this {
+		if ep.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match checks whether a packet for the given destination
+// is covered by any endpoint.
+func (this ServiceEndpoints) Match(ip net.IP, proto byte, port uint16) bool {
+	for _, ep := range this {
+		if ep.Match(ip, proto, port) {
+			return true
+		}
+	}
+	return false
+}
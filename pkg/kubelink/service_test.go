@@ -0,0 +1,127 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func TestServiceEndpointRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"10.0.0.1",
+		"10.0.0.1:80",
+		"10.0.0.1:53/udp",
+		"[fd00::1]",
+		"[fd00::1]:443",
+		"[fd00::1]:443/tcp",
+	} {
+		ep, err := ParseServiceEndpoint(s)
+		if err != nil {
+			t.Errorf("cannot parse %q: %s", s, err)
+			continue
+		}
+		if ep.String() != s {
+			t.Errorf("round trip of %q failed: got %q", s, ep.String())
+		}
+	}
+}
+
+func TestValidServices(t *testing.T) {
+	links := NewLinks(nil)
+	_, local, _ := net.ParseCIDR("10.96.0.0/16")
+	links.SetServiceCIDR(local, OVERLAP_WARN)
+
+	a := &v1alpha1.KubeLink{}
+	a.Name = "a"
+	a.Spec.ClusterAddress = "192.168.0.10/24"
+	a.Spec.CIDR = "100.64.1.0/24"
+	a.Spec.Endpoint = "a.example.com:80"
+	a.Status.Gateway = "10.0.0.1"
+	if _, err := links.UpdateLink(logger.New(), a); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &v1alpha1.KubeLink{}
+	b.Name = "b"
+	b.Spec.ClusterAddress = "192.168.0.11/24"
+	b.Spec.CIDR = "100.64.2.0/24"
+	b.Spec.Ingress = []string{"10.1.0.0/16"}
+	b.Spec.ServiceRanges = []string{"100.70.0.0/24", "10.0.0.0/8"}
+	b.Spec.Endpoint = "b.example.com:80"
+	b.Status.Gateway = "10.0.0.1"
+	b.Status.Services = []string{"100.64.1.5:80", "10.96.0.10:53/udp", "10.1.0.1", "100.70.0.1:443/tcp"}
+	l, err := links.UpdateLink(logger.New(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Services.Strings(); len(got) != 1 || got[0] != "100.70.0.1:443/tcp" {
+		t.Errorf("unexpected services %v", got)
+	}
+}
+
+func TestServiceRanges(t *testing.T) {
+	links := NewLinks(nil)
+	_, node, _ := net.ParseCIDR("10.250.0.0/16")
+	links.SetNodeCIDR(node)
+
+	a := &v1alpha1.KubeLink{}
+	a.Name = "a"
+	a.Spec.ClusterAddress = "192.168.0.10/24"
+	a.Spec.CIDR = "100.64.1.0/24"
+	a.Spec.ServiceRanges = []string{"100.70.0.0/24", "10.250.0.0/24"}
+	a.Spec.Endpoint = "a.example.com:80"
+	a.Status.Gateway = "10.250.0.2"
+	a.Status.Services = []string{
+		"100.64.1.5:80",      // egress
+		"100.70.0.1:443/tcp", // service range
+		"100.71.0.1:443/tcp", // outside of egress and service ranges
+		"10.250.0.10:22",     // node network
+	}
+	l, err := links.UpdateLink(logger.New(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Services.Strings(); len(got) != 2 || got[0] != "100.64.1.5:80" || got[1] != "100.70.0.1:443/tcp" {
+		t.Errorf("unexpected services %v", got)
+	}
+
+	ifce := &NodeInterface{Name: "eth0", Index: 2, IP: net.ParseIP("10.250.0.1")}
+	routes := links.GetRoutes(ifce)
+	for _, r := range routes {
+		for _, rejected := range []string{"100.71.0.1", "10.250.0.10"} {
+			if r.Dst.Contains(net.ParseIP(rejected)) {
+				t.Errorf("route %s installed for rejected service %s", r.Dst, rejected)
+			}
+		}
+	}
+	found := false
+	for _, r := range routes {
+		if r.Dst.String() == "100.70.0.1/32" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no route installed for service 100.70.0.1: %v", routes)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/cluster"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/resources"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+// testObject provides the object methods used by the link setup.
+type testObject struct {
+	resources.Object
+	link   *v1alpha1.KubeLink
+	events []string
+}
+
+func (this *testObject) GetName() string {
+	return this.link.Name
+}
+
+func (this *testObject) Data() resources.ObjectData {
+	return this.link
+}
+
+func (this *testObject) ModifyStatus(modifier resources.Modifier) (bool, error) {
+	return modifier(this.link)
+}
+
+func (this *testObject) Eventf(eventtype, reason, messageFmt string, args ...interface{}) {
+	this.events = append(this.events, eventtype+"/"+reason)
+}
+
+type testResource struct {
+	resources.Interface
+	objects []resources.Object
+}
+
+func (this *testResource) ListCached(selector labels.Selector) ([]resources.Object, error) {
+	return this.objects, nil
+}
+
+// allResources allows embedding the resources interface, which
+// itself has a method named Resources.
+type allResources = resources.Resources
+
+type testResources struct {
+	allResources
+	resource resources.Interface
+}
+
+func (this *testResources) Get(interface{}) (resources.Interface, error) {
+	return this.resource, nil
+}
+
+type testCluster struct {
+	cluster.Interface
+	resources resources.Resources
+}
+
+func (this *testCluster) Resources() resources.Resources {
+	return this.resources
+}
+
+func testSetupCluster(objects ...*testObject) cluster.Interface {
+	res := &testResource{}
+	for _, o := range objects {
+		res.objects = append(res.objects, o)
+	}
+	return &testCluster{resources: &testResources{resource: res}}
+}
+
+func TestSetupErrorStatus(t *testing.T) {
+	for name, events := range map[string]bool{"status": false, "events": true} {
+		valid := &testObject{link: testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")}
+		invalid := &testObject{link: testKubeLink("b", "192.168.0.12/24", "100.64.2.0/33")}
+
+		links := NewLinks(nil)
+		links.SetSetupEvents(events)
+		links.Setup(logger.New(), testSetupCluster(valid, invalid))
+
+		if links.GetLink("a") == nil {
+			t.Errorf("%s: valid link not loaded", name)
+		}
+		if valid.link.Status.State != "" || len(valid.events) != 0 {
+			t.Errorf("%s: status of valid link modified: %+v", name, valid.link.Status)
+		}
+
+		status := invalid.link.Status
+		if status.State != v1alpha1.STATE_INVALID || status.Message == "" || status.LastErrorTime == nil {
+			t.Errorf("%s: no error status for erroneous link: %+v", name, status)
+		}
+		if events {
+			if len(invalid.events) != 1 || invalid.events[0] != "Warning/InvalidLink" {
+				t.Errorf("%s: unexpected events %v", name, invalid.events)
+			}
+		} else if len(invalid.events) != 0 {
+			t.Errorf("%s: events emitted although disabled: %v", name, invalid.events)
+		}
+
+		// the setup is done only once
+		invalid.link.Status = v1alpha1.KubeLinkStatus{}
+		links.Setup(logger.New(), testSetupCluster(valid, invalid))
+		if invalid.link.Status.State != "" {
+			t.Errorf("%s: status written by repeated setup", name)
+		}
+	}
+}
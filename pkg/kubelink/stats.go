@@ -0,0 +1,126 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"sync/atomic"
+)
+
+// DropReason categorizes packets dropped by a tunnel connection.
+type DropReason int
+
+const (
+	DROP_UNKNOWN_SOURCE DropReason = iota
+	DROP_INGRESS_DENIED
+	DROP_WRONG_DESTINATION
+	DROP_UNKNOWN_TYPE
+	DROP_INVALID_HEADER
+	DROP_DUPLICATE
+	DROP_UNKNOWN_VERSION
+	DROP_OVERSIZED
+
+	// DROP_REASONS is the number of drop reasons
+	DROP_REASONS
+)
+
+var dropReasons = [DROP_REASONS]string{
+	"unknown-source",
+	"ingress-denied",
+	"wrong-destination",
+	"unknown-type",
+	"invalid-header",
+	"duplicate",
+	"unknown-version",
+	"oversized",
+}
+
+func (this DropReason) String() string {
+	if this < 0 || this >= DROP_REASONS {
+		return "unknown"
+	}
+	return dropReasons[this]
+}
+
+// LinkStats holds the traffic counters of a link. It is shared
+// by all versions of a link object.
+type LinkStats struct {
+	BytesIn    uint64
+	BytesOut   uint64
+	PacketsIn  uint64
+	PacketsOut uint64
+	Dropped    [DROP_REASONS]uint64
+	// Audited counts packets denied by the ingress but forwarded
+	// in audit mode.
+	Audited uint64
+}
+
+func (this *LinkStats) CountIn(n int) {
+	if this != nil {
+		atomic.AddUint64(&this.BytesIn, uint64(n))
+		atomic.AddUint64(&this.PacketsIn, 1)
+	}
+}
+
+func (this *LinkStats) CountOut(n int) {
+	if this != nil {
+		atomic.AddUint64(&this.BytesOut, uint64(n))
+		atomic.AddUint64(&this.PacketsOut, 1)
+	}
+}
+
+func (this *LinkStats) CountAudit() {
+	if this != nil {
+		atomic.AddUint64(&this.Audited, 1)
+	}
+}
+
+func (this *LinkStats) CountDrop(reason DropReason) {
+	if this != nil && reason >= 0 && reason < DROP_REASONS {
+		atomic.AddUint64(&this.Dropped[reason], 1)
+	}
+}
+
+// Snapshot returns a consistent copy of the actual counters.
+func (this *LinkStats) Snapshot() LinkStats {
+	if this == nil {
+		return LinkStats{}
+	}
+	result := LinkStats{
+		BytesIn:    atomic.LoadUint64(&this.BytesIn),
+		BytesOut:   atomic.LoadUint64(&this.BytesOut),
+		PacketsIn:  atomic.LoadUint64(&this.PacketsIn),
+		PacketsOut: atomic.LoadUint64(&this.PacketsOut),
+		Audited:    atomic.LoadUint64(&this.Audited),
+	}
+	for i := range this.Dropped {
+		result.Dropped[i] = atomic.LoadUint64(&this.Dropped[i])
+	}
+	return result
+}
+
+func (this *LinkStats) Add(o LinkStats) {
+	this.BytesIn += o.BytesIn
+	this.BytesOut += o.BytesOut
+	this.PacketsIn += o.PacketsIn
+	this.PacketsOut += o.PacketsOut
+	this.Audited += o.Audited
+	for i := range this.Dropped {
+		this.Dropped[i] += o.Dropped[i]
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+
+	"github.com/mandelsoft/kubelink/pkg/tcp"
+)
+
+// TraceMatch describes a link matching a traced destination.
+type TraceMatch struct {
+	Link   string
+	Reason string
+	CIDR   *net.IPNet
+}
+
+func (this *TraceMatch) prefix() int {
+	if this.CIDR == nil {
+		return -1
+	}
+	ones, _ := this.CIDR.Mask.Size()
+	return ones
+}
+
+func (this *TraceMatch) String() string {
+	return fmt.Sprintf("%s (%s %s)", this.Link, this.Reason, this.CIDR)
+}
+
+// Trace describes the decision path for a packet sent to a destination
+// address: the link selected for it, all matching candidates ordered
+// by their prefix length, the gateway and route used for it.
+// If a source address is given, the ingress verdict of the link
+// owning this cluster address is evaluated for the destination, too.
+type Trace struct {
+	Source      net.IP
+	Destination net.IP
+	Link        *TraceMatch
+	Candidates  []*TraceMatch
+	Gateway     net.IP
+	Route       *net.IPNet
+
+	SourceLink     string
+	IngressGranted bool
+	IngressSet     bool
+}
+
+// Trace evaluates the decision path for a packet from the given
+// (optional) source to the given destination address.
+func (this *Links) Trace(src, dst net.IP) *Trace {
+	trace := &Trace{
+		Source:      src,
+		Destination: dst,
+	}
+	link := this.GetLinkForIP(dst)
+
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	for _, l := range this.links {
+		if l.ClusterAddress != nil && l.ClusterAddress.IP.Equal(dst) {
+			trace.Candidates = append(trace.Candidates, &TraceMatch{l.Name, "cluster address", tcp.CIDRNet(l.ClusterAddress)})
+			continue
+		}
+		if l.IsHostOnly() && len(l.Services) == 0 {
+			continue
+		}
+		var match *TraceMatch
+		for _, e := range l.Egress {
+			if e.Contains(dst) {
+				m := &TraceMatch{l.Name, "egress", e}
+				if match == nil || m.prefix() > match.prefix() {
+					match = m
+				}
+			}
+		}
+		if match == nil {
+			for _, s := range l.Services {
+				if s.IP.Equal(dst) {
+					match = &TraceMatch{l.Name, "service", s.HostNet()}
+					break
+				}
+			}
+		}
+		if match != nil {
+			trace.Candidates = append(trace.Candidates, match)
+		}
+	}
+	sort.Slice(trace.Candidates, func(i, j int) bool {
+		pi, pj := trace.Candidates[i].prefix(), trace.Candidates[j].prefix()
+		if pi != pj {
+			return pi > pj
+		}
+		return trace.Candidates[i].Link < trace.Candidates[j].Link
+	})
+
+	if link != nil {
+		for _, c := range trace.Candidates {
+			if c.Link == link.Name {
+				trace.Link = c
+				trace.Route = c.CIDR
+				break
+			}
+		}
+		trace.Gateway = link.Gateway
+	}
+
+	if src != nil {
+		if l := this.clusteraddr[src.String()]; l != nil {
+			trace.SourceLink = l.Name
+			trace.IngressGranted, trace.IngressSet = l.AllowIngressAddress(dst)
+		}
+	}
+	return trace
+}
+
+// Write renders a human readable form of the trace.
+func (this *Trace) Write(w io.Writer) {
+	fmt.Fprintf(w, "destination: %s\n", this.Destination)
+	if len(this.Candidates) == 0 {
+		fmt.Fprintf(w, "candidates:  none\n")
+	} else {
+		fmt.Fprintf(w, "candidates:\n")
+		for _, c := range this.Candidates {
+			fmt.Fprintf(w, "  %s\n", c)
+		}
+	}
+	if this.Link == nil {
+		fmt.Fprintf(w, "link:        none\n")
+	} else {
+		fmt.Fprintf(w, "link:        %s\n", this.Link)
+		if len(this.Candidates) > 1 && this.Candidates[0].Link != this.Link.Link && this.Candidates[0].prefix() > this.Link.prefix() {
+			fmt.Fprintf(w, "warning:     more specific match %s not selected\n", this.Candidates[0])
+		}
+		if this.Gateway != nil {
+			fmt.Fprintf(w, "gateway:     %s\n", this.Gateway)
+			fmt.Fprintf(w, "route:       %s via %s\n", this.Route, this.Gateway)
+		} else {
+			fmt.Fprintf(w, "gateway:     none\n")
+		}
+	}
+	if this.Source != nil {
+		fmt.Fprintf(w, "source:      %s\n", this.Source)
+		switch {
+		case this.SourceLink == "":
+			fmt.Fprintf(w, "ingress:     dropped (unknown cluster address)\n")
+		case !this.IngressSet:
+			fmt.Fprintf(w, "ingress:     granted for link %s (no ingress restriction)\n", this.SourceLink)
+		case this.IngressGranted:
+			fmt.Fprintf(w, "ingress:     granted for link %s\n", this.SourceLink)
+		default:
+			fmt.Fprintf(w, "ingress:     dropped for link %s\n", this.SourceLink)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kubelink
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kubelink/pkg/apis/kubelink/v1alpha1"
+)
+
+func TestTrace(t *testing.T) {
+	links := NewLinks(nil)
+	a := testKubeLink("a", "192.168.0.11/24", "100.64.1.0/24")
+	a.Spec.Egress = []string{"10.1.0.0/16"}
+	b := testKubeLink("b", "192.168.0.12/24", "100.64.2.0/24")
+	b.Spec.Ingress = []string{"10.1.2.0/24"}
+	for _, kl := range []*v1alpha1.KubeLink{a, b} {
+		if _, err := links.UpdateLink(logger.New(), kl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]struct {
+		src, dst string
+		trace    string
+	}{
+		"granted": {"192.168.0.12", "10.1.2.3", `destination: 10.1.2.3
+candidates:
+  a (egress 10.1.0.0/16)
+link:        a (egress 10.1.0.0/16)
+gateway:     10.0.0.1
+route:       10.1.0.0/16 via 10.0.0.1
+source:      192.168.0.12
+ingress:     granted for link b
+`},
+		"dropped": {"192.168.0.12", "10.1.3.3", `destination: 10.1.3.3
+candidates:
+  a (egress 10.1.0.0/16)
+link:        a (egress 10.1.0.0/16)
+gateway:     10.0.0.1
+route:       10.1.0.0/16 via 10.0.0.1
+source:      192.168.0.12
+ingress:     dropped for link b
+`},
+		"cluster address": {"", "192.168.0.12", `destination: 192.168.0.12
+candidates:
+  b (cluster address 192.168.0.0/24)
+link:        b (cluster address 192.168.0.0/24)
+gateway:     10.0.0.1
+route:       192.168.0.0/24 via 10.0.0.1
+`},
+		"unknown": {"192.168.0.13", "10.2.0.1", `destination: 10.2.0.1
+candidates:  none
+link:        none
+source:      192.168.0.13
+ingress:     dropped (unknown cluster address)
+`},
+	}
+	for name, c := range cases {
+		buf := &bytes.Buffer{}
+		links.Trace(net.ParseIP(c.src), net.ParseIP(c.dst)).Write(buf)
+		if buf.String() != c.trace {
+			t.Errorf("%s: unexpected trace\n%s", name, buf.String())
+		}
+	}
+}
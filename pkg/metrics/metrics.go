@@ -0,0 +1,153 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+// Package metrics provides a minimal registry of metric collectors
+// rendered in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gardener/controller-manager-library/pkg/server"
+)
+
+const COUNTER = "counter"
+const GAUGE = "gauge"
+const HISTOGRAM = "histogram"
+
+func init() {
+	server.Register("/metrics", Handler)
+}
+
+type Labels map[string]string
+
+func (this Labels) String() string {
+	if len(this) == 0 {
+		return ""
+	}
+	keys := []string{}
+	for k := range this {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := []string{}
+	for _, k := range keys {
+		s = append(s, fmt.Sprintf("%s=%q", k, this[k]))
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+// With returns a copy of the labels with an additional label.
+func (this Labels) With(name, value string) Labels {
+	result := Labels{name: value}
+	for k, v := range this {
+		result[k] = v
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Writer renders metric families and their samples.
+type Writer struct {
+	buffer bytes.Buffer
+	seen   map[string]bool
+}
+
+func NewWriter() *Writer {
+	return &Writer{seen: map[string]bool{}}
+}
+
+// Describe emits the help and type information for a metric family once.
+func (this *Writer) Describe(name, typ, help string) {
+	if this.seen[name] {
+		return
+	}
+	this.seen[name] = true
+	fmt.Fprintf(&this.buffer, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(&this.buffer, "# TYPE %s %s\n", name, typ)
+}
+
+// Value emits a single sample.
+func (this *Writer) Value(name string, labels Labels, value float64) {
+	fmt.Fprintf(&this.buffer, "%s%s %v\n", name, labels, value)
+}
+
+// Histogram emits the samples of a histogram.
+func (this *Writer) Histogram(name string, labels Labels, h HistogramData) {
+	for i, b := range h.Buckets {
+		this.Value(name+"_bucket", labels.With("le", fmt.Sprintf("%v", b)), float64(h.Counts[i]))
+	}
+	this.Value(name+"_bucket", labels.With("le", "+Inf"), float64(h.Count))
+	this.Value(name+"_sum", labels, h.Sum)
+	this.Value(name+"_count", labels, float64(h.Count))
+}
+
+func (this *Writer) Bytes() []byte {
+	return this.buffer.Bytes()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type Collector interface {
+	Collect(w *Writer)
+}
+
+type CollectorFunc func(w *Writer)
+
+func (this CollectorFunc) Collect(w *Writer) {
+	this(w)
+}
+
+var lock sync.RWMutex
+var collectors = map[string]Collector{}
+
+// Register registers a collector under a unique name. A former
+// registration with the same name is replaced.
+func Register(name string, c Collector) {
+	lock.Lock()
+	defer lock.Unlock()
+	collectors[name] = c
+}
+
+// Collect renders the metrics of all registered collectors.
+func Collect() []byte {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	names := []string{}
+	for n := range collectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	w := NewWriter()
+	for _, n := range names {
+		collectors[n].Collect(w)
+	}
+	return w.Bytes()
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(Collect())
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets (in seconds).
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramData is the state of a single histogram. Counts holds the
+// cumulative number of observations per bucket.
+type HistogramData struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+func (this *HistogramData) Observe(v float64) {
+	for i, b := range this.Buckets {
+		if v <= b {
+			this.Counts[i]++
+		}
+	}
+	this.Count++
+	this.Sum += v
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	keys   []string
+}
+
+func (this *vec) labelsFor(key string) Labels {
+	labels := Labels{}
+	for i, v := range strings.Split(key, "\x00") {
+		labels[this.labels[i]] = v
+	}
+	return labels
+}
+
+func key(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+var _ Collector = &CounterVec{}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		vec:    vec{name: name, help: help, labels: labels},
+		values: map[string]float64{},
+	}
+}
+
+// Inc increments the counter for the given label values.
+func (this *CounterVec) Inc(values ...string) {
+	this.Add(1, values...)
+}
+
+// Add adds a value to the counter for the given label values.
+func (this *CounterVec) Add(v float64, values ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	k := key(values)
+	if _, ok := this.values[k]; !ok {
+		this.keys = append(this.keys, k)
+	}
+	this.values[k] += v
+}
+
+// Get returns the actual counter value for the given label values.
+func (this *CounterVec) Get(values ...string) float64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.values[key(values)]
+}
+
+func (this *CounterVec) Collect(w *Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	w.Describe(this.name, COUNTER, this.help)
+	for _, k := range this.keys {
+		w.Value(this.name, this.labelsFor(k), this.values[k])
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	values  map[string]*HistogramData
+}
+
+var _ Collector = &HistogramVec{}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		vec:     vec{name: name, help: help, labels: labels},
+		buckets: buckets,
+		values:  map[string]*HistogramData{},
+	}
+}
+
+// Observe adds an observation to the histogram for the given label values.
+func (this *HistogramVec) Observe(v float64, values ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	k := key(values)
+	h := this.values[k]
+	if h == nil {
+		h = &HistogramData{Buckets: this.buckets, Counts: make([]uint64, len(this.buckets))}
+		this.values[k] = h
+		this.keys = append(this.keys, k)
+	}
+	h.Observe(v)
+}
+
+// Get returns a copy of the histogram for the given label values.
+func (this *HistogramVec) Get(values ...string) HistogramData {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	h := this.values[key(values)]
+	if h == nil {
+		return HistogramData{Buckets: this.buckets, Counts: make([]uint64, len(this.buckets))}
+	}
+	result := *h
+	result.Counts = append(h.Counts[:0:0], h.Counts...)
+	return result
+}
+
+func (this *HistogramVec) Collect(w *Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	w.Describe(this.name, HISTOGRAM, this.help)
+	for _, k := range this.keys {
+		w.Histogram(this.name, this.labelsFor(k), *this.values[k])
+	}
+}
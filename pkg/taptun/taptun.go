@@ -43,6 +43,27 @@ func NewTun(name string) (*Tun, error) {
 	}, err
 }
 
+// NewTunQueues creates a multi queue *Tun device with the specified name
+// and returns one device per queue connected to the tun interface.
+func NewTunQueues(name string, queues int) ([]*Tun, error) {
+	var result []*Tun
+	for i := 0; i < queues; i++ {
+		n, f, err := openTunQueue(name)
+		if err != nil {
+			for _, t := range result {
+				t.ReadWriteCloser.Close()
+			}
+			return nil, err
+		}
+		name = n
+		result = append(result, &Tun{
+			ReadWriteCloser: f,
+			name:            n,
+		})
+	}
+	return result, nil
+}
+
 // OpenTun creates a tunN interface and returns a *Tun device connected to
 // the tun interface.
 func OpenTun() (*Tun, error) {
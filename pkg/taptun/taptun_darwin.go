@@ -55,6 +55,10 @@ func openTun(name string) (string, *os.File, error) {
 	return createInterface(name)
 }
 
+func openTunQueue(name string) (string, *os.File, error) {
+	return "", nil, errors.New("multi queue tun not supported")
+}
+
 func openTap(name string) (string, *os.File, error) {
 	// not support yet
 	return "", nil, errors.New("tap not support yet.")
@@ -111,6 +111,10 @@ func openTun(name string) (string, *os.File, error) {
 	return createInterface("/dev/tun", name)
 }
 
+func openTunQueue(name string) (string, *os.File, error) {
+	return "", nil, errors.New("multi queue tun not supported")
+}
+
 func openTap(name string) (string, *os.File, error) {
 	return createInterface("/dev/tap", name)
 }
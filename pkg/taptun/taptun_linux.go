@@ -66,6 +66,10 @@ func openTun(name string) (string, *os.File, error) {
 	return createInterface(unix.IFF_TUN|unix.IFF_NO_PI, name)
 }
 
+func openTunQueue(name string) (string, *os.File, error) {
+	return createInterface(unix.IFF_TUN|unix.IFF_NO_PI|unix.IFF_MULTI_QUEUE, name)
+}
+
 func openTap(name string) (string, *os.File, error) {
 	return createInterface(unix.IFF_TAP|unix.IFF_NO_PI, name)
 }
@@ -37,6 +37,10 @@ func openTun(_ string) (string, *os.File, error) {
 	return createInterface(0)
 }
 
+func openTunQueue(_ string) (string, *os.File, error) {
+	return createInterface(0)
+}
+
 func openTap(_ string) (string, *os.File, error) {
 	return createInterface(0)
 }
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+const handoffMessage = "kubelink-listener"
+
+// SendListener passes the file descriptor of a tcp listener to the
+// peer of a unix socket connection.
+func SendListener(conn *net.UnixConn, l *net.TCPListener) error {
+	f, err := l.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, _, err = conn.WriteMsgUnix([]byte(handoffMessage), syscall.UnixRights(int(f.Fd())), nil)
+	return err
+}
+
+// ReceiveListener receives the file descriptor of a tcp listener
+// sent by SendListener.
+func ReceiveListener(conn *net.UnixConn) (net.Listener, error) {
+	buf := make([]byte, len(handoffMessage))
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	if string(buf[:n]) != handoffMessage {
+		return nil, fmt.Errorf("invalid handoff message")
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, fmt.Errorf("no listener passed")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		return nil, fmt.Errorf("unexpected number of passed file descriptors: %d", len(fds))
+	}
+	f := os.NewFile(uintptr(fds[0]), "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// InheritListener requests the listener of a predecessor process
+// serving handoffs on the given unix socket. It returns nil if
+// there is no predecessor.
+func InheritListener(socket string, timeout time.Duration) (net.Listener, error) {
+	c, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return nil, nil
+	}
+	conn := c.(*net.UnixConn)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	l, err := ReceiveListener(conn)
+	if err != nil {
+		return nil, fmt.Errorf("listener handoff failed: %s", err)
+	}
+	// wait for the predecessor to release the handoff socket
+	io.Copy(ioutil.Discard, conn)
+	return l, nil
+}
+
+// ServeHandoff passes the listener to the first successor process
+// requesting it on the given unix socket and calls handoff afterwards.
+// The socket is released before the successor is released, so that
+// it can serve handoffs on the same socket.
+func ServeHandoff(ctx context.Context, socket string, l *net.TCPListener, handoff func()) error {
+	os.Remove(socket)
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		ul.Close()
+		return fmt.Errorf("cannot restrict access to handoff socket: %s", err)
+	}
+	go func() {
+		<-ctx.Done()
+		ul.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ul.AcceptUnix()
+			if err != nil {
+				return
+			}
+			if err := checkPeerUser(conn); err != nil {
+				logger.Warnf("rejecting listener handoff: %s", err)
+				conn.Close()
+				continue
+			}
+			err = SendListener(conn, l)
+			if err != nil {
+				logger.Errorf("listener handoff failed: %s", err)
+				conn.Close()
+				continue
+			}
+			ul.Close()
+			conn.Close()
+			handoff()
+			return
+		}
+	}()
+	return nil
+}
+
+// checkPeerUser checks that the peer of a unix socket connection runs
+// as the same user as the actual process.
+func checkPeerUser(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var cerr error
+	err = raw.Control(func(fd uintptr) {
+		cred, cerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get peer credentials: %s", err)
+	}
+	if int(cred.Uid) != os.Geteuid() {
+		return fmt.Errorf("peer process %d runs as user %d", cred.Pid, cred.Uid)
+	}
+	return nil
+}
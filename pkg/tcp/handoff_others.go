@@ -0,0 +1,36 @@
+// +build !linux
+
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+func InheritListener(socket string, timeout time.Duration) (net.Listener, error) {
+	return nil, fmt.Errorf("listener handoff not supported")
+}
+
+func ServeHandoff(ctx context.Context, socket string, l *net.TCPListener, handoff func()) error {
+	return fmt.Errorf("listener handoff not supported")
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package tcp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/ctxutil"
+)
+
+// HandshakeContextKey is a context key. The context passed to the
+// handler of a connection accepted under a handshake limit carries
+// the handshake slot used by HandshakeDone.
+var HandshakeContextKey = ctxutil.SimpleKey("tcp-handshake")
+
+// handshakeLimiter bounds the number of connections in the handshake
+// phase. A connection occupies a slot from its acceptance until the
+// handler reports the handshake to be done or the connection is closed.
+type handshakeLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newHandshakeLimiter(max int, timeout time.Duration) *handshakeLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &handshakeLimiter{
+		slots:   make(chan struct{}, max),
+		timeout: timeout,
+	}
+}
+
+// acquire waits for a free slot for at most the configured queue
+// timeout. It returns nil if no slot became available. It is called
+// by the accept loop, which is blocked while waiting.
+func (this *handshakeLimiter) acquire(done <-chan struct{}) *handshakeSlot {
+	select {
+	case this.slots <- struct{}{}:
+		return &handshakeSlot{limiter: this}
+	default:
+	}
+	if this.timeout <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(this.timeout)
+	defer timer.Stop()
+	select {
+	case this.slots <- struct{}{}:
+		return &handshakeSlot{limiter: this}
+	case <-timer.C:
+	case <-done:
+	}
+	return nil
+}
+
+// pending returns the number of connections in the handshake phase.
+func (this *handshakeLimiter) pending() int {
+	return len(this.slots)
+}
+
+type handshakeSlot struct {
+	once    sync.Once
+	limiter *handshakeLimiter
+}
+
+func (this *handshakeSlot) release() {
+	if this != nil {
+		this.once.Do(func() { <-this.limiter.slots })
+	}
+}
+
+// HandshakeDone releases the handshake slot of the connection the
+// given context belongs to. Handlers call it as soon as the
+// connection is established to make room for further handshakes.
+func HandshakeDone(ctx context.Context) {
+	if slot, ok := ctx.Value(HandshakeContextKey).(*handshakeSlot); ok {
+		slot.release()
+	}
+}
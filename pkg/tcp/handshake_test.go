@@ -0,0 +1,157 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package tcp
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+// testHandshakeServer serves connections with a limit of one pending
+// handshake. The handler reports every served connection.
+func testHandshakeServer(t *testing.T, timeout time.Duration, handler HandlerFunc) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		Handler:               handler,
+		MaxHandshakes:         1,
+		HandshakeQueueTimeout: timeout,
+		ErrorLog:              log.New(ioutil.Discard, "", 0),
+	}
+	go srv.Serve(l)
+	return srv, l.Addr().String()
+}
+
+func testDial(t *testing.T, addr string) net.Conn {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func expectServed(t *testing.T, served chan int, n int, msg string) {
+	select {
+	case i := <-served:
+		if i != n {
+			t.Errorf("%s: connection %d served instead of %d", msg, i, n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s: connection %d not served", msg, n)
+	}
+}
+
+func TestHandshakeReleaseOnDone(t *testing.T) {
+	served := make(chan int, 2)
+	block := make(chan struct{})
+	defer close(block)
+	count := 0
+	srv, addr := testHandshakeServer(t, 5*time.Second, func(ctx context.Context, conn net.Conn) {
+		count++
+		HandshakeDone(ctx)
+		served <- count
+		<-block
+	})
+	defer srv.Close()
+
+	c1 := testDial(t, addr)
+	defer c1.Close()
+	expectServed(t, served, 1, "first")
+	c2 := testDial(t, addr)
+	defer c2.Close()
+	expectServed(t, served, 2, "after handshake done")
+}
+
+func TestHandshakeReleaseOnClose(t *testing.T) {
+	served := make(chan int, 2)
+	count := 0
+	srv, addr := testHandshakeServer(t, 5*time.Second, func(ctx context.Context, conn net.Conn) {
+		count++
+		served <- count
+	})
+	defer srv.Close()
+
+	c1 := testDial(t, addr)
+	defer c1.Close()
+	expectServed(t, served, 1, "first")
+	c2 := testDial(t, addr)
+	defer c2.Close()
+	expectServed(t, served, 2, "after close")
+}
+
+func TestHandshakeReleaseOnPanic(t *testing.T) {
+	served := make(chan int, 2)
+	count := 0
+	srv, addr := testHandshakeServer(t, 5*time.Second, func(ctx context.Context, conn net.Conn) {
+		count++
+		served <- count
+		panic("handler failure")
+	})
+	defer srv.Close()
+
+	c1 := testDial(t, addr)
+	defer c1.Close()
+	expectServed(t, served, 1, "first")
+	c2 := testDial(t, addr)
+	defer c2.Close()
+	expectServed(t, served, 2, "after panic")
+}
+
+func TestHandshakeQueueTimeout(t *testing.T) {
+	served := make(chan int, 2)
+	block := make(chan struct{})
+	defer close(block)
+	count := 0
+	srv, addr := testHandshakeServer(t, 100*time.Millisecond, func(ctx context.Context, conn net.Conn) {
+		count++
+		served <- count
+		<-block
+	})
+	defer srv.Close()
+
+	c1 := testDial(t, addr)
+	defer c1.Close()
+	expectServed(t, served, 1, "first")
+
+	// the pending handshake occupies the only slot, so the second
+	// connection is closed after the queue timeout.
+	start := time.Now()
+	c2 := testDial(t, addr)
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c2.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("data received on rejected connection")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("connection not rejected")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("connection rejected before queue timeout (%s)", d)
+	}
+	select {
+	case <-served:
+		t.Errorf("rejected connection served")
+	default:
+	}
+}
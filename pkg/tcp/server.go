@@ -123,6 +123,10 @@ type conn struct {
 	// This is the value of a Handler's (*Request).RemoteAddr.
 	remoteAddr string
 
+	// handshake is the handshake slot occupied by the connection
+	// if the server limits concurrent handshakes.
+	handshake *handshakeSlot
+
 	// tlsState is the TLS connection state when using TLS.
 	// nil means not TLS.
 	tlsState *tls.ConnectionState
@@ -182,10 +186,29 @@ func (c *conn) serve(ctx context.Context) {
 			buf = buf[:runtime.Stack(buf, false)]
 			c.server.logf("tcp: panic serving %v: %v\n%s", c.remoteAddr, err, buf)
 		}
+		c.handshake.release()
 		c.close()
 		c.setState(c.rwc, StateClosed)
 	}()
 
+	if d := c.server.HandshakeTimeout; d != 0 {
+		c.rwc.SetDeadline(time.Now().Add(d))
+	}
+	if config := c.server.optionalTLS; config != nil {
+		rwc, isTLS, err := sniffTLS(c.rwc)
+		if err != nil {
+			if err != io.EOF {
+				c.server.logf("tcp: cannot detect protocol of %s: %v", c.rwc.RemoteAddr(), err)
+			}
+			return
+		}
+		if isTLS {
+			c.rwc = tls.Server(rwc, config)
+		} else {
+			c.rwc = rwc
+		}
+	}
+
 	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
 		if d := c.server.ReadTimeout; d != 0 {
 			c.rwc.SetReadDeadline(time.Now().Add(d))
@@ -203,9 +226,16 @@ func (c *conn) serve(ctx context.Context) {
 		*c.tlsState = tlsConn.ConnectionState()
 	}
 
+	if c.server.HandshakeTimeout != 0 {
+		c.rwc.SetDeadline(time.Time{})
+	}
+
 	ctx, cancelCtx := context.WithCancel(ctx)
 	c.cancelCtx = cancelCtx
 	defer cancelCtx()
+	if c.handshake != nil {
+		ctx = context.WithValue(ctx, HandshakeContextKey, c.handshake)
+	}
 
 	c.server.Handler.ServeConnection(ctx, c.rwc)
 }
@@ -371,6 +401,36 @@ type Server struct {
 	// value.
 	ConnContext func(ctx context.Context, c net.Conn) context.Context
 
+	// AllowPlaintext enables ServeTLS to accept plain connections, also.
+	// TLS connections are detected by their first byte, the decision
+	// about accepting the plain connection is left to the Handler.
+	AllowPlaintext bool
+
+	// MaxHandshakes limits the number of connections concurrently
+	// in the handshake phase (TLS and handler specific handshake,
+	// see HandshakeDone). Excess connections wait for at most
+	// HandshakeQueueTimeout for a free slot before they are rejected.
+	// The accept loop waits for the slot, so while all slots are
+	// occupied further connections stay in the listen backlog of the
+	// kernel for up to HandshakeQueueTimeout each. This intentionally
+	// pushes back on connection floods instead of queueing an unbounded
+	// number of accepted connections.
+	// If zero, there is no limit.
+	MaxHandshakes         int
+	HandshakeQueueTimeout time.Duration
+
+	// HandshakeTimeout is the maximum duration of the protocol
+	// detection and TLS handshake of a connection.
+	// If zero, there is no timeout.
+	HandshakeTimeout time.Duration
+
+	// Listener is an already established listener used by
+	// ListenAndServe and ListenAndServeTLS instead of listening
+	// on Addr, for example a listener inherited from a predecessor.
+	Listener net.Listener
+
+	optionalTLS *tls.Config
+
 	disableKeepAlives int32     // accessed atomically.
 	inShutdown        int32     // accessed atomically (non-zero means we're in Shutdown)
 	nextProtoOnce     sync.Once // guards setupHTTP2_* init
@@ -458,6 +518,33 @@ func (this *Server) RegisterOnShutdown(f func()) {
 	this.lock.Unlock()
 }
 
+// Drain stops accepting new connections and waits for the active
+// connections to finish. Connections still active when the context
+// is done are closed.
+func (this *Server) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&this.inShutdown, 1)
+	this.lock.Lock()
+	this._closeDoneChan()
+	err := this._closeListeners()
+	this.lock.Unlock()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		this.lock.Lock()
+		if len(this.activeConn) == 0 || ctx.Err() != nil {
+			this._closeConnections()
+			this.lock.Unlock()
+			return err
+		}
+		this.lock.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
 func (this *Server) _closeListeners() error {
 	var err error
 	for ln := range this.listeners {
@@ -512,6 +599,7 @@ func (this *Server) Serve(l net.Listener) error {
 	var tempDelay time.Duration // how long to sleep on accept failure
 
 	ctx := context.WithValue(baseCtx, ServerContextKey, this)
+	handshakes := newHandshakeLimiter(this.MaxHandshakes, this.HandshakeQueueTimeout)
 	for {
 		rw, err := l.Accept()
 		if err != nil {
@@ -543,7 +631,17 @@ func (this *Server) Serve(l net.Listener) error {
 			}
 		}
 		tempDelay = 0
+		var slot *handshakeSlot
+		if handshakes != nil {
+			slot = handshakes.acquire(this.getDoneChan())
+			if slot == nil {
+				this.logf("tcp: too many pending handshakes (%d), rejecting connection from %s", handshakes.pending(), rw.RemoteAddr())
+				rw.Close()
+				continue
+			}
+		}
 		c := this.newConn(rw)
+		c.handshake = slot
 		c.setState(c.rwc, StateNew) // before Serve can return
 		go c.serve(connCtx)
 	}
@@ -575,6 +673,10 @@ func (this *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
 		}
 	}
 
+	if this.AllowPlaintext {
+		this.optionalTLS = config
+		return this.Serve(l)
+	}
 	tlsListener := tls.NewListener(l, config)
 	return this.Serve(tlsListener)
 }
@@ -697,7 +799,7 @@ func (this *Server) ListenAndServeTLS(certFile, keyFile string) error {
 		addr = ":https"
 	}
 
-	ln, err := net.Listen("tcp", addr)
+	ln, err := this.listen(addr)
 	if err != nil {
 		return err
 	}
@@ -723,9 +825,16 @@ func (this *Server) ListenAndServe() error {
 	if addr == "" {
 		addr = ":http"
 	}
-	ln, err := net.Listen("tcp", addr)
+	ln, err := this.listen(addr)
 	if err != nil {
 		return err
 	}
 	return this.Serve(ln)
 }
+
+func (this *Server) listen(addr string) (net.Listener, error) {
+	if this.Listener != nil {
+		return this.Listener, nil
+	}
+	return net.Listen("tcp", addr)
+}
@@ -19,6 +19,7 @@
 package tcp
 
 import (
+	"bufio"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -107,6 +108,20 @@ func CIDRIP(cidr *net.IPNet, ip net.IP) *net.IPNet {
 	return &net
 }
 
+// BroadcastIP returns the last address of a network.
+func BroadcastIP(cidr *net.IPNet) net.IP {
+	ip := CloneIP(cidr.IP.Mask(cidr.Mask))
+	for i := range ip {
+		ip[i] |= ^cidr.Mask[i]
+	}
+	return ip
+}
+
+// OverlappingCIDR checks whether two networks share any address.
+func OverlappingCIDR(a, b *net.IPNet) bool {
+	return a.Contains(b.IP.Mask(b.Mask)) || b.Contains(a.IP.Mask(a.Mask))
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 type CIDRList []*net.IPNet
@@ -127,6 +142,20 @@ func (this *CIDRList) Add(cidrs ...*net.IPNet) {
 	*this = append(*this, cidrs...)
 }
 
+// Remove removes all entries equal to the given network.
+func (this *CIDRList) Remove(cidr *net.IPNet) {
+	list := (*this)[:0]
+	for _, c := range *this {
+		if !EqualCIDR(c, cidr) {
+			list = append(list, c)
+		}
+	}
+	for i := len(list); i < len(*this); i++ {
+		(*this)[i] = nil
+	}
+	*this = list
+}
+
 func (this *CIDRList) IsEmpty() bool {
 	return len(*this) == 0
 }
@@ -135,6 +164,26 @@ func (this *CIDRList) IsSet() bool {
 	return *this != nil
 }
 
+// Equal compares two lists as sets, ignoring the order of the entries.
+// An unset (nil) list is different from an empty one.
+func (this *CIDRList) Equal(other CIDRList) bool {
+	if this.IsSet() != other.IsSet() {
+		return false
+	}
+	set := map[string]bool{}
+	for _, c := range *this {
+		set[c.String()] = true
+	}
+	found := map[string]bool{}
+	for _, c := range other {
+		if !set[c.String()] {
+			return false
+		}
+		found[c.String()] = true
+	}
+	return len(found) == len(set)
+}
+
 func (this *CIDRList) Contains(ip net.IP) bool {
 	for _, c := range *this {
 		if c.Contains(ip) {
@@ -144,6 +193,19 @@ func (this *CIDRList) Contains(ip net.IP) bool {
 	return false
 }
 
+// ContainsNet checks whether any entry completely contains the given
+// network.
+func (this *CIDRList) ContainsNet(sub *net.IPNet) bool {
+	ones, bits := sub.Mask.Size()
+	for _, c := range *this {
+		cones, cbits := c.Mask.Size()
+		if cbits == bits && cones <= ones && c.Contains(sub.IP) {
+			return true
+		}
+	}
+	return false
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 func Family(ip net.IP) int {
@@ -152,3 +214,62 @@ func Family(ip net.IP) int {
 	}
 	return netlink.FAMILY_V4
 }
+
+////////////////////////////////////////////////////////////////////////////////
+
+// recordTypeHandshake is the first byte of every TLS connection.
+const recordTypeHandshake = 0x16
+
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (this *peekedConn) Read(b []byte) (int, error) {
+	return this.reader.Read(b)
+}
+
+// sniffTLS checks whether a connection starts with a TLS handshake.
+// The returned connection must be used instead of the original one.
+func sniffTLS(conn net.Conn) (net.Conn, bool, error) {
+	reader := bufio.NewReader(conn)
+	b, err := reader.Peek(1)
+	if err != nil {
+		return nil, false, err
+	}
+	return &peekedConn{Conn: conn, reader: reader}, b[0] == recordTypeHandshake, nil
+}
+
+// Peekable returns a connection supporting Peek. The returned connection
+// must be used instead of the original one.
+func Peekable(conn net.Conn) net.Conn {
+	if _, ok := conn.(*peekedConn); ok {
+		return conn
+	}
+	return &peekedConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Peek returns the next n bytes of a peekable connection without
+// consuming them.
+func Peek(conn net.Conn, n int) ([]byte, error) {
+	c, ok := conn.(*peekedConn)
+	if !ok {
+		return nil, fmt.Errorf("connection does not support peeking")
+	}
+	return c.reader.Peek(n)
+}
+
+// RawConn returns the underlying network connection of a possibly
+// wrapped connection.
+func RawConn(conn net.Conn) net.Conn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *peekedConn:
+			conn = c.Conn
+		default:
+			return conn
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package tcp
+
+import (
+	"net"
+	"testing"
+)
+
+func testCIDRList(t *testing.T, cidrs ...string) CIDRList {
+	list := CIDRList{}
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list.Add(n)
+	}
+	return list
+}
+
+func TestCIDRListEqual(t *testing.T) {
+	a := testCIDRList(t, "10.0.0.0/24", "10.0.1.0/24")
+	cases := map[string]struct {
+		list  CIDRList
+		equal bool
+	}{
+		"same":      {list: testCIDRList(t, "10.0.0.0/24", "10.0.1.0/24"), equal: true},
+		"reordered": {list: testCIDRList(t, "10.0.1.0/24", "10.0.0.0/24"), equal: true},
+		"added":     {list: testCIDRList(t, "10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24"), equal: false},
+		"removed":   {list: testCIDRList(t, "10.0.0.0/24"), equal: false},
+		"replaced":  {list: testCIDRList(t, "10.0.0.0/24", "10.0.2.0/24"), equal: false},
+		"empty":     {list: CIDRList{}, equal: false},
+		"nil":       {list: nil, equal: false},
+	}
+	for name, c := range cases {
+		if r := a.Equal(c.list); r != c.equal {
+			t.Errorf("%s: equal is %t", name, r)
+		}
+		if r := c.list.Equal(a); r != c.equal {
+			t.Errorf("%s: reverse equal is %t", name, r)
+		}
+	}
+
+	var unset CIDRList
+	if !unset.Equal(nil) || !(&CIDRList{}).Equal(CIDRList{}) {
+		t.Errorf("identical lists differ")
+	}
+	if unset.Equal(CIDRList{}) || (&CIDRList{}).Equal(nil) {
+		t.Errorf("nil list equals empty list")
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+const OTLP_BATCH_SIZE = 100
+const OTLP_FLUSH_INTERVAL = 5 * time.Second
+
+// OTLPExporter exports spans in batches to an OpenTelemetry collector
+// using the OTLP/HTTP protocol with JSON encoding.
+type OTLPExporter struct {
+	url     string
+	service string
+	client  *http.Client
+	spans   chan *Span
+}
+
+var _ Exporter = &OTLPExporter{}
+
+// NewOTLPExporter creates an exporter for the given collector endpoint
+// (e.g. http://otel-collector:4318). Spans are sent until the given
+// context is done.
+func NewOTLPExporter(ctx context.Context, endpoint, service string) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	e := &OTLPExporter{
+		url:     url,
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		spans:   make(chan *Span, OTLP_BATCH_SIZE*10),
+	}
+	go e.run(ctx)
+	return e
+}
+
+// Export queues a span. Spans are dropped if the queue is full.
+func (this *OTLPExporter) Export(span *Span) {
+	select {
+	case this.spans <- span:
+	default:
+	}
+}
+
+func (this *OTLPExporter) run(ctx context.Context) {
+	ticker := time.NewTicker(OTLP_FLUSH_INTERVAL)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case <-ctx.Done():
+			this.send(batch)
+			return
+		case s := <-this.spans:
+			batch = append(batch, s)
+			if len(batch) < OTLP_BATCH_SIZE {
+				continue
+			}
+		case <-ticker.C:
+		}
+		if err := this.send(batch); err != nil {
+			logger.Warnf("cannot export %d spans: %s", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+func (this *OTLPExporter) send(batch []*Span) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(this.Request(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := this.client.Post(this.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// OTLP JSON encoding
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpEvent struct {
+	Name         string `json:"name"`
+	TimeUnixNano string `json:"timeUnixNano"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// OTLPRequest is the body of an OTLP/HTTP trace export request.
+type OTLPRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const otlpKindInternal = 1
+const otlpStatusOK = 1
+const otlpStatusError = 2
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Request converts a batch of spans into an OTLP export request.
+func (this *OTLPExporter) Request(batch []*Span) *OTLPRequest {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "github.com/mandelsoft/kubelink"
+	for _, s := range batch {
+		s.lock.Lock()
+		span := otlpSpan{
+			TraceID:           s.traceID(),
+			SpanID:            s.spanID(),
+			ParentSpanID:      s.parentID(),
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: nanos(s.Start),
+			EndTimeUnixNano:   nanos(s.End),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		for k, v := range s.Attributes {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: k, Value: otlpValue{v}})
+		}
+		for _, e := range s.Events {
+			span.Events = append(span.Events, otlpEvent{Name: e.Name, TimeUnixNano: nanos(e.Time)})
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		s.lock.Unlock()
+		scope.Spans = append(scope.Spans, span)
+	}
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	rs.Resource.Attributes = []otlpAttribute{{Key: "service.name", Value: otlpValue{this.service}}}
+	return &OTLPRequest{ResourceSpans: []otlpResourceSpans{rs}}
+}
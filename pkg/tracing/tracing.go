@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+// Package tracing provides a minimal span model for tracing the
+// lifecycle of tunnel connections. Spans are handed to a globally
+// configured exporter. Without exporter tracing is a no-op.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Exporter receives finished spans.
+type Exporter interface {
+	Export(span *Span)
+}
+
+var lock sync.RWMutex
+var exporter Exporter
+
+// SetExporter sets the exporter for finished spans. A nil exporter
+// disables tracing.
+func SetExporter(e Exporter) {
+	lock.Lock()
+	defer lock.Unlock()
+	exporter = e
+}
+
+func getExporter() Exporter {
+	lock.RLock()
+	defer lock.RUnlock()
+	return exporter
+}
+
+// Enabled reports whether spans are recorded.
+func Enabled() bool {
+	return getExporter() != nil
+}
+
+type Event struct {
+	Name string
+	Time time.Time
+}
+
+// Span describes a timed operation. All methods may be called
+// on a nil span, which is used if tracing is disabled.
+type Span struct {
+	lock       sync.Mutex
+	TraceID    [16]byte
+	SpanID     [8]byte
+	ParentID   [8]byte
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Events     []Event
+	Error      string
+	ended      bool
+}
+
+// StartSpan starts a new root span with the given attributes given as
+// key/value pairs. It returns nil if tracing is disabled.
+func StartSpan(name string, attrs ...string) *Span {
+	if !Enabled() {
+		return nil
+	}
+	span := newSpan(name, attrs)
+	rand.Read(span.TraceID[:])
+	return span
+}
+
+// StartChild starts a new span in the trace of the given one.
+func (this *Span) StartChild(name string, attrs ...string) *Span {
+	if this == nil {
+		return nil
+	}
+	span := newSpan(name, attrs)
+	span.TraceID = this.TraceID
+	span.ParentID = this.SpanID
+	return span
+}
+
+func newSpan(name string, attrs []string) *Span {
+	span := &Span{
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+	}
+	rand.Read(span.SpanID[:])
+	span.SetAttributes(attrs...)
+	return span
+}
+
+// SetAttributes sets attributes given as key/value pairs.
+func (this *Span) SetAttributes(attrs ...string) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for i := 0; i+1 < len(attrs); i += 2 {
+		this.Attributes[attrs[i]] = attrs[i+1]
+	}
+}
+
+// AddEvent records a named event at the actual time.
+func (this *Span) AddEvent(name string) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Events = append(this.Events, Event{Name: name, Time: time.Now()})
+}
+
+// Finish ends the span, marks it as failed for a non-nil error and
+// exports it. Further calls are ignored.
+func (this *Span) Finish(err error) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	if this.ended {
+		this.lock.Unlock()
+		return
+	}
+	this.ended = true
+	this.End = time.Now()
+	if err != nil {
+		this.Error = err.Error()
+	}
+	this.lock.Unlock()
+
+	if e := getExporter(); e != nil {
+		e.Export(this)
+	}
+}
+
+func (this *Span) traceID() string {
+	return hex.EncodeToString(this.TraceID[:])
+}
+
+func (this *Span) spanID() string {
+	return hex.EncodeToString(this.SpanID[:])
+}
+
+func (this *Span) parentID() string {
+	if this.ParentID == [8]byte{} {
+		return ""
+	}
+	return hex.EncodeToString(this.ParentID[:])
+}